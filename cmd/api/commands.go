@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/auth"
+	"github.com/mrshabel/sgbank/internal/config"
+	"github.com/mrshabel/sgbank/internal/db"
+	"github.com/mrshabel/sgbank/internal/handlers"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/pain"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// command is a maintenance task run in place of the server. eg: sgbank rebuild-balances
+type command struct {
+	name        string
+	description string
+	run         func(ctx context.Context, cfg *config.Config, logger *slog.Logger, args []string) error
+}
+
+var commands = []command{
+	{
+		name:        "rebuild-balances",
+		description: "recompute account balances from transaction lines and report any drift",
+		run:         rebuildBalances,
+	},
+	{
+		name:        "verify-ledger",
+		description: "walk the ledger hash chain and report the first altered, inserted or removed entry",
+		run:         verifyLedger,
+	},
+	{
+		name:        "accrue-interest",
+		description: "accrue a day of interest [-date YYYY-MM-DD], yesterday by default. Days already accrued are left untouched",
+		run:         accrueInterest,
+	},
+	{
+		name:        "pay-interest",
+		description: "pay out a month of accrued interest -month YYYY-MM. Months already paid out are left untouched",
+		run:         payInterest,
+	},
+	{
+		name:        "post-batch",
+		description: "post a pain.001 credit transfer file -file path [-mode atomic|per_instruction] and write its pain.002 status report to stdout or -report path",
+		run:         postBatch,
+	},
+	{
+		name:        "issue-api-key",
+		description: "issue an api key -name name [-user id] [-ttl duration] for a service, owned by the system user by default. The key is printed once",
+		run:         issueAPIKey,
+	},
+	{
+		name:        "set-role",
+		description: "set the role of a user -user id -role customer|auditor|operator, such as the first operator of the bank",
+		run:         setRole,
+	},
+	{
+		name:        "migrate",
+		description: "manage schema migrations: up, down [-steps n], status, create <name>",
+		run:         migrate,
+	},
+}
+
+// runCommand executes the named command with the remaining arguments
+func runCommand(cfg *config.Config, logger *slog.Logger, name string, args []string) error {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(context.Background(), cfg, logger, args)
+		}
+	}
+
+	usage()
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// usage prints the available commands
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: sgbank [command] [flags]")
+	fmt.Fprintln(os.Stderr, "\nStarts the server when no command is given. Available commands:")
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.description)
+	}
+	w.Flush()
+}
+
+// openDB connects to the configured database for commands that need it
+func openDB(cfg *config.Config, logger *slog.Logger) (*sql.DB, error) {
+	return db.New(cfg.DatabaseURL, logger)
+}
+
+// rebuildBalances recomputes the materialized account balances. Drifted balances are corrected unless -dry-run is set, in which case any drift fails the command
+func rebuildBalances(ctx context.Context, cfg *config.Config, logger *slog.Logger, args []string) error {
+	fs := flag.NewFlagSet("rebuild-balances", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "report drift without correcting it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	transactionRepo := repository.NewTransactionRepository(db, logger)
+	drifts, err := transactionRepo.RebuildBalances(ctx, !*dryRun)
+	if err != nil {
+		return err
+	}
+
+	if len(drifts) == 0 {
+		logger.Info("Account balances match transaction lines")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tCURRENCY\tSTORED DEBITS\tSTORED CREDITS\tACTUAL DEBITS\tACTUAL CREDITS")
+	for _, d := range drifts {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\n", d.AccountID, d.Currency, d.StoredDebits, d.StoredCredits, d.ActualDebits, d.ActualCredits)
+	}
+	w.Flush()
+
+	if *dryRun {
+		return fmt.Errorf("%d account balances drifted", len(drifts))
+	}
+	logger.Warn("Corrected drifted account balances", "count", len(drifts))
+	return nil
+}
+
+// verifyLedger checks the ledger hash chain against the database and fails when it has been tampered with
+func verifyLedger(ctx context.Context, cfg *config.Config, logger *slog.Logger, args []string) error {
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	transactionRepo := repository.NewTransactionRepository(db, logger)
+	result, err := transactionRepo.VerifyLedger(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !result.Valid {
+		fault := result.Fault
+		transactionID := ""
+		if fault.TransactionID != nil {
+			transactionID = fault.TransactionID.String()
+		}
+		return fmt.Errorf("ledger entry %s (sequence %d, transaction %s) after %d verified entries: %s", fault.Kind, fault.Sequence, transactionID, result.Entries, fault.Reason)
+	}
+
+	logger.Info("Ledger chain verified", "entries", result.Entries, "head_sequence", result.HeadSequence, "head_hash", result.HeadHash)
+	return nil
+}
+
+// accrueInterest accrues interest for a single day that has ended. Re-running a day reports the original run
+func accrueInterest(ctx context.Context, cfg *config.Config, logger *slog.Logger, args []string) error {
+	fs := flag.NewFlagSet("accrue-interest", flag.ContinueOnError)
+	date := fs.String("date", time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly), "day to accrue")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	day, err := time.Parse(time.DateOnly, *date)
+	if err != nil {
+		return fmt.Errorf("invalid date: %w", err)
+	}
+
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	transactionRepo := repository.NewTransactionRepository(db, logger)
+	run, created, err := transactionRepo.AccrueInterest(ctx, day)
+	if err != nil {
+		return err
+	}
+
+	if !created {
+		logger.Info("Interest already accrued", "day", *date, "accounts", run.Accounts, "accrued_at", run.CreatedAt)
+		return nil
+	}
+	logger.Info("Accrued interest", "day", *date, "accounts", run.Accounts)
+	return nil
+}
+
+// payInterest pays out the interest accrued over a month that has ended. Re-running a month reports the original run
+func payInterest(ctx context.Context, cfg *config.Config, logger *slog.Logger, args []string) error {
+	fs := flag.NewFlagSet("pay-interest", flag.ContinueOnError)
+	month := fs.String("month", "", "month to pay out, eg: 2024-01")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	start, err := time.Parse("2006-01", *month)
+	if err != nil {
+		return fmt.Errorf("invalid month: %w", err)
+	}
+
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	transactionRepo := repository.NewTransactionRepository(db, logger)
+	run, created, err := transactionRepo.PayInterest(ctx, start)
+	if err != nil {
+		return err
+	}
+
+	if !created {
+		logger.Info("Interest already paid out", "month", *month, "accounts", run.Accounts, "paid_at", run.CreatedAt)
+		return nil
+	}
+	logger.Info("Paid out interest", "month", *month, "accounts", run.Accounts)
+	return nil
+}
+
+// postBatch posts the credit transfers of a pain.001 file and writes the pain.002 status report of the batch. A rejected batch, or one that was already processed,
+// fails the command
+func postBatch(ctx context.Context, cfg *config.Config, logger *slog.Logger, args []string) error {
+	fs := flag.NewFlagSet("post-batch", flag.ContinueOnError)
+	path := fs.String("file", "", "pain.001 file to post")
+	mode := fs.String("mode", string(models.ATOMIC_BATCH), "post the batch atomically or per_instruction")
+	reportPath := fs.String("report", "", "file to write the pain.002 status report to, stdout by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("a pain.001 file is required")
+	}
+	if m := models.BatchMode(*mode); m != models.ATOMIC_BATCH && m != models.PER_INSTRUCTION_BATCH {
+		return fmt.Errorf("invalid mode %q", *mode)
+	}
+
+	f, err := os.Open(*path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	batch, err := pain.Parse(f)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	transactionHandler := handlers.NewTransactionHandler(
+		repository.NewTransactionRepository(db, logger),
+		repository.NewAccountRepository(db, logger),
+		repository.NewFXRateRepository(db, logger),
+		repository.NewIdempotencyRepository(db, logger),
+		repository.NewHoldRepository(db, logger),
+		repository.NewFeeRepository(db, logger),
+		repository.NewPaymentBatchRepository(db, logger),
+		logger,
+	)
+	report, postErr := transactionHandler.PostBatch(ctx, batch, models.BatchMode(*mode))
+	if report == nil {
+		return postErr
+	}
+
+	body, err := pain.StatusReport(report)
+	if err != nil {
+		return err
+	}
+	if *reportPath != "" {
+		err = os.WriteFile(*reportPath, body, 0o644)
+	} else {
+		_, err = os.Stdout.Write(body)
+	}
+	if err != nil {
+		return err
+	}
+
+	if postErr != nil {
+		return postErr
+	}
+	if report.Status == models.PAYMENT_REJECTED {
+		return fmt.Errorf("payment batch %s was rejected", report.MessageID)
+	}
+	logger.Info("Posted payment batch", "message_id", report.MessageID, "mode", report.Mode, "status", report.Status, "instructions", len(report.Instructions))
+	return nil
+}
+
+// issueAPIKey issues an api key outside the api, for services and for the first key of a user, and prints it to stdout
+func issueAPIKey(ctx context.Context, cfg *config.Config, logger *slog.Logger, args []string) error {
+	fs := flag.NewFlagSet("issue-api-key", flag.ContinueOnError)
+	name := fs.String("name", "", "name telling the key apart")
+	user := fs.String("user", models.SystemUserID, "id of the user the key authenticates as")
+	ttl := fs.Duration("ttl", 0, "how long the key is valid for, forever by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("a key name is required")
+	}
+	userID, err := uuid.Parse(*user)
+	if err != nil {
+		return fmt.Errorf("invalid user id %q", *user)
+	}
+	if *ttl < 0 {
+		return errors.New("ttl must not be negative")
+	}
+
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	key, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		return err
+	}
+	data := &models.CreateAPIKey{
+		UserID:  userID,
+		Name:    *name,
+		Prefix:  prefix,
+		KeyHash: auth.HashSecret(key),
+	}
+	if *ttl > 0 {
+		expiresAt := time.Now().Add(*ttl)
+		data.ExpiresAt = &expiresAt
+	}
+	apiKey, err := repository.NewAPIKeyRepository(db, logger).CreateAPIKey(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Issued api key", "id", apiKey.ID, "user_id", apiKey.UserID, "name", apiKey.Name)
+	fmt.Println(key)
+	return nil
+}
+
+// setRole changes the role of a user without going through the api, which needs an operator to do so
+func setRole(ctx context.Context, cfg *config.Config, logger *slog.Logger, args []string) error {
+	fs := flag.NewFlagSet("set-role", flag.ContinueOnError)
+	user := fs.String("user", "", "id of the user")
+	role := fs.String("role", "", "role to grant: customer, auditor or operator")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	userID, err := uuid.Parse(*user)
+	if err != nil {
+		return fmt.Errorf("invalid user id %q", *user)
+	}
+	if userID.String() == models.SystemUserID {
+		return handlers.ErrSystemRole
+	}
+	switch models.Role(*role) {
+	case models.CUSTOMER, models.AUDITOR, models.OPERATOR:
+	default:
+		return fmt.Errorf("invalid role %q", *role)
+	}
+
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	updated, err := repository.NewUserRepository(db, logger).SetUserRole(ctx, userID, models.Role(*role))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user %s not found", userID)
+		}
+		return err
+	}
+
+	logger.Info("Set user role", "user_id", updated.ID, "role", updated.Role)
+	return nil
+}
+
+// migrate applies, reverts, lists or scaffolds schema migrations. Creating a migration only writes files and needs no database
+func migrate(ctx context.Context, cfg *config.Config, logger *slog.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: sgbank migrate up|down|status|create")
+	}
+	action, args := args[0], args[1:]
+
+	if action == "create" {
+		fs := flag.NewFlagSet("migrate create", flag.ContinueOnError)
+		dir := fs.String("dir", "internal/db/migrations", "directory to write the migration files to")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New("usage: sgbank migrate create [-dir path] <name>")
+		}
+
+		up, down, err := db.CreateMigration(*dir, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		logger.Info("Created migration", "up", up, "down", down)
+		return nil
+	}
+
+	conn, err := openDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch action {
+	case "up":
+		applied, err := db.MigrateUp(ctx, conn, logger)
+		if err != nil {
+			return err
+		}
+		logger.Info("Schema is up to date", "applied", applied)
+		return nil
+
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := fs.Int("steps", 1, "number of migrations to revert")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if *steps < 1 {
+			return errors.New("steps must be at least 1")
+		}
+
+		reverted, err := db.MigrateDown(ctx, conn, logger, *steps)
+		if err != nil {
+			return err
+		}
+		logger.Info("Reverted migrations", "reverted", reverted)
+		return nil
+
+	case "status":
+		statuses, err := db.MigrationStatuses(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			name := s.Name
+			if s.Missing {
+				name = "(missing file)"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, name, appliedAt)
+		}
+		return w.Flush()
+	}
+
+	return fmt.Errorf("unknown migrate action %q", action)
+}
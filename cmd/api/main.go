@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
@@ -11,9 +14,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/mrshabel/sgbank/internal/config"
-	"github.com/mrshabel/sgbank/internal/db"
+	dbpkg "github.com/mrshabel/sgbank/internal/db"
 	"github.com/mrshabel/sgbank/internal/handlers"
+	"github.com/mrshabel/sgbank/internal/jobs"
 	log "github.com/mrshabel/sgbank/internal/logger"
+	"github.com/mrshabel/sgbank/internal/mailer"
 	"github.com/mrshabel/sgbank/internal/repository"
 )
 
@@ -22,14 +27,31 @@ func main() {
 	cfg := config.New()
 	logger := log.New(cfg.Env)
 
+	// run a maintenance command in place of the server
+	if len(os.Args) > 1 {
+		if err := runCommand(cfg, logger, os.Args[1], os.Args[2:]); err != nil {
+			logger.Error("Command failed", "command", os.Args[1], "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// setup database
-	db, err := db.New(cfg.DatabaseURL, logger)
+	db, err := dbpkg.New(cfg.DatabaseURL, logger)
 	if err != nil {
 		logger.Error("Failed to connect to db", "error", err)
 		os.Exit(1)
 	}
 	defer db.Close()
 
+	// bring the schema up to date
+	if cfg.AutoMigrate {
+		if _, err := dbpkg.MigrateUp(context.Background(), db, logger); err != nil {
+			logger.Error("Failed to apply migrations", "error", err)
+			os.Exit(1)
+		}
+	}
+
 	// http server
 	router := gin.Default()
 	server := &http.Server{
@@ -39,23 +61,94 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
-	// register middlewares
+	secret, err := tokenSecret(cfg, logger)
+	if err != nil {
+		logger.Error("Failed to load token secret", "error", err)
+		os.Exit(1)
+	}
+	digestKey, err := auditSecret(cfg, logger)
+	if err != nil {
+		logger.Error("Failed to load audit secret", "error", err)
+		os.Exit(1)
+	}
+
+	mail, closeMail, err := newMailer(cfg, logger)
+	if err != nil {
+		logger.Error("Failed to set up mailer", "error", err)
+		os.Exit(1)
+	}
+	defer closeMail()
 
 	// create repositories
 	userRepo := repository.NewUserRepository(db, logger)
 	accountRepo := repository.NewAccountRepository(db, logger)
 	transactionRepo := repository.NewTransactionRepository(db, logger)
+	fxRateRepo := repository.NewFXRateRepository(db, logger)
+	idempotencyRepo := repository.NewIdempotencyRepository(db, logger)
+	holdRepo := repository.NewHoldRepository(db, logger)
+	standingOrderRepo := repository.NewStandingOrderRepository(db, logger)
+	interestRepo := repository.NewInterestRepository(db, logger)
+	feeRepo := repository.NewFeeRepository(db, logger)
+	paymentBatchRepo := repository.NewPaymentBatchRepository(db, logger)
+	apiKeyRepo := repository.NewAPIKeyRepository(db, logger)
+	accessDenialRepo := repository.NewAccessDenialRepository(db, logger)
+	credentialRepo := repository.NewCredentialRepository(db, logger)
+	sessionRepo := repository.NewSessionRepository(db, logger)
+	userTokenRepo := repository.NewUserTokenRepository(db, logger)
+	auditRepo := repository.NewAuditRepository(db, logger)
 
 	// create handlers
-	userHandler := handlers.NewUserHandler(userRepo, logger)
-	accountHandler := handlers.NewAccountHandler(accountRepo, logger)
-	transactionHandler := handlers.NewTransactionHandler(transactionRepo, accountRepo, logger)
+	verificationHandler := handlers.NewVerificationHandler(userRepo, userTokenRepo, mail, cfg.EmailVerificationTTL, cfg.PasswordResetTTL, logger)
+	userHandler := handlers.NewUserHandler(userRepo, verificationHandler, logger)
+	accountHandler := handlers.NewAccountHandler(accountRepo, transactionRepo, userRepo, logger)
+	transactionHandler := handlers.NewTransactionHandler(transactionRepo, accountRepo, fxRateRepo, idempotencyRepo, holdRepo, feeRepo, paymentBatchRepo, logger)
+	fxRateHandler := handlers.NewFXRateHandler(fxRateRepo, logger)
+	reportHandler := handlers.NewReportHandler(transactionRepo, logger)
+	ledgerHandler := handlers.NewLedgerHandler(transactionRepo, logger)
+	standingOrderHandler := handlers.NewStandingOrderHandler(standingOrderRepo, transactionHandler, logger)
+	interestHandler := handlers.NewInterestHandler(interestRepo, accountRepo, logger)
+	feeHandler := handlers.NewFeeHandler(feeRepo, logger)
+	authHandler := handlers.NewAuthHandler(apiKeyRepo, userRepo, sessionRepo, secret, cfg.TokenTTL, cfg.APIKeyRotationGrace, logger)
+	sessionHandler := handlers.NewSessionHandler(userRepo, credentialRepo, sessionRepo, secret, cfg.SessionTTL, cfg.LoginMaxAttempts, cfg.LoginLockout, logger)
+	accessHandler := handlers.NewAccessHandler(accessDenialRepo, logger)
+	auditHandler := handlers.NewAuditHandler(auditRepo, digestKey, logger)
+
+	// register middlewares
+	router.Use(auditHandler.Record, authHandler.Authenticate, accessHandler.Authorize)
+
+	// chain the transactions recorded before the ledger chain existed
+	if sealed, err := transactionRepo.SealLedger(context.Background()); err != nil {
+		logger.Error("Failed to seal ledger", "error", err)
+		os.Exit(1)
+	} else if sealed > 0 {
+		logger.Info("Sealed existing transactions into the ledger chain", "count", sealed)
+	}
 
 	// register handlers here
 	handlers.RegisterPingHandler(router, logger)
+	handlers.RegisterAuthHandlers(authHandler, router, logger)
+	handlers.RegisterSessionHandlers(sessionHandler, router, logger)
+	handlers.RegisterVerificationHandlers(verificationHandler, router, logger)
 	handlers.RegisterUserHandlers(userHandler, router, logger)
 	handlers.RegisterAccountHandlers(accountHandler, router, logger)
 	handlers.RegisterTransactionHandlers(transactionHandler, router, logger)
+	handlers.RegisterReportHandlers(reportHandler, router, logger)
+	handlers.RegisterFXRateHandlers(fxRateHandler, router, logger)
+	handlers.RegisterLedgerHandlers(ledgerHandler, router, logger)
+	handlers.RegisterStandingOrderHandlers(standingOrderHandler, router, logger)
+	handlers.RegisterInterestHandlers(interestHandler, router, logger)
+	handlers.RegisterFeeHandlers(feeHandler, router, logger)
+	handlers.RegisterAuditHandlers(auditHandler, router, logger)
+
+	// start background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	jobs.Start(jobsCtx, logger,
+		jobs.NewBalanceCheckpointJob(transactionRepo, cfg.CheckpointInterval, logger),
+		jobs.NewHoldExpiryJob(holdRepo, cfg.HoldExpiryInterval, logger),
+		jobs.NewArrearsJob(transactionRepo, cfg.ArrearsInterval, cfg.ArrearsGrace, logger),
+		jobs.NewInterestJob(transactionRepo, cfg.InterestInterval, logger),
+		jobs.NewStandingOrderJob(standingOrderRepo, transactionRepo, transactionHandler, cfg.StandingOrderInterval, cfg.StandingOrderMaxAttempts, cfg.StandingOrderRetryDelay, logger),
+	)
 
 	// start server in background
 	go func() {
@@ -67,11 +160,75 @@ func main() {
 	}()
 
 	// monitor signal interrupts
-	cleanup(server, logger)
+	cleanup(server, stopJobs, logger)
+
+}
+
+// minTokenSecretLength is the shortest token secret accepted, matching the size of the HMAC-SHA256 key
+const minTokenSecretLength = 32
+
+// tokenSecret returns the configured secret bearer tokens are signed with. Local development falls back to a random secret, so tokens do not outlive the process
+func tokenSecret(cfg *config.Config, logger *slog.Logger) ([]byte, error) {
+	if cfg.TokenSecret != "" {
+		if len(cfg.TokenSecret) < minTokenSecretLength {
+			return nil, fmt.Errorf("JWT_SECRET must be at least %d bytes", minTokenSecretLength)
+		}
+		return []byte(cfg.TokenSecret), nil
+	}
+	if cfg.Env != config.DEV {
+		return nil, errors.New("JWT_SECRET is required")
+	}
+
+	logger.Warn("JWT_SECRET is not set. Signing bearer tokens with a random secret")
+	secret := make([]byte, minTokenSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// devAuditSecret keys the audit digests in local development when AUDIT_SECRET is not set. It is fixed so that digests stay checkable across restarts, and
+// public in this source, so anyone can forge the digests it keys. It is never used outside DEV
+const devAuditSecret = "sgbank local development audit secret"
 
+// auditSecret returns the configured secret the audit log digests are keyed with. It is kept apart from JWT_SECRET so that rotating the token secret leaves
+// recorded digests verifiable
+func auditSecret(cfg *config.Config, logger *slog.Logger) ([]byte, error) {
+	if cfg.AuditSecret != "" {
+		if len(cfg.AuditSecret) < minTokenSecretLength {
+			return nil, fmt.Errorf("AUDIT_SECRET must be at least %d bytes", minTokenSecretLength)
+		}
+		return []byte(cfg.AuditSecret), nil
+	}
+	if cfg.Env != config.DEV {
+		return nil, errors.New("AUDIT_SECRET is required")
+	}
+
+	logger.Warn("AUDIT_SECRET is not set. Keying audit digests with the local development secret")
+	return []byte(devAuditSecret), nil
+}
+
+// newMailer returns the mailer of the configured transport along with a function releasing it. Emails are only delivered through smtp; the file and stdout
+// transports are meant for local development and tests
+func newMailer(cfg *config.Config, logger *slog.Logger) (mailer.Mailer, func(), error) {
+	switch cfg.MailTransport {
+	case "smtp":
+		return mailer.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), func() {}, nil
+	case "file":
+		f, err := os.OpenFile(cfg.MailFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, nil, err
+		}
+		logger.Warn("Emails are written to a file and not delivered", "file", cfg.MailFile)
+		return mailer.NewFileMailer(f, cfg.MailFrom), func() { f.Close() }, nil
+	case "stdout":
+		logger.Warn("Emails are written to stdout and not delivered")
+		return mailer.NewFileMailer(os.Stdout, cfg.MailFrom), func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", cfg.MailTransport)
 }
 
-func cleanup(server *http.Server, logger *slog.Logger) {
+func cleanup(server *http.Server, stopJobs context.CancelFunc, logger *slog.Logger) {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
 	// timeout graceful shutdown
@@ -80,6 +237,7 @@ func cleanup(server *http.Server, logger *slog.Logger) {
 
 	<-sigCh
 	logger.Warn("Received interrupt. Shutting down...")
+	stopJobs()
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Error("failed to shutdown server", "error", err)
 		os.Exit(1)
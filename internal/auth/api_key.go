@@ -0,0 +1,14 @@
+package auth
+
+// APIKeyPrefix starts every key issued by the server, so that leaked keys are easy to scan for
+const APIKeyPrefix = "sgb_"
+
+// GenerateAPIKey returns a new random API key along with the start of it that tells it apart in listings. Keys are stored by their HashSecret
+func GenerateAPIKey() (key, prefix string, err error) {
+	secret, err := randomSecret()
+	if err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + secret
+	return key, key[:len(APIKeyPrefix)+8], nil
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// errors
+var (
+	ErrInvalidPasswordHash = errors.New("password hash is malformed")
+)
+
+// Argon2id parameters of new password hashes, following the OWASP recommendation of 19 MiB of memory and 2 passes. Stored hashes carry their own parameters, so
+// these may be raised without invalidating existing passwords
+const (
+	passwordTime    = 2
+	passwordMemory  = 19 * 1024
+	passwordThreads = 1
+	passwordSaltLen = 16
+	passwordKeyLen  = 32
+)
+
+// dummyPasswordHash is verified against when a login names an unknown user, so that the response time does not reveal which emails are registered
+var dummyPasswordHash, _ = HashPassword("sgbank dummy password")
+
+// HashPassword returns the Argon2id hash of a password in the PHC string format, eg: $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, passwordTime, passwordMemory, passwordThreads, passwordKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, passwordMemory, passwordTime, passwordThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword reports whether the password matches the hash. An empty hash, as held by users without a password, matches nothing but takes as long to check
+func VerifyPassword(password, hash string) (bool, error) {
+	if hash == "" {
+		_, err := VerifyPassword(password, dummyPasswordHash)
+		return false, err
+	}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, ErrInvalidPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrInvalidPasswordHash
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || time == 0 || threads == 0 {
+		return false, ErrInvalidPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrInvalidPasswordHash
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false, ErrInvalidPasswordHash
+	}
+
+	got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
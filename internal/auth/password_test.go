@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("HashPassword() = %q, want the PHC format with the default parameters", hash)
+	}
+
+	// every hash is salted
+	again, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if again == hash {
+		t.Error("HashPassword() returned the same hash twice")
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	const password = "correct horse battery staple"
+	hash, err := HashPassword(password)
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	parts := strings.Split(hash, "$")
+	// with replaces a part of the hash
+	with := func(i int, part string) string {
+		changed := append([]string(nil), parts...)
+		changed[i] = part
+		return strings.Join(changed, "$")
+	}
+
+	// a hash made before the parameters were raised
+	salt := []byte("saltsaltsaltsalt")
+	lighter := "$argon2id$v=19$m=64,t=1,p=1$" + base64.RawStdEncoding.EncodeToString(salt) + "$" +
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte(password), salt, 1, 64, 1, 16))
+
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+		want     bool
+		err      error
+	}{
+		{"right password", password, hash, true, nil},
+		{"wrong password", "correct horse battery stapler", hash, false, nil},
+		{"empty password", "", hash, false, nil},
+		// stored hashes keep their own parameters
+		{"other parameters", password, lighter, true, nil},
+		{"no password set", password, "", false, nil},
+		{"truncated", password, hash[:len(hash)/2], false, ErrInvalidPasswordHash},
+		{"other algorithm", password, with(1, "argon2i"), false, ErrInvalidPasswordHash},
+		{"other version", password, with(2, "v=16"), false, ErrInvalidPasswordHash},
+		{"no passes", password, with(3, "m=19456,t=0,p=1"), false, ErrInvalidPasswordHash},
+		{"no threads", password, with(3, "m=19456,t=2,p=0"), false, ErrInvalidPasswordHash},
+		{"parameters not numbers", password, with(3, "m=a,t=b,p=c"), false, ErrInvalidPasswordHash},
+		{"salt not base64", password, with(4, "!!!"), false, ErrInvalidPasswordHash},
+		{"key not base64", password, with(5, "!!!"), false, ErrInvalidPasswordHash},
+		{"empty key", password, with(5, ""), false, ErrInvalidPasswordHash},
+		{"bcrypt hash", password, "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", false, ErrInvalidPasswordHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyPassword(tt.password, tt.hash)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("VerifyPassword() error = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("VerifyPassword() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
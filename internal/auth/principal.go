@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// Method is how the caller of a request authenticated
+type Method string
+
+const (
+	API_KEY      Method = "api_key"
+	BEARER_TOKEN Method = "bearer_token"
+	SESSION      Method = "session"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID uuid.UUID
+	// Role is read from the user on every request, so role changes apply at once
+	Role   models.Role
+	Method Method
+	// CredentialID is the id of the session the request was made with, or of the API key it was made with directly or through a bearer token
+	CredentialID string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the context carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal carried by the context. Work started by the server itself, such as background jobs and commands, carries none
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// randomSecret returns 256 random bits, base64url encoded. It backs api keys and the tokens emailed to users
+func randomSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// HashSecret returns the hex SHA-256 hash a random secret, such as an api key or an emailed token, is stored and looked up by. Secrets carry at least 80 random
+// bits, too many to guess, so a fast unsalted hash is enough
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errors
+var (
+	ErrInvalidToken = errors.New("token is malformed or its signature is invalid")
+	ErrTokenExpired = errors.New("token has expired")
+)
+
+// Issuer is the iss claim of every token signed by the server
+const Issuer = "sgbank"
+
+// tokenHeader is the encoded JOSE header of every token. Only HS256 is signed and accepted
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Claims are the registered JWT claims of a token. Subject is the id of the user the token was issued to
+type Claims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+	// SessionID is the id of the server-side session of a token issued at login, which is checked on every request so that the token can be revoked
+	SessionID string `json:"sid,omitempty"`
+	// KeyID is the id of the api key a token was exchanged for, which is checked on every request so that revoking or rotating the key ends the token too
+	KeyID string `json:"kid,omitempty"`
+}
+
+// NewClaims creates the claims of a token for the user that expires after the given duration
+func NewClaims(userID uuid.UUID, ttl time.Duration, now time.Time) Claims {
+	return Claims{
+		Issuer:    Issuer,
+		Subject:   userID.String(),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		ID:        uuid.NewString(),
+	}
+}
+
+// SignToken encodes the claims as a JWT signed with HMAC-SHA256
+func SignToken(secret []byte, claims Claims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sign(secret, unsigned)), nil
+}
+
+// ParseToken verifies the signature, issuer and expiry of a token and returns its claims. Tokens signed with any algorithm other than HS256 are rejected
+func ParseToken(secret []byte, token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil || header.Algorithm != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, sign(secret, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Issuer != Issuer || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+func sign(secret []byte, unsigned string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
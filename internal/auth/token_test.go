@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseToken(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	userID := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+
+	claims := NewClaims(userID, 15*time.Minute, now)
+	claims.KeyID = "22222222-2222-2222-2222-222222222222"
+	signed := mustSign(t, secret, claims)
+	header, payload, signature := splitToken(t, signed)
+
+	// withHeader re-signs the payload under another JOSE header
+	withHeader := func(raw string) string {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(raw))
+		return encoded + "." + payload + "." + base64.RawURLEncoding.EncodeToString(sign(secret, encoded+"."+payload))
+	}
+	// withClaims signs other claims under the usual header
+	withClaims := func(change func(*Claims)) string {
+		c := claims
+		change(&c)
+		return mustSign(t, secret, c)
+	}
+	// flip changes the first character of an encoded part, which carries no padding bits
+	flip := func(part string) string {
+		replacement := "A"
+		if part[0] == 'A' {
+			replacement = "B"
+		}
+		return replacement + part[1:]
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		err   error
+	}{
+		{"valid", signed, now, nil},
+		{"valid until expiry", signed, now.Add(15*time.Minute - time.Second), nil},
+		{"expired", signed, now.Add(15 * time.Minute), ErrTokenExpired},
+		{"long expired", signed, now.Add(24 * time.Hour), ErrTokenExpired},
+		{"alg none", withHeader(`{"alg":"none","typ":"JWT"}`), now, ErrInvalidToken},
+		{"alg none unsigned", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + payload + ".", now, ErrInvalidToken},
+		{"alg HS512", withHeader(`{"alg":"HS512","typ":"JWT"}`), now, ErrInvalidToken},
+		{"alg RS256", withHeader(`{"alg":"RS256","typ":"JWT"}`), now, ErrInvalidToken},
+		{"header without alg", withHeader(`{"typ":"JWT"}`), now, ErrInvalidToken},
+		{"tampered signature", header + "." + payload + "." + flip(signature), now, ErrInvalidToken},
+		{"tampered payload", header + "." + flip(payload) + "." + signature, now, ErrInvalidToken},
+		{"claims from another token", header + "." + splitPayload(t, withClaims(func(c *Claims) { c.Subject = uuid.NewString() })) + "." + signature, now, ErrInvalidToken},
+		{"signed with another secret", mustSign(t, []byte("other-secret"), claims), now, ErrInvalidToken},
+		{"foreign issuer", withClaims(func(c *Claims) { c.Issuer = "someone-else" }), now, ErrInvalidToken},
+		{"no subject", withClaims(func(c *Claims) { c.Subject = "" }), now, ErrInvalidToken},
+		{"two parts", header + "." + payload, now, ErrInvalidToken},
+		{"four parts", signed + "." + signature, now, ErrInvalidToken},
+		{"not base64", "!!!." + payload + "." + signature, now, ErrInvalidToken},
+		{"empty", "", now, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseToken(secret, tt.token, tt.now)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseToken() error = %v, want %v", err, tt.err)
+			}
+			if err == nil && *got != claims {
+				t.Errorf("ParseToken() = %+v, want %+v", *got, claims)
+			}
+		})
+	}
+}
+
+func TestHashSecret(t *testing.T) {
+	// keys are looked up by this hash, so changing it locks every issued key out
+	if got, want := HashSecret("sgb_example"), "af6ffed8b5db67cc440859b223abdc6329a94673f2f8abaae0913ec7784f809e"; got != want {
+		t.Errorf("HashSecret() = %s, want %s", got, want)
+	}
+
+	key, prefix, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey() error = %v", err)
+	}
+	other, _, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		matching bool
+	}{
+		{"same key", key, true},
+		{"another key", other, false},
+		{"prefix only", prefix, false},
+		{"trailing whitespace", key + " ", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if matching := HashSecret(tt.key) == HashSecret(key); matching != tt.matching {
+				t.Errorf("hash of %q matches the key's = %v, want %v", tt.key, matching, tt.matching)
+			}
+		})
+	}
+
+	if !strings.HasPrefix(key, APIKeyPrefix) || !strings.HasPrefix(key, prefix) || len(prefix) != len(APIKeyPrefix)+8 {
+		t.Errorf("GenerateAPIKey() = %q with prefix %q", key, prefix)
+	}
+}
+
+func splitToken(t *testing.T, token string) (header, payload, signature string) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token %q has %d parts", token, len(parts))
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+func splitPayload(t *testing.T, token string) string {
+	t.Helper()
+	_, payload, _ := splitToken(t, token)
+	return payload
+}
+
+func mustSign(t *testing.T, secret []byte, claims Claims) string {
+	t.Helper()
+	token, err := SignToken(secret, claims)
+	if err != nil {
+		t.Fatalf("SignToken() error = %v", err)
+	}
+	return token
+}
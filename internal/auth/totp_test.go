@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 test vectors, "12345678901234567890", base32 encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B lists 8 digit codes. Codes of 6 digits are their last 6, as both are the same value modulo a power of ten
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	key := []byte("12345678901234567890")
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	// the code of 1111111111 belongs to step 37037037, which runs from 1111111110 to 1111111139
+	const code, step = "050471", 37037037
+	at := func(unix int64) time.Time { return time.Unix(unix, 0) }
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		now      time.Time
+		lastStep int64
+		want     bool
+	}{
+		{"current step", rfc6238Secret, code, at(1111111111), 0, true},
+		{"lower case secret", strings.ToLower(rfc6238Secret), code, at(1111111111), 0, true},
+		{"clock behind by a step", rfc6238Secret, code, at(1111111111 + totpPeriod), 0, true},
+		{"clock ahead by a step", rfc6238Secret, code, at(1111111111 - totpPeriod), 0, true},
+		{"clock behind by two steps", rfc6238Secret, code, at(1111111111 + 2*totpPeriod), 0, false},
+		{"clock ahead by two steps", rfc6238Secret, code, at(1111111111 - 2*totpPeriod), 0, false},
+		{"step already used", rfc6238Secret, code, at(1111111111), step, false},
+		{"later step already used", rfc6238Secret, code, at(1111111111 + totpPeriod), step + 1, false},
+		{"earlier step used", rfc6238Secret, code, at(1111111111), step - 1, true},
+		{"wrong code", rfc6238Secret, "050472", at(1111111111), 0, false},
+		{"short code", rfc6238Secret, "50471", at(1111111111), 0, false},
+		{"invalid secret", "not base32!", code, at(1111111111), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(tt.secret, tt.code, tt.now, tt.lastStep)
+			if ok != tt.want {
+				t.Fatalf("ValidateTOTP() = %d, %v, want %v", got, ok, tt.want)
+			}
+			if ok && got != step {
+				t.Errorf("ValidateTOTP() step = %d, want %d", got, step)
+			}
+		})
+	}
+
+	// the step returned is the one to pass as lastStep, after which the same code is refused
+	now := at(1111111111)
+	used, ok := ValidateTOTP(rfc6238Secret, code, now, 0)
+	if !ok {
+		t.Fatal("ValidateTOTP() rejected a valid code")
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, code, now, used); ok {
+		t.Error("ValidateTOTP() accepted a code twice")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes() error = %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	hashes := make(map[string]bool)
+	for _, code := range codes {
+		if groups := strings.Split(code, "-"); len(groups) != 4 || len(code) != 19 {
+			t.Errorf("code %q is not four groups of four characters", code)
+		}
+		hashes[HashRecoveryCode(code)] = true
+	}
+	if len(hashes) != len(codes) {
+		t.Errorf("got %d distinct hashes for %d codes", len(hashes), len(codes))
+	}
+
+	// a code may be typed in another case, without its dashes or with spaces
+	code := codes[0]
+	for _, typed := range []string{strings.ToUpper(code), strings.ReplaceAll(code, "-", ""), strings.ReplaceAll(code, "-", " ")} {
+		if HashRecoveryCode(typed) != HashRecoveryCode(code) {
+			t.Errorf("HashRecoveryCode(%q) differs from the hash of %q", typed, code)
+		}
+	}
+	if HashRecoveryCode(codes[1]) == HashRecoveryCode(code) {
+		t.Error("two codes hash alike")
+	}
+}
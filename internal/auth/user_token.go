@@ -0,0 +1,7 @@
+package auth
+
+// GenerateUserToken returns a new random single use token to email to a user, such as to verify their email or reset their password. Tokens are stored by their
+// HashSecret
+func GenerateUserToken() (string, error) {
+	return randomSecret()
+}
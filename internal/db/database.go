@@ -7,6 +7,7 @@ import (
 	_ "github.com/lib/pq"
 )
 
+// New connects to the database. The schema is managed separately through versioned migrations, see MigrateUp
 func New(connStr string, logger *slog.Logger) (*sql.DB, error) {
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
@@ -23,60 +24,5 @@ func New(connStr string, logger *slog.Logger) (*sql.DB, error) {
 
 	logger.Debug("connected to database successfully")
 
-	// migrations setup
-	migration_queries := `
-		-- users--
-		CREATE TABLE IF NOT EXISTS users (
-			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-			email VARCHAR(255) UNIQUE NOT NULL,
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-		);
-
-		-- add default root user. skip if exists --
-		INSERT INTO users (id, email)
-		VALUES ('00000000-0000-0000-0000-000000000000', 'internal@sgbank.com')
-		ON CONFLICT (id) DO NOTHING;
-
-		-- add default root account. skip if exists --
-		INSERT INTO accounts (account_number, user_id)
-		VALUES ('0000000000', '00000000-0000-0000-0000-000000000000')
-		ON CONFLICT (account_number) DO NOTHING;
-
-		-- accounts --
-		CREATE TABLE IF NOT EXISTS accounts (
-			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-			account_number VARCHAR(12) UNIQUE NOT NULL,
-			user_id UUID NOT NULL REFERENCES users(id),
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-			deleted_at TIMESTAMPTZ
-		);
-
-		-- transactions --
-		-- TODO: block updates on transactions --
-		CREATE TABLE IF NOT EXISTS transactions (
-			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-			reference VARCHAR(255) UNIQUE NOT NULL,
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-		);
-
-		-- transaction lines --
-		-- TODO: block updates on transaction lines --
-		CREATE TABLE IF NOT EXISTS transaction_lines (
-			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-			account_id UUID NOT NULL REFERENCES accounts(id),
-			transaction_id UUID NOT NULL REFERENCES transactions(id),
-			purpose VARCHAR(50) NOT NULL,
-			amount VARCHAR(50) NOT NULL,
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-			UNIQUE(account_id, transaction_id)
-		);
-	`
-
-	if _, err := db.Exec(migration_queries); err != nil {
-		return nil, err
-	}
-
 	return db, nil
 }
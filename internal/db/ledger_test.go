@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestTransactionLinesOpenOnlyToCreator checks that lines can be added to a transaction by the database transaction that created it, whatever its
+// created_at and whether it was created under a savepoint, and by no other. It runs against the postgres database in TEST_DATABASE_URL, in a schema of its own that is dropped afterwards
+func TestTransactionLinesOpenOnlyToCreator(t *testing.T) {
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db, _ := openTestSchema(t, connStr, logger)
+	if _, err := MigrateUp(ctx, db, logger); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+
+	var rootID string
+	if err := db.QueryRowContext(ctx, `SELECT id FROM accounts WHERE account_number = '0000000000'`).Scan(&rootID); err != nil {
+		t.Fatalf("read root account: %v", err)
+	}
+
+	post := func(tx *sql.Tx, transactionID string) error {
+		query := `INSERT INTO transaction_lines (account_id, transaction_id, purpose, amount, currency) VALUES ($1, $2, 'debit', 100, 'USD'), ($1, $2, 'credit', 100, 'USD')`
+		_, err := tx.ExecContext(ctx, query, rootID, transactionID)
+		return err
+	}
+
+	// a backfilled transaction keeps its original timestamp and still takes its lines
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	var transactionID string
+	backdated := time.Date(2020, 1, 31, 9, 0, 0, 0, time.UTC)
+	if err := tx.QueryRowContext(ctx, `INSERT INTO transactions (reference, created_at) VALUES ('backfill-1', $1) RETURNING id`, backdated).Scan(&transactionID); err != nil {
+		t.Fatalf("insert transaction: %v", err)
+	}
+	if err := post(tx, transactionID); err != nil {
+		t.Fatalf("add lines to a backdated transaction: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	// a transaction inserted under a released savepoint stays open to the rest of its database transaction
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	var savedID string
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT posting`); err != nil {
+		t.Fatalf("savepoint: %v", err)
+	}
+	if err := tx.QueryRowContext(ctx, `INSERT INTO transactions (reference) VALUES ('savepoint-1') RETURNING id`).Scan(&savedID); err != nil {
+		t.Fatalf("insert transaction: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT posting`); err != nil {
+		t.Fatalf("release savepoint: %v", err)
+	}
+	if err := post(tx, savedID); err != nil {
+		t.Fatalf("add lines to a transaction created under a savepoint: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	// once committed, no later database transaction may add to it
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+	if err := post(tx, transactionID); err == nil {
+		t.Error("lines were added to a committed transaction")
+	}
+}
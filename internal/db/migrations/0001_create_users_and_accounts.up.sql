@@ -0,0 +1,29 @@
+-- users --
+CREATE TABLE IF NOT EXISTS users (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	email VARCHAR(255) UNIQUE NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- accounts --
+CREATE TABLE IF NOT EXISTS accounts (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	account_number VARCHAR(12) UNIQUE NOT NULL,
+	user_id UUID NOT NULL REFERENCES users(id),
+	class VARCHAR(20) NOT NULL DEFAULT 'liability' CHECK (class IN ('asset', 'liability', 'equity', 'revenue', 'expense')),
+	currency CHAR(3) NOT NULL DEFAULT 'USD',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	deleted_at TIMESTAMPTZ
+);
+
+-- add default root user. skip if exists --
+INSERT INTO users (id, email)
+VALUES ('00000000-0000-0000-0000-000000000000', 'internal@sgbank.com')
+ON CONFLICT (id) DO NOTHING;
+
+-- add default root account. skip if exists --
+INSERT INTO accounts (account_number, user_id, class)
+VALUES ('0000000000', '00000000-0000-0000-0000-000000000000', 'asset')
+ON CONFLICT (account_number) DO NOTHING;
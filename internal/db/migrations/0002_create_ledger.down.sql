@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS transaction_lines;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS fx_rates;
@@ -0,0 +1,39 @@
+-- fx rates --
+CREATE TABLE IF NOT EXISTS fx_rates (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	base_currency CHAR(3) NOT NULL,
+	quote_currency CHAR(3) NOT NULL,
+	rate NUMERIC(24, 12) NOT NULL CHECK (rate > 0),
+	effective_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	CHECK (base_currency <> quote_currency)
+);
+CREATE INDEX IF NOT EXISTS fx_rates_pair_effective_at_idx ON fx_rates (base_currency, quote_currency, effective_at DESC);
+
+-- transactions --
+CREATE TABLE IF NOT EXISTS transactions (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	reference VARCHAR(255) UNIQUE NOT NULL,
+	fx_rate_id UUID REFERENCES fx_rates(id),
+	reversal_of UUID REFERENCES transactions(id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS transactions_reversal_of_idx ON transactions (reversal_of) WHERE reversal_of IS NOT NULL;
+
+-- transaction lines --
+CREATE TABLE IF NOT EXISTS transaction_lines (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	account_id UUID NOT NULL REFERENCES accounts(id),
+	transaction_id UUID NOT NULL REFERENCES transactions(id),
+	purpose VARCHAR(50) NOT NULL CHECK (purpose IN ('debit', 'credit')),
+	-- amounts are stored in the minor units of the line currency --
+	amount BIGINT NOT NULL CHECK (amount > 0),
+	currency CHAR(3) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- multi-leg entries may touch an account more than once (eg: fee + principal) --
+ALTER TABLE transaction_lines DROP CONSTRAINT IF EXISTS transaction_lines_account_id_transaction_id_key;
+CREATE INDEX IF NOT EXISTS transaction_lines_account_id_idx ON transaction_lines (account_id);
+CREATE INDEX IF NOT EXISTS transaction_lines_transaction_id_idx ON transaction_lines (transaction_id);
+CREATE INDEX IF NOT EXISTS transaction_lines_account_id_created_at_idx ON transaction_lines (account_id, created_at);
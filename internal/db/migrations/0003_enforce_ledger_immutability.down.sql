@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS transaction_lines_balanced ON transaction_lines;
+DROP TRIGGER IF EXISTS transactions_balanced ON transactions;
+DROP FUNCTION IF EXISTS check_transaction_balanced();
+
+DROP TRIGGER IF EXISTS transaction_lines_no_truncate ON transaction_lines;
+DROP TRIGGER IF EXISTS transaction_lines_immutable ON transaction_lines;
+DROP TRIGGER IF EXISTS transactions_no_truncate ON transactions;
+DROP TRIGGER IF EXISTS transactions_immutable ON transactions;
+DROP FUNCTION IF EXISTS reject_ledger_mutation();
@@ -0,0 +1,75 @@
+-- ledger immutability. transactions and their lines are append-only, corrections are posted as reversals --
+CREATE OR REPLACE FUNCTION reject_ledger_mutation() RETURNS TRIGGER AS $$
+BEGIN
+	RAISE EXCEPTION '% is append-only, % is not allowed', TG_TABLE_NAME, TG_OP
+	USING ERRCODE = 'restrict_violation';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS transactions_immutable ON transactions;
+CREATE TRIGGER transactions_immutable
+BEFORE UPDATE OR DELETE ON transactions
+FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+DROP TRIGGER IF EXISTS transactions_no_truncate ON transactions;
+CREATE TRIGGER transactions_no_truncate
+BEFORE TRUNCATE ON transactions
+FOR EACH STATEMENT EXECUTE FUNCTION reject_ledger_mutation();
+
+DROP TRIGGER IF EXISTS transaction_lines_immutable ON transaction_lines;
+CREATE TRIGGER transaction_lines_immutable
+BEFORE UPDATE OR DELETE ON transaction_lines
+FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+DROP TRIGGER IF EXISTS transaction_lines_no_truncate ON transaction_lines;
+CREATE TRIGGER transaction_lines_no_truncate
+BEFORE TRUNCATE ON transaction_lines
+FOR EACH STATEMENT EXECUTE FUNCTION reject_ledger_mutation();
+
+-- double-entry invariant. checked at commit so that every line of a transaction is in place before it runs --
+CREATE OR REPLACE FUNCTION check_transaction_balanced() RETURNS TRIGGER AS $$
+DECLARE
+	txn_id UUID;
+	unbalanced RECORD;
+BEGIN
+	IF TG_TABLE_NAME = 'transactions' THEN
+		txn_id := NEW.id;
+	ELSE
+		txn_id := NEW.transaction_id;
+	END IF;
+
+	IF (SELECT COUNT(*) FROM transaction_lines WHERE transaction_id = txn_id) < 2 THEN
+		RAISE EXCEPTION 'transaction % must have at least two lines', txn_id
+		USING ERRCODE = 'check_violation';
+	END IF;
+
+	SELECT
+	currency,
+	COALESCE(SUM(CASE WHEN purpose = 'debit' THEN amount END), 0) AS debits,
+	COALESCE(SUM(CASE WHEN purpose = 'credit' THEN amount END), 0) AS credits
+	INTO unbalanced
+	FROM transaction_lines
+	WHERE transaction_id = txn_id
+	GROUP BY currency
+	HAVING COALESCE(SUM(CASE WHEN purpose = 'debit' THEN amount END), 0) <> COALESCE(SUM(CASE WHEN purpose = 'credit' THEN amount END), 0)
+	LIMIT 1;
+
+	IF FOUND THEN
+		RAISE EXCEPTION 'transaction % is unbalanced in %: debits % credits %', txn_id, unbalanced.currency, unbalanced.debits, unbalanced.credits
+		USING ERRCODE = 'check_violation';
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS transactions_balanced ON transactions;
+CREATE CONSTRAINT TRIGGER transactions_balanced
+AFTER INSERT ON transactions
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW EXECUTE FUNCTION check_transaction_balanced();
+
+DROP TRIGGER IF EXISTS transaction_lines_balanced ON transaction_lines;
+CREATE CONSTRAINT TRIGGER transaction_lines_balanced
+AFTER INSERT ON transaction_lines
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW EXECUTE FUNCTION check_transaction_balanced();
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS balance_checkpoints;
+DROP TABLE IF EXISTS account_balances;
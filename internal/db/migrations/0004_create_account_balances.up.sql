@@ -0,0 +1,21 @@
+-- account balances. materialized debit and credit totals per account and currency, updated with every posting --
+CREATE TABLE IF NOT EXISTS account_balances (
+	account_id UUID NOT NULL REFERENCES accounts(id),
+	currency CHAR(3) NOT NULL,
+	debits BIGINT NOT NULL DEFAULT 0 CHECK (debits >= 0),
+	credits BIGINT NOT NULL DEFAULT 0 CHECK (credits >= 0),
+	version BIGINT NOT NULL DEFAULT 0,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (account_id, currency)
+);
+
+-- balance checkpoints. cumulative totals of an account at a point in time, used to answer as-of balance queries without a full scan --
+CREATE TABLE IF NOT EXISTS balance_checkpoints (
+	account_id UUID NOT NULL REFERENCES accounts(id),
+	currency CHAR(3) NOT NULL,
+	as_of TIMESTAMPTZ NOT NULL,
+	debits BIGINT NOT NULL CHECK (debits >= 0),
+	credits BIGINT NOT NULL CHECK (credits >= 0),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (account_id, currency, as_of)
+);
@@ -0,0 +1,8 @@
+-- idempotency keys. the response is stored in the same transaction as the posting it describes --
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key VARCHAR(255) PRIMARY KEY,
+	request_hash CHAR(64) NOT NULL,
+	status_code INT NOT NULL DEFAULT 0,
+	response_body JSONB,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
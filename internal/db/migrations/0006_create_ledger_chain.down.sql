@@ -0,0 +1 @@
+DROP TABLE IF EXISTS ledger_chain;
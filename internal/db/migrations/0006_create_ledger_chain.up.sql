@@ -0,0 +1,18 @@
+-- ledger chain. every transaction is linked to the one before it by a hash covering its content and lines --
+CREATE TABLE IF NOT EXISTS ledger_chain (
+	sequence BIGINT PRIMARY KEY CHECK (sequence > 0),
+	transaction_id UUID UNIQUE NOT NULL REFERENCES transactions(id),
+	prev_hash CHAR(64) NOT NULL,
+	hash CHAR(64) UNIQUE NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+DROP TRIGGER IF EXISTS ledger_chain_immutable ON ledger_chain;
+CREATE TRIGGER ledger_chain_immutable
+BEFORE UPDATE OR DELETE ON ledger_chain
+FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+DROP TRIGGER IF EXISTS ledger_chain_no_truncate ON ledger_chain;
+CREATE TRIGGER ledger_chain_no_truncate
+BEFORE TRUNCATE ON ledger_chain
+FOR EACH STATEMENT EXECUTE FUNCTION reject_ledger_mutation();
@@ -0,0 +1,17 @@
+-- authorization holds. active holds reserve part of an account's balance until they are captured, voided or expire --
+CREATE TABLE IF NOT EXISTS holds (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	reference VARCHAR(255) UNIQUE NOT NULL,
+	account_id UUID NOT NULL REFERENCES accounts(id),
+	recipient_id UUID NOT NULL REFERENCES accounts(id),
+	amount BIGINT NOT NULL CHECK (amount > 0),
+	captured_amount BIGINT NOT NULL DEFAULT 0 CHECK (captured_amount >= 0 AND captured_amount <= amount),
+	currency CHAR(3) NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'captured', 'voided', 'expired')),
+	transaction_id UUID REFERENCES transactions(id),
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS holds_account_id_active_idx ON holds (account_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS holds_expires_at_active_idx ON holds (expires_at) WHERE status = 'active';
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS account_arrears;
+DROP TABLE IF EXISTS account_limit_changes;
+ALTER TABLE accounts DROP COLUMN IF EXISTS credit_limit;
+ALTER TABLE accounts DROP COLUMN IF EXISTS overdraft_limit;
+ALTER TABLE accounts DROP COLUMN IF EXISTS type;
@@ -0,0 +1,39 @@
+-- account types and limits. deposit accounts may be overdrawn within an approved overdraft limit, credit lines are drawn below zero up to their credit limit --
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS type VARCHAR(20) NOT NULL DEFAULT 'deposit' CHECK (type IN ('deposit', 'credit_line'));
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS overdraft_limit BIGINT NOT NULL DEFAULT 0 CHECK (overdraft_limit >= 0);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS credit_limit BIGINT NOT NULL DEFAULT 0 CHECK (credit_limit >= 0);
+
+-- approval audit trail of limit changes. append-only --
+CREATE TABLE IF NOT EXISTS account_limit_changes (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	account_id UUID NOT NULL REFERENCES accounts(id),
+	kind VARCHAR(20) NOT NULL CHECK (kind IN ('overdraft', 'credit')),
+	previous_limit BIGINT NOT NULL,
+	new_limit BIGINT NOT NULL CHECK (new_limit >= 0),
+	approved_by UUID NOT NULL REFERENCES users(id),
+	reason TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS account_limit_changes_account_id_created_at_idx ON account_limit_changes (account_id, created_at);
+
+DROP TRIGGER IF EXISTS account_limit_changes_immutable ON account_limit_changes;
+CREATE TRIGGER account_limit_changes_immutable
+BEFORE UPDATE OR DELETE ON account_limit_changes
+FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+DROP TRIGGER IF EXISTS account_limit_changes_no_truncate ON account_limit_changes;
+CREATE TRIGGER account_limit_changes_no_truncate
+BEFORE TRUNCATE ON account_limit_changes
+FOR EACH STATEMENT EXECUTE FUNCTION reject_ledger_mutation();
+
+-- customer accounts with a negative balance. flagged once in arrears, removed when back to zero or above --
+CREATE TABLE IF NOT EXISTS account_arrears (
+	account_id UUID PRIMARY KEY REFERENCES accounts(id),
+	currency CHAR(3) NOT NULL,
+	balance BIGINT NOT NULL,
+	limit_amount BIGINT NOT NULL,
+	negative_since TIMESTAMPTZ NOT NULL,
+	reason VARCHAR(20) CHECK (reason IN ('over_limit', 'overdrawn')),
+	flagged_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
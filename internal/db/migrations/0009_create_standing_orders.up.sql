@@ -0,0 +1,38 @@
+-- standing orders. scheduled or recurring transfers between two accounts --
+CREATE TABLE IF NOT EXISTS standing_orders (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	reference VARCHAR(255) UNIQUE NOT NULL,
+	sender VARCHAR(12) NOT NULL REFERENCES accounts(account_number),
+	recipient VARCHAR(12) NOT NULL REFERENCES accounts(account_number),
+	amount BIGINT NOT NULL CHECK (amount > 0),
+	currency CHAR(3),
+	convert_currency BOOLEAN NOT NULL DEFAULT FALSE,
+	schedule_kind VARCHAR(20) NOT NULL CHECK (schedule_kind IN ('once', 'daily', 'weekly', 'monthly', 'last_business_day')),
+	start_date DATE NOT NULL,
+	end_date DATE CHECK (end_date >= start_date),
+	day_of_month SMALLINT CHECK (day_of_month BETWEEN 1 AND 31),
+	status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'cancelled', 'completed')),
+	-- next occurrence that has not been scheduled as a run yet --
+	next_run_on DATE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS standing_orders_sender_idx ON standing_orders (sender);
+CREATE INDEX IF NOT EXISTS standing_orders_recipient_idx ON standing_orders (recipient);
+CREATE INDEX IF NOT EXISTS standing_orders_next_run_on_active_idx ON standing_orders (next_run_on) WHERE status = 'active';
+
+-- one run per order and occurrence, so an occurrence is never posted twice --
+CREATE TABLE IF NOT EXISTS standing_order_runs (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	standing_order_id UUID NOT NULL REFERENCES standing_orders(id),
+	occurrence DATE NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	transaction_id UUID REFERENCES transactions(id),
+	next_attempt_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (standing_order_id, occurrence)
+);
+CREATE INDEX IF NOT EXISTS standing_order_runs_next_attempt_at_pending_idx ON standing_order_runs (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,66 @@
+-- interest products. annual rates accrued daily under a day count convention --
+CREATE TABLE IF NOT EXISTS interest_products (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	name VARCHAR(100) UNIQUE NOT NULL,
+	annual_rate NUMERIC(12, 8) NOT NULL CHECK (annual_rate >= 0),
+	day_count VARCHAR(10) NOT NULL CHECK (day_count IN ('ACT/365', '30/360')),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- enrolment of accounts in an interest product. interest accrues from the day of enrolment --
+CREATE TABLE IF NOT EXISTS account_interest (
+	account_id UUID PRIMARY KEY REFERENCES accounts(id),
+	product_id UUID NOT NULL REFERENCES interest_products(id),
+	enrolled_on DATE NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- interest runs. each day is accrued and each month paid out at most once --
+CREATE TABLE IF NOT EXISTS interest_runs (
+	kind VARCHAR(10) NOT NULL CHECK (kind IN ('accrual', 'payout')),
+	period DATE NOT NULL,
+	accounts INT NOT NULL DEFAULT 0,
+	transaction_id UUID REFERENCES transactions(id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (kind, period)
+);
+
+-- daily interest earned per account on its closing balance. amount is exact, posted is the whole minor units recognised on the ledger --
+CREATE TABLE IF NOT EXISTS interest_accruals (
+	account_id UUID NOT NULL REFERENCES accounts(id),
+	currency CHAR(3) NOT NULL,
+	accrual_date DATE NOT NULL,
+	product_id UUID NOT NULL REFERENCES interest_products(id),
+	balance BIGINT NOT NULL,
+	annual_rate NUMERIC(12, 8) NOT NULL,
+	day_count VARCHAR(10) NOT NULL,
+	days INT NOT NULL,
+	amount NUMERIC(38, 12) NOT NULL CHECK (amount >= 0),
+	posted BIGINT NOT NULL CHECK (posted >= 0),
+	transaction_id UUID REFERENCES transactions(id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (account_id, currency, accrual_date)
+);
+CREATE INDEX IF NOT EXISTS interest_accruals_accrual_date_idx ON interest_accruals (accrual_date);
+
+-- runs and accruals are append-only --
+DROP TRIGGER IF EXISTS interest_runs_immutable ON interest_runs;
+CREATE TRIGGER interest_runs_immutable
+BEFORE UPDATE OR DELETE ON interest_runs
+FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+DROP TRIGGER IF EXISTS interest_runs_no_truncate ON interest_runs;
+CREATE TRIGGER interest_runs_no_truncate
+BEFORE TRUNCATE ON interest_runs
+FOR EACH STATEMENT EXECUTE FUNCTION reject_ledger_mutation();
+
+DROP TRIGGER IF EXISTS interest_accruals_immutable ON interest_accruals;
+CREATE TRIGGER interest_accruals_immutable
+BEFORE UPDATE OR DELETE ON interest_accruals
+FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+DROP TRIGGER IF EXISTS interest_accruals_no_truncate ON interest_accruals;
+CREATE TRIGGER interest_accruals_no_truncate
+BEFORE TRUNCATE ON interest_accruals
+FOR EACH STATEMENT EXECUTE FUNCTION reject_ledger_mutation();
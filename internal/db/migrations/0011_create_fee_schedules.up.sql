@@ -0,0 +1,44 @@
+-- fee schedules. effective-dated rules pricing transfers, optionally scoped to an account type or user --
+CREATE TABLE IF NOT EXISTS fee_schedules (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	name VARCHAR(100) NOT NULL,
+	kind VARCHAR(20) NOT NULL CHECK (kind IN ('flat', 'percentage', 'tiered')),
+	currency CHAR(3) NOT NULL,
+	account_type VARCHAR(20) CHECK (account_type IN ('deposit', 'credit_line')),
+	user_id UUID REFERENCES users(id),
+	flat_amount BIGINT NOT NULL DEFAULT 0 CHECK (flat_amount >= 0),
+	rate NUMERIC(12, 8) CHECK (rate >= 0),
+	min_amount BIGINT CHECK (min_amount >= 0),
+	max_amount BIGINT CHECK (max_amount >= 0 AND max_amount >= min_amount),
+	tiers JSONB NOT NULL DEFAULT '[]',
+	supersedes UUID REFERENCES fee_schedules(id),
+	effective_from TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	effective_to TIMESTAMPTZ CHECK (effective_to > effective_from),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS fee_schedules_currency_effective_from_idx ON fee_schedules (currency, effective_from);
+
+-- fees charged on transfers, posted in the same transaction. append-only --
+CREATE TABLE IF NOT EXISTS transaction_fees (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	transaction_id UUID NOT NULL REFERENCES transactions(id),
+	account_id UUID NOT NULL REFERENCES accounts(id),
+	schedule_id UUID NOT NULL REFERENCES fee_schedules(id),
+	name VARCHAR(100) NOT NULL,
+	amount BIGINT NOT NULL CHECK (amount > 0),
+	currency CHAR(3) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS transaction_fees_transaction_id_idx ON transaction_fees (transaction_id);
+CREATE INDEX IF NOT EXISTS transaction_fees_account_id_created_at_idx ON transaction_fees (account_id, currency, created_at);
+
+DROP TRIGGER IF EXISTS transaction_fees_immutable ON transaction_fees;
+CREATE TRIGGER transaction_fees_immutable
+BEFORE UPDATE OR DELETE ON transaction_fees
+FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+DROP TRIGGER IF EXISTS transaction_fees_no_truncate ON transaction_fees;
+CREATE TRIGGER transaction_fees_no_truncate
+BEFORE TRUNCATE ON transaction_fees
+FOR EACH STATEMENT EXECUTE FUNCTION reject_ledger_mutation();
@@ -0,0 +1,11 @@
+-- payment batches. pain.001 credit transfer files along with their pain.002 status report. A file is processed at most once per message id --
+CREATE TABLE IF NOT EXISTS payment_batches (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	message_id VARCHAR(35) UNIQUE NOT NULL,
+	mode VARCHAR(20) NOT NULL CHECK (mode IN ('atomic', 'per_instruction')),
+	status CHAR(4) NOT NULL DEFAULT 'PDNG' CHECK (status IN ('PDNG', 'ACSC', 'PART', 'RJCT')),
+	-- the instructions of the file with the outcome of each --
+	instructions JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
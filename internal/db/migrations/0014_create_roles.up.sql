@@ -0,0 +1,29 @@
+-- user roles. customers act on their own records, auditors read everything and operators run the bank. The system user is an operator --
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'customer' CHECK (role IN ('customer', 'auditor', 'operator'));
+UPDATE users SET role = 'operator' WHERE id = '00000000-0000-0000-0000-000000000000';
+
+-- requests refused for the role of their caller. append-only --
+CREATE TABLE IF NOT EXISTS access_denials (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID NOT NULL REFERENCES users(id),
+	role VARCHAR(20) NOT NULL,
+	method VARCHAR(10) NOT NULL,
+	route TEXT NOT NULL,
+	path TEXT NOT NULL,
+	permission VARCHAR(50) NOT NULL,
+	reason TEXT NOT NULL,
+	ip_address VARCHAR(45) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS access_denials_user_id_created_at_idx ON access_denials (user_id, created_at);
+CREATE INDEX IF NOT EXISTS access_denials_created_at_idx ON access_denials (created_at);
+
+DROP TRIGGER IF EXISTS access_denials_immutable ON access_denials;
+CREATE TRIGGER access_denials_immutable
+BEFORE UPDATE OR DELETE ON access_denials
+FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+DROP TRIGGER IF EXISTS access_denials_no_truncate ON access_denials;
+CREATE TRIGGER access_denials_no_truncate
+BEFORE TRUNCATE ON access_denials
+FOR EACH STATEMENT EXECUTE FUNCTION reject_ledger_mutation();
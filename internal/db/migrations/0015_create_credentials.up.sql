@@ -0,0 +1,34 @@
+-- password and TOTP credentials of users. passwords are stored as Argon2id hashes in the PHC string format. A TOTP secret is pending until a code confirms it --
+CREATE TABLE IF NOT EXISTS user_credentials (
+	user_id UUID PRIMARY KEY REFERENCES users(id),
+	password_hash TEXT,
+	totp_secret VARCHAR(64),
+	totp_enabled_at TIMESTAMPTZ,
+	totp_last_step BIGINT NOT NULL DEFAULT 0,
+	failed_logins INT NOT NULL DEFAULT 0,
+	locked_until TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- single use recovery codes of the TOTP second factor, stored as SHA-256 hashes --
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID NOT NULL REFERENCES users(id),
+	code_hash CHAR(64) NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (user_id, code_hash)
+);
+
+-- login sessions. bearer tokens issued at login carry the session id and stop working once it is revoked or expired --
+CREATE TABLE IF NOT EXISTS sessions (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID NOT NULL REFERENCES users(id),
+	ip_address VARCHAR(45) NOT NULL,
+	user_agent VARCHAR(255) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	revoked_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
@@ -0,0 +1,15 @@
+-- email verification. existing users verify their email before opening new accounts. The system user has no mailbox and is verified --
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+UPDATE users SET email_verified_at = NOW() WHERE id = '00000000-0000-0000-0000-000000000000' AND email_verified_at IS NULL;
+
+-- single use tokens emailed to users to verify their email or reset their password. Only the SHA-256 hash of a token is stored --
+CREATE TABLE IF NOT EXISTS user_tokens (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID NOT NULL REFERENCES users(id),
+	purpose VARCHAR(20) NOT NULL CHECK (purpose IN ('email_verification', 'password_reset')),
+	token_hash CHAR(64) UNIQUE NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS user_tokens_user_id_purpose_idx ON user_tokens (user_id, purpose);
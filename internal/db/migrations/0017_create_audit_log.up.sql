@@ -0,0 +1,32 @@
+-- every state-changing api call: who made it, with which credentials, from where and what came of it. Bodies are kept only as HMAC-SHA256 digests keyed with AUDIT_SECRET, which plain SHA-256 does not reproduce. append-only --
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	actor_id UUID,
+	actor_role VARCHAR(20) NOT NULL,
+	auth_method VARCHAR(20) NOT NULL,
+	credential_id VARCHAR(64) NOT NULL,
+	method VARCHAR(10) NOT NULL,
+	route TEXT NOT NULL,
+	path TEXT NOT NULL,
+	entity_type VARCHAR(50) NOT NULL,
+	entity_id VARCHAR(100) NOT NULL,
+	status INT NOT NULL,
+	ip_address VARCHAR(45) NOT NULL,
+	user_agent VARCHAR(255) NOT NULL,
+	request_digest VARCHAR(64) NOT NULL,
+	response_digest VARCHAR(64) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_created_at_idx ON audit_log (actor_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_entity_created_at_idx ON audit_log (entity_type, entity_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+
+DROP TRIGGER IF EXISTS audit_log_immutable ON audit_log;
+CREATE TRIGGER audit_log_immutable
+BEFORE UPDATE OR DELETE ON audit_log
+FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate
+BEFORE TRUNCATE ON audit_log
+FOR EACH STATEMENT EXECUTE FUNCTION reject_ledger_mutation();
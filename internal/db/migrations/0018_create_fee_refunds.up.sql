@@ -0,0 +1,17 @@
+-- fee refunds. the transaction that paid back each fee charged on a transfer. a fee is refunded at most once. append-only --
+CREATE TABLE IF NOT EXISTS fee_refunds (
+	transaction_fee_id UUID PRIMARY KEY REFERENCES transaction_fees(id),
+	transaction_id UUID NOT NULL REFERENCES transactions(id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS fee_refunds_transaction_id_idx ON fee_refunds (transaction_id);
+
+DROP TRIGGER IF EXISTS fee_refunds_immutable ON fee_refunds;
+CREATE TRIGGER fee_refunds_immutable
+BEFORE UPDATE OR DELETE ON fee_refunds
+FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+DROP TRIGGER IF EXISTS fee_refunds_no_truncate ON fee_refunds;
+CREATE TRIGGER fee_refunds_no_truncate
+BEFORE TRUNCATE ON fee_refunds
+FOR EACH STATEMENT EXECUTE FUNCTION reject_ledger_mutation();
@@ -0,0 +1,6 @@
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+-- keys are global again. only the oldest use of a key shared by several users is kept --
+DELETE FROM idempotency_keys AS newer USING idempotency_keys AS older
+WHERE newer.key = older.key AND (newer.created_at, newer.user_id) > (older.created_at, older.user_id);
+ALTER TABLE idempotency_keys ADD CONSTRAINT idempotency_keys_pkey PRIMARY KEY (key);
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,5 @@
+-- idempotency keys are scoped to the user who made the request, so a key reused by another user never replays their response. keys claimed before belong to the system user --
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS user_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000' REFERENCES users(id);
+ALTER TABLE idempotency_keys ALTER COLUMN user_id DROP DEFAULT;
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD CONSTRAINT idempotency_keys_pkey PRIMARY KEY (user_id, key);
@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS transaction_lines_open_transaction ON transaction_lines;
+DROP FUNCTION IF EXISTS check_transaction_open();
+
+DROP TRIGGER IF EXISTS transaction_lines_balanced ON transaction_lines;
+CREATE CONSTRAINT TRIGGER transaction_lines_balanced
+AFTER INSERT ON transaction_lines
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW EXECUTE FUNCTION check_transaction_balanced();
@@ -0,0 +1,19 @@
+-- the double-entry invariant is checked once per transaction at commit by transactions_balanced, instead of once for every line. lines may only be added by
+-- the database transaction that created their transaction, so no later insert can unbalance a committed one --
+DROP TRIGGER IF EXISTS transaction_lines_balanced ON transaction_lines;
+
+CREATE OR REPLACE FUNCTION check_transaction_open() RETURNS TRIGGER AS $$
+BEGIN
+	-- now() is the start of the current database transaction, which is when every transaction created in it was recorded
+	IF NOT EXISTS (SELECT 1 FROM transactions WHERE id = NEW.transaction_id AND created_at = now()) THEN
+		RAISE EXCEPTION 'lines can only be added to transaction % by the database transaction that created it', NEW.transaction_id
+		USING ERRCODE = 'restrict_violation';
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS transaction_lines_open_transaction ON transaction_lines;
+CREATE TRIGGER transaction_lines_open_transaction
+BEFORE INSERT ON transaction_lines
+FOR EACH ROW EXECUTE FUNCTION check_transaction_open();
@@ -0,0 +1,10 @@
+CREATE OR REPLACE FUNCTION check_transaction_open() RETURNS TRIGGER AS $$
+BEGIN
+	-- now() is the start of the current database transaction, which is when every transaction created in it was recorded
+	IF NOT EXISTS (SELECT 1 FROM transactions WHERE id = NEW.transaction_id AND created_at = now()) THEN
+		RAISE EXCEPTION 'lines can only be added to transaction % by the database transaction that created it', NEW.transaction_id
+		USING ERRCODE = 'restrict_violation';
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,11 @@
+-- a transaction is open to new lines only in the database transaction that inserted it, which is the one whose id is its xmin. This holds whatever
+-- created_at the transaction was given, so imports and restores that keep the original timestamps can still add lines --
+CREATE OR REPLACE FUNCTION check_transaction_open() RETURNS TRIGGER AS $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM transactions WHERE id = NEW.transaction_id AND xmin = pg_current_xact_id()::xid) THEN
+		RAISE EXCEPTION 'lines can only be added to transaction % by the database transaction that created it', NEW.transaction_id
+		USING ERRCODE = 'restrict_violation';
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,5 @@
+ALTER TABLE audit_log DISABLE TRIGGER audit_log_immutable;
+UPDATE audit_log SET actor_role = '' WHERE actor_role IS NULL;
+ALTER TABLE audit_log ENABLE TRIGGER audit_log_immutable;
+
+ALTER TABLE audit_log ALTER COLUMN actor_role SET NOT NULL;
@@ -0,0 +1,7 @@
+-- calls made without valid credentials have no role, which is recorded as NULL rather than an empty role. the append-only guard is lifted only while the
+-- entries recorded so far are converted --
+ALTER TABLE audit_log ALTER COLUMN actor_role DROP NOT NULL;
+
+ALTER TABLE audit_log DISABLE TRIGGER audit_log_immutable;
+UPDATE audit_log SET actor_role = NULL WHERE actor_role = '';
+ALTER TABLE audit_log ENABLE TRIGGER audit_log_immutable;
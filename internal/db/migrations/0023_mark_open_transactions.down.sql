@@ -0,0 +1,12 @@
+CREATE OR REPLACE FUNCTION check_transaction_open() RETURNS TRIGGER AS $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM transactions WHERE id = NEW.transaction_id AND xmin = pg_current_xact_id()::xid) THEN
+		RAISE EXCEPTION 'lines can only be added to transaction % by the database transaction that created it', NEW.transaction_id
+		USING ERRCODE = 'restrict_violation';
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS transactions_mark_open ON transactions;
+DROP FUNCTION IF EXISTS mark_transaction_open();
@@ -0,0 +1,23 @@
+-- transactions are marked open in a setting local to the database transaction that inserts them, and lines are admitted only to marked transactions. Unlike
+-- xmin, the mark holds when the transaction is inserted under a savepoint, and goes away with it when the savepoint is rolled back --
+CREATE OR REPLACE FUNCTION mark_transaction_open() RETURNS TRIGGER AS $$
+BEGIN
+	PERFORM set_config('sgbank.open_transactions', coalesce(current_setting('sgbank.open_transactions', true), '') || NEW.id::text || ',', true);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS transactions_mark_open ON transactions;
+CREATE TRIGGER transactions_mark_open
+AFTER INSERT ON transactions
+FOR EACH ROW EXECUTE FUNCTION mark_transaction_open();
+
+CREATE OR REPLACE FUNCTION check_transaction_open() RETURNS TRIGGER AS $$
+BEGIN
+	IF strpos(coalesce(current_setting('sgbank.open_transactions', true), ''), NEW.transaction_id::text) = 0 THEN
+		RAISE EXCEPTION 'lines can only be added to transaction % by the database transaction that created it', NEW.transaction_id
+		USING ERRCODE = 'restrict_violation';
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,2 @@
+-- stored responses are replayed byte for byte. JSONB re-orders keys and drops whitespace, so responses are kept as the bytes that were sent --
+ALTER TABLE idempotency_keys ALTER COLUMN response_body TYPE BYTEA USING convert_to(response_body::TEXT, 'UTF8');
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatalf("LoadMigrations() error = %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("LoadMigrations() returned no migrations")
+	}
+
+	// versions are numbered from 1 without gaps, so a missing or misnumbered file shows up here
+	for i, m := range migrations {
+		if want := int64(i + 1); m.Version != want {
+			t.Fatalf("migration %d has version %d, want %d", i, m.Version, want)
+		}
+		if m.Name == "" {
+			t.Errorf("migration %d has no name", m.Version)
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migration %d_%s has an empty script", m.Version, m.Name)
+		}
+	}
+}
+
+// TestMigrateUpDown applies every migration to an empty schema, reverts them all and applies them again, so that each down script undoes its up script. It
+// runs against the postgres database in TEST_DATABASE_URL, in a schema of its own that is dropped afterwards
+func TestMigrateUpDown(t *testing.T) {
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db, schema := openTestSchema(t, connStr, logger)
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatalf("LoadMigrations() error = %v", err)
+	}
+	total := len(migrations)
+
+	assertApplied := func(want int) {
+		t.Helper()
+		statuses, err := MigrationStatuses(ctx, db)
+		if err != nil {
+			t.Fatalf("MigrationStatuses() error = %v", err)
+		}
+		applied := 0
+		for i, status := range statuses {
+			if status.Missing {
+				t.Errorf("version %d is applied but has no file", status.Version)
+			}
+			if status.AppliedAt != nil {
+				applied++
+				// migrations are applied and reverted in order, so only a prefix may be applied
+				if i >= want {
+					t.Errorf("version %d is applied out of order", status.Version)
+				}
+			}
+		}
+		if applied != want {
+			t.Fatalf("%d migrations applied, want %d", applied, want)
+		}
+	}
+
+	if n, err := MigrateUp(ctx, db, logger); err != nil || n != total {
+		t.Fatalf("MigrateUp() = %d, %v, want %d", n, err, total)
+	}
+	assertApplied(total)
+
+	// a second run has nothing left to apply
+	if n, err := MigrateUp(ctx, db, logger); err != nil || n != 0 {
+		t.Fatalf("MigrateUp() again = %d, %v, want 0", n, err)
+	}
+
+	if n, err := MigrateDown(ctx, db, logger, 1); err != nil || n != 1 {
+		t.Fatalf("MigrateDown(1) = %d, %v, want 1", n, err)
+	}
+	assertApplied(total - 1)
+
+	if n, err := MigrateDown(ctx, db, logger, total); err != nil || n != total-1 {
+		t.Fatalf("MigrateDown(all) = %d, %v, want %d", n, err, total-1)
+	}
+	assertApplied(0)
+
+	// nothing but the bookkeeping table may be left behind
+	var leftover []string
+	rows, err := db.QueryContext(ctx, `SELECT table_name FROM information_schema.tables WHERE table_schema = $1 AND table_name <> 'schema_migrations'`, schema)
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("scan table: %v", err)
+		}
+		leftover = append(leftover, name)
+	}
+	rows.Close()
+	if len(leftover) > 0 {
+		t.Errorf("tables left after reverting every migration: %v", leftover)
+	}
+
+	if n, err := MigrateUp(ctx, db, logger); err != nil || n != total {
+		t.Fatalf("MigrateUp() after down = %d, %v, want %d", n, err, total)
+	}
+	assertApplied(total)
+}
+
+// openTestSchema creates an empty schema in the given database and connects to it. The schema is dropped when the test ends
+func openTestSchema(t *testing.T, connStr string, logger *slog.Logger) (*sql.DB, string) {
+	t.Helper()
+	admin, err := New(connStr, logger)
+	if err != nil {
+		t.Fatalf("connect to database: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "migrate_test_" + uuid.NewString()[:8]
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	db, err := New(withSearchPath(t, connStr, schema), logger)
+	if err != nil {
+		t.Fatalf("connect to schema: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, schema
+}
+
+// withSearchPath points a connection string at the given schema
+func withSearchPath(t *testing.T, connStr, schema string) string {
+	t.Helper()
+	if !strings.HasPrefix(connStr, "postgres://") && !strings.HasPrefix(connStr, "postgresql://") {
+		return connStr + " search_path=" + schema
+	}
+
+	u, err := url.Parse(connStr)
+	if err != nil {
+		t.Fatalf("parse TEST_DATABASE_URL: %v", err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/auth"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// errors
+var (
+	ErrForbidden       = errors.New("role of the caller does not allow the request")
+	ErrNotAccountOwner = errors.New("only the account owner may debit the account")
+)
+
+// Permission is an action granted to roles
+type Permission string
+
+const (
+	// READ reads the caller's own users, accounts, transactions and the records kept on them
+	READ Permission = "read"
+	// READ_ALL reads the records of every user along with bank-wide reports
+	READ_ALL Permission = "read:all"
+	// WRITE moves money out of and manages the caller's own accounts
+	WRITE Permission = "write"
+	// MANAGE_CREDENTIALS issues, rotates and revokes the caller's own credentials
+	MANAGE_CREDENTIALS Permission = "credentials:manage"
+	// MANAGE_USERS changes the roles of users
+	MANAGE_USERS Permission = "users:manage"
+	// MANAGE_ACCOUNTS opens accounts for any user, disables them and changes their limits and interest
+	MANAGE_ACCOUNTS Permission = "accounts:manage"
+	// MANAGE_PRODUCTS maintains fx rates, fee schedules and interest products
+	MANAGE_PRODUCTS Permission = "products:manage"
+	// POST_SYSTEM debits system accounts such as the root account
+	POST_SYSTEM Permission = "ledger:post_system"
+)
+
+// rolePermissions lists the permissions granted to each role. Auditors are read-only
+var rolePermissions = map[models.Role][]Permission{
+	models.CUSTOMER: {READ, WRITE, MANAGE_CREDENTIALS},
+	models.AUDITOR:  {READ, READ_ALL, MANAGE_CREDENTIALS},
+	models.OPERATOR: {READ, READ_ALL, WRITE, MANAGE_CREDENTIALS, MANAGE_USERS, MANAGE_ACCOUNTS, MANAGE_PRODUCTS, POST_SYSTEM},
+}
+
+// routePermissions lists the permission each authenticated route needs, keyed by method and route path. Routes missing from it are denied to every role.
+// Handlers further limit READ and WRITE to the caller's own records
+var routePermissions = map[string]Permission{
+	"POST /auth/token":               MANAGE_CREDENTIALS,
+	"POST /api-keys":                 MANAGE_CREDENTIALS,
+	"GET /api-keys":                  MANAGE_CREDENTIALS,
+	"POST /api-keys/:id/rotate":      MANAGE_CREDENTIALS,
+	"DELETE /api-keys/:id":           MANAGE_CREDENTIALS,
+	"POST /auth/logout":              MANAGE_CREDENTIALS,
+	"GET /auth/sessions":             MANAGE_CREDENTIALS,
+	"DELETE /auth/sessions/:id":      MANAGE_CREDENTIALS,
+	"PUT /auth/password":             MANAGE_CREDENTIALS,
+	"POST /auth/totp":                MANAGE_CREDENTIALS,
+	"POST /auth/totp/confirm":        MANAGE_CREDENTIALS,
+	"POST /auth/totp/disable":        MANAGE_CREDENTIALS,
+	"POST /auth/totp/recovery-codes": MANAGE_CREDENTIALS,
+	"POST /auth/verify-email/resend": MANAGE_CREDENTIALS,
+
+	"GET /users/:id":        READ,
+	"PATCH /users/:id/role": MANAGE_USERS,
+
+	"POST /accounts":                  WRITE,
+	"GET /accounts":                   READ,
+	"GET /accounts/:id":               READ,
+	"GET /accounts/:id/balance":       READ,
+	"PATCH /accounts/:id/disable":     MANAGE_ACCOUNTS,
+	"PUT /accounts/:id/limit":         MANAGE_ACCOUNTS,
+	"GET /accounts/:id/limit-changes": READ,
+	"GET /accounts/:id/statements":    READ,
+	"PUT /accounts/:id/interest":      MANAGE_ACCOUNTS,
+	"GET /accounts/:id/interest":      READ,
+
+	"POST /transactions":                 WRITE,
+	"GET /transactions":                  READ,
+	"GET /transactions/:id":              READ,
+	"POST /transactions/:id/reverse":     WRITE,
+	"POST /transactions/:id/refund-fees": POST_SYSTEM,
+	"POST /journal-entries":              WRITE,
+	"POST /bulk-transfers":               WRITE,
+	"POST /holds":                        WRITE,
+	"GET /holds/:id":                     READ,
+	"POST /holds/:id/capture":            WRITE,
+	"POST /holds/:id/void":               WRITE,
+
+	"POST /standing-orders":            WRITE,
+	"GET /standing-orders":             READ,
+	"GET /standing-orders/:id":         READ,
+	"GET /standing-orders/:id/runs":    READ,
+	"POST /standing-orders/:id/cancel": WRITE,
+
+	"POST /fx-rates":             MANAGE_PRODUCTS,
+	"GET /fx-rates":              READ,
+	"GET /fx-rates/effective":    READ,
+	"POST /interest-products":    MANAGE_PRODUCTS,
+	"GET /interest-products":     READ,
+	"GET /interest-products/:id": READ,
+	"POST /fee-schedules":        MANAGE_PRODUCTS,
+	"GET /fee-schedules":         READ_ALL,
+	"GET /fee-schedules/:id":     READ_ALL,
+	"PUT /fee-schedules/:id":     MANAGE_PRODUCTS,
+	"DELETE /fee-schedules/:id":  MANAGE_PRODUCTS,
+	"GET /ledger/verify":         READ_ALL,
+	"GET /reports/trial-balance": READ_ALL,
+	"GET /reports/arrears":       READ_ALL,
+	"GET /audit":                 READ_ALL,
+}
+
+// AccessHandler authorizes authenticated requests by the role of their caller and records every denied attempt
+type AccessHandler struct {
+	accessDenialRepo *repository.AccessDenialRepository
+	logger           *slog.Logger
+}
+
+// NewAccessHandler creates a new access handler
+func NewAccessHandler(accessDenialRepo *repository.AccessDenialRepository, logger *slog.Logger) *AccessHandler {
+	return &AccessHandler{
+		accessDenialRepo: accessDenialRepo,
+		logger:           logger,
+	}
+}
+
+// denialRecorder records a request denied by the handlers
+type denialRecorder func(ctx context.Context, permission Permission, reason string)
+
+type denialRecorderKey struct{}
+
+// Authorize is the middleware that checks the route permission of every authenticated request. It runs after Authenticate and lets the handlers record the
+// attempts they deny on the caller's records
+func (h *AccessHandler) Authorize(c *gin.Context) {
+	principal, ok := auth.PrincipalFrom(c.Request.Context())
+	if !ok || c.FullPath() == "" {
+		c.Next()
+		return
+	}
+
+	attempt := models.CreateAccessDenial{
+		UserID:    principal.UserID,
+		Role:      principal.Role,
+		Method:    c.Request.Method,
+		Route:     c.FullPath(),
+		Path:      c.Request.URL.Path,
+		IPAddress: c.ClientIP(),
+	}
+	record := func(ctx context.Context, permission Permission, reason string) {
+		denial := attempt
+		denial.Permission, denial.Reason = string(permission), reason
+		// the denial is kept even when the client goes away
+		if err := h.accessDenialRepo.RecordDenial(context.WithoutCancel(ctx), &denial); err != nil {
+			h.logError("failed to record access denial", err)
+		}
+		h.logger.Warn("access denied", "user_id", denial.UserID, "role", denial.Role, "method", denial.Method, "route", denial.Route, "permission", denial.Permission)
+	}
+
+	permission, ok := routePermissions[c.Request.Method+" "+c.FullPath()]
+	if !ok || !can(principal.Role, permission) {
+		message := "Role " + string(principal.Role) + " may not " + c.Request.Method + " " + c.FullPath()
+		record(c.Request.Context(), permission, message)
+		c.AbortWithStatusJSON(http.StatusForbidden, models.APIResponse{
+			Message: message,
+		})
+		return
+	}
+
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), denialRecorderKey{}, denialRecorder(record)))
+	c.Next()
+}
+
+func (h *AccessHandler) logError(message string, err error) {
+	h.logger.Error(message, "error", err)
+}
+
+// can reports whether the role is granted the permission
+func can(role models.Role, permission Permission) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
+
+// principalCan reports whether the caller is granted the permission. Work started by the server itself is granted everything
+func principalCan(ctx context.Context, permission Permission) bool {
+	principal, ok := auth.PrincipalFrom(ctx)
+	return !ok || can(principal.Role, permission)
+}
+
+// denyAccess records the denied attempt and returns the forbidden request error reported for it
+func denyAccess(ctx context.Context, permission Permission, message string, err error) error {
+	if record, ok := ctx.Value(denialRecorderKey{}).(denialRecorder); ok {
+		record(ctx, permission, message)
+	}
+	return newRequestError(http.StatusForbidden, message, err)
+}
+
+// authorizeOwner verifies that the caller is one of the given owners of a record, unless they are granted the override permission
+func authorizeOwner(ctx context.Context, override Permission, ownerIDs ...string) error {
+	principal, ok := auth.PrincipalFrom(ctx)
+	if !ok || can(principal.Role, override) || slices.Contains(ownerIDs, principal.UserID.String()) {
+		return nil
+	}
+	return denyAccess(ctx, override, "Only the owner may access this record", ErrForbidden)
+}
+
+// authorizeAccountAccess verifies that the caller owns any of the given accounts, unless they are granted the override permission. Accounts are looked up by id
+// or account number, disabled ones included
+func authorizeAccountAccess(ctx context.Context, accountRepo *repository.AccountRepository, override Permission, ids []uuid.UUID, acctNums ...string) error {
+	if principalCan(ctx, override) {
+		return nil
+	}
+	owners, err := accountRepo.GetAccountOwners(ctx, ids, acctNums)
+	if err != nil {
+		return fmt.Errorf("retrieve account owners: %w", err)
+	}
+	return authorizeOwner(ctx, override, owners...)
+}
+
+// authorizeDebit verifies that the caller owns every account debited by the request. Operators may also debit system accounts, such as the root account, and
+// debit-normal accounts: a debit grows their balance instead of drawing on it, so no balance check holds their owner back. Work started by the server itself
+// carries no principal and was authorized when it was set up, such as standing orders posted by the background worker
+func authorizeDebit(ctx context.Context, accounts ...*models.Account) error {
+	principal, ok := auth.PrincipalFrom(ctx)
+	if !ok {
+		return nil
+	}
+	for _, acct := range accounts {
+		debitNormal := acct.Class.NormalSide() == models.DEBIT
+		if acct.IsSystem() || debitNormal {
+			if can(principal.Role, POST_SYSTEM) {
+				continue
+			}
+			if !acct.IsSystem() && acct.UserID == principal.UserID.String() {
+				return denyAccess(ctx, POST_SYSTEM, "Only operators may debit "+string(acct.Class)+" account "+acct.AccountNumber, ErrForbidden)
+			}
+		} else if acct.UserID == principal.UserID.String() {
+			continue
+		}
+
+		permission := WRITE
+		if acct.IsSystem() {
+			permission = POST_SYSTEM
+		}
+		return denyAccess(ctx, permission, "Only the owner of account "+acct.AccountNumber+" may debit it", ErrNotAccountOwner)
+	}
+	return nil
+}
+
+// debitedAccounts returns the accounts with a debit line among the given lines
+func debitedAccounts(lines []models.CreateTransactionLine, accounts map[string]*models.Account) []*models.Account {
+	accountsByID := make(map[uuid.UUID]*models.Account, len(accounts))
+	for _, acct := range accounts {
+		accountsByID[acct.ID] = acct
+	}
+
+	var debited []*models.Account
+	seen := make(map[uuid.UUID]bool)
+	for _, line := range lines {
+		if line.Purpose == models.DEBIT && !seen[line.AccountID] {
+			seen[line.AccountID] = true
+			debited = append(debited, accountsByID[line.AccountID])
+		}
+	}
+	return debited
+}
+
+// respondAccessError writes a failed authorization to the client. Denials keep their status and message, anything else is logged and reported with the fallback
+// message
+func respondAccessError(c *gin.Context, logger *slog.Logger, err error, fallback string) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		c.JSON(reqErr.status, models.APIResponse{
+			Message: reqErr.message,
+		})
+		return
+	}
+
+	logger.Error(fallback, "error", err)
+	c.JSON(http.StatusBadRequest, models.APIResponse{
+		Message: fallback,
+	})
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/auth"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// withPrincipal returns a context carrying a caller of the given role
+func withPrincipal(userID uuid.UUID, role models.Role) context.Context {
+	return auth.WithPrincipal(context.Background(), &auth.Principal{UserID: userID, Role: role, Method: auth.SESSION})
+}
+
+// recordDenials returns a context that keeps the permissions of the denials recorded on it, as Authorize does for the handlers
+func recordDenials(ctx context.Context, denied *[]Permission) context.Context {
+	return context.WithValue(ctx, denialRecorderKey{}, denialRecorder(func(_ context.Context, permission Permission, _ string) {
+		*denied = append(*denied, permission)
+	}))
+}
+
+func TestRolePermissions(t *testing.T) {
+	tests := []struct {
+		role       models.Role
+		permission Permission
+		want       bool
+	}{
+		{models.CUSTOMER, READ, true},
+		{models.CUSTOMER, WRITE, true},
+		{models.CUSTOMER, MANAGE_CREDENTIALS, true},
+		{models.CUSTOMER, READ_ALL, false},
+		{models.CUSTOMER, MANAGE_USERS, false},
+		{models.CUSTOMER, MANAGE_ACCOUNTS, false},
+		{models.CUSTOMER, MANAGE_PRODUCTS, false},
+		{models.CUSTOMER, POST_SYSTEM, false},
+		// auditors read everything and change nothing but their own credentials
+		{models.AUDITOR, READ, true},
+		{models.AUDITOR, READ_ALL, true},
+		{models.AUDITOR, MANAGE_CREDENTIALS, true},
+		{models.AUDITOR, WRITE, false},
+		{models.AUDITOR, MANAGE_USERS, false},
+		{models.AUDITOR, MANAGE_ACCOUNTS, false},
+		{models.AUDITOR, MANAGE_PRODUCTS, false},
+		{models.AUDITOR, POST_SYSTEM, false},
+		{models.OPERATOR, MANAGE_USERS, true},
+		{models.OPERATOR, POST_SYSTEM, true},
+		{"unknown", READ, false},
+	}
+	for _, tt := range tests {
+		if got := can(tt.role, tt.permission); got != tt.want {
+			t.Errorf("can(%s, %s) = %v, want %v", tt.role, tt.permission, got, tt.want)
+		}
+	}
+
+	// every permission a route needs is granted to operators
+	for route, permission := range routePermissions {
+		if !can(models.OPERATOR, permission) {
+			t.Errorf("%s needs %s, which no operator has", route, permission)
+		}
+	}
+}
+
+func TestRoutePermissionsCoverRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	RegisterPingHandler(router, logger)
+	RegisterAuthHandlers(&AuthHandler{}, router, logger)
+	RegisterSessionHandlers(&SessionHandler{}, router, logger)
+	RegisterVerificationHandlers(&VerificationHandler{}, router, logger)
+	RegisterUserHandlers(&UserHandler{}, router, logger)
+	RegisterAccountHandlers(&AccountHandler{}, router, logger)
+	RegisterTransactionHandlers(&TransactionHandler{}, router, logger)
+	RegisterReportHandlers(&ReportHandler{}, router, logger)
+	RegisterFXRateHandlers(&FXRateHandler{}, router, logger)
+	RegisterLedgerHandlers(&LedgerHandler{}, router, logger)
+	RegisterStandingOrderHandlers(&StandingOrderHandler{}, router, logger)
+	RegisterInterestHandlers(&InterestHandler{}, router, logger)
+	RegisterFeeHandlers(&FeeHandler{}, router, logger)
+	RegisterAuditHandlers(&AuditHandler{}, router, logger)
+
+	registered := make(map[string]bool)
+	for _, route := range router.Routes() {
+		key := route.Method + " " + route.Path
+		registered[key] = true
+		_, authenticated := routePermissions[key]
+		if publicRoutes[key] == authenticated {
+			t.Errorf("%s must be either public or have a permission", key)
+		}
+	}
+	for key := range routePermissions {
+		if !registered[key] {
+			t.Errorf("%s has a permission but is not registered", key)
+		}
+	}
+	for key := range publicRoutes {
+		if !registered[key] {
+			t.Errorf("%s is public but is not registered", key)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	// denials fail to be written to this database, which the middleware logs and carries on from
+	db, err := sql.Open("postgres", "host=/nonexistent sslmode=disable")
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	h := NewAccessHandler(repository.NewAccessDenialRepository(db, logger), logger)
+
+	tests := []struct {
+		name   string
+		role   models.Role
+		method string
+		path   string
+		want   int
+	}{
+		{"customer reads", models.CUSTOMER, http.MethodGet, "/accounts/1", http.StatusOK},
+		{"customer posts", models.CUSTOMER, http.MethodPost, "/transactions", http.StatusOK},
+		{"customer changes a role", models.CUSTOMER, http.MethodPatch, "/users/1/role", http.StatusForbidden},
+		{"customer reads the audit log", models.CUSTOMER, http.MethodGet, "/audit", http.StatusForbidden},
+		{"auditor reads the audit log", models.AUDITOR, http.MethodGet, "/audit", http.StatusOK},
+		{"auditor posts", models.AUDITOR, http.MethodPost, "/transactions", http.StatusForbidden},
+		{"operator changes a role", models.OPERATOR, http.MethodPatch, "/users/1/role", http.StatusOK},
+		{"route without a permission", models.OPERATOR, http.MethodGet, "/unlisted", http.StatusForbidden},
+		{"unauthenticated", "", http.MethodGet, "/audit", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			// Authenticate stands in for the middleware that sets the principal, and rejects unauthenticated calls itself
+			router.Use(func(c *gin.Context) {
+				if tt.role != "" {
+					c.Request = c.Request.WithContext(withPrincipal(uuid.New(), tt.role))
+				}
+			}, h.Authorize)
+			ok := func(c *gin.Context) {
+				// handlers are handed a recorder for the denials of their own
+				if _, recorder := c.Request.Context().Value(denialRecorderKey{}).(denialRecorder); !recorder && tt.role != "" {
+					t.Error("handler context carries no denial recorder")
+				}
+				c.Status(http.StatusOK)
+			}
+			router.GET("/accounts/:id", ok)
+			router.POST("/transactions", ok)
+			router.PATCH("/users/:id/role", ok)
+			router.GET("/audit", ok)
+			router.GET("/unlisted", ok)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("%s %s as %q = %d, want %d", tt.method, tt.path, tt.role, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeOwner(t *testing.T) {
+	owner, other := uuid.New(), uuid.New()
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		denied bool
+	}{
+		{"owner", withPrincipal(owner, models.CUSTOMER), false},
+		{"another customer", withPrincipal(other, models.CUSTOMER), true},
+		{"auditor", withPrincipal(other, models.AUDITOR), false},
+		{"server", context.Background(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var denied []Permission
+			err := authorizeOwner(recordDenials(tt.ctx, &denied), READ_ALL, owner.String())
+			if tt.denied != (err != nil) {
+				t.Fatalf("authorizeOwner() = %v, want denied %v", err, tt.denied)
+			}
+			if !tt.denied {
+				return
+			}
+
+			var reqErr *requestError
+			if !errors.As(err, &reqErr) || reqErr.status != http.StatusForbidden || !errors.Is(err, ErrForbidden) {
+				t.Errorf("authorizeOwner() = %#v, want a forbidden request error", err)
+			}
+			if len(denied) != 1 || denied[0] != READ_ALL {
+				t.Errorf("recorded denials = %v, want [%s]", denied, READ_ALL)
+			}
+		})
+	}
+}
+
+func TestAuthorizeAccountAccess(t *testing.T) {
+	// callers granted the override are let through without the owners being looked up
+	for _, ctx := range []context.Context{withPrincipal(uuid.New(), models.OPERATOR), context.Background()} {
+		if err := authorizeAccountAccess(ctx, nil, MANAGE_ACCOUNTS, []uuid.UUID{uuid.New()}); err != nil {
+			t.Errorf("authorizeAccountAccess() = %v, want nil", err)
+		}
+	}
+}
+
+func TestAuthorizeDebit(t *testing.T) {
+	customer, other, operator := uuid.New(), uuid.New(), uuid.New()
+	account := func(owner string, class models.AccountClass) *models.Account {
+		return &models.Account{ID: uuid.New(), AccountNumber: "1000000001", UserID: owner, Class: class, Currency: models.USD}
+	}
+	deposit := account(customer.String(), models.LIABILITY)
+	othersDeposit := account(other.String(), models.LIABILITY)
+	loan := account(customer.String(), models.ASSET)
+	root := account(models.SystemUserID, models.ASSET)
+	revenue := account(models.SystemUserID, models.REVENUE)
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		accounts []*models.Account
+		// denied is the permission the denial is recorded with, if any
+		denied Permission
+		err    error
+	}{
+		{"owner debits a deposit", withPrincipal(customer, models.CUSTOMER), []*models.Account{deposit}, "", nil},
+		{"customer debits another's deposit", withPrincipal(customer, models.CUSTOMER), []*models.Account{othersDeposit}, WRITE, ErrNotAccountOwner},
+		{"owner debits a debit-normal account", withPrincipal(customer, models.CUSTOMER), []*models.Account{loan}, POST_SYSTEM, ErrForbidden},
+		{"customer debits the root account", withPrincipal(customer, models.CUSTOMER), []*models.Account{root}, POST_SYSTEM, ErrNotAccountOwner},
+		{"customer debits a system revenue account", withPrincipal(customer, models.CUSTOMER), []*models.Account{revenue}, POST_SYSTEM, ErrNotAccountOwner},
+		{"one account of several not owned", withPrincipal(customer, models.CUSTOMER), []*models.Account{deposit, othersDeposit}, WRITE, ErrNotAccountOwner},
+		{"operator debits the root account", withPrincipal(operator, models.OPERATOR), []*models.Account{root, revenue}, "", nil},
+		{"operator debits a customer's debit-normal account", withPrincipal(operator, models.OPERATOR), []*models.Account{loan}, "", nil},
+		// operators run the bank, but a customer's deposit is still debited only by its owner
+		{"operator debits a customer's deposit", withPrincipal(operator, models.OPERATOR), []*models.Account{deposit}, WRITE, ErrNotAccountOwner},
+		{"auditor debits their own deposit", withPrincipal(customer, models.AUDITOR), []*models.Account{deposit}, "", nil},
+		{"server", context.Background(), []*models.Account{othersDeposit, root}, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var denied []Permission
+			err := authorizeDebit(recordDenials(tt.ctx, &denied), tt.accounts...)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("authorizeDebit() = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil {
+				if len(denied) != 0 {
+					t.Errorf("recorded denials = %v, want none", denied)
+				}
+				return
+			}
+
+			var reqErr *requestError
+			if !errors.As(err, &reqErr) || reqErr.status != http.StatusForbidden {
+				t.Errorf("authorizeDebit() = %#v, want a forbidden request error", err)
+			}
+			if len(denied) != 1 || denied[0] != tt.denied {
+				t.Errorf("recorded denials = %v, want [%s]", denied, tt.denied)
+			}
+		})
+	}
+}
@@ -3,13 +3,19 @@ package handlers
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mrshabel/sgbank/internal/auth"
 	"github.com/mrshabel/sgbank/internal/models"
 	"github.com/mrshabel/sgbank/internal/repository"
+	"github.com/mrshabel/sgbank/internal/statement"
 	"github.com/mrshabel/sgbank/internal/utils"
 )
 
@@ -17,19 +23,25 @@ import (
 var (
 	ErrAccountExists   = errors.New("account already exist")
 	ErrAccountNotFound = errors.New("account not found")
+	ErrCreditLineClass = errors.New("credit lines must be liability accounts")
+	ErrSelfApproval    = errors.New("a limit change cannot be approved by the account owner")
 )
 
 // AccountHandler contains http handlers for account-related endpoints
 type AccountHandler struct {
-	accountRepo *repository.AccountRepository
-	logger      *slog.Logger
+	accountRepo     *repository.AccountRepository
+	transactionRepo *repository.TransactionRepository
+	userRepo        *repository.UserRepository
+	logger          *slog.Logger
 }
 
 // NewAccountHandler creates a new account handler
-func NewAccountHandler(accountRepo *repository.AccountRepository, logger *slog.Logger) *AccountHandler {
+func NewAccountHandler(accountRepo *repository.AccountRepository, transactionRepo *repository.TransactionRepository, userRepo *repository.UserRepository, logger *slog.Logger) *AccountHandler {
 	return &AccountHandler{
-		accountRepo: accountRepo,
-		logger:      logger,
+		accountRepo:     accountRepo,
+		transactionRepo: transactionRepo,
+		userRepo:        userRepo,
+		logger:          logger,
 	}
 }
 
@@ -37,7 +49,11 @@ func NewAccountHandler(accountRepo *repository.AccountRepository, logger *slog.L
 
 // CreateAccountRequest represents the account request payload
 type CreateAccountRequest struct {
-	UserID string `json:"user_id" binding:"required,uuid"`
+	UserID   string              `json:"user_id" binding:"required,uuid"`
+	Class    models.AccountClass `json:"class" binding:"omitempty,oneof=asset liability equity revenue expense"`
+	Currency models.Currency     `json:"currency" binding:"omitempty,iso4217"`
+	// Type defaults to a deposit account. Limits are set separately through an approved limit change
+	Type models.AccountType `json:"type" binding:"omitempty,oneof=deposit credit_line"`
 }
 
 // CreateAccount handles new account creation
@@ -52,10 +68,68 @@ func (h *AccountHandler) CreateAccount(c *gin.Context) {
 		return
 	}
 
+	// customer accounts are liabilities of the bank unless stated otherwise
+	if body.Class == "" {
+		body.Class = models.LIABILITY
+	}
+	if body.Currency == "" {
+		body.Currency = models.DefaultCurrency
+	}
+	if body.Type == "" {
+		body.Type = models.DEPOSIT
+	}
+	if body.Type == models.CREDIT_LINE && body.Class != models.LIABILITY {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: ErrCreditLineClass.Error(),
+		})
+		return
+	}
+	if !body.Currency.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: "Currency " + string(body.Currency) + " is not supported",
+		})
+		return
+	}
+
+	// customers open accounts for themselves, and only as liabilities of the bank. Debit-normal accounts grow when debited, so their owner could draw on
+	// them without limit
+	userID, _ := uuid.Parse(body.UserID)
+	if err := authorizeOwner(c.Request.Context(), MANAGE_ACCOUNTS, userID.String()); err != nil {
+		respondAccessError(c, h.logger, err, "failed to create account")
+		return
+	}
+	if body.Class != models.LIABILITY && !principalCan(c.Request.Context(), MANAGE_ACCOUNTS) {
+		err := denyAccess(c.Request.Context(), MANAGE_ACCOUNTS, "Only operators may open "+string(body.Class)+" accounts", ErrForbidden)
+		respondAccessError(c, h.logger, err, "failed to create account")
+		return
+	}
+
+	// accounts are only opened for users who verified their email
+	user, err := h.userRepo.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "User not found",
+			})
+			return
+		}
+		h.logError("failed to retrieve user", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to create account",
+		})
+		return
+	}
+	if user.EmailVerifiedAt == nil {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Message: "Email of the user must be verified before opening an account",
+		})
+		return
+	}
+
 	// TODO: generate unique account number
 	accountNumber := utils.GenerateAccountNumber(10)
 
-	account, err := h.accountRepo.CreateAccount(c.Request.Context(), &models.CreateAccount{AccountNumber: accountNumber, UserID: body.UserID})
+	account, err := h.accountRepo.CreateAccount(c.Request.Context(), &models.CreateAccount{AccountNumber: accountNumber, UserID: body.UserID, Class: body.Class, Currency: body.Currency, Type: body.Type})
 	if err != nil {
 		// log error
 		h.logError("failed to create account", err)
@@ -108,13 +182,82 @@ func (h *AccountHandler) GetAccount(c *gin.Context) {
 		return
 	}
 
+	if err := authorizeOwner(c.Request.Context(), READ_ALL, account.UserID); err != nil {
+		respondAccessError(c, h.logger, err, "Failed to retrieve account")
+		return
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Message: "Account retrieved successfully",
 		Data:    account,
 	})
 }
 
-// GetAccountURI represents the path params of the GetAccount request
+// GetAccountBalanceQuery represents the query params of the GetAccountBalance request
+type GetAccountBalanceQuery struct {
+	AsOf *time.Time `form:"as_of" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// GetAccountBalance handles the retrieval of an account's balances on the normal side of its class, one per currency held. The as_of query param returns the balances
+// as they stood at a past moment
+func (h *AccountHandler) GetAccountBalance(c *gin.Context) {
+	var params GetAccountURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		// log error
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var query GetAccountBalanceQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		// log error
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// parse uuid
+	id, _ := uuid.Parse(params.ID)
+	if err := authorizeAccountAccess(c.Request.Context(), h.accountRepo, READ_ALL, []uuid.UUID{id}); err != nil {
+		respondAccessError(c, h.logger, err, "Failed to retrieve account balance")
+		return
+	}
+	var balances []*models.AccountBalance
+	var err error
+	if query.AsOf != nil {
+		balances, err = h.transactionRepo.GetAccountBalancesAsOf(c.Request.Context(), id, *query.AsOf)
+	} else {
+		balances, err = h.transactionRepo.GetAccountBalances(c.Request.Context(), id)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			h.logError("account not found", err)
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Account not found",
+			})
+			return
+		}
+
+		// log error
+		h.logError("failed to retrieve account balance", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve account balance",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Account balance retrieved successfully",
+		Data:    balances,
+	})
+}
+
+// GetUserAccountsQuery represents the query params of the GetUserAccounts request
 type GetUserAccountsQuery struct {
 	UserID string `form:"user_id" binding:"required,uuid"`
 }
@@ -133,6 +276,10 @@ func (h *AccountHandler) GetUserAccounts(c *gin.Context) {
 
 	// parse uuid
 	userID, _ := uuid.Parse(params.UserID)
+	if err := authorizeOwner(c.Request.Context(), READ_ALL, userID.String()); err != nil {
+		respondAccessError(c, h.logger, err, "Failed to retrieve user accounts")
+		return
+	}
 	accounts, err := h.accountRepo.GetAccountsByUserID(c.Request.Context(), userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -200,6 +347,269 @@ func (h *AccountHandler) DisableAccount(c *gin.Context) {
 	})
 }
 
+// set account limit
+
+// SetAccountLimitRequest represents the limit change payload. The limit is the overdraft limit of a deposit account or the credit limit of a credit line
+type SetAccountLimitRequest struct {
+	Limit  *uint64 `json:"limit" binding:"required"`
+	Reason string  `json:"reason" binding:"required"`
+}
+
+// SetAccountLimit handles an approved change to how far below zero an account may go. The caller is the approver, and every change is recorded with them in
+// the account's audit trail
+func (h *AccountHandler) SetAccountLimit(c *gin.Context) {
+	var params GetAccountURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		// log error
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var body SetAccountLimitRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		// log error
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+	if *body.Limit > math.MaxInt64 {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: "Limit is too large",
+		})
+		return
+	}
+
+	// parse uuid
+	id, _ := uuid.Parse(params.ID)
+
+	// changes made by the server itself are approved by the system user
+	approvedBy := uuid.MustParse(models.SystemUserID)
+	if principal, ok := auth.PrincipalFrom(c.Request.Context()); ok {
+		approvedBy = principal.UserID
+	}
+	account, err := h.accountRepo.GetAccountByID(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Account not found",
+			})
+			return
+		}
+		h.logError("failed to retrieve account", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to set account limit",
+		})
+		return
+	}
+	if account.IsSystem() {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "System accounts do not have limits",
+		})
+		return
+	}
+	if account.UserID == approvedBy.String() {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Message: ErrSelfApproval.Error(),
+		})
+		return
+	}
+
+	account, change, err := h.accountRepo.SetAccountLimit(c.Request.Context(), &models.SetAccountLimit{
+		AccountID:  id,
+		Limit:      *body.Limit,
+		ApprovedBy: approvedBy,
+		Reason:     body.Reason,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Account not found",
+			})
+			return
+		}
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Message: "Approver does not exist",
+			})
+			return
+		}
+
+		// log error
+		h.logError("failed to set account limit", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to set account limit",
+		})
+		return
+	}
+
+	h.logger.Info("account limit changed", "account_id", id, "kind", change.Kind, "previous_limit", change.PreviousLimit, "new_limit", change.NewLimit, "approved_by", approvedBy)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Account limit updated successfully",
+		Data: gin.H{
+			"account": account,
+			"change":  change,
+		},
+	})
+}
+
+// GetLimitChanges handles the retrieval of the approval audit trail of an account's limits
+func (h *AccountHandler) GetLimitChanges(c *gin.Context) {
+	var params GetAccountURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		// log error
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// parse uuid
+	id, _ := uuid.Parse(params.ID)
+	if err := authorizeAccountAccess(c.Request.Context(), h.accountRepo, READ_ALL, []uuid.UUID{id}); err != nil {
+		respondAccessError(c, h.logger, err, "Failed to retrieve limit changes")
+		return
+	}
+	changes, err := h.accountRepo.GetLimitChanges(c.Request.Context(), id)
+	if err != nil {
+		// log error
+		h.logError("failed to retrieve limit changes", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve limit changes",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Limit changes retrieved successfully",
+		Data:    changes,
+	})
+}
+
+// get account statement
+
+// GetAccountStatementQuery selects the days from and to inclusive, in UTC, and the format of the statement. It defaults to the current month in json
+type GetAccountStatementQuery struct {
+	From   *time.Time             `form:"from" time_format:"2006-01-02" time_utc:"1"`
+	To     *time.Time             `form:"to" time_format:"2006-01-02" time_utc:"1"`
+	Format models.StatementFormat `form:"format" binding:"omitempty,oneof=csv json txt camt053 mt940"`
+}
+
+// GetAccountStatement handles the generation of an account statement: the opening balance, every line posted in the period with its running balance, and the
+// closing balance. A statement of a closed period is reproducible, and the X-Statement-Checksum header carries the SHA-256 hash of the body
+func (h *AccountHandler) GetAccountStatement(c *gin.Context) {
+	var params GetAccountURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		// log error
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var query GetAccountStatementQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		// log error
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	from, to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), now
+	if query.From != nil {
+		from = *query.From
+	}
+	if query.To != nil {
+		to = *query.To
+	}
+	if to.Before(from) {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: "Statement period must end on or after its start",
+		})
+		return
+	}
+	format := query.Format
+	if format == "" {
+		format = models.JSON_STATEMENT
+	}
+
+	// parse uuid
+	id, _ := uuid.Parse(params.ID)
+	account, err := h.accountRepo.GetAccountByID(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			h.logError("account not found", err)
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Account not found",
+			})
+			return
+		}
+
+		// log error
+		h.logError("failed to retrieve account", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to generate statement",
+		})
+		return
+	}
+
+	if err := authorizeOwner(c.Request.Context(), READ_ALL, account.UserID); err != nil {
+		respondAccessError(c, h.logger, err, "Failed to generate statement")
+		return
+	}
+
+	// the opening balance is read from the balance checkpoints, and only the lines of the period are read. As-of balances include the lines posted at that
+	// instant, so it is read as of the last microsecond, the precision of postgres timestamps, before the period
+	start, end := models.StatementPeriod(from, to)
+	// lines are read only up to the posting grace period before now, so that a posting committing after the statement is drawn never turns up in a later one
+	// of the same period. Once the period has ended and the grace period passed, every read renders the same statement
+	if cutoff := now.Add(-models.PostingGrace); end.After(cutoff) {
+		end = cutoff
+	}
+	opening, err := h.transactionRepo.GetAccountBalancesAsOf(c.Request.Context(), id, start.Add(-time.Microsecond))
+	if err != nil {
+		// log error
+		h.logError("failed to retrieve opening balances", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to generate statement",
+		})
+		return
+	}
+	transactions, err := h.transactionRepo.GetTransactionsByAccountIDBetween(c.Request.Context(), id, start, end)
+	if err != nil {
+		// log error
+		h.logError("failed to retrieve account transactions", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to generate statement",
+		})
+		return
+	}
+
+	stmt := models.NewStatement(account, opening, transactions, from, to)
+	body, err := statement.Render(stmt, format)
+	if err != nil {
+		// log error
+		h.logError("failed to render statement", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to generate statement",
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", statement.Filename(stmt, format)))
+	c.Header("X-Statement-Checksum", statement.Checksum(body))
+	c.Data(http.StatusOK, statement.ContentType(format), body)
+}
+
 func (h *AccountHandler) logError(message string, err error) {
 	h.logger.Error(message, "error", err)
 }
@@ -210,5 +620,9 @@ func RegisterAccountHandlers(h *AccountHandler, router *gin.Engine, logger *slog
 	r.POST("", h.CreateAccount)
 	r.GET("", h.GetUserAccounts)
 	r.GET("/:id", h.GetAccount)
+	r.GET("/:id/balance", h.GetAccountBalance)
 	r.PATCH("/:id/disable", h.DisableAccount)
+	r.PUT("/:id/limit", h.SetAccountLimit)
+	r.GET("/:id/limit-changes", h.GetLimitChanges)
+	r.GET("/:id/statements", h.GetAccountStatement)
 }
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/auth"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+const (
+	// maxAuditedBodySize bounds the request bodies read for their digest. It leaves room for the multipart framing of the largest payment file
+	maxAuditedBodySize = maxPaymentFileSize + 1<<20
+	// maxAuditedResponseSize bounds the part of a response kept to find the id of the record it created
+	maxAuditedResponseSize = 1 << 20
+)
+
+// auditActorKey names the user a public route identified, such as the user who logged in, when the request carries no principal
+const auditActorKey = "audit_actor"
+
+// AuditHandler records every state-changing api call in the audit log and contains the http handler to search it
+type AuditHandler struct {
+	auditRepo *repository.AuditRepository
+	// digestKey keys the HMAC-SHA256 digests of bodies, so a guessable body such as a login cannot be recovered from its digest by trying candidates
+	digestKey []byte
+	logger    *slog.Logger
+}
+
+// NewAuditHandler creates a new audit handler. The digest key must stay the same for as long as recorded digests are to be checked
+func NewAuditHandler(auditRepo *repository.AuditRepository, digestKey []byte, logger *slog.Logger) *AuditHandler {
+	return &AuditHandler{
+		auditRepo: auditRepo,
+		digestKey: digestKey,
+		logger:    logger,
+	}
+}
+
+// auditWriter passes a response through while computing its digest and keeping its start
+type auditWriter struct {
+	gin.ResponseWriter
+	digest hash.Hash
+	body   bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	w.digest.Write(b)
+	if room := maxAuditedResponseSize - w.body.Len(); room > 0 {
+		w.body.Write(b[:min(len(b), room)])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Record is the middleware that writes every POST, PUT, PATCH and DELETE call to the audit log once it has been answered. It runs before Authenticate, so calls
+// refused for their credentials or role are recorded too
+func (h *AuditHandler) Record(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		c.Next()
+		return
+	}
+	if c.FullPath() == "" {
+		c.Next()
+		return
+	}
+
+	// the body is read up front for its digest and handed on to the handler
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAuditedBodySize+1))
+	if err != nil {
+		h.logError("failed to read request body", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to read request body",
+		})
+		return
+	}
+	requestDigest := hmac.New(sha256.New, h.digestKey)
+	requestDigest.Write(body)
+	writer := &auditWriter{ResponseWriter: c.Writer, digest: hmac.New(sha256.New, h.digestKey)}
+	c.Writer = writer
+
+	if len(body) > maxAuditedBodySize {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, models.APIResponse{
+			Message: "Request body is too large",
+		})
+	} else {
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+
+	entry := models.CreateAuditEntry{
+		Method:         c.Request.Method,
+		Route:          c.FullPath(),
+		Path:           c.Request.URL.RequestURI(),
+		EntityType:     strings.SplitN(strings.TrimPrefix(c.FullPath(), "/"), "/", 2)[0],
+		EntityID:       c.Param("id"),
+		Status:         writer.Status(),
+		IPAddress:      c.ClientIP(),
+		UserAgent:      c.Request.UserAgent(),
+		RequestDigest:  hex.EncodeToString(requestDigest.Sum(nil)),
+		ResponseDigest: hex.EncodeToString(writer.digest.Sum(nil)),
+	}
+	if len(entry.UserAgent) > maxUserAgentLength {
+		entry.UserAgent = entry.UserAgent[:maxUserAgentLength]
+	}
+	if principal, ok := auth.PrincipalFrom(c.Request.Context()); ok {
+		entry.ActorID, entry.ActorRole, entry.AuthMethod, entry.CredentialID = &principal.UserID, &principal.Role, string(principal.Method), principal.CredentialID
+	} else if actorID, ok := c.Get(auditActorKey); ok {
+		id := actorID.(uuid.UUID)
+		entry.ActorID = &id
+	}
+	if entry.EntityID == "" && entry.Status < http.StatusMultipleChoices {
+		entry.EntityID = createdID(writer.body.Bytes())
+	}
+
+	// the entry is kept even when the client goes away
+	if err := h.auditRepo.RecordEntry(context.WithoutCancel(c.Request.Context()), &entry); err != nil {
+		h.logger.Error("failed to record audit entry", "error", err, "method", entry.Method, "path", entry.Path, "status", entry.Status)
+	}
+}
+
+// createdID returns the id of the record a json response carries, such as the record a POST created
+func createdID(body []byte) string {
+	var response struct {
+		Data struct {
+			ID json.RawMessage `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || len(response.Data.ID) == 0 {
+		return ""
+	}
+	var id any
+	if err := json.Unmarshal(response.Data.ID, &id); err != nil {
+		return ""
+	}
+	switch id := id.(type) {
+	case string:
+		return id
+	case float64:
+		return string(response.Data.ID)
+	}
+	return ""
+}
+
+// setAuditActor names the user a public route identified, for the audit entry of the request
+func setAuditActor(c *gin.Context, userID uuid.UUID) {
+	c.Set(auditActorKey, userID)
+}
+
+// get audit log
+
+// GetAuditLogQuery represents the filters of the audit log. Entries are returned newest first, 100 at a time by default; pass the id of the last entry as before
+// for the next page
+type GetAuditLogQuery struct {
+	Actor      string     `form:"actor" binding:"omitempty,uuid"`
+	EntityType string     `form:"entity_type" binding:"omitempty,max=50"`
+	EntityID   string     `form:"entity_id" binding:"omitempty,max=100"`
+	From       *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To         *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Before     int64      `form:"before" binding:"omitempty,min=1"`
+	Limit      int        `form:"limit" binding:"omitempty,min=1,max=1000"`
+}
+
+// GetAuditLog handles searching the audit log by actor, entity and time range. from is inclusive and to exclusive
+func (h *AuditHandler) GetAuditLog(c *gin.Context) {
+	var query GetAuditLogQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+	if query.From != nil && query.To != nil && !query.From.Before(*query.To) {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: "from must be before to",
+		})
+		return
+	}
+
+	filter := models.AuditFilter{
+		EntityType: query.EntityType,
+		EntityID:   query.EntityID,
+		From:       query.From,
+		To:         query.To,
+		Before:     query.Before,
+		Limit:      query.Limit,
+	}
+	if query.Actor != "" {
+		actorID, _ := uuid.Parse(query.Actor)
+		filter.ActorID = &actorID
+	}
+	if filter.Limit == 0 {
+		filter.Limit = 100
+	}
+
+	entries, err := h.auditRepo.GetEntries(c.Request.Context(), filter)
+	if err != nil {
+		h.logError("failed to retrieve audit log", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve audit log",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Audit log retrieved successfully",
+		Data:    entries,
+	})
+}
+
+func (h *AuditHandler) logError(message string, err error) {
+	h.logger.Error(message, "error", err)
+}
+
+// RegisterAuditHandlers adds all the handler methods to the provided http router
+func RegisterAuditHandlers(h *AuditHandler, router *gin.Engine, logger *slog.Logger) {
+	router.GET("/audit", h.GetAuditLog)
+}
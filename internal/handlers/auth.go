@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mrshabel/sgbank/internal/auth"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// errors
+var (
+	ErrUnauthenticated = errors.New("authentication required")
+	ErrUnknownUser     = errors.New("user of the credentials no longer exists")
+	ErrInvalidAPIKey   = errors.New("api key is invalid, expired or revoked")
+	ErrInvalidToken    = errors.New("bearer token is invalid")
+	ErrTokenExpired    = errors.New("bearer token has expired")
+	ErrSessionEnded    = errors.New("session of the bearer token has been revoked or has expired")
+	ErrAPIKeyNotFound  = errors.New("active api key not found")
+	ErrAPIKeyRotated   = errors.New("api key has already been rotated")
+)
+
+// APIKeyHeader is the header services send their api key in
+const APIKeyHeader = "X-API-Key"
+
+// publicRoutes may be called without credentials, keyed by method and route path
+var publicRoutes = map[string]bool{
+	"GET /ping":        true,
+	"POST /users":      true,
+	"POST /auth/login": true,
+
+	"POST /auth/verify-email":           true,
+	"POST /auth/password-reset":         true,
+	"POST /auth/password-reset/confirm": true,
+}
+
+// AuthHandler authenticates requests and contains http handlers for api key and token endpoints
+type AuthHandler struct {
+	apiKeyRepo  *repository.APIKeyRepository
+	userRepo    *repository.UserRepository
+	sessionRepo *repository.SessionRepository
+	// tokenSecret signs bearer tokens, which expire after tokenTTL
+	tokenSecret []byte
+	tokenTTL    time.Duration
+	// rotationGrace is how long a rotated api key keeps working alongside its replacement
+	rotationGrace time.Duration
+	logger        *slog.Logger
+}
+
+// NewAuthHandler creates a new auth handler
+func NewAuthHandler(apiKeyRepo *repository.APIKeyRepository, userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, tokenSecret []byte,
+	tokenTTL, rotationGrace time.Duration, logger *slog.Logger) *AuthHandler {
+	return &AuthHandler{
+		apiKeyRepo:    apiKeyRepo,
+		userRepo:      userRepo,
+		sessionRepo:   sessionRepo,
+		tokenSecret:   tokenSecret,
+		tokenTTL:      tokenTTL,
+		rotationGrace: rotationGrace,
+		logger:        logger,
+	}
+}
+
+// authenticate
+
+// Authenticate is the middleware that identifies the caller of every route but the public ones, from a bearer token in the Authorization header or an api key in
+// the X-API-Key header. The principal is carried by the request context for the handlers
+func (h *AuthHandler) Authenticate(c *gin.Context) {
+	if publicRoutes[c.Request.Method+" "+c.FullPath()] {
+		c.Next()
+		return
+	}
+
+	principal, err := h.authenticate(c)
+	if err == nil {
+		err = h.loadRole(c.Request.Context(), principal)
+	}
+	if err != nil {
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			c.Header("WWW-Authenticate", `Bearer realm="sgbank"`)
+			c.AbortWithStatusJSON(reqErr.status, models.APIResponse{
+				Message: reqErr.message,
+			})
+			return
+		}
+		h.logError("failed to authenticate request", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to authenticate request",
+		})
+		return
+	}
+
+	c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), principal))
+	c.Next()
+}
+
+// authenticate resolves the principal of the request from its credentials. Missing or invalid credentials are returned as request errors
+func (h *AuthHandler) authenticate(c *gin.Context) (*auth.Principal, error) {
+	if scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		claims, err := auth.ParseToken(h.tokenSecret, strings.TrimSpace(token), time.Now())
+		if err != nil {
+			if errors.Is(err, auth.ErrTokenExpired) {
+				return nil, newRequestError(http.StatusUnauthorized, "Bearer token has expired", ErrTokenExpired)
+			}
+			return nil, newRequestError(http.StatusUnauthorized, "Bearer token is invalid", ErrInvalidToken)
+		}
+		userID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			return nil, newRequestError(http.StatusUnauthorized, "Bearer token is invalid", ErrInvalidToken)
+		}
+		if claims.KeyID != "" {
+			// tokens exchanged for an api key are only accepted while the key is active
+			keyID, err := uuid.Parse(claims.KeyID)
+			if err != nil {
+				return nil, newRequestError(http.StatusUnauthorized, "Bearer token is invalid", ErrInvalidToken)
+			}
+			if _, err := h.apiKeyRepo.GetActiveAPIKey(c.Request.Context(), keyID, userID); err != nil {
+				if err == sql.ErrNoRows {
+					return nil, newRequestError(http.StatusUnauthorized, "API key of the bearer token is invalid, expired or revoked", ErrInvalidAPIKey)
+				}
+				return nil, err
+			}
+			return &auth.Principal{UserID: userID, Method: auth.BEARER_TOKEN, CredentialID: keyID.String()}, nil
+		}
+
+		// tokens issued at login are only accepted while their session is active. Every token names its credential, so one naming neither is rejected
+		sessionID, err := uuid.Parse(claims.SessionID)
+		if err != nil {
+			return nil, newRequestError(http.StatusUnauthorized, "Bearer token is invalid", ErrInvalidToken)
+		}
+		if _, err := h.sessionRepo.GetActiveSession(c.Request.Context(), sessionID, userID); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, newRequestError(http.StatusUnauthorized, "Session has been revoked or has expired", ErrSessionEnded)
+			}
+			return nil, err
+		}
+		return &auth.Principal{UserID: userID, Method: auth.SESSION, CredentialID: sessionID.String()}, nil
+	}
+
+	if key := c.GetHeader(APIKeyHeader); key != "" {
+		apiKey, err := h.apiKeyRepo.GetActiveAPIKeyByHash(c.Request.Context(), auth.HashSecret(key))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, newRequestError(http.StatusUnauthorized, "API key is invalid, expired or revoked", ErrInvalidAPIKey)
+			}
+			return nil, err
+		}
+		return &auth.Principal{UserID: apiKey.UserID, Method: auth.API_KEY, CredentialID: apiKey.ID.String()}, nil
+	}
+
+	return nil, newRequestError(http.StatusUnauthorized, "Authentication required", ErrUnauthenticated)
+}
+
+// loadRole sets the current role of the principal's user
+func (h *AuthHandler) loadRole(ctx context.Context, principal *auth.Principal) error {
+	user, err := h.userRepo.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return newRequestError(http.StatusUnauthorized, "User of the credentials no longer exists", ErrUnknownUser)
+		}
+		return err
+	}
+	principal.Role = user.Role
+	return nil
+}
+
+// create token
+
+// TokenResponse represents a signed bearer token and when it expires
+type TokenResponse struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresIn   int64     `json:"expires_in"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// CreateToken handles the exchange of an api key for a short-lived bearer token of its user, which stops working with the key. Bearer tokens cannot be renewed
+// with themselves
+func (h *AuthHandler) CreateToken(c *gin.Context) {
+	principal, _ := auth.PrincipalFrom(c.Request.Context())
+	if principal.Method != auth.API_KEY {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Message: "Bearer tokens are only issued for api keys",
+		})
+		return
+	}
+
+	now := time.Now()
+	claims := auth.NewClaims(principal.UserID, h.tokenTTL, now)
+	claims.KeyID = principal.CredentialID
+	token, err := auth.SignToken(h.tokenSecret, claims)
+	if err != nil {
+		h.logError("failed to sign token", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to create token",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Token created successfully",
+		Data: TokenResponse{
+			AccessToken: token,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(h.tokenTTL.Seconds()),
+			ExpiresAt:   time.Unix(claims.ExpiresAt, 0).UTC(),
+		},
+	})
+}
+
+// create api key
+
+// CreateAPIKeyRequest represents the api key request payload. Keys do not expire unless an expiry is given
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// IssuedAPIKeyResponse carries a newly issued api key. The key is only ever returned in this response
+type IssuedAPIKeyResponse struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey handles issuing a new api key to the caller
+func (h *AuthHandler) CreateAPIKey(c *gin.Context) {
+	var body CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: "API key expiry must be in the future",
+		})
+		return
+	}
+
+	principal, _ := auth.PrincipalFrom(c.Request.Context())
+	key, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		h.logError("failed to generate api key", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to create api key",
+		})
+		return
+	}
+
+	apiKey, err := h.apiKeyRepo.CreateAPIKey(c.Request.Context(), &models.CreateAPIKey{
+		UserID:    principal.UserID,
+		Name:      body.Name,
+		Prefix:    prefix,
+		KeyHash:   auth.HashSecret(key),
+		ExpiresAt: body.ExpiresAt,
+	})
+	if err != nil {
+		h.logError("failed to create api key", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to create api key",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "API key created successfully",
+		Data:    IssuedAPIKeyResponse{APIKey: apiKey, Key: key},
+	})
+}
+
+// GetAPIKeys handles the retrieval of the caller's api keys
+func (h *AuthHandler) GetAPIKeys(c *gin.Context) {
+	principal, _ := auth.PrincipalFrom(c.Request.Context())
+	keys, err := h.apiKeyRepo.GetAPIKeysByUserID(c.Request.Context(), principal.UserID)
+	if err != nil {
+		h.logError("failed to retrieve api keys", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to retrieve api keys",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "API keys retrieved successfully",
+		Data:    keys,
+	})
+}
+
+// GetAPIKeyURI represents the path params of the api key requests
+type GetAPIKeyURI struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+// RotateAPIKeyRequest represents the rotation request payload. The new key does not expire unless an expiry is given
+type RotateAPIKeyRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// RotateAPIKey handles replacing one of the caller's active api keys with a new one. The replaced key keeps working for the rotation grace period so that
+// services can roll the new key out
+func (h *AuthHandler) RotateAPIKey(c *gin.Context) {
+	var params GetAPIKeyURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var body RotateAPIKeyRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			h.logError("invalid request body", err)
+			c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+	now := time.Now()
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(now) {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: "API key expiry must be in the future",
+		})
+		return
+	}
+
+	principal, _ := auth.PrincipalFrom(c.Request.Context())
+	key, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		h.logError("failed to generate api key", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to rotate api key",
+		})
+		return
+	}
+
+	id, _ := uuid.Parse(params.ID)
+	apiKey, err := h.apiKeyRepo.RotateAPIKey(c.Request.Context(), id, &models.CreateAPIKey{
+		UserID:    principal.UserID,
+		Prefix:    prefix,
+		KeyHash:   auth.HashSecret(key),
+		ExpiresAt: body.ExpiresAt,
+	}, now.Add(h.rotationGrace))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Active API key not found",
+			})
+			return
+		}
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Message: ErrAPIKeyRotated.Error(),
+			})
+			return
+		}
+		h.logError("failed to rotate api key", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to rotate api key",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "API key rotated successfully",
+		Data:    IssuedAPIKeyResponse{APIKey: apiKey, Key: key},
+	})
+}
+
+// RevokeAPIKey handles revoking one of the caller's api keys. It stops working at once
+func (h *AuthHandler) RevokeAPIKey(c *gin.Context) {
+	var params GetAPIKeyURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	principal, _ := auth.PrincipalFrom(c.Request.Context())
+	id, _ := uuid.Parse(params.ID)
+	apiKey, err := h.apiKeyRepo.RevokeAPIKey(c.Request.Context(), id, principal.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "API key not found or already revoked",
+			})
+			return
+		}
+		h.logError("failed to revoke api key", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to revoke api key",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "API key revoked successfully",
+		Data:    apiKey,
+	})
+}
+
+func (h *AuthHandler) logError(message string, err error) {
+	h.logger.Error(message, "error", err)
+}
+
+// RegisterAuthHandlers adds all the handler methods to the provided http router
+func RegisterAuthHandlers(h *AuthHandler, router *gin.Engine, logger *slog.Logger) {
+	router.POST("/auth/token", h.CreateToken)
+
+	r := router.Group("/api-keys")
+	r.POST("", h.CreateAPIKey)
+	r.GET("", h.GetAPIKeys)
+	r.POST("/:id/rotate", h.RotateAPIKey)
+	r.DELETE("/:id", h.RevokeAPIKey)
+}
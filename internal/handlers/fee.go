@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// errors
+var (
+	ErrFeeScheduleInPast    = errors.New("fee schedules cannot take effect in the past")
+	ErrFeeScheduleDates     = errors.New("fee schedule must end after it takes effect")
+	ErrFeeFlatAmount        = errors.New("flat fees require a flat amount greater than zero")
+	ErrFeeRateRequired      = errors.New("percentage fees require a rate")
+	ErrFeeMinAboveMax       = errors.New("minimum fee cannot exceed the maximum fee")
+	ErrFeeCurrencySupported = errors.New("fee currency is not supported")
+)
+
+// FeeHandler contains http handlers for fee schedule endpoints
+type FeeHandler struct {
+	feeRepo *repository.FeeRepository
+	logger  *slog.Logger
+}
+
+// NewFeeHandler creates a new fee handler
+func NewFeeHandler(feeRepo *repository.FeeRepository, logger *slog.Logger) *FeeHandler {
+	return &FeeHandler{
+		feeRepo: feeRepo,
+		logger:  logger,
+	}
+}
+
+// create fee schedule
+
+// FeeScheduleRequest represents the fee schedule request payload. Amounts are in minor units of the currency and rates are decimal fractions, eg: "0.015" for 1.5%.
+// The schedule takes effect now unless a later effective_from is given
+type FeeScheduleRequest struct {
+	Name     string          `json:"name" binding:"required,max=100"`
+	Kind     models.FeeKind  `json:"kind" binding:"required,oneof=flat percentage tiered"`
+	Currency models.Currency `json:"currency" binding:"required,iso4217"`
+	// AccountType and UserID scope the schedule to matching senders
+	AccountType   *models.AccountType `json:"account_type" binding:"omitempty,oneof=deposit credit_line"`
+	UserID        *string             `json:"user_id" binding:"omitempty,uuid"`
+	FlatAmount    uint64              `json:"flat_amount"`
+	Rate          *string             `json:"rate"`
+	MinAmount     *uint64             `json:"min_amount"`
+	MaxAmount     *uint64             `json:"max_amount"`
+	Tiers         []models.FeeTier    `json:"tiers"`
+	EffectiveFrom *time.Time          `json:"effective_from"`
+	EffectiveTo   *time.Time          `json:"effective_to"`
+}
+
+// schedule validates the request against the rules of its kind and converts it into a fee schedule
+func (r FeeScheduleRequest) schedule(now time.Time) (*models.CreateFeeSchedule, error) {
+	if !r.Currency.Valid() {
+		return nil, ErrFeeCurrencySupported
+	}
+
+	switch r.Kind {
+	case models.FLAT_FEE:
+		if r.FlatAmount == 0 {
+			return nil, ErrFeeFlatAmount
+		}
+	case models.PERCENTAGE_FEE:
+		if r.Rate == nil {
+			return nil, ErrFeeRateRequired
+		}
+		if _, err := models.ParseFeeRate(*r.Rate); err != nil {
+			return nil, err
+		}
+	case models.TIERED_FEE:
+		if err := models.ValidateFeeTiers(r.Tiers); err != nil {
+			return nil, err
+		}
+	}
+	if r.MinAmount != nil && r.MaxAmount != nil && *r.MinAmount > *r.MaxAmount {
+		return nil, ErrFeeMinAboveMax
+	}
+
+	effectiveFrom := now
+	if r.EffectiveFrom != nil {
+		if r.EffectiveFrom.Before(now) {
+			return nil, ErrFeeScheduleInPast
+		}
+		effectiveFrom = *r.EffectiveFrom
+	}
+	if r.EffectiveTo != nil && !r.EffectiveTo.After(effectiveFrom) {
+		return nil, ErrFeeScheduleDates
+	}
+
+	data := &models.CreateFeeSchedule{
+		Name:          r.Name,
+		Kind:          r.Kind,
+		Currency:      r.Currency,
+		AccountType:   r.AccountType,
+		FlatAmount:    r.FlatAmount,
+		MinAmount:     r.MinAmount,
+		MaxAmount:     r.MaxAmount,
+		EffectiveFrom: effectiveFrom,
+		EffectiveTo:   r.EffectiveTo,
+	}
+	if r.UserID != nil {
+		userID, _ := uuid.Parse(*r.UserID)
+		data.UserID = &userID
+	}
+	// keep only the pricing fields the kind uses
+	if r.Kind != models.FLAT_FEE {
+		data.FlatAmount = 0
+		if r.Kind == models.PERCENTAGE_FEE {
+			data.Rate = r.Rate
+		} else {
+			data.Tiers = r.Tiers
+		}
+	} else {
+		data.MinAmount, data.MaxAmount = nil, nil
+	}
+	return data, nil
+}
+
+// CreateFeeSchedule handles the creation of a fee schedule
+func (h *FeeHandler) CreateFeeSchedule(c *gin.Context) {
+	var body FeeScheduleRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	data, err := body.schedule(time.Now())
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	schedule, err := h.feeRepo.CreateSchedule(c.Request.Context(), data)
+	if err != nil {
+		h.respondRepoError(c, err, "failed to create fee schedule")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Fee schedule created successfully",
+		Data:    schedule,
+	})
+}
+
+// get fee schedules
+
+type GetFeeSchedulesQuery struct {
+	At *time.Time `form:"at" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// GetFeeSchedules handles the retrieval of fee schedules. The at query param narrows them to the schedules in force at that moment
+func (h *FeeHandler) GetFeeSchedules(c *gin.Context) {
+	var params GetFeeSchedulesQuery
+	if err := c.ShouldBindQuery(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	schedules, err := h.feeRepo.GetSchedules(c.Request.Context(), params.At)
+	if err != nil {
+		h.logError("failed to retrieve fee schedules", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve fee schedules",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Fee schedules retrieved successfully",
+		Data:    schedules,
+	})
+}
+
+type GetFeeScheduleURI struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+// GetFeeSchedule handles the retrieval of a fee schedule
+func (h *FeeHandler) GetFeeSchedule(c *gin.Context) {
+	var params GetFeeScheduleURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	id, _ := uuid.Parse(params.ID)
+	schedule, err := h.feeRepo.GetScheduleByID(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Fee schedule not found",
+			})
+			return
+		}
+		h.logError("failed to retrieve fee schedule", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve fee schedule",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Fee schedule retrieved successfully",
+		Data:    schedule,
+	})
+}
+
+// UpdateFeeSchedule handles changing a fee schedule. The change is recorded as a new version taking effect at effective_from, now by default, and the current
+// version stays in force until then
+func (h *FeeHandler) UpdateFeeSchedule(c *gin.Context) {
+	var params GetFeeScheduleURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var body FeeScheduleRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	data, err := body.schedule(time.Now())
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	id, _ := uuid.Parse(params.ID)
+	schedule, err := h.feeRepo.SupersedeSchedule(c.Request.Context(), id, data)
+	if err != nil {
+		h.respondRepoError(c, err, "failed to update fee schedule")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Fee schedule updated successfully",
+		Data:    schedule,
+	})
+}
+
+type EndFeeScheduleQuery struct {
+	At *time.Time `form:"at" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// EndFeeSchedule handles taking a fee schedule out of force at the given time, now by default. The schedule is kept for the fees already charged under it
+func (h *FeeHandler) EndFeeSchedule(c *gin.Context) {
+	var params GetFeeScheduleURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var query EndFeeScheduleQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	now := time.Now()
+	at := now
+	if query.At != nil {
+		if query.At.Before(now) {
+			c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+				Message: "Fee schedules cannot be ended in the past",
+			})
+			return
+		}
+		at = *query.At
+	}
+
+	id, _ := uuid.Parse(params.ID)
+	schedule, err := h.feeRepo.EndSchedule(c.Request.Context(), id, at)
+	if err != nil {
+		h.respondRepoError(c, err, "failed to end fee schedule")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Fee schedule ended successfully",
+		Data:    schedule,
+	})
+}
+
+// respondRepoError reports a failed write to a fee schedule
+func (h *FeeHandler) respondRepoError(c *gin.Context, err error, fallback string) {
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Message: "Fee schedule not found",
+		})
+	case errors.Is(err, repository.ErrFeeScheduleEnded):
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Message: err.Error(),
+		})
+	default:
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Message: "User does not exist",
+			})
+			return
+		}
+		h.logError(fallback, err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: fallback,
+		})
+	}
+}
+
+func (h *FeeHandler) logError(message string, err error) {
+	h.logger.Error(message, "error", err)
+}
+
+// RegisterFeeHandlers adds all the handler methods to the provided http router
+func RegisterFeeHandlers(h *FeeHandler, router *gin.Engine, logger *slog.Logger) {
+	r := router.Group("/fee-schedules")
+	r.POST("", h.CreateFeeSchedule)
+	r.GET("", h.GetFeeSchedules)
+	r.GET("/:id", h.GetFeeSchedule)
+	r.PUT("/:id", h.UpdateFeeSchedule)
+	r.DELETE("/:id", h.EndFeeSchedule)
+}
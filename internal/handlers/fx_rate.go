@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// FXRateHandler contains http handlers for fx rate management endpoints
+type FXRateHandler struct {
+	fxRateRepo *repository.FXRateRepository
+	logger     *slog.Logger
+}
+
+// NewFXRateHandler creates a new fx rate handler
+func NewFXRateHandler(fxRateRepo *repository.FXRateRepository, logger *slog.Logger) *FXRateHandler {
+	return &FXRateHandler{
+		fxRateRepo: fxRateRepo,
+		logger:     logger,
+	}
+}
+
+// create fx rate
+
+// CreateFXRateRequest represents the fx rate request payload. The rate is the price of one unit of the base currency in the quote currency
+type CreateFXRateRequest struct {
+	BaseCurrency  models.Currency `json:"base_currency" binding:"required,iso4217"`
+	QuoteCurrency models.Currency `json:"quote_currency" binding:"required,iso4217,nefield=BaseCurrency"`
+	Rate          string          `json:"rate" binding:"required"`
+	// EffectiveAt defaults to the time the rate is loaded
+	EffectiveAt *time.Time `json:"effective_at"`
+}
+
+// CreateFXRate handles the loading of a new fx rate
+func (h *FXRateHandler) CreateFXRate(c *gin.Context) {
+	var body CreateFXRateRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		// log error
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if !body.BaseCurrency.Valid() || !body.QuoteCurrency.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: "Currency pair is not supported",
+		})
+		return
+	}
+	if _, err := models.ParseFXRate(body.Rate); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	effectiveAt := time.Now()
+	if body.EffectiveAt != nil {
+		effectiveAt = *body.EffectiveAt
+	}
+
+	rate, err := h.fxRateRepo.CreateRate(c.Request.Context(), &models.CreateFXRate{
+		BaseCurrency:  body.BaseCurrency,
+		QuoteCurrency: body.QuoteCurrency,
+		Rate:          body.Rate,
+		EffectiveAt:   effectiveAt,
+	})
+	if err != nil {
+		// log error
+		h.logError("failed to create fx rate", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to create fx rate",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "FX rate created successfully",
+		Data:    rate,
+	})
+}
+
+// GetFXRatesQuery represents the query params of the GetFXRates request
+type GetFXRatesQuery struct {
+	BaseCurrency  models.Currency `form:"base" binding:"required,iso4217"`
+	QuoteCurrency models.Currency `form:"quote" binding:"required,iso4217"`
+}
+
+// GetFXRates handles the retrieval of the rate history of a currency pair
+func (h *FXRateHandler) GetFXRates(c *gin.Context) {
+	var params GetFXRatesQuery
+	if err := c.ShouldBindQuery(&params); err != nil {
+		// log error
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	rates, err := h.fxRateRepo.GetRates(c.Request.Context(), params.BaseCurrency, params.QuoteCurrency)
+	if err != nil {
+		// log error
+		h.logError("failed to retrieve fx rates", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve fx rates",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "FX rates retrieved successfully",
+		Data:    rates,
+	})
+}
+
+// GetEffectiveFXRateQuery represents the query params of the GetEffectiveFXRate request
+type GetEffectiveFXRateQuery struct {
+	BaseCurrency  models.Currency `form:"base" binding:"required,iso4217"`
+	QuoteCurrency models.Currency `form:"quote" binding:"required,iso4217"`
+	At            *time.Time      `form:"at" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// GetEffectiveFXRate handles the retrieval of the rate in force for a currency pair at a point in time, now by default
+func (h *FXRateHandler) GetEffectiveFXRate(c *gin.Context) {
+	var params GetEffectiveFXRateQuery
+	if err := c.ShouldBindQuery(&params); err != nil {
+		// log error
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	at := time.Now()
+	if params.At != nil {
+		at = *params.At
+	}
+
+	rate, err := h.fxRateRepo.GetEffectiveRate(c.Request.Context(), params.BaseCurrency, params.QuoteCurrency, at)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			h.logError("fx rate not found", err)
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "FX rate not found",
+			})
+			return
+		}
+
+		// log error
+		h.logError("failed to retrieve fx rate", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve fx rate",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "FX rate retrieved successfully",
+		Data:    rate,
+	})
+}
+
+func (h *FXRateHandler) logError(message string, err error) {
+	h.logger.Error(message, "error", err)
+}
+
+// RegisterFXRateHandlers adds all the handler methods to the provided http router
+func RegisterFXRateHandlers(h *FXRateHandler, router *gin.Engine, logger *slog.Logger) {
+	r := router.Group("/fx-rates")
+	r.POST("", h.CreateFXRate)
+	r.GET("", h.GetFXRates)
+	r.GET("/effective", h.GetEffectiveFXRate)
+}
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// defaultHoldDuration is how long a hold reserves funds when no expiry is given
+const defaultHoldDuration = 7 * 24 * time.Hour
+
+// errors
+var (
+	ErrHoldNotFound       = errors.New("hold not found")
+	ErrHoldNotActive      = errors.New("hold is no longer active")
+	ErrHoldExpired        = errors.New("hold has expired")
+	ErrHoldCaptureTooHigh = errors.New("capture amount exceeds the held amount")
+	ErrHoldNotDrawnDown   = errors.New("holds can only be placed on accounts whose balance a debit reduces")
+)
+
+// create hold
+
+// CreateHoldRequest represents the hold request payload. The amount is reserved on the account until it is captured for the recipient, voided or expires
+type CreateHoldRequest struct {
+	Reference string `json:"reference" binding:"required"`
+	Account   string `json:"account" binding:"required"`
+	Recipient string `json:"recipient" binding:"required"`
+	Amount    uint64 `json:"amount" binding:"required,gt=0"`
+	// Currency optionally asserts the currency of the hold. It defaults to the currency of the account
+	Currency models.Currency `json:"currency" binding:"omitempty,iso4217"`
+	// ExpiresAt defaults to seven days from now
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateHold handles placing a hold on an account. The hold is only placed when the available balance of the account covers it
+func (h *TransactionHandler) CreateHold(c *gin.Context) {
+	var body CreateHoldRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	expiresAt := time.Now().Add(defaultHoldDuration)
+	if body.ExpiresAt != nil {
+		if !body.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+				Message: "Hold expiry must be in the future",
+			})
+			return
+		}
+		expiresAt = *body.ExpiresAt
+	}
+
+	// Start database transaction
+	repoTx, err := h.transactionRepo.GetTx(c.Request.Context())
+	if err != nil {
+		h.logError("failed to obtain database transaction", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to place hold",
+		})
+		return
+	}
+	defer repoTx.Rollback()
+
+	// validate accounts exist
+	accounts, err := h.validateAccounts(c.Request.Context(), body.Account, body.Recipient)
+	if err != nil {
+		h.respondError(c, err, "failed to place hold")
+		return
+	}
+	if err := authorizeDebit(c.Request.Context(), accounts[body.Account]); err != nil {
+		h.respondError(c, err, "failed to place hold")
+		return
+	}
+
+	// a hold reserves funds for a transfer, so it follows the same currency rules
+	transfer := CreateTransactionRequest{
+		Reference: body.Reference,
+		Sender:    body.Account,
+		Recipient: body.Recipient,
+		Amount:    body.Amount,
+		Currency:  body.Currency,
+	}
+	currency, err := transferCurrency(transfer, accounts)
+	if err != nil {
+		h.respondError(c, err, "failed to place hold")
+		return
+	}
+
+	acct := accounts[body.Account]
+	if acct.IsSystem() || acct.Class.Effect(models.DEBIT, body.Amount) >= 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: ErrHoldNotDrawnDown.Error(),
+		})
+		return
+	}
+	if err := h.ensureSufficientBalance(c.Request.Context(), repoTx, acct, body.Amount); err != nil {
+		h.respondError(c, err, "failed to place hold")
+		return
+	}
+
+	hold, err := h.holdRepo.CreateHold(c.Request.Context(), repoTx, &models.CreateHold{
+		Reference:   body.Reference,
+		AccountID:   acct.ID,
+		RecipientID: accounts[body.Recipient].ID,
+		Amount:      body.Amount,
+		Currency:    currency,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		h.logError("failed to create hold", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to place hold",
+		})
+		return
+	}
+
+	// commit transaction
+	if err := repoTx.Commit(); err != nil {
+		h.logError("failed to commit hold", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to place hold",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Hold placed successfully",
+		Data:    hold,
+	})
+}
+
+// GetHoldURI represents the path params of the hold requests
+type GetHoldURI struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+// GetHold handles hold retrieval
+func (h *TransactionHandler) GetHold(c *gin.Context) {
+	var params GetHoldURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	id, _ := uuid.Parse(params.ID)
+	hold, err := h.holdRepo.GetHoldByID(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Hold not found",
+			})
+			return
+		}
+		h.logError("failed to retrieve hold", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve hold",
+		})
+		return
+	}
+	if err := authorizeAccountAccess(c.Request.Context(), h.accountRepo, READ_ALL, []uuid.UUID{hold.AccountID, hold.RecipientID}); err != nil {
+		h.respondError(c, err, "Failed to retrieve hold")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Hold retrieved successfully",
+		Data:    hold,
+	})
+}
+
+// capture hold
+
+// CaptureHoldRequest represents the capture request payload. The amount is optional and defaults to the full held amount
+type CaptureHoldRequest struct {
+	Reference string `json:"reference" binding:"required"`
+	Amount    uint64 `json:"amount" binding:"omitempty,gt=0"`
+}
+
+// CaptureHold handles the capture of an active hold by posting the captured amount from the account to the recipient. A partial capture releases the rest of the hold
+func (h *TransactionHandler) CaptureHold(c *gin.Context) {
+	var params GetHoldURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var body CaptureHoldRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Start database transaction
+	repoTx, err := h.transactionRepo.GetTx(c.Request.Context())
+	if err != nil {
+		h.logError("failed to obtain database transaction", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to capture hold",
+		})
+		return
+	}
+	defer repoTx.Rollback()
+
+	hold, err := h.lockActiveHold(c, repoTx, params.ID)
+	if err != nil {
+		return
+	}
+
+	amount := body.Amount
+	if amount == 0 {
+		amount = hold.Amount
+	}
+	if amount > hold.Amount {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: ErrHoldCaptureTooHigh.Error(),
+		})
+		return
+	}
+
+	// both accounts must still be active
+	accounts, err := h.accountRepo.GetAccountsByIDs(c.Request.Context(), []uuid.UUID{hold.AccountID, hold.RecipientID})
+	if err != nil {
+		h.logError("failed to retrieve related accounts", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to capture hold",
+		})
+		return
+	}
+	if len(accounts) != 2 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Held or recipient account is disabled",
+		})
+		return
+	}
+
+	// the captured amount was reserved by the hold, so the account can cover it without a further balance check
+	transaction, err := h.createTransaction(c.Request.Context(), repoTx, &models.CreateTransaction{
+		Reference: body.Reference,
+		Lines: []models.CreateTransactionLine{
+			{
+				AccountID: hold.AccountID,
+				Purpose:   models.DEBIT,
+				Amount:    amount,
+				Currency:  hold.Currency,
+			},
+			{
+				AccountID: hold.RecipientID,
+				Purpose:   models.CREDIT,
+				Amount:    amount,
+				Currency:  hold.Currency,
+			},
+		},
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to capture hold")
+		return
+	}
+
+	hold, err = h.holdRepo.CaptureHold(c.Request.Context(), repoTx, hold.ID, amount, transaction.ID)
+	if err != nil {
+		h.logError("failed to capture hold", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to capture hold",
+		})
+		return
+	}
+
+	// commit transaction
+	if err := repoTx.Commit(); err != nil {
+		h.logError("failed to commit hold capture", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to capture hold",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Hold captured successfully",
+		Data:    hold,
+	})
+}
+
+// VoidHold handles releasing an active hold without moving any funds
+func (h *TransactionHandler) VoidHold(c *gin.Context) {
+	var params GetHoldURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Start database transaction
+	repoTx, err := h.transactionRepo.GetTx(c.Request.Context())
+	if err != nil {
+		h.logError("failed to obtain database transaction", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to void hold",
+		})
+		return
+	}
+	defer repoTx.Rollback()
+
+	hold, err := h.lockActiveHold(c, repoTx, params.ID)
+	if err != nil {
+		return
+	}
+
+	hold, err = h.holdRepo.VoidHold(c.Request.Context(), repoTx, hold.ID)
+	if err != nil {
+		h.logError("failed to void hold", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to void hold",
+		})
+		return
+	}
+
+	// commit transaction
+	if err := repoTx.Commit(); err != nil {
+		h.logError("failed to commit hold void", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to void hold",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Hold voided successfully",
+		Data:    hold,
+	})
+}
+
+// lockActiveHold locks the hold for the rest of the database transaction and verifies that it can still be captured or voided by the caller
+func (h *TransactionHandler) lockActiveHold(c *gin.Context, tx *sql.Tx, holdID string) (*models.Hold, error) {
+	id, _ := uuid.Parse(holdID)
+	hold, err := h.holdRepo.LockHold(c.Request.Context(), tx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Hold not found",
+			})
+			return nil, ErrHoldNotFound
+		}
+		h.logError("failed to lock hold", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to process hold",
+		})
+		return nil, err
+	}
+
+	if hold.Status != models.HoldActive {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Message: "Hold is already " + string(hold.Status),
+		})
+		return nil, ErrHoldNotActive
+	}
+	if hold.Expired(time.Now()) {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Message: ErrHoldExpired.Error(),
+		})
+		return nil, ErrHoldExpired
+	}
+	if err := h.authorizeHoldParty(c.Request.Context(), hold); err != nil {
+		h.respondError(c, err, "failed to process hold")
+		return nil, err
+	}
+	return hold, nil
+}
+
+// authorizeHoldParty verifies that the caller owns the recipient account of the hold, unless they manage accounts. The debit itself was authorized when the hold
+// was placed, and the payer may only read the hold: voiding it would take back the funds the recipient was promised
+func (h *TransactionHandler) authorizeHoldParty(ctx context.Context, hold *models.Hold) error {
+	return authorizeAccountAccess(ctx, h.accountRepo, MANAGE_ACCOUNTS, []uuid.UUID{hold.RecipientID})
+}
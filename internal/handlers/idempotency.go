@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/auth"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// IdempotencyKeyHeader is the request header that makes a posting safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength is the longest idempotency key accepted
+const maxIdempotencyKeyLength = 255
+
+// idempotentContentType is the content type of stored responses, the one gin writes JSON with
+const idempotentContentType = "application/json; charset=utf-8"
+
+// errors
+var (
+	ErrIdempotencyKeyReused = errors.New("idempotency key already used with a different request")
+)
+
+// idempotencyKeyOwner returns the user whose idempotency keys the request uses. Keys are scoped to their user so that one user's key never replays another's
+// response. Requests made by the server itself use the keys of the system user
+func idempotencyKeyOwner(c *gin.Context) uuid.UUID {
+	if principal, ok := auth.PrincipalFrom(c.Request.Context()); ok {
+		return principal.UserID
+	}
+	return uuid.MustParse(models.SystemUserID)
+}
+
+// claimIdempotencyKey reserves the idempotency key of the request in the given database transaction. When the key was used before, the original response is replayed
+// if the request matches, otherwise the request is rejected with a conflict. A response has been written whenever replayed is true or an error is returned
+func (h *TransactionHandler) claimIdempotencyKey(c *gin.Context, tx *sql.Tx, key string, body any) (replayed bool, err error) {
+	if len(key) > maxIdempotencyKeyLength {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: "Idempotency key is too long",
+		})
+		return false, errors.New("idempotency key is too long")
+	}
+
+	requestHash, err := hashRequest(c, body)
+	if err != nil {
+		h.logError("failed to hash request", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to process transaction",
+		})
+		return false, err
+	}
+
+	record, err := h.idempotencyRepo.ClaimKey(c.Request.Context(), tx, idempotencyKeyOwner(c), key, requestHash)
+	if err != nil {
+		h.logError("failed to claim idempotency key", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to process transaction",
+		})
+		return false, err
+	}
+
+	// first use of the key
+	if record == nil {
+		return false, nil
+	}
+
+	if record.RequestHash != requestHash {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Message: ErrIdempotencyKeyReused.Error(),
+		})
+		return false, ErrIdempotencyKeyReused
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(record.StatusCode, idempotentContentType, record.ResponseBody)
+	return true, nil
+}
+
+// saveIdempotentResponse stores the response of a request made with an idempotency key in the same database transaction as its posting. The encoded response
+// is returned so that the request is answered with the very bytes a replay returns
+func (h *TransactionHandler) saveIdempotentResponse(c *gin.Context, tx *sql.Tx, key string, statusCode int, response models.APIResponse) ([]byte, error) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.idempotencyRepo.SaveResponse(c.Request.Context(), tx, idempotencyKeyOwner(c), key, statusCode, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// hashRequest fingerprints a request by its method, path and decoded body. Re-encoding the body ignores differences in whitespace and key order
+func hashRequest(c *gin.Context, body any) (string, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method + " " + c.FullPath() + "\n"))
+	hash.Write(encoded)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+	"github.com/mrshabel/sgbank/internal/utils"
+)
+
+func TestHashRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// hash decodes the raw body into a transfer request as the handlers do, then fingerprints it
+	var hashes []string
+	hash := func(c *gin.Context) {
+		var body CreateTransactionRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			t.Fatalf("bind body: %v", err)
+		}
+		h, err := hashRequest(c, body)
+		if err != nil {
+			t.Fatalf("hashRequest() error = %v", err)
+		}
+		hashes = append(hashes, h)
+	}
+	router.POST("/transactions", hash)
+	router.POST("/transactions/:id/reverse", hash)
+
+	send := func(method, path, body string) string {
+		hashes = nil
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, strings.NewReader(body)))
+		if len(hashes) != 1 {
+			t.Fatalf("%s %s was not hashed", method, path)
+		}
+		return hashes[0]
+	}
+
+	body := `{"reference":"ref-1","sender":"1000000001","recipient":"1000000002","amount":100}`
+	original := send(http.MethodPost, "/transactions", body)
+	if len(original) != 64 {
+		t.Errorf("hash %q is not hex SHA-256", original)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		same bool
+	}{
+		{"same request", "/transactions", body, true},
+		{"whitespace and key order", "/transactions", "{\n  \"amount\": 100, \"recipient\": \"1000000002\",\n  \"sender\": \"1000000001\", \"reference\": \"ref-1\"\n}", true},
+		{"other amount", "/transactions", strings.Replace(body, "100", "101", 1), false},
+		{"other reference", "/transactions", strings.Replace(body, "ref-1", "ref-2", 1), false},
+		// the route is hashed rather than the path, but another route never matches
+		{"other route", "/transactions/1/reverse", body, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := send(http.MethodPost, tt.path, tt.body); (got == original) != tt.same {
+				t.Errorf("hash = %s, original %s, want same %v", got, original, tt.same)
+			}
+		})
+	}
+}
+
+// TestCreateTransactionIdempotent retries a transfer with its idempotency key and checks that it is posted once, that the retry replays the original response
+// byte for byte and that a different request under the same key is refused. It runs against the postgres database in TEST_DATABASE_URL, which is migrated up first
+func TestCreateTransactionIdempotent(t *testing.T) {
+	h, db := openTestHandler(t)
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	router := gin.New()
+	RegisterTransactionHandlers(h, router, logger)
+
+	user, err := repository.NewUserRepository(db, logger).CreateUser(ctx, &models.CreateUser{Email: uuid.NewString() + "@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	account, err := repository.NewAccountRepository(db, logger).CreateAccount(ctx, &models.CreateAccount{
+		AccountNumber: utils.GenerateAccountNumber(10),
+		UserID:        user.ID.String(),
+		Class:         models.LIABILITY,
+		Currency:      models.DefaultCurrency,
+		Type:          models.DEPOSIT,
+	})
+	if err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+
+	key := uuid.NewString()
+	request := CreateTransactionRequest{Reference: uuid.NewString(), Sender: models.RootAccount, Recipient: account.AccountNumber, Amount: 250}
+	post := func(body CreateTransactionRequest) *httptest.ResponseRecorder {
+		encoded, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/transactions", bytes.NewReader(encoded))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post(request)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first request: status %d: %s", first.Code, first.Body)
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("first request is marked as replayed")
+	}
+
+	retry := post(request)
+	if retry.Code != http.StatusOK || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry: status %d, replayed %q", retry.Code, retry.Header().Get("Idempotent-Replayed"))
+	}
+	if !bytes.Equal(retry.Body.Bytes(), first.Body.Bytes()) {
+		t.Errorf("retry replayed\n%s\nwant\n%s", retry.Body, first.Body)
+	}
+	if retry.Header().Get("Content-Type") != first.Header().Get("Content-Type") {
+		t.Errorf("retry content type = %q, want %q", retry.Header().Get("Content-Type"), first.Header().Get("Content-Type"))
+	}
+
+	changed := request
+	changed.Amount++
+	if conflict := post(changed); conflict.Code != http.StatusConflict {
+		t.Errorf("other request under the same key: status %d, want %d", conflict.Code, http.StatusConflict)
+	}
+
+	// the transfer was posted once
+	var credits int64
+	if err := db.QueryRowContext(ctx, `SELECT credits FROM account_balances WHERE account_id = $1 AND currency = $2`, account.ID, models.DefaultCurrency).Scan(&credits); err != nil {
+		t.Fatalf("read balance: %v", err)
+	}
+	if credits != int64(request.Amount) {
+		t.Errorf("account credited %d, want %d", credits, request.Amount)
+	}
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// errors
+var (
+	ErrInterestNotDeposit = errors.New("interest is only paid on customer deposit accounts whose balance a credit increases")
+)
+
+// InterestHandler contains http handlers for interest products and the interest earned by accounts
+type InterestHandler struct {
+	interestRepo *repository.InterestRepository
+	accountRepo  *repository.AccountRepository
+	logger       *slog.Logger
+}
+
+// NewInterestHandler creates a new interest handler
+func NewInterestHandler(interestRepo *repository.InterestRepository, accountRepo *repository.AccountRepository, logger *slog.Logger) *InterestHandler {
+	return &InterestHandler{
+		interestRepo: interestRepo,
+		accountRepo:  accountRepo,
+		logger:       logger,
+	}
+}
+
+// create interest product
+
+// CreateInterestProductRequest represents the interest product request payload. The annual rate is a decimal fraction, eg: "0.035" for 3.5% a year
+type CreateInterestProductRequest struct {
+	Name       string          `json:"name" binding:"required,max=100"`
+	AnnualRate string          `json:"annual_rate" binding:"required"`
+	DayCount   models.DayCount `json:"day_count" binding:"required,oneof=ACT/365 30/360"`
+}
+
+// CreateInterestProduct handles the creation of an interest product
+func (h *InterestHandler) CreateInterestProduct(c *gin.Context) {
+	var body CreateInterestProductRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if _, err := models.ParseInterestRate(body.AnnualRate); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	product, err := h.interestRepo.CreateProduct(c.Request.Context(), &models.CreateInterestProduct{
+		Name:       body.Name,
+		AnnualRate: body.AnnualRate,
+		DayCount:   body.DayCount,
+	})
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Message: "Interest product name already exists",
+			})
+			return
+		}
+		h.logError("failed to create interest product", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to create interest product",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Interest product created successfully",
+		Data:    product,
+	})
+}
+
+// GetInterestProducts handles the retrieval of every interest product
+func (h *InterestHandler) GetInterestProducts(c *gin.Context) {
+	products, err := h.interestRepo.GetProducts(c.Request.Context())
+	if err != nil {
+		h.logError("failed to retrieve interest products", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve interest products",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Interest products retrieved successfully",
+		Data:    products,
+	})
+}
+
+type GetInterestProductURI struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+// GetInterestProduct handles the retrieval of an interest product
+func (h *InterestHandler) GetInterestProduct(c *gin.Context) {
+	var params GetInterestProductURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	id, _ := uuid.Parse(params.ID)
+	product, err := h.interestRepo.GetProductByID(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Interest product not found",
+			})
+			return
+		}
+		h.logError("failed to retrieve interest product", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve interest product",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Interest product retrieved successfully",
+		Data:    product,
+	})
+}
+
+// enrol account
+
+// EnrolAccountRequest represents the interest enrolment request payload
+type EnrolAccountRequest struct {
+	ProductID string `json:"product_id" binding:"required,uuid"`
+}
+
+// EnrolAccount handles enrolling an account in an interest product. Interest accrues from today, and an account that is already enrolled moves to the new product
+func (h *InterestHandler) EnrolAccount(c *gin.Context) {
+	var params GetAccountURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var body EnrolAccountRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	acctID, _ := uuid.Parse(params.ID)
+	account, err := h.accountRepo.GetAccountByID(c.Request.Context(), acctID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Account not found",
+			})
+			return
+		}
+		h.logError("failed to retrieve account", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to enrol account",
+		})
+		return
+	}
+	if account.IsSystem() || account.Class != models.LIABILITY || account.Type != models.DEPOSIT {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: ErrInterestNotDeposit.Error(),
+		})
+		return
+	}
+
+	productID, _ := uuid.Parse(body.ProductID)
+	if _, err := h.interestRepo.GetProductByID(c.Request.Context(), productID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Interest product not found",
+			})
+			return
+		}
+		h.logError("failed to retrieve interest product", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to enrol account",
+		})
+		return
+	}
+
+	enrolment, err := h.interestRepo.EnrolAccount(c.Request.Context(), acctID, productID, time.Now().UTC())
+	if err != nil {
+		h.logError("failed to enrol account", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to enrol account",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Account enrolled successfully",
+		Data:    enrolment,
+	})
+}
+
+// get account interest
+
+// GetAccountInterestQuery narrows the accruals returned to the days between from and to inclusive. It defaults to the current month
+type GetAccountInterestQuery struct {
+	From *time.Time `form:"from" time_format:"2006-01-02" time_utc:"1"`
+	To   *time.Time `form:"to" time_format:"2006-01-02" time_utc:"1"`
+}
+
+// AccountInterestResponse is the interest product of an account along with its daily accruals
+type AccountInterestResponse struct {
+	*models.AccountInterest
+	Accruals []*models.InterestAccrual `json:"accruals"`
+}
+
+// GetAccountInterest handles the retrieval of the interest product of an account and the interest it accrued each day
+func (h *InterestHandler) GetAccountInterest(c *gin.Context) {
+	var params GetAccountURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var query GetAccountInterestQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	from, to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), now
+	if query.From != nil {
+		from = *query.From
+	}
+	if query.To != nil {
+		to = *query.To
+	}
+
+	acctID, _ := uuid.Parse(params.ID)
+	if err := authorizeAccountAccess(c.Request.Context(), h.accountRepo, READ_ALL, []uuid.UUID{acctID}); err != nil {
+		respondAccessError(c, h.logger, err, "Failed to retrieve account interest")
+		return
+	}
+	enrolment, err := h.interestRepo.GetAccountInterest(c.Request.Context(), acctID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Account is not enrolled in an interest product",
+			})
+			return
+		}
+		h.logError("failed to retrieve account interest", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve account interest",
+		})
+		return
+	}
+
+	accruals, err := h.interestRepo.GetAccruals(c.Request.Context(), acctID, from, to)
+	if err != nil {
+		h.logError("failed to retrieve interest accruals", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve account interest",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Account interest retrieved successfully",
+		Data: AccountInterestResponse{
+			AccountInterest: enrolment,
+			Accruals:        accruals,
+		},
+	})
+}
+
+func (h *InterestHandler) logError(message string, err error) {
+	h.logger.Error(message, "error", err)
+}
+
+// RegisterInterestHandlers adds all the handler methods to the provided http router
+func RegisterInterestHandlers(h *InterestHandler, router *gin.Engine, logger *slog.Logger) {
+	r := router.Group("/interest-products")
+	r.POST("", h.CreateInterestProduct)
+	r.GET("", h.GetInterestProducts)
+	r.GET("/:id", h.GetInterestProduct)
+
+	router.PUT("/accounts/:id/interest", h.EnrolAccount)
+	router.GET("/accounts/:id/interest", h.GetAccountInterest)
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// create journal entry
+
+// JournalEntryLineRequest represents a single debit or credit leg of a journal entry
+type JournalEntryLineRequest struct {
+	AccountNumber string                    `json:"account_number" binding:"required"`
+	Purpose       models.TransactionPurpose `json:"purpose" binding:"required,oneof=credit debit"`
+	Amount        uint64                    `json:"amount" binding:"required,gt=0"`
+	// Currency defaults to the currency of the account. System accounts may be posted in any supported currency
+	Currency models.Currency `json:"currency" binding:"omitempty,iso4217"`
+}
+
+// CreateJournalEntryRequest represents the journal entry request payload. An entry is only accepted when its debits equal its credits
+type CreateJournalEntryRequest struct {
+	Reference string                    `json:"reference" binding:"required"`
+	Lines     []JournalEntryLineRequest `json:"lines" binding:"required,min=2,dive"`
+}
+
+// CreateJournalEntry handles the posting of a multi-leg transaction across any number of accounts
+func (h *TransactionHandler) CreateJournalEntry(c *gin.Context) {
+	var body CreateJournalEntryRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Start database transaction
+	repoTx, err := h.transactionRepo.GetTx(c.Request.Context())
+	if err != nil {
+		h.logError("failed to obtain database transaction", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to create journal entry",
+		})
+		return
+	}
+	defer repoTx.Rollback()
+
+	// validate accounts exist
+	acctNums := make([]string, 0, len(body.Lines))
+	for _, line := range body.Lines {
+		acctNums = append(acctNums, line.AccountNumber)
+	}
+	accounts, err := h.validateAccounts(c.Request.Context(), acctNums...)
+	if err != nil {
+		h.respondError(c, err, "failed to process journal entry")
+		return
+	}
+
+	// map request lines to ledger lines and verify that they balance per currency
+	lines := make([]models.CreateTransactionLine, 0, len(body.Lines))
+	for _, line := range body.Lines {
+		acct := accounts[line.AccountNumber]
+		currency := line.Currency
+		if currency == "" {
+			currency = acct.Currency
+		}
+		if !acct.Accepts(currency) {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Message: "Account " + acct.AccountNumber + " does not hold " + string(currency),
+			})
+			return
+		}
+
+		lines = append(lines, models.CreateTransactionLine{
+			AccountID: acct.ID,
+			Purpose:   line.Purpose,
+			Amount:    line.Amount,
+			Currency:  currency,
+		})
+	}
+	if err := repository.ValidateLines(lines); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := authorizeDebit(c.Request.Context(), debitedAccounts(lines, accounts)...); err != nil {
+		h.respondError(c, err, "failed to process journal entry")
+		return
+	}
+
+	// verify that every account with a net outflow can cover it
+	if err := h.checkNetOutflows(c.Request.Context(), repoTx, lines, accounts); err != nil {
+		h.respondError(c, err, "failed to process journal entry")
+		return
+	}
+
+	// create the transaction
+	transaction, err := h.createTransaction(c.Request.Context(), repoTx, &models.CreateTransaction{
+		Reference: body.Reference,
+		Lines:     lines,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to process journal entry")
+		return
+	}
+
+	// commit transaction
+	if err := repoTx.Commit(); err != nil {
+		h.logError("failed to commit journal entry", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to process journal entry",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Journal entry processed successfully",
+		Data:    transaction,
+	})
+}
+
+// checkNetOutflows verifies that every account whose normal balance is reduced by the entry can cover the reduction. All reduced accounts are locked together before any balance is read
+func (h *TransactionHandler) checkNetOutflows(ctx context.Context, tx *sql.Tx, lines []models.CreateTransactionLine, accounts map[string]*models.Account) error {
+	accountsByID := make(map[uuid.UUID]*models.Account, len(accounts))
+	for _, acct := range accounts {
+		accountsByID[acct.ID] = acct
+	}
+
+	// net effect of the entry on each account's normal balance
+	effects := make(map[uuid.UUID]int64)
+	var order []uuid.UUID
+	for _, line := range lines {
+		if _, ok := effects[line.AccountID]; !ok {
+			order = append(order, line.AccountID)
+		}
+		// effects stay within ±MaxInt64 so that a net reduction can always be negated
+		effect, net := accountsByID[line.AccountID].Class.Effect(line.Purpose, line.Amount), effects[line.AccountID]
+		if (effect > 0 && net > math.MaxInt64-effect) || (effect < 0 && net < -math.MaxInt64-effect) {
+			return newRequestError(http.StatusBadRequest, repository.ErrLineAmountOverflow.Error(), repository.ErrLineAmountOverflow)
+		}
+		effects[line.AccountID] = net + effect
+	}
+
+	var reduced []uuid.UUID
+	for _, acctID := range order {
+		if effects[acctID] < 0 && !accountsByID[acctID].IsSystem() {
+			reduced = append(reduced, acctID)
+		}
+	}
+	if len(reduced) == 0 {
+		return nil
+	}
+
+	if err := h.transactionRepo.LockAccounts(ctx, tx, reduced...); err != nil {
+		return fmt.Errorf("lock accounts: %w", err)
+	}
+
+	for _, acctID := range reduced {
+		if err := h.ensureSufficientBalance(ctx, tx, accountsByID[acctID], uint64(-effects[acctID])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+func TestCheckNetOutflowsOverflow(t *testing.T) {
+	customer := &models.Account{ID: uuid.New(), AccountNumber: "1000000001", UserID: uuid.NewString(), Class: models.LIABILITY, Currency: models.USD}
+	root := &models.Account{ID: uuid.New(), AccountNumber: "0000000000", UserID: models.SystemUserID, Class: models.ASSET, Currency: models.USD}
+	accounts := map[string]*models.Account{customer.AccountNumber: customer, root.AccountNumber: root}
+	line := func(acct *models.Account, purpose models.TransactionPurpose, currency models.Currency) models.CreateTransactionLine {
+		return models.CreateTransactionLine{AccountID: acct.ID, Purpose: purpose, Amount: math.MaxInt64, Currency: currency}
+	}
+
+	tests := []struct {
+		name  string
+		lines []models.CreateTransactionLine
+		err   error
+	}{
+		// each currency balances on its own, but the customer is drained of 2×MaxInt64 in all
+		{"reduction beyond int64", []models.CreateTransactionLine{
+			line(customer, models.DEBIT, models.USD), line(root, models.CREDIT, models.USD),
+			line(customer, models.DEBIT, models.EUR), line(root, models.CREDIT, models.EUR),
+		}, repository.ErrLineAmountOverflow},
+		{"increase beyond int64", []models.CreateTransactionLine{
+			line(customer, models.CREDIT, models.USD), line(root, models.DEBIT, models.USD),
+			line(customer, models.CREDIT, models.EUR), line(root, models.DEBIT, models.EUR),
+		}, repository.ErrLineAmountOverflow},
+		// reductions of system accounts are not checked, so nothing is read from the database
+		{"no reduction", []models.CreateTransactionLine{line(customer, models.CREDIT, models.USD), line(root, models.DEBIT, models.USD)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &TransactionHandler{}
+			if err := h.checkNetOutflows(context.Background(), nil, tt.lines, accounts); !errors.Is(err, tt.err) {
+				t.Errorf("checkNetOutflows() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// LedgerHandler contains http handlers for ledger integrity checks
+type LedgerHandler struct {
+	transactionRepo *repository.TransactionRepository
+	logger          *slog.Logger
+}
+
+// NewLedgerHandler creates a new ledger handler
+func NewLedgerHandler(transactionRepo *repository.TransactionRepository, logger *slog.Logger) *LedgerHandler {
+	return &LedgerHandler{
+		transactionRepo: transactionRepo,
+		logger:          logger,
+	}
+}
+
+// VerifyLedger handles the verification of the ledger hash chain. The report names the first entry that was altered, inserted or removed
+func (h *LedgerHandler) VerifyLedger(c *gin.Context) {
+	result, err := h.transactionRepo.VerifyLedger(c.Request.Context())
+	if err != nil {
+		// log error
+		h.logError("failed to verify ledger", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to verify ledger",
+		})
+		return
+	}
+
+	message := "Ledger verified successfully"
+	if !result.Valid {
+		h.logger.Warn("ledger chain verification failed", "kind", result.Fault.Kind, "sequence", result.Fault.Sequence, "reason", result.Fault.Reason)
+		message = "Ledger has been tampered with"
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: message,
+		Data:    result,
+	})
+}
+
+func (h *LedgerHandler) logError(message string, err error) {
+	h.logger.Error(message, "error", err)
+}
+
+// RegisterLedgerHandlers adds all the handler methods to the provided http router
+func RegisterLedgerHandlers(h *LedgerHandler, router *gin.Engine, logger *slog.Logger) {
+	r := router.Group("/ledger")
+	r.GET("/verify", h.VerifyLedger)
+}
@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/pain"
+)
+
+// errors
+var (
+	ErrBatchProcessed = errors.New("payment batch has already been processed")
+	ErrBatchRejected  = errors.New("payment batch was rejected")
+)
+
+// maxPaymentFileSize bounds the size of an uploaded pain.001 file
+const maxPaymentFileSize = 10 << 20
+
+// create bulk transfer
+
+// CreateBulkTransferQuery selects how the batch is posted, atomically by default, and the format of the status report, json by default or a pain.002 document
+type CreateBulkTransferQuery struct {
+	Mode   models.BatchMode `form:"mode" binding:"omitempty,oneof=atomic per_instruction"`
+	Format string           `form:"format" binding:"omitempty,oneof=json xml"`
+}
+
+// CreateBulkTransfer handles the upload of a pain.001 credit transfer file, sent as the request body or as the file field of a multipart form. Every instruction is
+// validated and posted as a transfer, and the pain.002 style status report of the batch is returned
+func (h *TransactionHandler) CreateBulkTransfer(c *gin.Context) {
+	var query CreateBulkTransferQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+	mode := query.Mode
+	if mode == "" {
+		mode = models.ATOMIC_BATCH
+	}
+
+	var file io.Reader = http.MaxBytesReader(c.Writer, c.Request.Body, maxPaymentFileSize)
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+				Message: "A pain.001 file is required in the file field",
+			})
+			return
+		}
+		if header.Size > maxPaymentFileSize {
+			c.JSON(http.StatusRequestEntityTooLarge, models.APIResponse{
+				Message: "Payment file is too large",
+			})
+			return
+		}
+		f, err := header.Open()
+		if err != nil {
+			h.logError("failed to open payment file", err)
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Message: "failed to process payment batch",
+			})
+			return
+		}
+		defer f.Close()
+		file = f
+	}
+
+	batch, err := pain.Parse(file)
+	if err != nil {
+		h.logError("invalid payment file", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	report, err := h.PostBatch(c.Request.Context(), batch, mode)
+	if err != nil {
+		var reqErr *requestError
+		if report != nil && errors.As(err, &reqErr) {
+			h.respondPaymentReport(c, reqErr.status, reqErr.message, report, query.Format)
+			return
+		}
+		h.respondError(c, err, "failed to process payment batch")
+		return
+	}
+
+	h.logger.Info("payment batch processed", "message_id", report.MessageID, "mode", report.Mode, "status", report.Status, "instructions", len(report.Instructions))
+	h.respondPaymentReport(c, http.StatusOK, "Payment batch processed successfully", report, query.Format)
+}
+
+// respondPaymentReport writes the status report of a payment batch as json or as a pain.002 document
+func (h *TransactionHandler) respondPaymentReport(c *gin.Context, status int, message string, report *models.PaymentReport, format string) {
+	if format != "xml" {
+		c.JSON(status, models.APIResponse{
+			Message: message,
+			Data:    report,
+		})
+		return
+	}
+
+	body, err := pain.StatusReport(report)
+	if err != nil {
+		h.logError("failed to render payment status report", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to render payment status report",
+		})
+		return
+	}
+	c.Data(status, "application/xml; charset=utf-8", body)
+}
+
+// PostBatch validates every instruction of a payment batch and posts them as transfers, either all in one database transaction or each in its own. The report
+// of an atomic batch that was rejected is not stored, so the corrected file may be uploaded again, and is returned along with an unprocessable request error. A
+// batch whose message id was already processed is not posted again: its stored report is returned along with a conflict request error
+func (h *TransactionHandler) PostBatch(ctx context.Context, batch *models.PaymentBatch, mode models.BatchMode) (*models.PaymentReport, error) {
+	if processed, err := h.processedBatch(ctx, batch.MessageID); processed != nil || err != nil {
+		return processed, err
+	}
+
+	report := &models.PaymentReport{
+		ID:           uuid.New(),
+		MessageID:    batch.MessageID,
+		Mode:         mode,
+		Status:       models.PAYMENT_PENDING,
+		Instructions: make([]models.InstructionReport, len(batch.Instructions)),
+	}
+	transfers := make([]*CreateTransactionRequest, len(batch.Instructions))
+	var debtors []uuid.UUID
+	seen := make(map[string]bool)
+	for i, instruction := range batch.Instructions {
+		report.Instructions[i] = models.InstructionReport{PaymentInstruction: instruction, Status: models.PAYMENT_PENDING}
+
+		transfer, debtor, err := h.batchTransfer(ctx, batch.MessageID, i, instruction, seen)
+		if err != nil {
+			code, ok := rejectionReason(err)
+			if !ok {
+				return nil, err
+			}
+			report.Instructions[i].Reject(code, err.Error())
+			continue
+		}
+		transfers[i] = transfer
+		debtors = append(debtors, debtor.ID)
+	}
+
+	if mode == models.ATOMIC_BATCH {
+		return h.postAtomicBatch(ctx, report, transfers, debtors)
+	}
+	return h.postBatchPerInstruction(ctx, report, transfers)
+}
+
+// batchTransfer validates an instruction of a batch and converts it into a transfer. The transfer is referenced by the message id of the batch and the end to end
+// id of the instruction, or its position when it has none. The debtor account is returned along with it
+func (h *TransactionHandler) batchTransfer(ctx context.Context, messageID string, i int, instruction models.PaymentInstruction, seen map[string]bool) (*CreateTransactionRequest, *models.Account, error) {
+	reference := messageID + "/" + strconv.Itoa(i+1)
+	if instruction.EndToEndID != models.NotProvided {
+		if seen[instruction.EndToEndID] {
+			return nil, nil, newRequestError(http.StatusBadRequest, "End to end id "+instruction.EndToEndID+" is used by another instruction of the batch", ErrTransactionExists)
+		}
+		seen[instruction.EndToEndID] = true
+		reference = messageID + "/" + instruction.EndToEndID
+	}
+
+	if !instruction.Currency.Valid() {
+		return nil, nil, newRequestError(http.StatusBadRequest, "Currency "+string(instruction.Currency)+" is not supported", ErrCurrencyMismatch)
+	}
+	amount, err := instruction.Currency.ParseAmount(instruction.Amount)
+	if err != nil {
+		return nil, nil, newRequestError(http.StatusBadRequest, "Amount "+instruction.Amount+" is invalid", err)
+	}
+
+	accounts, err := h.validateAccounts(ctx, instruction.Debtor, instruction.Creditor)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := authorizeDebit(ctx, accounts[instruction.Debtor]); err != nil {
+		return nil, nil, err
+	}
+
+	return &CreateTransactionRequest{
+		Reference: reference,
+		Sender:    instruction.Debtor,
+		Recipient: instruction.Creditor,
+		Amount:    amount,
+		Currency:  instruction.Currency,
+	}, accounts[instruction.Debtor], nil
+}
+
+// postAtomicBatch posts every valid transfer of the batch in one database transaction. The debtor accounts are locked up front in id order, and a single rejected
+// instruction rejects the whole batch
+func (h *TransactionHandler) postAtomicBatch(ctx context.Context, report *models.PaymentReport, transfers []*CreateTransactionRequest, debtors []uuid.UUID) (*models.PaymentReport, error) {
+	if rejectBatch(report) {
+		return report, batchRejectedError(report)
+	}
+
+	tx, err := h.transactionRepo.GetTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	claimed, err := h.paymentBatchRepo.CreateBatch(ctx, tx, report)
+	if err != nil {
+		return h.batchClaimError(ctx, report.MessageID, err)
+	}
+	report.ID, report.CreatedAt = claimed.ID, claimed.CreatedAt
+
+	if err := h.transactionRepo.LockAccounts(ctx, tx, debtors...); err != nil {
+		return nil, fmt.Errorf("lock debtor accounts: %w", err)
+	}
+	for i, transfer := range transfers {
+		transaction, err := h.PostTransfer(ctx, tx, *transfer)
+		if err != nil {
+			code, ok := rejectionReason(err)
+			if !ok {
+				return nil, err
+			}
+			// the rejection may have aborted the database transaction, and it rejects the rest of the batch anyway
+			report.Instructions[i].Reject(code, err.Error())
+			break
+		}
+		report.Instructions[i].Status, report.Instructions[i].TransactionID = models.PAYMENT_ACCEPTED, &transaction.ID
+	}
+	if rejectBatch(report) {
+		return report, batchRejectedError(report)
+	}
+
+	report.Settle()
+	if err := h.paymentBatchRepo.CompleteBatch(ctx, tx, report); err != nil {
+		return nil, fmt.Errorf("complete payment batch: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit payment batch: %w", err)
+	}
+	return report, nil
+}
+
+// postBatchPerInstruction records the batch, then posts each valid transfer in its own database transaction so that rejected instructions do not hold back the
+// others
+func (h *TransactionHandler) postBatchPerInstruction(ctx context.Context, report *models.PaymentReport, transfers []*CreateTransactionRequest) (*models.PaymentReport, error) {
+	err := h.inTx(ctx, func(tx *sql.Tx) error {
+		claimed, err := h.paymentBatchRepo.CreateBatch(ctx, tx, report)
+		if err != nil {
+			return err
+		}
+		report.ID, report.CreatedAt = claimed.ID, claimed.CreatedAt
+		return nil
+	})
+	if err != nil {
+		return h.batchClaimError(ctx, report.MessageID, err)
+	}
+
+	for i, transfer := range transfers {
+		if transfer == nil {
+			continue
+		}
+		var transaction *models.Transaction
+		err := h.inTx(ctx, func(tx *sql.Tx) (err error) {
+			transaction, err = h.PostTransfer(ctx, tx, *transfer)
+			return err
+		})
+		if err != nil {
+			code, ok := rejectionReason(err)
+			if !ok {
+				// a failure of the server must not keep the rest of the batch from being posted
+				h.logError("failed to post payment instruction", err)
+				code, err = models.REASON_NARRATIVE, errors.New("failed to process instruction")
+			}
+			report.Instructions[i].Reject(code, err.Error())
+			continue
+		}
+		report.Instructions[i].Status, report.Instructions[i].TransactionID = models.PAYMENT_ACCEPTED, &transaction.ID
+	}
+
+	report.Settle()
+	if err := h.inTx(ctx, func(tx *sql.Tx) error {
+		return h.paymentBatchRepo.CompleteBatch(ctx, tx, report)
+	}); err != nil {
+		return nil, fmt.Errorf("complete payment batch: %w", err)
+	}
+	return report, nil
+}
+
+// inTx runs fn in a database transaction that is committed when fn succeeds
+func (h *TransactionHandler) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := h.transactionRepo.GetTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// processedBatch returns the stored report and a conflict request error when a batch with the message id was already processed
+func (h *TransactionHandler) processedBatch(ctx context.Context, messageID string) (*models.PaymentReport, error) {
+	processed, err := h.paymentBatchRepo.GetBatchByMessageID(ctx, messageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("retrieve payment batch: %w", err)
+	}
+	return processed, newRequestError(http.StatusConflict, "Payment batch "+messageID+" has already been processed", ErrBatchProcessed)
+}
+
+// batchClaimError reports a failure to record a batch. A concurrent upload of the same file surfaces as a unique violation and is reported as already processed
+func (h *TransactionHandler) batchClaimError(ctx context.Context, messageID string, err error) (*models.PaymentReport, error) {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		if processed, err := h.processedBatch(ctx, messageID); processed != nil || err != nil {
+			return processed, err
+		}
+		return nil, newRequestError(http.StatusConflict, "Payment batch "+messageID+" is being processed", ErrBatchProcessed)
+	}
+	return nil, fmt.Errorf("create payment batch: %w", err)
+}
+
+// batchRejectedError reports an atomic batch that was rejected as a whole
+func batchRejectedError(report *models.PaymentReport) error {
+	return newRequestError(http.StatusUnprocessableEntity, "Payment batch "+report.MessageID+" was rejected", ErrBatchRejected)
+}
+
+// rejectBatch rejects every instruction of an atomic batch when any of them was rejected. It reports whether the batch was rejected
+func rejectBatch(report *models.PaymentReport) bool {
+	rejected := false
+	for _, instruction := range report.Instructions {
+		rejected = rejected || instruction.Status == models.PAYMENT_REJECTED
+	}
+	if !rejected {
+		return false
+	}
+
+	for i := range report.Instructions {
+		if report.Instructions[i].Status != models.PAYMENT_REJECTED {
+			report.Instructions[i].Reject(models.REASON_NARRATIVE, "Batch rejected because another instruction was rejected")
+		}
+	}
+	report.Settle()
+	return true
+}
+
+// rejectionReason returns the ISO 20022 reason code of a transfer rejected by the ledger rules. It reports false for failures that are not caused by the transfer
+func rejectionReason(err error) (string, bool) {
+	var reqErr *requestError
+	if !errors.As(err, &reqErr) {
+		return "", false
+	}
+
+	switch {
+	case errors.Is(err, ErrAccountNotFound):
+		return models.REASON_INCORRECT_ACCOUNT, true
+	case errors.Is(err, ErrNotAccountOwner):
+		return models.REASON_FORBIDDEN, true
+	case errors.Is(err, ErrInsufficientBalance):
+		return models.REASON_INSUFFICIENT, true
+	case errors.Is(err, ErrTransactionExists):
+		return models.REASON_DUPLICATE, true
+	case errors.Is(err, ErrCurrencyMismatch):
+		return models.REASON_INVALID_CURRENCY, true
+	case errors.Is(err, models.ErrInvalidAmount), errors.Is(err, ErrFeeOverflow):
+		return models.REASON_INVALID_AMOUNT, true
+	}
+	return models.REASON_NARRATIVE, true
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// ReportHandler contains http handlers for ledger reports
+type ReportHandler struct {
+	transactionRepo *repository.TransactionRepository
+	logger          *slog.Logger
+}
+
+// NewReportHandler creates a new report handler
+func NewReportHandler(transactionRepo *repository.TransactionRepository, logger *slog.Logger) *ReportHandler {
+	return &ReportHandler{
+		transactionRepo: transactionRepo,
+		logger:          logger,
+	}
+}
+
+// GetTrialBalance handles the retrieval of the balances of all accounts in the chart of accounts
+func (h *ReportHandler) GetTrialBalance(c *gin.Context) {
+	report, err := h.transactionRepo.GetTrialBalance(c.Request.Context())
+	if err != nil {
+		// log error
+		h.logError("failed to retrieve trial balance", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve trial balance",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Trial balance retrieved successfully",
+		Data:    report,
+	})
+}
+
+// GetArrears handles the retrieval of the customer accounts flagged as in arrears
+func (h *ReportHandler) GetArrears(c *gin.Context) {
+	arrears, err := h.transactionRepo.GetArrears(c.Request.Context())
+	if err != nil {
+		// log error
+		h.logError("failed to retrieve arrears", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve arrears",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Arrears retrieved successfully",
+		Data:    arrears,
+	})
+}
+
+func (h *ReportHandler) logError(message string, err error) {
+	h.logger.Error(message, "error", err)
+}
+
+// RegisterReportHandlers adds all the handler methods to the provided http router
+func RegisterReportHandlers(h *ReportHandler, router *gin.Engine, logger *slog.Logger) {
+	r := router.Group("/reports")
+	r.GET("/trial-balance", h.GetTrialBalance)
+	r.GET("/arrears", h.GetArrears)
+}
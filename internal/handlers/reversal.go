@@ -0,0 +1,542 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// errors
+var (
+	ErrReversalOfReversal  = errors.New("a reversal cannot be reversed")
+	ErrAlreadyReversed     = errors.New("transaction has already been fully reversed")
+	ErrReversalExceeded    = errors.New("reversal amount exceeds the amount left to reverse")
+	ErrPartialFXReversal   = errors.New("partial reversals are only supported for single-currency transactions")
+	ErrReversalTooGranular = errors.New("reversal amount is too small to split across the transaction lines")
+	ErrNoFees              = errors.New("transaction carried no fees")
+	ErrFeesRefunded        = errors.New("fees of the transaction have already been refunded")
+)
+
+// reverse transaction
+
+// ReverseTransactionRequest represents the reversal request payload. The amount is optional and defaults to everything not yet reversed
+type ReverseTransactionRequest struct {
+	Reference string `json:"reference" binding:"required"`
+	Amount    uint64 `json:"amount" binding:"omitempty,gt=0"`
+}
+
+// ReverseTransaction handles the full or partial reversal of a transaction by posting mirrored lines linked to it. The total reversed can never exceed the original amount.
+// Only the principal legs are mirrored; the fees charged on a transfer are paid back through RefundFees
+func (h *TransactionHandler) ReverseTransaction(c *gin.Context) {
+	var params GetTransactionURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var body ReverseTransactionRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Start database transaction
+	repoTx, err := h.transactionRepo.GetTx(c.Request.Context())
+	if err != nil {
+		h.logError("failed to obtain database transaction", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to reverse transaction",
+		})
+		return
+	}
+	defer repoTx.Rollback()
+
+	// serialize reversals of the same transaction
+	id, _ := uuid.Parse(params.ID)
+	if err := h.transactionRepo.LockTransaction(c.Request.Context(), repoTx, id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Transaction not found",
+			})
+			return
+		}
+		h.logError("failed to lock transaction", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to reverse transaction",
+		})
+		return
+	}
+
+	original, err := h.transactionRepo.GetTransactionByID(c.Request.Context(), id)
+	if err != nil {
+		h.logError("failed to retrieve transaction", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to reverse transaction",
+		})
+		return
+	}
+	if original.ReversalOf != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: ErrReversalOfReversal.Error(),
+		})
+		return
+	}
+
+	reversed, err := h.transactionRepo.GetReversedTotals(c.Request.Context(), repoTx, id)
+	if err != nil {
+		h.logError("failed to retrieve reversed totals", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to reverse transaction",
+		})
+		return
+	}
+
+	// fees are refunded on their own, so only the principal legs are reversed
+	principal, err := h.principalLines(c.Request.Context(), original)
+	if err != nil {
+		h.logError("failed to separate fee legs", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to reverse transaction",
+		})
+		return
+	}
+
+	lines, err := reversalLines(principal, reversed, body.Amount)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrAlreadyReversed) || errors.Is(err, ErrReversalExceeded) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// verify that the accounts being debited by the reversal can cover it
+	accounts, err := h.reversalAccounts(c, lines, "failed to reverse transaction")
+	if err != nil {
+		return
+	}
+	if err := authorizeDebit(c.Request.Context(), debitedAccounts(lines, accounts)...); err != nil {
+		h.respondError(c, err, "failed to reverse transaction")
+		return
+	}
+	if err := h.checkNetOutflows(c.Request.Context(), repoTx, lines, accounts); err != nil {
+		h.respondError(c, err, "failed to reverse transaction")
+		return
+	}
+
+	reversal, err := h.createTransaction(c.Request.Context(), repoTx, &models.CreateTransaction{
+		Reference:  body.Reference,
+		FXRateID:   original.FXRateID,
+		ReversalOf: &original.ID,
+		Lines:      lines,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to reverse transaction")
+		return
+	}
+
+	// commit transaction
+	if err := repoTx.Commit(); err != nil {
+		h.logError("failed to commit reversal", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to reverse transaction",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Transaction reversed successfully",
+		Data:    reversal,
+	})
+}
+
+// refund fees
+
+// RefundFeesRequest represents the fee refund payload
+type RefundFeesRequest struct {
+	Reference string `json:"reference" binding:"required"`
+}
+
+// RefundFees handles paying back the fees charged on a transfer to the accounts that were charged them. The refund debits the fee revenue accounts, so it is
+// posted apart from reversals of the transfer, by callers allowed to post to system accounts. Each fee is refunded at most once
+func (h *TransactionHandler) RefundFees(c *gin.Context) {
+	var params GetTransactionURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var body RefundFeesRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Start database transaction
+	repoTx, err := h.transactionRepo.GetTx(c.Request.Context())
+	if err != nil {
+		h.logError("failed to obtain database transaction", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to refund fees",
+		})
+		return
+	}
+	defer repoTx.Rollback()
+
+	// serialize refunds and reversals of the same transaction
+	id, _ := uuid.Parse(params.ID)
+	if err := h.transactionRepo.LockTransaction(c.Request.Context(), repoTx, id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Transaction not found",
+			})
+			return
+		}
+		h.logError("failed to lock transaction", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to refund fees",
+		})
+		return
+	}
+
+	original, err := h.transactionRepo.GetTransactionByID(c.Request.Context(), id)
+	if err != nil {
+		h.logError("failed to retrieve transaction", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to refund fees",
+		})
+		return
+	}
+	if original.ReversalOf != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: ErrNoFees.Error(),
+		})
+		return
+	}
+
+	fees, err := h.feeRepo.GetTransactionFees(c.Request.Context(), id)
+	if err != nil {
+		h.logError("failed to retrieve transaction fees", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to refund fees",
+		})
+		return
+	}
+	if len(fees) == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: ErrNoFees.Error(),
+		})
+		return
+	}
+	revenue, err := h.feeRevenueAccounts(c.Request.Context(), fees)
+	if err != nil {
+		h.logError("failed to retrieve fee revenue accounts", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to refund fees",
+		})
+		return
+	}
+
+	lines := feeRefundLines(fees, revenue)
+	accounts, err := h.reversalAccounts(c, lines, "failed to refund fees")
+	if err != nil {
+		return
+	}
+	if err := authorizeDebit(c.Request.Context(), debitedAccounts(lines, accounts)...); err != nil {
+		h.respondError(c, err, "failed to refund fees")
+		return
+	}
+
+	refund, err := h.createTransaction(c.Request.Context(), repoTx, &models.CreateTransaction{
+		Reference:  body.Reference,
+		ReversalOf: &original.ID,
+		Lines:      lines,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to refund fees")
+		return
+	}
+	if err := h.feeRepo.RecordRefunds(c.Request.Context(), repoTx, refund.ID, fees); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Message: ErrFeesRefunded.Error(),
+			})
+			return
+		}
+		h.logError("failed to record fee refunds", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to refund fees",
+		})
+		return
+	}
+
+	// commit transaction
+	if err := repoTx.Commit(); err != nil {
+		h.logError("failed to commit fee refund", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to refund fees",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Fees refunded successfully",
+		Data:    refund,
+	})
+}
+
+// feeRevenueAccounts retrieves the system accounts credited with the given fees, per currency
+func (h *TransactionHandler) feeRevenueAccounts(ctx context.Context, fees []models.TransactionFee) (map[models.Currency]*models.Account, error) {
+	var acctNums []string
+	for _, fee := range fees {
+		acctNums = append(acctNums, models.FeeRevenueAccount(fee.Currency))
+	}
+	accounts, err := h.accountRepo.GetAccountsByAcctNumbers(ctx, acctNums)
+	if err != nil {
+		return nil, err
+	}
+
+	revenue := make(map[models.Currency]*models.Account, len(accounts))
+	for _, acct := range accounts {
+		revenue[acct.Currency] = acct
+	}
+	for _, fee := range fees {
+		if _, ok := revenue[fee.Currency]; !ok {
+			return nil, fmt.Errorf("fee revenue account %s not found", models.FeeRevenueAccount(fee.Currency))
+		}
+	}
+	return revenue, nil
+}
+
+// principalLines returns the lines of a transaction without the legs of the fees charged on it
+func (h *TransactionHandler) principalLines(ctx context.Context, original *models.Transaction) ([]models.TransactionLine, error) {
+	fees, err := h.feeRepo.GetTransactionFees(ctx, original.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retrieve transaction fees: %w", err)
+	}
+	if len(fees) == 0 {
+		return original.Lines, nil
+	}
+	revenue, err := h.feeRevenueAccounts(ctx, fees)
+	if err != nil {
+		return nil, err
+	}
+	return withoutFeeLegs(original.Lines, fees, revenue), nil
+}
+
+// withoutFeeLegs removes the legs of each fee from the lines: the debit of the charged account and the credit of the fee revenue account, both for the fee amount.
+// Lines matching a leg are interchangeable, and the last one is removed
+func withoutFeeLegs(lines []models.TransactionLine, fees []models.TransactionFee, revenue map[models.Currency]*models.Account) []models.TransactionLine {
+	removed := make([]bool, len(lines))
+	remove := func(accountID string, purpose models.TransactionPurpose, fee models.TransactionFee) {
+		for i := len(lines) - 1; i >= 0; i-- {
+			if line := lines[i]; !removed[i] && line.AccountID == accountID && line.Purpose == purpose && line.Amount == fee.Amount && line.Currency == fee.Currency {
+				removed[i] = true
+				return
+			}
+		}
+	}
+	for _, fee := range fees {
+		remove(fee.AccountID.String(), models.DEBIT, fee)
+		remove(revenue[fee.Currency].ID.String(), models.CREDIT, fee)
+	}
+
+	principal := make([]models.TransactionLine, 0, len(lines))
+	for i, line := range lines {
+		if !removed[i] {
+			principal = append(principal, line)
+		}
+	}
+	return principal
+}
+
+// feeRefundLines pays each fee back from its fee revenue account to the account that was charged it
+func feeRefundLines(fees []models.TransactionFee, revenue map[models.Currency]*models.Account) []models.CreateTransactionLine {
+	lines := make([]models.CreateTransactionLine, 0, 2*len(fees))
+	for _, fee := range fees {
+		lines = append(lines,
+			models.CreateTransactionLine{
+				AccountID: revenue[fee.Currency].ID,
+				Purpose:   models.DEBIT,
+				Amount:    fee.Amount,
+				Currency:  fee.Currency,
+			},
+			models.CreateTransactionLine{
+				AccountID: fee.AccountID,
+				Purpose:   models.CREDIT,
+				Amount:    fee.Amount,
+				Currency:  fee.Currency,
+			},
+		)
+	}
+	return lines
+}
+
+// reversalAccounts retrieves the accounts touched by the reversal lines. Every account must still be active
+func (h *TransactionHandler) reversalAccounts(c *gin.Context, lines []models.CreateTransactionLine, failure string) (map[string]*models.Account, error) {
+	ids := make([]uuid.UUID, 0, len(lines))
+	for _, line := range lines {
+		ids = append(ids, line.AccountID)
+	}
+
+	accounts, err := h.accountRepo.GetAccountsByIDs(c.Request.Context(), ids)
+	if err != nil {
+		h.logError("failed to retrieve related accounts", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: failure,
+		})
+		return nil, err
+	}
+
+	accountMap := make(map[string]*models.Account, len(accounts))
+	found := make(map[uuid.UUID]bool, len(accounts))
+	for _, acct := range accounts {
+		accountMap[acct.AccountNumber] = acct
+		found[acct.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Message: "Account " + id.String() + " is disabled",
+			})
+			return nil, ErrAccountNotFound
+		}
+	}
+	return accountMap, nil
+}
+
+// reversalLines mirrors the principal lines of the original transaction for the given amount, or for everything not yet reversed when the amount is zero. Partial
+// amounts are split across the lines of each side in proportion to the original amounts so that the reversal still balances
+func reversalLines(principal []models.TransactionLine, reversed map[models.Currency]uint64, amount uint64) ([]models.CreateTransactionLine, error) {
+	// original debit totals per currency
+	totals := make(map[models.Currency]uint64)
+	for _, line := range principal {
+		if line.Purpose == models.DEBIT {
+			totals[line.Currency] += line.Amount
+		}
+	}
+
+	var remaining uint64
+	fullyReversed := true
+	for currency, total := range totals {
+		if reversed[currency] < total {
+			fullyReversed = false
+		}
+		remaining = total - min(reversed[currency], total)
+	}
+	if fullyReversed {
+		return nil, ErrAlreadyReversed
+	}
+
+	// transactions in several currencies can only be reversed whole, at their original amounts
+	if len(totals) > 1 {
+		if amount != 0 || len(reversed) > 0 {
+			return nil, ErrPartialFXReversal
+		}
+		lines := make([]models.CreateTransactionLine, 0, len(principal))
+		for _, line := range principal {
+			lines = append(lines, mirrorLine(line, line.Amount))
+		}
+		return lines, nil
+	}
+
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount > remaining {
+		return nil, ErrReversalExceeded
+	}
+
+	var total uint64
+	for _, t := range totals {
+		total = t
+	}
+
+	var debits, credits []models.TransactionLine
+	for _, line := range principal {
+		if line.Purpose == models.DEBIT {
+			debits = append(debits, line)
+		} else {
+			credits = append(credits, line)
+		}
+	}
+
+	lines := make([]models.CreateTransactionLine, 0, len(principal))
+	for _, side := range [][]models.TransactionLine{debits, credits} {
+		for i, share := range allocate(side, amount, total) {
+			if share > 0 {
+				lines = append(lines, mirrorLine(side[i], share))
+			}
+		}
+	}
+	if len(lines) < 2 {
+		return nil, ErrReversalTooGranular
+	}
+	return lines, nil
+}
+
+// allocate splits amount across the lines in proportion to amount/total using the largest remainder method, so that the shares always add up to amount
+func allocate(lines []models.TransactionLine, amount, total uint64) []uint64 {
+	shares := make([]uint64, len(lines))
+	remainders := make([]uint64, len(lines))
+	var allocated uint64
+	for i, line := range lines {
+		// line.Amount * amount / total without overflowing. line.Amount <= total keeps the quotient within 64 bits
+		hi, lo := bits.Mul64(line.Amount, amount)
+		shares[i], remainders[i] = bits.Div64(hi, lo, total)
+		allocated += shares[i]
+	}
+
+	order := make([]int, len(lines))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for i := 0; allocated < amount; i++ {
+		shares[order[i%len(order)]]++
+		allocated++
+	}
+	return shares
+}
+
+// mirrorLine returns a line that compensates the given line for the given amount
+func mirrorLine(line models.TransactionLine, amount uint64) models.CreateTransactionLine {
+	purpose := models.DEBIT
+	if line.Purpose == models.DEBIT {
+		purpose = models.CREDIT
+	}
+
+	accountID, _ := uuid.Parse(line.AccountID)
+	return models.CreateTransactionLine{
+		AccountID: accountID,
+		Purpose:   purpose,
+		Amount:    amount,
+		Currency:  line.Currency,
+	}
+}
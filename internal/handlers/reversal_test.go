@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		amounts []uint64
+		amount  uint64
+		total   uint64
+		want    []uint64
+	}{
+		{"exact shares", []uint64{50, 30, 20}, 10, 100, []uint64{5, 3, 2}},
+		{"whole amount", []uint64{50, 30, 20}, 100, 100, []uint64{50, 30, 20}},
+		{"largest remainder first", []uint64{50, 30, 20}, 1, 100, []uint64{1, 0, 0}},
+		{"remainders", []uint64{50, 30, 20}, 7, 100, []uint64{4, 2, 1}},
+		{"ties keep line order", []uint64{1, 1, 1}, 2, 3, []uint64{1, 1, 0}},
+		{"single line", []uint64{100}, 33, 100, []uint64{33}},
+		{"no overflow", []uint64{math.MaxInt64, math.MaxInt64}, math.MaxInt64, math.MaxInt64 * 2, []uint64{math.MaxInt64/2 + 1, math.MaxInt64 / 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := make([]models.TransactionLine, len(tt.amounts))
+			for i, amount := range tt.amounts {
+				lines[i].Amount = amount
+			}
+
+			got := allocate(lines, tt.amount, tt.total)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("allocate(%v, %d, %d) = %v, want %v", tt.amounts, tt.amount, tt.total, got, tt.want)
+			}
+			var sum uint64
+			for _, share := range got {
+				sum += share
+			}
+			if sum != tt.amount {
+				t.Errorf("shares add up to %d, want %d", sum, tt.amount)
+			}
+		})
+	}
+}
+
+func TestReversalLines(t *testing.T) {
+	sender, recipient, other := uuid.New(), uuid.New(), uuid.New()
+	line := func(accountID uuid.UUID, purpose models.TransactionPurpose, amount uint64, currency models.Currency) models.TransactionLine {
+		return models.TransactionLine{AccountID: accountID.String(), Purpose: purpose, Amount: amount, Currency: currency}
+	}
+	mirror := func(accountID uuid.UUID, purpose models.TransactionPurpose, amount uint64, currency models.Currency) models.CreateTransactionLine {
+		return models.CreateTransactionLine{AccountID: accountID, Purpose: purpose, Amount: amount, Currency: currency}
+	}
+
+	transfer := []models.TransactionLine{
+		line(sender, models.DEBIT, 100, models.USD),
+		line(recipient, models.CREDIT, 60, models.USD),
+		line(other, models.CREDIT, 40, models.USD),
+	}
+	fx := []models.TransactionLine{
+		line(sender, models.DEBIT, 100, models.EUR),
+		line(other, models.CREDIT, 100, models.EUR),
+		line(other, models.DEBIT, 110, models.USD),
+		line(recipient, models.CREDIT, 110, models.USD),
+	}
+
+	tests := []struct {
+		name      string
+		principal []models.TransactionLine
+		reversed  map[models.Currency]uint64
+		amount    uint64
+		want      []models.CreateTransactionLine
+		err       error
+	}{
+		{
+			name:      "full",
+			principal: transfer,
+			want: []models.CreateTransactionLine{
+				mirror(sender, models.CREDIT, 100, models.USD),
+				mirror(recipient, models.DEBIT, 60, models.USD),
+				mirror(other, models.DEBIT, 40, models.USD),
+			},
+		},
+		{
+			name:      "partial",
+			principal: transfer,
+			amount:    50,
+			want: []models.CreateTransactionLine{
+				mirror(sender, models.CREDIT, 50, models.USD),
+				mirror(recipient, models.DEBIT, 30, models.USD),
+				mirror(other, models.DEBIT, 20, models.USD),
+			},
+		},
+		{
+			name:      "remainder of a partial reversal",
+			principal: transfer,
+			reversed:  map[models.Currency]uint64{models.USD: 50},
+			want: []models.CreateTransactionLine{
+				mirror(sender, models.CREDIT, 50, models.USD),
+				mirror(recipient, models.DEBIT, 30, models.USD),
+				mirror(other, models.DEBIT, 20, models.USD),
+			},
+		},
+		{
+			name:      "smallest amount",
+			principal: transfer,
+			amount:    1,
+			want: []models.CreateTransactionLine{
+				mirror(sender, models.CREDIT, 1, models.USD),
+				mirror(recipient, models.DEBIT, 1, models.USD),
+			},
+		},
+		{
+			name:      "exceeds what is left",
+			principal: transfer,
+			reversed:  map[models.Currency]uint64{models.USD: 50},
+			amount:    51,
+			err:       ErrReversalExceeded,
+		},
+		{
+			name:      "already reversed",
+			principal: transfer,
+			reversed:  map[models.Currency]uint64{models.USD: 100},
+			err:       ErrAlreadyReversed,
+		},
+		{
+			name:      "full fx",
+			principal: fx,
+			want: []models.CreateTransactionLine{
+				mirror(sender, models.CREDIT, 100, models.EUR),
+				mirror(other, models.DEBIT, 100, models.EUR),
+				mirror(other, models.CREDIT, 110, models.USD),
+				mirror(recipient, models.DEBIT, 110, models.USD),
+			},
+		},
+		{
+			name:      "partial fx",
+			principal: fx,
+			amount:    50,
+			err:       ErrPartialFXReversal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := reversalLines(tt.principal, tt.reversed, tt.amount)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("reversalLines() error = %v, want %v", err, tt.err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("reversalLines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithoutFeeLegs(t *testing.T) {
+	sender, recipient := uuid.New(), uuid.New()
+	revenue := map[models.Currency]*models.Account{models.USD: {ID: uuid.New()}}
+	line := func(accountID uuid.UUID, purpose models.TransactionPurpose, amount uint64) models.TransactionLine {
+		return models.TransactionLine{AccountID: accountID.String(), Purpose: purpose, Amount: amount, Currency: models.USD}
+	}
+	fee := func(amount uint64) models.TransactionFee {
+		return models.TransactionFee{AccountID: sender, Amount: amount, Currency: models.USD}
+	}
+
+	tests := []struct {
+		name  string
+		lines []models.TransactionLine
+		fees  []models.TransactionFee
+		want  []models.TransactionLine
+	}{
+		{
+			name:  "no fees",
+			lines: []models.TransactionLine{line(sender, models.DEBIT, 100), line(recipient, models.CREDIT, 100)},
+			want:  []models.TransactionLine{line(sender, models.DEBIT, 100), line(recipient, models.CREDIT, 100)},
+		},
+		{
+			name: "fees",
+			lines: []models.TransactionLine{
+				line(sender, models.DEBIT, 100), line(recipient, models.CREDIT, 100),
+				line(sender, models.DEBIT, 5), line(revenue[models.USD].ID, models.CREDIT, 5),
+				line(sender, models.DEBIT, 2), line(revenue[models.USD].ID, models.CREDIT, 2),
+			},
+			fees: []models.TransactionFee{fee(5), fee(2)},
+			want: []models.TransactionLine{line(sender, models.DEBIT, 100), line(recipient, models.CREDIT, 100)},
+		},
+		{
+			name: "fee as large as the principal",
+			lines: []models.TransactionLine{
+				line(sender, models.DEBIT, 100), line(recipient, models.CREDIT, 100),
+				line(sender, models.DEBIT, 100), line(revenue[models.USD].ID, models.CREDIT, 100),
+			},
+			fees: []models.TransactionFee{fee(100)},
+			want: []models.TransactionLine{line(sender, models.DEBIT, 100), line(recipient, models.CREDIT, 100)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withoutFeeLegs(tt.lines, tt.fees, revenue); !slices.Equal(got, tt.want) {
+				t.Errorf("withoutFeeLegs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,647 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/auth"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// errors
+var (
+	ErrInvalidCredentials   = errors.New("email or password is incorrect")
+	ErrLoginLocked          = errors.New("too many failed logins")
+	ErrSecondFactorRequired = errors.New("a totp code or recovery code is required")
+	ErrInvalidSecondFactor  = errors.New("totp code or recovery code is invalid")
+)
+
+// maxUserAgentLength bounds the user agent stored with a session
+const maxUserAgentLength = 255
+
+// SessionHandler contains http handlers for password and TOTP logins, the sessions they start and the credentials behind them
+type SessionHandler struct {
+	userRepo       *repository.UserRepository
+	credentialRepo *repository.CredentialRepository
+	sessionRepo    *repository.SessionRepository
+	// tokenSecret signs the bearer token of a session, which expires with the session after sessionTTL
+	tokenSecret []byte
+	sessionTTL  time.Duration
+	// maxLoginAttempts failed logins in a row lock a user out for loginLockout
+	maxLoginAttempts int
+	loginLockout     time.Duration
+	logger           *slog.Logger
+}
+
+// NewSessionHandler creates a new session handler
+func NewSessionHandler(userRepo *repository.UserRepository, credentialRepo *repository.CredentialRepository, sessionRepo *repository.SessionRepository, tokenSecret []byte,
+	sessionTTL time.Duration, maxLoginAttempts int, loginLockout time.Duration, logger *slog.Logger) *SessionHandler {
+	return &SessionHandler{
+		userRepo:         userRepo,
+		credentialRepo:   credentialRepo,
+		sessionRepo:      sessionRepo,
+		tokenSecret:      tokenSecret,
+		sessionTTL:       sessionTTL,
+		maxLoginAttempts: maxLoginAttempts,
+		loginLockout:     loginLockout,
+		logger:           logger,
+	}
+}
+
+// login
+
+// LoginRequest represents the login request payload. Users with TOTP enabled also send a code from their authenticator, or one of their recovery codes
+type LoginRequest struct {
+	Email        string `json:"email" binding:"required,email"`
+	Password     string `json:"password" binding:"required,max=128"`
+	TOTPCode     string `json:"totp_code" binding:"omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code" binding:"omitempty,max=32"`
+}
+
+// SessionTokenResponse carries the bearer token of a new session along with the session itself
+type SessionTokenResponse struct {
+	TokenResponse
+	Session *models.Session `json:"session"`
+}
+
+// Login handles a login with email and password, and a second factor when the user enabled TOTP. It starts a server-side session and returns a bearer token
+// that is accepted until the session is revoked or expires
+func (h *SessionHandler) Login(c *gin.Context) {
+	var body LoginRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	credentials, err := h.loginCredentials(ctx, body.Email)
+	if err == nil {
+		setAuditActor(c, credentials.UserID)
+		err = h.verifyCredentials(ctx, credentials, body.Password, body.TOTPCode, body.RecoveryCode)
+	}
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrInvalidSecondFactor) {
+			h.logger.Warn("failed login", "email", body.Email, "ip_address", c.ClientIP(), "error", err)
+		}
+		respondAccessError(c, h.logger, err, "failed to log in")
+		return
+	}
+
+	now := time.Now()
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	session, err := h.sessionRepo.CreateSession(ctx, &models.CreateSession{
+		UserID:    credentials.UserID,
+		IPAddress: c.ClientIP(),
+		UserAgent: userAgent,
+		ExpiresAt: now.Add(h.sessionTTL),
+	})
+	if err != nil {
+		h.logError("failed to create session", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to log in",
+		})
+		return
+	}
+
+	claims := auth.NewClaims(session.UserID, h.sessionTTL, now)
+	claims.SessionID, claims.ExpiresAt = session.ID.String(), session.ExpiresAt.Unix()
+	token, err := auth.SignToken(h.tokenSecret, claims)
+	if err != nil {
+		h.logError("failed to sign token", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to log in",
+		})
+		return
+	}
+
+	h.logger.Info("user logged in", "user_id", session.UserID, "session_id", session.ID)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Logged in successfully",
+		Data: SessionTokenResponse{
+			TokenResponse: TokenResponse{
+				AccessToken: token,
+				TokenType:   "Bearer",
+				ExpiresIn:   int64(h.sessionTTL.Seconds()),
+				ExpiresAt:   session.ExpiresAt.UTC(),
+			},
+			Session: session,
+		},
+	})
+}
+
+// loginCredentials retrieves the credentials of the user with the email. Unknown emails and users without a password take as long to reject as a wrong
+// password, so that a login does not reveal who is registered
+func (h *SessionHandler) loginCredentials(ctx context.Context, email string) (*models.Credentials, error) {
+	user, err := h.userRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, h.rejectUnknownUser()
+		}
+		return nil, fmt.Errorf("retrieve user: %w", err)
+	}
+	credentials, err := h.credentialRepo.GetCredentials(ctx, user.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, h.rejectUnknownUser()
+		}
+		return nil, fmt.Errorf("retrieve credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+func (h *SessionHandler) rejectUnknownUser() error {
+	if _, err := auth.VerifyPassword("", ""); err != nil {
+		return err
+	}
+	return newRequestError(http.StatusUnauthorized, "Email or password is incorrect", ErrInvalidCredentials)
+}
+
+// verifyCredentials checks the password of the user, and the TOTP code or recovery code when TOTP is enabled. Every failure counts towards locking the user
+// out, and a success claims the TOTP code so that it cannot be used again
+func (h *SessionHandler) verifyCredentials(ctx context.Context, credentials *models.Credentials, password, totpCode, recoveryCode string) error {
+	now := time.Now()
+	if credentials.Locked(now) {
+		return newRequestError(http.StatusTooManyRequests, "Too many failed logins. Try again later", ErrLoginLocked)
+	}
+
+	ok, err := auth.VerifyPassword(password, credentials.PasswordHash)
+	if err != nil {
+		return fmt.Errorf("verify password: %w", err)
+	}
+	if !ok {
+		h.recordFailedLogin(ctx, credentials.UserID)
+		return newRequestError(http.StatusUnauthorized, "Email or password is incorrect", ErrInvalidCredentials)
+	}
+
+	var step int64
+	if credentials.TOTPEnabled() {
+		switch {
+		case totpCode != "":
+			if step, ok = auth.ValidateTOTP(credentials.TOTPSecret, totpCode, now, credentials.TOTPLastStep); !ok {
+				h.recordFailedLogin(ctx, credentials.UserID)
+				return newRequestError(http.StatusUnauthorized, "TOTP code is invalid", ErrInvalidSecondFactor)
+			}
+		case recoveryCode != "":
+			if err := h.credentialRepo.UseRecoveryCode(ctx, credentials.UserID, auth.HashRecoveryCode(recoveryCode)); err != nil {
+				if err == sql.ErrNoRows {
+					h.recordFailedLogin(ctx, credentials.UserID)
+					return newRequestError(http.StatusUnauthorized, "Recovery code is invalid or has been used", ErrInvalidSecondFactor)
+				}
+				return fmt.Errorf("use recovery code: %w", err)
+			}
+			h.logger.Warn("recovery code used", "user_id", credentials.UserID)
+		default:
+			return newRequestError(http.StatusUnauthorized, "A TOTP code or recovery code is required", ErrSecondFactorRequired)
+		}
+	}
+
+	if err := h.credentialRepo.RecordLogin(ctx, credentials.UserID, step); err != nil {
+		if err == sql.ErrNoRows {
+			// a concurrent login used the same code first
+			return newRequestError(http.StatusUnauthorized, "TOTP code is invalid", ErrInvalidSecondFactor)
+		}
+		return fmt.Errorf("record login: %w", err)
+	}
+	return nil
+}
+
+// recordFailedLogin counts a failed login towards locking the user out
+func (h *SessionHandler) recordFailedLogin(ctx context.Context, userID uuid.UUID) {
+	credentials, err := h.credentialRepo.RecordFailedLogin(context.WithoutCancel(ctx), userID, h.maxLoginAttempts, h.loginLockout)
+	if err != nil {
+		h.logError("failed to record failed login", err)
+		return
+	}
+	if credentials.Locked(time.Now()) {
+		h.logger.Warn("user locked out after failed logins", "user_id", userID, "locked_until", credentials.LockedUntil)
+	}
+}
+
+// Logout handles ending the session the request was made with. Its bearer token stops working at once
+func (h *SessionHandler) Logout(c *gin.Context) {
+	principal, _ := auth.PrincipalFrom(c.Request.Context())
+	if principal.Method != auth.SESSION {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Only login sessions can be logged out",
+		})
+		return
+	}
+
+	id, _ := uuid.Parse(principal.CredentialID)
+	h.revokeSession(c, id, principal.UserID, "Logged out successfully")
+}
+
+// sessions
+
+// GetSessions handles the retrieval of the caller's active sessions
+func (h *SessionHandler) GetSessions(c *gin.Context) {
+	principal, _ := auth.PrincipalFrom(c.Request.Context())
+	sessions, err := h.sessionRepo.GetActiveSessionsByUserID(c.Request.Context(), principal.UserID)
+	if err != nil {
+		h.logError("failed to retrieve sessions", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to retrieve sessions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Sessions retrieved successfully",
+		Data:    sessions,
+	})
+}
+
+// GetSessionURI represents the path params of the session requests
+type GetSessionURI struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+// RevokeSession handles ending one of the caller's sessions, such as one left open on another device
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	var params GetSessionURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	principal, _ := auth.PrincipalFrom(c.Request.Context())
+	id, _ := uuid.Parse(params.ID)
+	h.revokeSession(c, id, principal.UserID, "Session revoked successfully")
+}
+
+func (h *SessionHandler) revokeSession(c *gin.Context, id, userID uuid.UUID, message string) {
+	session, err := h.sessionRepo.RevokeSession(c.Request.Context(), id, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Active session not found",
+			})
+			return
+		}
+		h.logError("failed to revoke session", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to revoke session",
+		})
+		return
+	}
+
+	h.logger.Info("session revoked", "user_id", session.UserID, "session_id", session.ID)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: message,
+		Data:    session,
+	})
+}
+
+// change password
+
+// ChangePasswordRequest represents the change password request payload. The current password, and a second factor when TOTP is enabled, are required once the
+// user has a password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"max=128"`
+	NewPassword     string `json:"new_password" binding:"required,min=12,max=128"`
+	TOTPCode        string `json:"totp_code" binding:"omitempty,len=6,numeric"`
+	RecoveryCode    string `json:"recovery_code" binding:"omitempty,max=32"`
+}
+
+// ChangePassword handles setting the caller's password. Users created without a password, before passwords existed, set their first one here. Every other
+// session of the user is revoked
+func (h *SessionHandler) ChangePassword(c *gin.Context) {
+	var body ChangePasswordRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	principal, _ := auth.PrincipalFrom(ctx)
+	credentials, err := h.credentialRepo.GetCredentials(ctx, principal.UserID)
+	if err != nil && err != sql.ErrNoRows {
+		h.logError("failed to retrieve credentials", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to change password",
+		})
+		return
+	}
+	if credentials != nil && credentials.PasswordHash != "" {
+		if err := h.verifyCredentials(ctx, credentials, body.CurrentPassword, body.TOTPCode, body.RecoveryCode); err != nil {
+			respondAccessError(c, h.logger, err, "failed to change password")
+			return
+		}
+	}
+
+	hash, err := auth.HashPassword(body.NewPassword)
+	if err != nil {
+		h.logError("failed to hash password", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to change password",
+		})
+		return
+	}
+	var keepSession *uuid.UUID
+	if principal.Method == auth.SESSION {
+		id, _ := uuid.Parse(principal.CredentialID)
+		keepSession = &id
+	}
+	if err := h.credentialRepo.SetPassword(ctx, principal.UserID, hash, keepSession); err != nil {
+		h.logError("failed to set password", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to change password",
+		})
+		return
+	}
+
+	h.logger.Info("password changed", "user_id", principal.UserID)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Password changed successfully",
+	})
+}
+
+// totp
+
+// ReauthenticateRequest represents the payload of the TOTP requests, which need the caller's password, and a second factor once TOTP is enabled
+type ReauthenticateRequest struct {
+	Password     string `json:"password" binding:"required,max=128"`
+	TOTPCode     string `json:"totp_code" binding:"omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code" binding:"omitempty,max=32"`
+}
+
+// TOTPEnrolmentResponse carries the secret of a pending TOTP enrolment and its otpauth:// URI for authenticator apps
+type TOTPEnrolmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCodesResponse carries newly issued recovery codes. They are only ever returned in this response
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// StartTOTP handles starting a TOTP enrolment for the caller. The secret is added to an authenticator app and takes effect once a code from it is confirmed.
+// Starting again replaces a pending secret
+func (h *SessionHandler) StartTOTP(c *gin.Context) {
+	credentials, ok := h.reauthenticate(c, "failed to start totp enrolment")
+	if !ok {
+		return
+	}
+	if credentials.TOTPEnabled() {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Message: "TOTP is already enabled",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := h.userRepo.GetUserByID(ctx, credentials.UserID)
+	if err != nil {
+		h.logError("failed to retrieve user", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to start totp enrolment",
+		})
+		return
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		h.logError("failed to generate totp secret", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to start totp enrolment",
+		})
+		return
+	}
+	if err := h.credentialRepo.StartTOTP(ctx, credentials.UserID, secret); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Message: "TOTP is already enabled",
+			})
+			return
+		}
+		h.logError("failed to start totp enrolment", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to start totp enrolment",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "TOTP enrolment started successfully",
+		Data:    TOTPEnrolmentResponse{Secret: secret, URI: auth.TOTPURI(secret, user.Email)},
+	})
+}
+
+// ConfirmTOTPRequest represents the payload confirming a TOTP enrolment
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+// ConfirmTOTP handles enabling the pending TOTP secret of the caller with a code from their authenticator. Logins need a second factor from then on, and the
+// recovery codes for a lost authenticator are returned
+func (h *SessionHandler) ConfirmTOTP(c *gin.Context) {
+	var body ConfirmTOTPRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	principal, _ := auth.PrincipalFrom(ctx)
+	credentials, err := h.credentialRepo.GetCredentials(ctx, principal.UserID)
+	if err != nil && err != sql.ErrNoRows {
+		h.logError("failed to retrieve credentials", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to confirm totp",
+		})
+		return
+	}
+	if credentials == nil || credentials.TOTPSecret == "" || credentials.TOTPEnabled() {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Message: "No TOTP enrolment is pending",
+		})
+		return
+	}
+	step, ok := auth.ValidateTOTP(credentials.TOTPSecret, body.Code, time.Now(), credentials.TOTPLastStep)
+	if !ok {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "TOTP code is invalid",
+		})
+		return
+	}
+
+	codes, hashes, err := recoveryCodes()
+	if err != nil {
+		h.logError("failed to generate recovery codes", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to confirm totp",
+		})
+		return
+	}
+	if err := h.credentialRepo.EnableTOTP(ctx, principal.UserID, step, hashes); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Message: "No TOTP enrolment is pending",
+			})
+			return
+		}
+		h.logError("failed to enable totp", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to confirm totp",
+		})
+		return
+	}
+
+	h.logger.Info("totp enabled", "user_id", principal.UserID)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "TOTP enabled successfully",
+		Data:    RecoveryCodesResponse{RecoveryCodes: codes},
+	})
+}
+
+// DisableTOTP handles removing the TOTP second factor of the caller along with their recovery codes
+func (h *SessionHandler) DisableTOTP(c *gin.Context) {
+	credentials, ok := h.reauthenticate(c, "failed to disable totp")
+	if !ok {
+		return
+	}
+
+	if err := h.credentialRepo.DisableTOTP(c.Request.Context(), credentials.UserID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Message: "TOTP is not enabled",
+			})
+			return
+		}
+		h.logError("failed to disable totp", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to disable totp",
+		})
+		return
+	}
+
+	h.logger.Info("totp disabled", "user_id", credentials.UserID)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "TOTP disabled successfully",
+	})
+}
+
+// RegenerateRecoveryCodes handles replacing the caller's recovery codes, such as after some were used. The previous codes stop working at once
+func (h *SessionHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	credentials, ok := h.reauthenticate(c, "failed to regenerate recovery codes")
+	if !ok {
+		return
+	}
+	if !credentials.TOTPEnabled() {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Message: "TOTP is not enabled",
+		})
+		return
+	}
+
+	codes, hashes, err := recoveryCodes()
+	if err == nil {
+		err = h.credentialRepo.ReplaceRecoveryCodes(c.Request.Context(), credentials.UserID, hashes)
+	}
+	if err != nil {
+		h.logError("failed to regenerate recovery codes", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to regenerate recovery codes",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Recovery codes regenerated successfully",
+		Data:    RecoveryCodesResponse{RecoveryCodes: codes},
+	})
+}
+
+// reauthenticate binds a ReauthenticateRequest and verifies it against the caller's credentials. It writes the response and reports false when it fails
+func (h *SessionHandler) reauthenticate(c *gin.Context, fallback string) (*models.Credentials, bool) {
+	var body ReauthenticateRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return nil, false
+	}
+
+	ctx := c.Request.Context()
+	principal, _ := auth.PrincipalFrom(ctx)
+	credentials, err := h.credentialRepo.GetCredentials(ctx, principal.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Message: "A password must be set first",
+			})
+			return nil, false
+		}
+		h.logError("failed to retrieve credentials", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: fallback,
+		})
+		return nil, false
+	}
+	if credentials.PasswordHash == "" {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Message: "A password must be set first",
+		})
+		return nil, false
+	}
+	if err := h.verifyCredentials(ctx, credentials, body.Password, body.TOTPCode, body.RecoveryCode); err != nil {
+		respondAccessError(c, h.logger, err, fallback)
+		return nil, false
+	}
+	return credentials, true
+}
+
+// recoveryCodes generates a new set of recovery codes along with the hashes they are stored by
+func recoveryCodes() ([]string, []string, error) {
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+func (h *SessionHandler) logError(message string, err error) {
+	h.logger.Error(message, "error", err)
+}
+
+// RegisterSessionHandlers adds all the handler methods to the provided http router
+func RegisterSessionHandlers(h *SessionHandler, router *gin.Engine, logger *slog.Logger) {
+	r := router.Group("/auth")
+	r.POST("/login", h.Login)
+	r.POST("/logout", h.Logout)
+	r.GET("/sessions", h.GetSessions)
+	r.DELETE("/sessions/:id", h.RevokeSession)
+	r.PUT("/password", h.ChangePassword)
+	r.POST("/totp", h.StartTOTP)
+	r.POST("/totp/confirm", h.ConfirmTOTP)
+	r.POST("/totp/disable", h.DisableTOTP)
+	r.POST("/totp/recovery-codes", h.RegenerateRecoveryCodes)
+}
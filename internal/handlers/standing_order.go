@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// errors
+var (
+	ErrStandingOrderNotActive = errors.New("standing order is no longer active")
+	ErrScheduleHasNoDates     = errors.New("schedule has no occurrences from today")
+)
+
+// StandingOrderHandler contains http handlers for standing order endpoints. Transfers are validated with the same rules as one-off transactions
+type StandingOrderHandler struct {
+	standingOrderRepo *repository.StandingOrderRepository
+	transactions      *TransactionHandler
+	logger            *slog.Logger
+}
+
+// NewStandingOrderHandler creates a new standing order handler
+func NewStandingOrderHandler(standingOrderRepo *repository.StandingOrderRepository, transactions *TransactionHandler, logger *slog.Logger) *StandingOrderHandler {
+	return &StandingOrderHandler{
+		standingOrderRepo: standingOrderRepo,
+		transactions:      transactions,
+		logger:            logger,
+	}
+}
+
+// create standing order
+
+// ScheduleRequest represents the schedule of a standing order. Dates are given as YYYY-MM-DD
+type ScheduleRequest struct {
+	Kind      models.ScheduleKind `json:"kind" binding:"required,oneof=once daily weekly monthly last_business_day"`
+	StartDate string              `json:"start_date" binding:"required,datetime=2006-01-02"`
+	EndDate   string              `json:"end_date" binding:"omitempty,datetime=2006-01-02"`
+	// DayOfMonth is the day monthly schedules run on, clamped to the last day of shorter months. It defaults to the day of the start date
+	DayOfMonth int `json:"day_of_month" binding:"omitempty,min=1,max=31"`
+}
+
+// CreateStandingOrderRequest represents the standing order request payload. Each occurrence is posted as a transfer from the sender to the recipient
+type CreateStandingOrderRequest struct {
+	Reference string `json:"reference" binding:"required"`
+	Sender    string `json:"sender" binding:"required"`
+	Recipient string `json:"recipient" binding:"required"`
+	Amount    uint64 `json:"amount" binding:"required,gt=0"`
+	// Currency optionally asserts the currency of the transfers. It defaults to the currency of the debited account
+	Currency models.Currency `json:"currency" binding:"omitempty,iso4217"`
+	// Convert allows transfers between accounts of different currencies at the fx rate in force when each occurrence is posted
+	Convert  bool            `json:"convert"`
+	Schedule ScheduleRequest `json:"schedule" binding:"required"`
+}
+
+// CreateStandingOrder handles the creation of a standing order. Occurrences are posted by a background worker from the later of the start date and today
+func (h *StandingOrderHandler) CreateStandingOrder(c *gin.Context) {
+	var body CreateStandingOrderRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	schedule, err := body.Schedule.schedule()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+	nextRunOn, ok := schedule.Next(time.Now())
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: ErrScheduleHasNoDates.Error(),
+		})
+		return
+	}
+
+	// reject transfers that could never be posted. Balances are only checked when each occurrence runs
+	transfer := CreateTransactionRequest{
+		Reference: body.Reference,
+		Sender:    body.Sender,
+		Recipient: body.Recipient,
+		Amount:    body.Amount,
+		Currency:  body.Currency,
+		Convert:   body.Convert,
+	}
+	accounts, err := h.transactions.validateAccounts(c.Request.Context(), body.Sender, body.Recipient)
+	if err != nil {
+		h.transactions.respondError(c, err, "failed to create standing order")
+		return
+	}
+	if err := authorizeDebit(c.Request.Context(), accounts[body.Sender]); err != nil {
+		h.transactions.respondError(c, err, "failed to create standing order")
+		return
+	}
+	if sender, recipient := accounts[body.Sender], accounts[body.Recipient]; !body.Convert || sender.IsSystem() || sender.Currency == recipient.Currency {
+		if _, err := transferCurrency(transfer, accounts); err != nil {
+			h.transactions.respondError(c, err, "failed to create standing order")
+			return
+		}
+	}
+
+	data := &models.CreateStandingOrder{
+		Reference: body.Reference,
+		Sender:    body.Sender,
+		Recipient: body.Recipient,
+		Amount:    body.Amount,
+		Convert:   body.Convert,
+		Schedule:  *schedule,
+		NextRunOn: nextRunOn,
+	}
+	if body.Currency != "" {
+		data.Currency = &body.Currency
+	}
+	order, err := h.standingOrderRepo.CreateStandingOrder(c.Request.Context(), data)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Message: "Standing order reference already exists",
+			})
+			return
+		}
+		h.logError("failed to create standing order", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to create standing order",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Standing order created successfully",
+		Data:    order,
+	})
+}
+
+// schedule converts the request into a schedule. Monthly schedules run on the day of the start date unless a day is given
+func (r ScheduleRequest) schedule() (*models.Schedule, error) {
+	start, err := time.Parse(time.DateOnly, r.StartDate)
+	if err != nil {
+		return nil, err
+	}
+	schedule := &models.Schedule{
+		Kind:      r.Kind,
+		StartDate: start,
+	}
+
+	if r.EndDate != "" {
+		end, err := time.Parse(time.DateOnly, r.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		if end.Before(start) {
+			return nil, errors.New("schedule end date is before its start date")
+		}
+		schedule.EndDate = &end
+	}
+
+	if r.Kind == models.MONTHLY {
+		schedule.DayOfMonth = r.DayOfMonth
+		if schedule.DayOfMonth == 0 {
+			schedule.DayOfMonth = start.Day()
+		}
+	}
+	return schedule, nil
+}
+
+// get standing orders
+
+type GetStandingOrdersQuery struct {
+	Account string `form:"account" binding:"required"`
+}
+
+// GetStandingOrders handles the retrieval of the standing orders paying from or into an account
+func (h *StandingOrderHandler) GetStandingOrders(c *gin.Context) {
+	var params GetStandingOrdersQuery
+	if err := c.ShouldBindQuery(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := authorizeAccountAccess(c.Request.Context(), h.transactions.accountRepo, READ_ALL, nil, params.Account); err != nil {
+		h.transactions.respondError(c, err, "Failed to retrieve standing orders")
+		return
+	}
+	orders, err := h.standingOrderRepo.GetStandingOrdersByAccount(c.Request.Context(), params.Account)
+	if err != nil {
+		h.logError("failed to retrieve standing orders", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve standing orders",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Standing orders retrieved successfully",
+		Data:    orders,
+	})
+}
+
+// get standing order
+
+type GetStandingOrderURI struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+// GetStandingOrder handles the retrieval of a standing order
+func (h *StandingOrderHandler) GetStandingOrder(c *gin.Context) {
+	var params GetStandingOrderURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	id, _ := uuid.Parse(params.ID)
+	order, err := h.standingOrderRepo.GetStandingOrderByID(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Standing order not found",
+			})
+			return
+		}
+		h.logError("failed to retrieve standing order", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve standing order",
+		})
+		return
+	}
+	if err := authorizeAccountAccess(c.Request.Context(), h.transactions.accountRepo, READ_ALL, nil, order.Sender, order.Recipient); err != nil {
+		h.transactions.respondError(c, err, "Failed to retrieve standing order")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Standing order retrieved successfully",
+		Data:    order,
+	})
+}
+
+// GetStandingOrderRuns handles the retrieval of the occurrences of a standing order along with their outcome
+func (h *StandingOrderHandler) GetStandingOrderRuns(c *gin.Context) {
+	var params GetStandingOrderURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	id, _ := uuid.Parse(params.ID)
+	order, err := h.standingOrderRepo.GetStandingOrderByID(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Standing order not found",
+			})
+			return
+		}
+		h.logError("failed to retrieve standing order", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve standing order runs",
+		})
+		return
+	}
+	if err := authorizeAccountAccess(c.Request.Context(), h.transactions.accountRepo, READ_ALL, nil, order.Sender, order.Recipient); err != nil {
+		h.transactions.respondError(c, err, "Failed to retrieve standing order runs")
+		return
+	}
+
+	runs, err := h.standingOrderRepo.GetRuns(c.Request.Context(), id)
+	if err != nil {
+		h.logError("failed to retrieve standing order runs", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve standing order runs",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Standing order runs retrieved successfully",
+		Data:    runs,
+	})
+}
+
+// CancelStandingOrder handles the cancellation of an active standing order. Occurrences still waiting to be posted are dropped
+func (h *StandingOrderHandler) CancelStandingOrder(c *gin.Context) {
+	var params GetStandingOrderURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// only the sender may stop paying, unless the caller manages accounts. A missing order is reported below
+	id, _ := uuid.Parse(params.ID)
+	if existing, err := h.standingOrderRepo.GetStandingOrderByID(c.Request.Context(), id); err == nil {
+		if err := authorizeAccountAccess(c.Request.Context(), h.transactions.accountRepo, MANAGE_ACCOUNTS, nil, existing.Sender); err != nil {
+			h.transactions.respondError(c, err, "failed to cancel standing order")
+			return
+		}
+	}
+	order, err := h.standingOrderRepo.CancelStandingOrder(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// tell a missing order apart from one that already stopped
+			if _, getErr := h.standingOrderRepo.GetStandingOrderByID(c.Request.Context(), id); getErr == nil {
+				c.JSON(http.StatusConflict, models.APIResponse{
+					Message: ErrStandingOrderNotActive.Error(),
+				})
+				return
+			}
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "Standing order not found",
+			})
+			return
+		}
+		h.logError("failed to cancel standing order", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to cancel standing order",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Standing order cancelled successfully",
+		Data:    order,
+	})
+}
+
+func (h *StandingOrderHandler) logError(message string, err error) {
+	h.logger.Error(message, "error", err)
+}
+
+// RegisterStandingOrderHandlers adds all the handler methods to the provided http router
+func RegisterStandingOrderHandlers(h *StandingOrderHandler, router *gin.Engine, logger *slog.Logger) {
+	r := router.Group("/standing-orders")
+	r.POST("", h.CreateStandingOrder)
+	r.GET("", h.GetStandingOrders)
+	r.GET("/:id", h.GetStandingOrder)
+	r.GET("/:id/runs", h.GetStandingOrderRuns)
+	r.POST("/:id/cancel", h.CancelStandingOrder)
+}
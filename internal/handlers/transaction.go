@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/mrshabel/sgbank/internal/models"
 	"github.com/mrshabel/sgbank/internal/repository"
 )
@@ -16,21 +21,56 @@ import (
 var (
 	ErrTransactionExists   = errors.New("transaction already exist")
 	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrCurrencyMismatch    = errors.New("account currencies do not match")
+	ErrFXRateNotFound      = errors.New("fx rate not found")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrFeeOverflow         = errors.New("transfer amount and fees overflow")
 )
 
+// requestError is a failure caused by the request rather than the server. It is reported to the client with its status and message
+type requestError struct {
+	status  int
+	message string
+	err     error
+}
+
+// newRequestError creates a request error reported with the given status and message. err is the underlying cause
+func newRequestError(status int, message string, err error) *requestError {
+	return &requestError{status: status, message: message, err: err}
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
 // TransactionHandler contains http handlers for transaction-related endpoints
 type TransactionHandler struct {
 	transactionRepo *repository.TransactionRepository
 	accountRepo     *repository.AccountRepository
-	logger          *slog.Logger
+	fxRateRepo      *repository.FXRateRepository
+	idempotencyRepo *repository.IdempotencyRepository
+	holdRepo        *repository.HoldRepository
+	feeRepo         *repository.FeeRepository
+	// paymentBatchRepo records the pain.001 batches posted through the handler
+	paymentBatchRepo *repository.PaymentBatchRepository
+	logger           *slog.Logger
 }
 
 // NewTransactionHandler creates a new transaction handler
-func NewTransactionHandler(transactionRepo *repository.TransactionRepository, accountRepo *repository.AccountRepository, logger *slog.Logger) *TransactionHandler {
+func NewTransactionHandler(transactionRepo *repository.TransactionRepository, accountRepo *repository.AccountRepository, fxRateRepo *repository.FXRateRepository, idempotencyRepo *repository.IdempotencyRepository, holdRepo *repository.HoldRepository, feeRepo *repository.FeeRepository, paymentBatchRepo *repository.PaymentBatchRepository, logger *slog.Logger) *TransactionHandler {
 	return &TransactionHandler{
-		transactionRepo: transactionRepo,
-		accountRepo:     accountRepo,
-		logger:          logger,
+		transactionRepo:  transactionRepo,
+		accountRepo:      accountRepo,
+		fxRateRepo:       fxRateRepo,
+		idempotencyRepo:  idempotencyRepo,
+		holdRepo:         holdRepo,
+		feeRepo:          feeRepo,
+		paymentBatchRepo: paymentBatchRepo,
+		logger:           logger,
 	}
 }
 
@@ -42,12 +82,16 @@ type CreateTransactionRequest struct {
 	Sender    string `json:"sender" binding:"required"`
 	Recipient string `json:"recipient" binding:"required"`
 	Amount    uint64 `json:"amount" binding:"required,gt=0"`
+	// Currency optionally asserts the currency of the transfer. It defaults to the currency of the debited account
+	Currency models.Currency `json:"currency" binding:"omitempty,iso4217"`
+	// Convert allows a transfer between accounts of different currencies at the effective fx rate. The amount is in the sender's currency
+	Convert bool `json:"convert"`
 	// Purpose   string `json:"purpose" binding:"required"`
 }
 
 // validate ensures that the input correctly matches the ledger rules
 
-// CreateTransaction handles new transaction creation
+// CreateTransaction handles new transaction creation. Requests carrying an Idempotency-Key header are posted at most once
 func (h *TransactionHandler) CreateTransaction(c *gin.Context) {
 	var body CreateTransactionRequest
 	if err := c.ShouldBindJSON(&body); err != nil {
@@ -69,29 +113,33 @@ func (h *TransactionHandler) CreateTransaction(c *gin.Context) {
 	}
 	defer repoTx.Rollback()
 
-	// validate accounts exist
-	accounts, err := h.validateAccounts(c, body.Sender, body.Recipient)
-	if err != nil {
-		return
+	// replay the original response of a retried request
+	idempotencyKey := c.GetHeader(IdempotencyKeyHeader)
+	if idempotencyKey != "" {
+		if replayed, err := h.claimIdempotencyKey(c, repoTx, idempotencyKey, body); replayed || err != nil {
+			return
+		}
 	}
 
-	// create transaction lines based on transaction type
-	lines, err := h.createTransactionLines(c, body, accounts)
+	transaction, err := h.PostTransfer(c.Request.Context(), repoTx, body)
 	if err != nil {
+		h.respondError(c, err, "failed to process transaction")
 		return
 	}
 
-	// create the transaction
-	transaction, err := h.transactionRepo.CreateTransaction(c.Request.Context(), repoTx, &models.CreateTransaction{
-		Reference: body.Reference,
-		Lines:     lines,
-	})
-	if err != nil {
-		h.logError("failed to create transaction", err)
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Message: "failed to process transaction",
-		})
-		return
+	response := models.APIResponse{
+		Message: "Transaction processed successfully",
+		Data:    newTransferResponse(transaction, body.Amount),
+	}
+	var saved []byte
+	if idempotencyKey != "" {
+		if saved, err = h.saveIdempotentResponse(c, repoTx, idempotencyKey, http.StatusOK, response); err != nil {
+			h.logError("failed to save idempotent response", err)
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Message: "failed to process transaction",
+			})
+			return
+		}
 	}
 
 	// commit transaction
@@ -103,28 +151,161 @@ func (h *TransactionHandler) CreateTransaction(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, models.APIResponse{
-		Message: "Transaction processed successfully",
-		Data:    transaction,
-	})
+	if saved != nil {
+		c.Data(http.StatusOK, idempotentContentType, saved)
+		return
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-// validateAccounts retrieves the associated accounts in the system.
-func (h *TransactionHandler) validateAccounts(c *gin.Context, sender, recipient string) (map[string]*models.Account, error) {
-	accounts, err := h.accountRepo.GetAccountsByAcctNumbers(c.Request.Context(), []string{sender, recipient})
+// TransferResponse itemizes a posted transfer into the principal sent and the fees charged to the sender on top of it
+type TransferResponse struct {
+	*models.Transaction
+	Principal uint64 `json:"principal"`
+	TotalFees uint64 `json:"total_fees"`
+}
+
+func newTransferResponse(transaction *models.Transaction, principal uint64) TransferResponse {
+	response := TransferResponse{Transaction: transaction, Principal: principal}
+	for _, fee := range transaction.Fees {
+		response.TotalFees += fee.Amount
+	}
+	return response
+}
+
+// PostTransfer validates a sender/recipient transfer and posts it within the provided database transaction, along with the fees its sender is charged. It is the
+// posting path shared by CreateTransaction and scheduled transfers. Failures caused by the transfer itself are returned as request errors
+func (h *TransactionHandler) PostTransfer(ctx context.Context, tx *sql.Tx, body CreateTransactionRequest) (*models.Transaction, error) {
+	// validate accounts exist
+	accounts, err := h.validateAccounts(ctx, body.Sender, body.Recipient)
 	if err != nil {
-		h.logError("failed to retrieve related accounts", err)
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Message: "failed to create transaction",
-		})
+		return nil, err
+	}
+	if err := authorizeDebit(ctx, accounts[body.Sender]); err != nil {
 		return nil, err
 	}
 
-	if len(accounts) < 2 {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Message: "Sender/Recipient account does not exist",
+	fees, err := h.transferFees(ctx, tx, body, accounts[body.Sender])
+	if err != nil {
+		return nil, err
+	}
+
+	// create transaction lines based on transaction type
+	lines, fxRate, err := h.createTransactionLines(ctx, tx, body, accounts, fees)
+	if err != nil {
+		return nil, err
+	}
+
+	// create the transaction
+	data := &models.CreateTransaction{
+		Reference: body.Reference,
+		Lines:     lines,
+	}
+	if fxRate != nil {
+		data.FXRateID = &fxRate.ID
+	}
+	transaction, err := h.createTransaction(ctx, tx, data)
+	if err != nil {
+		return nil, fmt.Errorf("create transaction: %w", err)
+	}
+
+	if len(fees) > 0 {
+		transaction.Fees, err = h.feeRepo.RecordFees(ctx, tx, transaction.ID, fees)
+		if err != nil {
+			return nil, fmt.Errorf("record fees: %w", err)
+		}
+	}
+	return transaction, nil
+}
+
+// transferFees prices the transfer under the fee schedules in force for its sender. Fees are charged in the sender's currency. Transfers from system accounts are free
+func (h *TransactionHandler) transferFees(ctx context.Context, tx *sql.Tx, body CreateTransactionRequest, sender *models.Account) ([]models.TransactionFee, error) {
+	if sender.IsSystem() {
+		return nil, nil
+	}
+
+	now := time.Now()
+	schedules, err := h.feeRepo.GetApplicableSchedules(ctx, tx, sender, sender.Currency, now)
+	if err != nil {
+		return nil, fmt.Errorf("retrieve fee schedules: %w", err)
+	}
+
+	var volume *uint64
+	var fees []models.TransactionFee
+	for _, schedule := range schedules {
+		// tiered fees depend on what the sender already sent this month
+		if schedule.Kind == models.TIERED_FEE && volume == nil {
+			v, err := h.feeRepo.GetMonthlyVolume(ctx, tx, sender.ID, sender.Currency, now)
+			if err != nil {
+				return nil, fmt.Errorf("retrieve monthly volume: %w", err)
+			}
+			volume = &v
+		}
+
+		var monthlyVolume uint64
+		if volume != nil {
+			monthlyVolume = *volume
+		}
+		amount, err := schedule.Fee(body.Amount, monthlyVolume)
+		if err != nil {
+			return nil, newRequestError(http.StatusBadRequest, "Failed to price fee "+schedule.Name+": "+err.Error(), err)
+		}
+		if amount == 0 {
+			continue
+		}
+		fees = append(fees, models.TransactionFee{
+			AccountID:  sender.ID,
+			ScheduleID: schedule.ID,
+			Name:       schedule.Name,
+			Amount:     amount,
+			Currency:   sender.Currency,
 		})
-		return nil, errors.New("accounts not found")
+	}
+	return fees, nil
+}
+
+// feeLines builds the legs charging the fees to the sender: Debit sender, Credit the fee revenue account of the currency. The total debited from the sender is returned
+// along with them
+func (h *TransactionHandler) feeLines(ctx context.Context, tx *sql.Tx, sender *models.Account, amount uint64, fees []models.TransactionFee) ([]models.CreateTransactionLine, uint64, error) {
+	total := amount
+	var lines []models.CreateTransactionLine
+	for _, fee := range fees {
+		if total+fee.Amount < total || total+fee.Amount > math.MaxInt64 {
+			return nil, 0, newRequestError(http.StatusBadRequest, ErrFeeOverflow.Error(), ErrFeeOverflow)
+		}
+		total += fee.Amount
+
+		revenue, err := h.accountRepo.GetOrCreateSystemAccount(ctx, tx, &models.CreateAccount{
+			AccountNumber: models.FeeRevenueAccount(fee.Currency),
+			Class:         models.REVENUE,
+			Currency:      fee.Currency,
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("retrieve fee revenue account: %w", err)
+		}
+		lines = append(lines,
+			models.CreateTransactionLine{
+				AccountID: sender.ID,
+				Purpose:   models.DEBIT,
+				Amount:    fee.Amount,
+				Currency:  fee.Currency,
+			},
+			models.CreateTransactionLine{
+				AccountID: revenue.ID,
+				Purpose:   models.CREDIT,
+				Amount:    fee.Amount,
+				Currency:  fee.Currency,
+			},
+		)
+	}
+	return lines, total, nil
+}
+
+// validateAccounts retrieves the associated accounts in the system. Every given account number must exist
+func (h *TransactionHandler) validateAccounts(ctx context.Context, acctNums ...string) (map[string]*models.Account, error) {
+	accounts, err := h.accountRepo.GetAccountsByAcctNumbers(ctx, acctNums)
+	if err != nil {
+		return nil, fmt.Errorf("retrieve related accounts: %w", err)
 	}
 
 	// map account numbers to accounts
@@ -133,12 +314,30 @@ func (h *TransactionHandler) validateAccounts(c *gin.Context, sender, recipient
 		accountMap[acct.AccountNumber] = acct
 	}
 
+	for _, acctNum := range acctNums {
+		if _, ok := accountMap[acctNum]; !ok {
+			return nil, newRequestError(http.StatusBadRequest, "Account "+acctNum+" does not exist", ErrAccountNotFound)
+		}
+	}
+
 	return accountMap, nil
 }
 
-func (h *TransactionHandler) createTransactionLines(c *gin.Context, body CreateTransactionRequest, accounts map[string]*models.Account) ([]models.CreateTransactionLine, error) {
+// createTransactionLines builds the double-entry lines of a sender/recipient transfer and the fees charged on it. The fx rate used is returned for cross-currency transfers
+func (h *TransactionHandler) createTransactionLines(ctx context.Context, tx *sql.Tx, body CreateTransactionRequest, accounts map[string]*models.Account, fees []models.TransactionFee) ([]models.CreateTransactionLine, *models.FXRate, error) {
 	var lines []models.CreateTransactionLine
 
+	// convert cross-currency transfers through the fx position accounts
+	sender, recipient := accounts[body.Sender], accounts[body.Recipient]
+	if body.Convert && !sender.IsSystem() && sender.Currency != recipient.Currency {
+		return h.createFXTransactionLines(ctx, tx, body, sender, recipient, fees)
+	}
+
+	currency, err := transferCurrency(body, accounts)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// skip balance checks for transfer from root accounts (deposits)
 	if body.Sender == models.RootAccount {
 		// system transactions: Credit recipient, Debit root account
@@ -147,30 +346,26 @@ func (h *TransactionHandler) createTransactionLines(c *gin.Context, body CreateT
 				AccountID: accounts[body.Recipient].ID,
 				Purpose:   models.CREDIT,
 				Amount:    body.Amount,
+				Currency:  currency,
 			},
 			{
 				AccountID: accounts[models.RootAccount].ID,
 				Purpose:   models.DEBIT,
 				Amount:    body.Amount,
+				Currency:  currency,
 			},
 		}
 	} else {
-		// verify that sender has sufficient balance for inter-account transfers (non-root)
+		// verify that sender has sufficient balance for inter-account transfers (non-root), fees included
 		senderAcct := accounts[body.Sender]
-		balance, err := h.transactionRepo.GetBalanceByAccountID(c.Request.Context(), senderAcct.ID)
+		feeLegs, total, err := h.feeLines(ctx, tx, senderAcct, body.Amount, fees)
 		if err != nil {
-			h.logError("failed to retrieve sender balance", err)
-			c.JSON(http.StatusBadRequest, models.APIResponse{
-				Message: "failed to process transaction",
-			})
-			return nil, err
+			return nil, nil, err
 		}
-
-		if body.Amount > balance {
-			c.JSON(http.StatusBadRequest, models.APIResponse{
-				Message: "Insufficient balance",
-			})
-			return nil, errors.New("insufficient balance")
+		if effect := senderAcct.Class.Effect(models.DEBIT, total); effect < 0 {
+			if err := h.ensureSufficientBalance(ctx, tx, senderAcct, uint64(-effect)); err != nil {
+				return nil, nil, err
+			}
 		}
 
 		// double entry: Debit sender, Credit recipient
@@ -179,16 +374,135 @@ func (h *TransactionHandler) createTransactionLines(c *gin.Context, body CreateT
 				AccountID: senderAcct.ID,
 				Purpose:   models.DEBIT,
 				Amount:    body.Amount,
+				Currency:  currency,
 			},
 			{
 				AccountID: accounts[body.Recipient].ID,
 				Purpose:   models.CREDIT,
 				Amount:    body.Amount,
+				Currency:  currency,
 			},
 		}
+		lines = append(lines, feeLegs...)
+	}
+
+	return lines, nil, nil
+}
+
+// createFXTransactionLines builds the lines of a cross-currency transfer at the effective rate. Each currency side balances on its own through the fx position account of that currency:
+// Debit sender, Credit sender-currency position; Debit recipient-currency position, Credit recipient
+func (h *TransactionHandler) createFXTransactionLines(ctx context.Context, tx *sql.Tx, body CreateTransactionRequest, sender, recipient *models.Account, fees []models.TransactionFee) ([]models.CreateTransactionLine, *models.FXRate, error) {
+	if body.Currency != "" && body.Currency != sender.Currency {
+		return nil, nil, newRequestError(http.StatusBadRequest, "Transfer currency does not match the sender currency", ErrCurrencyMismatch)
+	}
+
+	rate, err := h.fxRateRepo.GetEffectiveRate(ctx, sender.Currency, recipient.Currency, time.Now())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, newRequestError(http.StatusBadRequest, "No fx rate available for "+string(sender.Currency)+"/"+string(recipient.Currency), ErrFXRateNotFound)
+		}
+		return nil, nil, fmt.Errorf("retrieve fx rate: %w", err)
+	}
+
+	converted, err := rate.Convert(body.Amount, sender.Currency)
+	if err != nil {
+		return nil, nil, newRequestError(http.StatusBadRequest, err.Error(), err)
+	}
+
+	// verify that the sender can cover the debit and fees in its own currency
+	feeLegs, total, err := h.feeLines(ctx, tx, sender, body.Amount, fees)
+	if err != nil {
+		return nil, nil, err
+	}
+	if effect := sender.Class.Effect(models.DEBIT, total); effect < 0 {
+		if err := h.ensureSufficientBalance(ctx, tx, sender, uint64(-effect)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// resolve the position accounts of both currencies
+	positions := make(map[models.Currency]*models.Account, 2)
+	for _, currency := range []models.Currency{sender.Currency, recipient.Currency} {
+		acct, err := h.accountRepo.GetOrCreateSystemAccount(ctx, tx, &models.CreateAccount{
+			AccountNumber: models.FXPositionAccount(currency),
+			Class:         models.ASSET,
+			Currency:      currency,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("retrieve fx position account: %w", err)
+		}
+		positions[currency] = acct
+	}
+
+	lines := []models.CreateTransactionLine{
+		{
+			AccountID: sender.ID,
+			Purpose:   models.DEBIT,
+			Amount:    body.Amount,
+			Currency:  sender.Currency,
+		},
+		{
+			AccountID: positions[sender.Currency].ID,
+			Purpose:   models.CREDIT,
+			Amount:    body.Amount,
+			Currency:  sender.Currency,
+		},
+		{
+			AccountID: positions[recipient.Currency].ID,
+			Purpose:   models.DEBIT,
+			Amount:    converted,
+			Currency:  recipient.Currency,
+		},
+		{
+			AccountID: recipient.ID,
+			Purpose:   models.CREDIT,
+			Amount:    converted,
+			Currency:  recipient.Currency,
+		},
+	}
+	return append(lines, feeLegs...), rate, nil
+}
+
+// transferCurrency resolves the currency of a sender/recipient transfer. Deposits from the root account take the recipient's currency, every other transfer takes the sender's currency which the recipient must also hold
+func transferCurrency(body CreateTransactionRequest, accounts map[string]*models.Account) (models.Currency, error) {
+	sender, recipient := accounts[body.Sender], accounts[body.Recipient]
+
+	currency := sender.Currency
+	if sender.IsSystem() {
+		currency = recipient.Currency
+	}
+
+	if body.Currency != "" && body.Currency != currency {
+		return "", newRequestError(http.StatusBadRequest, "Transfer currency does not match the account currency", ErrCurrencyMismatch)
 	}
 
-	return lines, nil
+	if !sender.Accepts(currency) || !recipient.Accepts(currency) {
+		return "", newRequestError(http.StatusBadRequest, "Cross-currency transfers require an FX leg. Set convert to transfer at the effective fx rate", ErrCurrencyMismatch)
+	}
+	return currency, nil
+}
+
+// ensureSufficientBalance verifies that reducing the available balance of the account by the given amount does not take it below zero. The available balance is the normal
+// balance less active holds, plus the account's overdraft or credit limit. The account is locked for the rest of the database transaction so that concurrent postings cannot
+// spend the same balance. System accounts are exempt
+func (h *TransactionHandler) ensureSufficientBalance(ctx context.Context, tx *sql.Tx, acct *models.Account, amount uint64) error {
+	if acct.IsSystem() {
+		return nil
+	}
+
+	if err := h.transactionRepo.LockAccounts(ctx, tx, acct.ID); err != nil {
+		return fmt.Errorf("lock account: %w", err)
+	}
+
+	balance, err := h.transactionRepo.GetAvailableBalanceByAccountID(ctx, tx, acct.ID)
+	if err != nil {
+		return fmt.Errorf("retrieve account balance: %w", err)
+	}
+
+	if balance < 0 || amount > uint64(balance) {
+		return newRequestError(http.StatusBadRequest, "Insufficient balance", ErrInsufficientBalance)
+	}
+	return nil
 }
 
 // GetTransactionURI represents the path params of the GetTransaction request
@@ -196,7 +510,7 @@ type GetTransactionURI struct {
 	ID string `uri:"id" binding:"required,uuid"`
 }
 
-// GetTransaction handles transaction retrieval
+// GetTransaction handles transaction retrieval along with any reversals posted against it
 func (h *TransactionHandler) GetTransaction(c *gin.Context) {
 	var params GetTransactionURI
 	if err := c.ShouldBindUri(&params); err != nil {
@@ -228,6 +542,37 @@ func (h *TransactionHandler) GetTransaction(c *gin.Context) {
 		return
 	}
 
+	acctIDs := make([]uuid.UUID, 0, len(transaction.Lines))
+	for _, line := range transaction.Lines {
+		if acctID, err := uuid.Parse(line.AccountID); err == nil {
+			acctIDs = append(acctIDs, acctID)
+		}
+	}
+	if err := authorizeAccountAccess(c.Request.Context(), h.accountRepo, READ_ALL, acctIDs); err != nil {
+		h.respondError(c, err, "Failed to retrieve transaction")
+		return
+	}
+
+	// attach the reversal chain
+	transaction.Reversals, err = h.transactionRepo.GetReversals(c.Request.Context(), transaction.ID)
+	if err != nil {
+		h.logError("failed to retrieve transaction reversals", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve transaction",
+		})
+		return
+	}
+
+	// itemize the fees charged on it
+	transaction.Fees, err = h.feeRepo.GetTransactionFees(c.Request.Context(), transaction.ID)
+	if err != nil {
+		h.logError("failed to retrieve transaction fees", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "Failed to retrieve transaction",
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Message: "Transaction retrieved successfully",
 		Data:    transaction,
@@ -252,6 +597,10 @@ func (h *TransactionHandler) GetAccountTransactions(c *gin.Context) {
 
 	// parse uuid
 	userID, _ := uuid.Parse(params.AccountID)
+	if err := authorizeAccountAccess(c.Request.Context(), h.accountRepo, READ_ALL, []uuid.UUID{userID}); err != nil {
+		h.respondError(c, err, "Failed to retrieve account transactions")
+		return
+	}
 	transactions, err := h.transactionRepo.GetTransactionsByAccountID(c.Request.Context(), userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -276,6 +625,35 @@ func (h *TransactionHandler) GetAccountTransactions(c *gin.Context) {
 	})
 }
 
+// createTransaction posts the transaction within the provided database transaction. Every posting path goes through it, so that a reference already taken is
+// reported as a conflict whichever path it was sent to
+func (h *TransactionHandler) createTransaction(ctx context.Context, tx *sql.Tx, data *models.CreateTransaction) (*models.Transaction, error) {
+	transaction, err := h.transactionRepo.CreateTransaction(ctx, tx, data)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" && pqErr.Constraint == "transactions_reference_key" {
+			return nil, newRequestError(http.StatusConflict, "Transaction reference "+data.Reference+" already exists", ErrTransactionExists)
+		}
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// respondError writes a failed request to the client. Request errors keep their status and message, anything else is logged and reported with the fallback message
+func (h *TransactionHandler) respondError(c *gin.Context, err error, fallback string) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		c.JSON(reqErr.status, models.APIResponse{
+			Message: reqErr.message,
+		})
+		return
+	}
+
+	h.logError(fallback, err)
+	c.JSON(http.StatusBadRequest, models.APIResponse{
+		Message: fallback,
+	})
+}
+
 func (h *TransactionHandler) logError(message string, err error) {
 	h.logger.Error(message, "error", err)
 }
@@ -286,4 +664,15 @@ func RegisterTransactionHandlers(h *TransactionHandler, router *gin.Engine, logg
 	r.POST("", h.CreateTransaction)
 	r.GET("", h.GetAccountTransactions)
 	r.GET("/:id", h.GetTransaction)
+	r.POST("/:id/reverse", h.ReverseTransaction)
+	r.POST("/:id/refund-fees", h.RefundFees)
+
+	router.POST("/journal-entries", h.CreateJournalEntry)
+	router.POST("/bulk-transfers", h.CreateBulkTransfer)
+
+	holds := router.Group("/holds")
+	holds.POST("", h.CreateHold)
+	holds.GET("/:id", h.GetHold)
+	holds.POST("/:id/capture", h.CaptureHold)
+	holds.POST("/:id/void", h.VoidHold)
 }
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	dbpkg "github.com/mrshabel/sgbank/internal/db"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+	"github.com/mrshabel/sgbank/internal/utils"
+)
+
+// TestCreateTransactionConcurrentDebits posts parallel debits against one funded account and verifies that it is never drawn below zero. It runs against the
+// postgres database in TEST_DATABASE_URL, which is migrated up first
+func TestCreateTransactionConcurrentDebits(t *testing.T) {
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	const (
+		opening = 1000
+		amount  = 30
+		debits  = 64
+	)
+
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db, err := dbpkg.New(connStr, logger)
+	if err != nil {
+		t.Fatalf("connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := dbpkg.MigrateUp(ctx, db, logger); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+
+	userRepo := repository.NewUserRepository(db, logger)
+	accountRepo := repository.NewAccountRepository(db, logger)
+	transactionRepo := repository.NewTransactionRepository(db, logger)
+	h := NewTransactionHandler(transactionRepo, accountRepo, repository.NewFXRateRepository(db, logger), repository.NewIdempotencyRepository(db, logger),
+		repository.NewHoldRepository(db, logger), repository.NewFeeRepository(db, logger), repository.NewPaymentBatchRepository(db, logger), logger)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterTransactionHandlers(h, router, logger)
+
+	user, err := userRepo.CreateUser(ctx, &models.CreateUser{Email: uuid.NewString() + "@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	openAccount := func() *models.Account {
+		account, err := accountRepo.CreateAccount(ctx, &models.CreateAccount{
+			AccountNumber: utils.GenerateAccountNumber(10),
+			UserID:        user.ID.String(),
+			Class:         models.LIABILITY,
+			Currency:      models.DefaultCurrency,
+			Type:          models.DEPOSIT,
+		})
+		if err != nil {
+			t.Fatalf("create account: %v", err)
+		}
+		return account
+	}
+	sender, recipient := openAccount(), openAccount()
+
+	transfer := func(from, to string, amount uint64) int {
+		body, _ := json.Marshal(CreateTransactionRequest{Reference: uuid.NewString(), Sender: from, Recipient: to, Amount: amount})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/transactions", bytes.NewReader(body)))
+		return w.Code
+	}
+
+	// fund the sender from the root account
+	if status := transfer(models.RootAccount, sender.AccountNumber, opening); status != http.StatusOK {
+		t.Fatalf("fund sender: status %d", status)
+	}
+
+	// watch the materialized balance while the debits race
+	done := make(chan struct{})
+	watched := make(chan int64, 1)
+	go func() {
+		lowest := int64(opening)
+		defer func() { watched <- lowest }()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			var balance int64
+			if err := db.QueryRowContext(ctx, `SELECT credits - debits FROM account_balances WHERE account_id = $1 AND currency = $2`, sender.ID, models.DefaultCurrency).Scan(&balance); err == nil && balance < lowest {
+				lowest = balance
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var succeeded atomic.Int64
+	for range debits {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if transfer(sender.AccountNumber, recipient.AccountNumber, amount) == http.StatusOK {
+				succeeded.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+
+	if total := succeeded.Load() * amount; total > opening {
+		t.Errorf("debited %d from an opening balance of %d", total, opening)
+	}
+	if lowest := <-watched; lowest < 0 {
+		t.Errorf("balance dropped to %d", lowest)
+	}
+
+	var credits, debitTotal int64
+	if err := db.QueryRowContext(ctx, `SELECT credits, debits FROM account_balances WHERE account_id = $1 AND currency = $2`, sender.ID, models.DefaultCurrency).Scan(&credits, &debitTotal); err != nil {
+		t.Fatalf("read balance: %v", err)
+	}
+	if balance := credits - debitTotal; balance < 0 {
+		t.Errorf("balance is %d after %d debits of %d", balance, succeeded.Load(), amount)
+	}
+}
+
+// openTestHandler connects a transaction handler to the postgres database in TEST_DATABASE_URL, which is migrated up first. The test is skipped without one
+func openTestHandler(t *testing.T) (*TransactionHandler, *sql.DB) {
+	t.Helper()
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db, err := dbpkg.New(connStr, logger)
+	if err != nil {
+		t.Fatalf("connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := dbpkg.MigrateUp(ctx, db, logger); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	h := NewTransactionHandler(repository.NewTransactionRepository(db, logger), repository.NewAccountRepository(db, logger), repository.NewFXRateRepository(db, logger),
+		repository.NewIdempotencyRepository(db, logger), repository.NewHoldRepository(db, logger), repository.NewFeeRepository(db, logger),
+		repository.NewPaymentBatchRepository(db, logger), logger)
+	return h, db
+}
+
+// TestDuplicateReference posts a reference that is already taken through each posting path and checks that every one reports a conflict
+func TestDuplicateReference(t *testing.T) {
+	h, db := openTestHandler(t)
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	router := gin.New()
+	RegisterTransactionHandlers(h, router, logger)
+
+	user, err := repository.NewUserRepository(db, logger).CreateUser(ctx, &models.CreateUser{Email: uuid.NewString() + "@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	account, err := repository.NewAccountRepository(db, logger).CreateAccount(ctx, &models.CreateAccount{
+		AccountNumber: utils.GenerateAccountNumber(10),
+		UserID:        user.ID.String(),
+		Class:         models.LIABILITY,
+		Currency:      models.DefaultCurrency,
+		Type:          models.DEPOSIT,
+	})
+	if err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+
+	post := func(path string, body any) *httptest.ResponseRecorder {
+		encoded, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(encoded)))
+		return w
+	}
+
+	reference := uuid.NewString()
+	first := post("/transactions", CreateTransactionRequest{Reference: reference, Sender: models.RootAccount, Recipient: account.AccountNumber, Amount: 500})
+	if first.Code != http.StatusOK {
+		t.Fatalf("first transfer: status %d: %s", first.Code, first.Body)
+	}
+	var posted struct {
+		Data struct {
+			ID uuid.UUID `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(first.Body.Bytes(), &posted); err != nil {
+		t.Fatalf("decode transfer: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		body any
+	}{
+		{"transfer", "/transactions", CreateTransactionRequest{Reference: reference, Sender: models.RootAccount, Recipient: account.AccountNumber, Amount: 1}},
+		{"journal entry", "/journal-entries", CreateJournalEntryRequest{Reference: reference, Lines: []JournalEntryLineRequest{
+			{AccountNumber: account.AccountNumber, Purpose: models.DEBIT, Amount: 1},
+			{AccountNumber: models.RootAccount, Purpose: models.CREDIT, Amount: 1},
+		}}},
+		{"reversal", "/transactions/" + posted.Data.ID.String() + "/reverse", ReverseTransactionRequest{Reference: reference}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := post(tt.path, tt.body); w.Code != http.StatusConflict {
+				t.Errorf("status %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+			}
+		})
+	}
+}
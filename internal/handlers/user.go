@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"log/slog"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/auth"
 	"github.com/mrshabel/sgbank/internal/models"
 	"github.com/mrshabel/sgbank/internal/repository"
 )
@@ -16,27 +18,33 @@ import (
 var (
 	ErrUserExists   = errors.New("user already exist")
 	ErrUserNotFound = errors.New("user not found")
+	ErrSystemRole   = errors.New("the role of the system user cannot be changed")
+	ErrSelfRole     = errors.New("users cannot change their own role")
 )
 
 // UserHandler contains http handlers for user-related endpoints
 type UserHandler struct {
 	userRepo *repository.UserRepository
-	logger   *slog.Logger
+	// verifications emails new users the token that verifies their email
+	verifications *VerificationHandler
+	logger        *slog.Logger
 }
 
 // NewUserHandler creates a new user handler
-func NewUserHandler(userRepo *repository.UserRepository, logger *slog.Logger) *UserHandler {
+func NewUserHandler(userRepo *repository.UserRepository, verifications *VerificationHandler, logger *slog.Logger) *UserHandler {
 	return &UserHandler{
-		userRepo: userRepo,
-		logger:   logger,
+		userRepo:      userRepo,
+		verifications: verifications,
+		logger:        logger,
 	}
 }
 
 // create user
 
-// CreateUserRequest represents the user request payload
+// CreateUserRequest represents the user request payload. The password is what the user logs in with
 type CreateUserRequest struct {
-	Email string `json:"email" binding:"required,email"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=12,max=128"`
 }
 
 // CreateUser handles new user creation
@@ -51,7 +59,17 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userRepo.CreateUser(c.Request.Context(), &models.CreateUser{Email: body.Email})
+	passwordHash, err := auth.HashPassword(body.Password)
+	if err != nil {
+		// log error
+		h.logError("failed to hash password", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to create user",
+		})
+		return
+	}
+
+	user, err := h.userRepo.CreateUser(c.Request.Context(), &models.CreateUser{Email: body.Email, PasswordHash: passwordHash})
 	if err != nil {
 		// log error
 		h.logError("failed to create user", err)
@@ -61,8 +79,19 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		return
 	}
 
+	setAuditActor(c, user.ID)
+
+	// the verification email is sent after the response. Users can ask for another one if it never arrives
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), mailTimeout)
+	go func() {
+		defer cancel()
+		if err := h.verifications.SendVerification(ctx, user); err != nil {
+			h.logError("failed to send verification email", err)
+		}
+	}()
+
 	c.JSON(http.StatusOK, models.APIResponse{
-		Message: "User created successfully",
+		Message: "User created successfully. A token to verify the email has been sent to it",
 		Data:    user,
 	})
 }
@@ -86,6 +115,10 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 
 	// parse uuid
 	id, _ := uuid.Parse(params.ID)
+	if err := authorizeOwner(c.Request.Context(), READ_ALL, id.String()); err != nil {
+		respondAccessError(c, h.logger, err, "Failed to retrieve user")
+		return
+	}
 	user, err := h.userRepo.GetUserByID(c.Request.Context(), id)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -110,6 +143,71 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 	})
 }
 
+// set user role
+
+// SetUserRoleRequest represents the set user role request payload
+type SetUserRoleRequest struct {
+	Role models.Role `json:"role" binding:"required,oneof=customer auditor operator"`
+}
+
+// SetUserRole handles changing the role of a user. The new role applies to the next request of the user. The system user stays an operator and callers cannot
+// change their own role, so the last operator can never demote themselves
+func (h *UserHandler) SetUserRole(c *gin.Context) {
+	var params GetUserURI
+	if err := c.ShouldBindUri(&params); err != nil {
+		// log error
+		h.logError("invalid request params", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+	var body SetUserRoleRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		// log error
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	id, _ := uuid.Parse(params.ID)
+	if id.String() == models.SystemUserID {
+		err := denyAccess(c.Request.Context(), MANAGE_USERS, ErrSystemRole.Error(), ErrSystemRole)
+		respondAccessError(c, h.logger, err, "failed to set user role")
+		return
+	}
+	if principal, ok := auth.PrincipalFrom(c.Request.Context()); ok && principal.UserID == id {
+		err := denyAccess(c.Request.Context(), MANAGE_USERS, ErrSelfRole.Error(), ErrSelfRole)
+		respondAccessError(c, h.logger, err, "failed to set user role")
+		return
+	}
+
+	user, err := h.userRepo.SetUserRole(c.Request.Context(), id, body.Role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Message: "User not found",
+			})
+			return
+		}
+
+		// log error
+		h.logError("failed to set user role", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to set user role",
+		})
+		return
+	}
+
+	h.logger.Info("user role changed", "user_id", user.ID, "role", user.Role)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "User role updated successfully",
+		Data:    user,
+	})
+}
+
 func (h *UserHandler) logError(message string, err error) {
 	h.logger.Error(message, "error", err)
 }
@@ -119,4 +217,5 @@ func RegisterUserHandlers(h *UserHandler, router *gin.Engine, logger *slog.Logge
 	r := router.Group("/users")
 	r.POST("", h.CreateUser)
 	r.GET("/:id", h.GetUser)
+	r.PATCH("/:id/role", h.SetUserRole)
 }
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrshabel/sgbank/internal/auth"
+	"github.com/mrshabel/sgbank/internal/mailer"
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// mailTimeout bounds the delivery of an email sent after the response
+const mailTimeout = 30 * time.Second
+
+// VerificationHandler contains http handlers for email verification and password resets, both of which email the user a single use token
+type VerificationHandler struct {
+	userRepo      *repository.UserRepository
+	userTokenRepo *repository.UserTokenRepository
+	mailer        mailer.Mailer
+	// verificationTTL and resetTTL are how long the emailed tokens stay valid
+	verificationTTL time.Duration
+	resetTTL        time.Duration
+	logger          *slog.Logger
+}
+
+// NewVerificationHandler creates a new verification handler
+func NewVerificationHandler(userRepo *repository.UserRepository, userTokenRepo *repository.UserTokenRepository, mailer mailer.Mailer, verificationTTL, resetTTL time.Duration,
+	logger *slog.Logger) *VerificationHandler {
+	return &VerificationHandler{
+		userRepo:        userRepo,
+		userTokenRepo:   userTokenRepo,
+		mailer:          mailer,
+		verificationTTL: verificationTTL,
+		resetTTL:        resetTTL,
+		logger:          logger,
+	}
+}
+
+// SendVerification emails the user a new token to verify their email with. Tokens sent to them earlier stop working
+func (h *VerificationHandler) SendVerification(ctx context.Context, user *models.User) error {
+	token, expiresAt, err := h.issueToken(ctx, user, models.EMAIL_VERIFICATION, h.verificationTTL)
+	if err != nil {
+		return err
+	}
+	return h.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your sgbank email",
+		Body: "Hello,\n\n" +
+			"Confirm that this is your email by sending the token below to POST /auth/verify-email as {\"token\": \"<token>\"}. It expires at " +
+			expiresAt.UTC().Format(time.RFC1123) + ".\n\n" +
+			token + "\n\n" +
+			"You need a verified email to open accounts. If you did not sign up for sgbank, ignore this email.\n",
+	})
+}
+
+// sendPasswordReset emails the user a new token to reset their password with. Tokens sent to them earlier stop working
+func (h *VerificationHandler) sendPasswordReset(ctx context.Context, user *models.User) error {
+	token, expiresAt, err := h.issueToken(ctx, user, models.PASSWORD_RESET, h.resetTTL)
+	if err != nil {
+		return err
+	}
+	return h.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your sgbank password",
+		Body: "Hello,\n\n" +
+			"Choose a new password by sending the token below to POST /auth/password-reset/confirm as {\"token\": \"<token>\", \"new_password\": \"<password>\"}. " +
+			"It expires at " + expiresAt.UTC().Format(time.RFC1123) + " and signs you out everywhere.\n\n" +
+			token + "\n\n" +
+			"If you did not ask to reset your password, ignore this email. Your password has not been changed.\n",
+	})
+}
+
+// issueToken generates and stores a new token of the user for the purpose, returning it along with its expiry
+func (h *VerificationHandler) issueToken(ctx context.Context, user *models.User, purpose models.TokenPurpose, ttl time.Duration) (string, time.Time, error) {
+	token, err := auth.GenerateUserToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	stored, err := h.userTokenRepo.CreateToken(ctx, &models.CreateUserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: auth.HashSecret(token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("store %s token: %w", purpose, err)
+	}
+	return token, stored.ExpiresAt, nil
+}
+
+// verify email
+
+// UserTokenRequest represents a request carrying an emailed token
+type UserTokenRequest struct {
+	Token string `json:"token" binding:"required,max=64"`
+}
+
+// VerifyEmail handles verifying the email of a user with the token emailed to them
+func (h *VerificationHandler) VerifyEmail(c *gin.Context) {
+	var body UserTokenRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	user, err := h.userTokenRepo.VerifyEmail(c.Request.Context(), auth.HashSecret(body.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Message: "Token is invalid, used or expired",
+			})
+			return
+		}
+		h.logError("failed to verify email", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to verify email",
+		})
+		return
+	}
+
+	setAuditActor(c, user.ID)
+	h.logger.Info("email verified", "user_id", user.ID)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Email verified successfully",
+		Data:    user,
+	})
+}
+
+// ResendVerification handles emailing the caller a new verification token, such as when the first one expired
+func (h *VerificationHandler) ResendVerification(c *gin.Context) {
+	ctx := c.Request.Context()
+	principal, _ := auth.PrincipalFrom(ctx)
+	user, err := h.userRepo.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		h.logError("failed to retrieve user", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to send verification email",
+		})
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Message: "Email has already been verified",
+		})
+		return
+	}
+
+	if err := h.SendVerification(ctx, user); err != nil {
+		h.logError("failed to send verification email", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to send verification email",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Verification email sent successfully",
+	})
+}
+
+// password reset
+
+// RequestPasswordResetRequest represents the password reset request payload
+type RequestPasswordResetRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// RequestPasswordReset handles emailing a password reset token to a user. The response is the same whether or not the email is registered, and the email is sent
+// after it, so that neither its content nor its timing reveals who is registered
+func (h *VerificationHandler) RequestPasswordReset(c *gin.Context) {
+	var body RequestPasswordResetRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), mailTimeout)
+	go func() {
+		defer cancel()
+		user, err := h.userRepo.GetUserByEmail(ctx, body.Email)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				h.logError("failed to retrieve user", err)
+			}
+			return
+		}
+		// the system user only posts on behalf of the bank and never logs in
+		if user.ID.String() == models.SystemUserID {
+			return
+		}
+		if err := h.sendPasswordReset(ctx, user); err != nil {
+			h.logError("failed to send password reset email", err)
+		}
+	}()
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "If the email is registered, a password reset token has been sent to it",
+	})
+}
+
+// ResetPasswordRequest represents the payload completing a password reset
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required,max=64"`
+	NewPassword string `json:"new_password" binding:"required,min=12,max=128"`
+}
+
+// ResetPassword handles setting a new password with the token emailed to the user. Every session of the user is revoked and any lockout is lifted. Receiving
+// the token proves the user owns their email, so it is marked as verified too
+func (h *VerificationHandler) ResetPassword(c *gin.Context) {
+	var body ResetPasswordRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.logError("invalid request body", err)
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	hash, err := auth.HashPassword(body.NewPassword)
+	if err != nil {
+		h.logError("failed to hash password", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to reset password",
+		})
+		return
+	}
+	token, err := h.userTokenRepo.ResetPassword(c.Request.Context(), auth.HashSecret(body.Token), hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Message: "Token is invalid, used or expired",
+			})
+			return
+		}
+		h.logError("failed to reset password", err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Message: "failed to reset password",
+		})
+		return
+	}
+
+	setAuditActor(c, token.UserID)
+	h.logger.Info("password reset", "user_id", token.UserID)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Message: "Password reset successfully",
+	})
+}
+
+func (h *VerificationHandler) logError(message string, err error) {
+	h.logger.Error(message, "error", err)
+}
+
+// RegisterVerificationHandlers adds all the handler methods to the provided http router
+func RegisterVerificationHandlers(h *VerificationHandler, router *gin.Engine, logger *slog.Logger) {
+	r := router.Group("/auth")
+	r.POST("/verify-email", h.VerifyEmail)
+	r.POST("/verify-email/resend", h.ResendVerification)
+	r.POST("/password-reset", h.RequestPasswordReset)
+	r.POST("/password-reset/confirm", h.ResetPassword)
+}
@@ -0,0 +1,28 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// NewArrearsJob creates a job that flags customer accounts in arrears. Deposit accounts are flagged once they have been overdrawn for longer than the grace period,
+// and any account as soon as it is below its limit
+func NewArrearsJob(transactionRepo *repository.TransactionRepository, interval, grace time.Duration, logger *slog.Logger) Job {
+	return Job{
+		Name:     "arrears",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			count, err := transactionRepo.UpdateArrears(ctx, time.Now(), grace)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				logger.Warn("flagged accounts in arrears", "count", count)
+			}
+			return nil
+		},
+	}
+}
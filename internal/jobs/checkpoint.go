@@ -0,0 +1,30 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/mrshabel/sgbank/internal/models"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// NewBalanceCheckpointJob creates a job that records balance checkpoints at the end of every closed UTC day. A day is closed once the posting grace period has
+// passed after midnight, so that any posting that started before midnight has committed before the day is checkpointed
+func NewBalanceCheckpointJob(transactionRepo *repository.TransactionRepository, interval time.Duration, logger *slog.Logger) Job {
+	return Job{
+		Name:     "balance-checkpoints",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			asOf := time.Now().UTC().Add(-models.PostingGrace).Truncate(24 * time.Hour)
+			count, err := transactionRepo.CreateBalanceCheckpoints(ctx, asOf)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				logger.Info("recorded balance checkpoints", "as_of", asOf, "count", count)
+			}
+			return nil
+		},
+	}
+}
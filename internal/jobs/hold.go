@@ -0,0 +1,27 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// NewHoldExpiryJob creates a job that marks active holds past their expiry as expired
+func NewHoldExpiryJob(holdRepo *repository.HoldRepository, interval time.Duration, logger *slog.Logger) Job {
+	return Job{
+		Name:     "hold-expiry",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			count, err := holdRepo.ExpireHolds(ctx, time.Now())
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				logger.Info("expired holds", "count", count)
+			}
+			return nil
+		},
+	}
+}
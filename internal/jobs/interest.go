@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// NewInterestJob creates a job that accrues interest for every day that has ended since the last accrual, then pays out every month that has ended. Days and months
+// are run at most once, so the job catches up after downtime without accruing twice
+func NewInterestJob(transactionRepo *repository.TransactionRepository, interval time.Duration, logger *slog.Logger) Job {
+	return Job{
+		Name:     "interest",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			now := time.Now().UTC()
+			yesterday := time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, time.UTC)
+
+			// accrue from the day after the last accrual, or from yesterday the first time
+			day := yesterday
+			last, err := transactionRepo.LastInterestAccrual(ctx)
+			if err == nil {
+				day = last.AddDate(0, 0, 1)
+			} else if err != sql.ErrNoRows {
+				return fmt.Errorf("retrieve last interest accrual: %w", err)
+			}
+
+			for ; !day.After(yesterday); day = day.AddDate(0, 0, 1) {
+				run, created, err := transactionRepo.AccrueInterest(ctx, day)
+				if err != nil {
+					return fmt.Errorf("accrue interest for %s: %w", day.Format(time.DateOnly), err)
+				}
+				if created && run.Accounts > 0 {
+					logger.Info("accrued interest", "day", day.Format(time.DateOnly), "accounts", run.Accounts)
+				}
+			}
+
+			months, err := transactionRepo.UnpaidInterestMonths(ctx, now)
+			if err != nil {
+				return fmt.Errorf("retrieve unpaid interest months: %w", err)
+			}
+			for _, month := range months {
+				run, created, err := transactionRepo.PayInterest(ctx, month)
+				if err != nil {
+					return fmt.Errorf("pay interest for %s: %w", month.Format("2006-01"), err)
+				}
+				if created {
+					logger.Info("paid interest", "month", month.Format("2006-01"), "accounts", run.Accounts)
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Job is a task run periodically in the background of the server
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Start runs every job on its own interval until the context is cancelled. Each job runs once immediately. Failures are logged and retried on the next tick
+func Start(ctx context.Context, logger *slog.Logger, jobs ...Job) {
+	for _, job := range jobs {
+		go run(ctx, logger, job)
+	}
+}
+
+func run(ctx context.Context, logger *slog.Logger, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := job.Run(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("job failed", "job", job.Name, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mrshabel/sgbank/internal/handlers"
+	"github.com/mrshabel/sgbank/internal/repository"
+)
+
+// NewStandingOrderJob creates a job that schedules the due occurrences of standing orders and posts them through the same path as one-off transfers. Failed occurrences
+// are retried after retryDelay until they have been attempted maxAttempts times
+func NewStandingOrderJob(standingOrderRepo *repository.StandingOrderRepository, transactionRepo *repository.TransactionRepository, transactionHandler *handlers.TransactionHandler, interval time.Duration, maxAttempts int, retryDelay time.Duration, logger *slog.Logger) Job {
+	// postNext posts the run that is due the longest. It reports false when no run is due
+	postNext := func(ctx context.Context) (bool, error) {
+		tx, err := transactionRepo.GetTx(ctx)
+		if err != nil {
+			return false, err
+		}
+		defer tx.Rollback()
+
+		run, err := standingOrderRepo.ClaimDueRun(ctx, tx, time.Now())
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return false, nil
+			}
+			return false, fmt.Errorf("claim due run: %w", err)
+		}
+		order, err := standingOrderRepo.GetStandingOrderByID(ctx, run.StandingOrderID)
+		if err != nil {
+			return false, fmt.Errorf("retrieve standing order %s: %w", run.StandingOrderID, err)
+		}
+
+		transfer := handlers.CreateTransactionRequest{
+			Reference: run.TransactionReference(),
+			Sender:    order.Sender,
+			Recipient: order.Recipient,
+			Amount:    order.Amount,
+			Convert:   order.Convert,
+		}
+		if order.Currency != nil {
+			transfer.Currency = *order.Currency
+		}
+
+		err = func() error {
+			transaction, err := transactionHandler.PostTransfer(ctx, tx, transfer)
+			if err != nil {
+				return err
+			}
+			if err := standingOrderRepo.CompleteRun(ctx, tx, run.ID, transaction.ID); err != nil {
+				return err
+			}
+			return tx.Commit()
+		}()
+		if err == nil {
+			logger.Info("posted standing order", "standing_order_id", order.ID, "occurrence", run.Occurrence.Format(time.DateOnly))
+			return true, nil
+		}
+
+		// release the claim before recording the failed attempt
+		tx.Rollback()
+		failed, failErr := standingOrderRepo.FailRun(ctx, run.ID, err.Error(), maxAttempts, time.Now().Add(retryDelay))
+		if failErr == sql.ErrNoRows {
+			// the order was cancelled in the meantime
+			return true, nil
+		}
+		if failErr != nil {
+			return false, fmt.Errorf("record failed run %s: %w", run.ID, failErr)
+		}
+		logger.Warn("standing order run failed", "standing_order_id", order.ID, "occurrence", run.Occurrence.Format(time.DateOnly), "attempts", failed.Attempts, "status", failed.Status, "error", err)
+		return true, nil
+	}
+
+	return Job{
+		Name:     "standing-orders",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			count, err := standingOrderRepo.ScheduleDueRuns(ctx, time.Now())
+			if err != nil {
+				return fmt.Errorf("schedule due runs: %w", err)
+			}
+			if count > 0 {
+				logger.Info("scheduled standing order runs", "count", count)
+			}
+
+			for ctx.Err() == nil {
+				posted, err := postNext(ctx)
+				if err != nil {
+					return err
+				}
+				if !posted {
+					break
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileMailer writes emails to a file or stdout in place of delivering them, for local development and tests
+type FileMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+// NewFileMailer creates a mailer that writes every email to w, one after another
+func NewFileMailer(w io.Writer, from string) *FileMailer {
+	return &FileMailer{w: w, from: from}
+}
+
+// Send writes the message followed by a separator line
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	body, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = io.WriteString(m.w, strings.ReplaceAll(string(body), "\r\n", "\n")+strings.Repeat("-", 72)+"\n")
+	return err
+}
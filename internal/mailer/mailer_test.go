@@ -0,0 +1,87 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	msg := Message{To: "Ada <ada@example.com>", Subject: "Vérifiez your email", Body: "Hello,\nyour token is abc.\r\n\nThanks"}
+
+	got, err := format("sgbank <no-reply@sgbank.test>", msg, now)
+	if err != nil {
+		t.Fatalf("format() error = %v", err)
+	}
+	want := "From: sgbank <no-reply@sgbank.test>\r\n" +
+		"To: Ada <ada@example.com>\r\n" +
+		"Subject: =?utf-8?q?V=C3=A9rifiez_your_email?=\r\n" +
+		"Date: Fri, 01 Mar 2024 12:00:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"Hello,\r\nyour token is abc.\r\n\r\nThanks\r\n"
+	if string(got) != want {
+		t.Errorf("format() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestFormatHeaderInjection(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		msg  Message
+	}{
+		{"to with a line feed", "no-reply@sgbank.test", Message{To: "ada@example.com\nBcc: eve@example.com", Subject: "Hello"}},
+		{"to with a carriage return", "no-reply@sgbank.test", Message{To: "ada@example.com\rBcc: eve@example.com", Subject: "Hello"}},
+		{"subject with crlf", "no-reply@sgbank.test", Message{To: "ada@example.com", Subject: "Hello\r\nBcc: eve@example.com"}},
+		{"subject ending the headers", "no-reply@sgbank.test", Message{To: "ada@example.com", Subject: "Hello\r\n\r\nforged body"}},
+		{"from with a line feed", "no-reply@sgbank.test\nBcc: eve@example.com", Message{To: "ada@example.com", Subject: "Hello"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := format(tt.from, tt.msg, time.Now()); !errors.Is(err, ErrInvalidHeader) {
+				t.Errorf("format() error = %v, want %v", err, ErrInvalidHeader)
+			}
+		})
+	}
+
+	// line breaks are only allowed in the body, where they cannot start a header
+	body, err := format("no-reply@sgbank.test", Message{To: "ada@example.com", Subject: "Hello", Body: "line\nBcc: eve@example.com"}, time.Now())
+	if err != nil {
+		t.Fatalf("format() error = %v", err)
+	}
+	headers, _, _ := strings.Cut(string(body), "\r\n\r\n")
+	if strings.Contains(headers, "Bcc") {
+		t.Errorf("body reached the headers:\n%s", headers)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewFileMailer(&buf, "no-reply@sgbank.test")
+
+	if err := m.Send(context.Background(), Message{To: "ada@example.com", Subject: "Hello", Body: "first"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := m.Send(context.Background(), Message{To: "bob@example.com", Subject: "Hello\nBcc: eve@example.com", Body: "second"}); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Send() error = %v, want %v", err, ErrInvalidHeader)
+	}
+
+	// the file is written with plain line feeds, one separated message per accepted send
+	out := buf.String()
+	if strings.Contains(out, "\r") {
+		t.Error("file contains carriage returns")
+	}
+	if !strings.HasPrefix(out, "From: no-reply@sgbank.test\nTo: ada@example.com\nSubject: Hello\n") || !strings.HasSuffix(out, "\nfirst\n"+strings.Repeat("-", 72)+"\n") {
+		t.Errorf("file =\n%s", out)
+	}
+	if strings.Contains(out, "bob@example.com") {
+		t.Error("a rejected message was written")
+	}
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency code
+type Currency string
+
+const (
+	USD Currency = "USD"
+	EUR Currency = "EUR"
+	GHS Currency = "GHS"
+	GBP Currency = "GBP"
+	NGN Currency = "NGN"
+	JPY Currency = "JPY"
+	KWD Currency = "KWD"
+
+	// DefaultCurrency is assigned to accounts created without a currency
+	DefaultCurrency = USD
+)
+
+// minorUnits holds the number of decimal places (ISO 4217 exponent) of every supported currency. All ledger amounts are stored in minor units
+var minorUnits = map[Currency]int{
+	USD: 2,
+	EUR: 2,
+	GHS: 2,
+	GBP: 2,
+	NGN: 2,
+	JPY: 0,
+	KWD: 3,
+}
+
+// Valid reports whether the currency is supported by the ledger
+func (c Currency) Valid() bool {
+	_, ok := minorUnits[c]
+	return ok
+}
+
+// MinorUnits returns the number of decimal places of the currency
+func (c Currency) MinorUnits() int {
+	return minorUnits[c]
+}
+
+// Format renders an amount in minor units as a decimal string using the precision of the currency. eg: 12345 USD => "123.45"
+func (c Currency) Format(amount int64) string {
+	sign := ""
+	value := uint64(amount)
+	if amount < 0 {
+		sign = "-"
+		value = uint64(-amount)
+	}
+
+	digits := strconv.FormatUint(value, 10)
+	exp := c.MinorUnits()
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// errors
+var (
+	ErrInvalidAmount = errors.New("amount must be a positive decimal within the precision of its currency")
+)
+
+// ParseAmount parses a decimal amount into the minor units of the currency. eg: "123.45" USD => 12345. The amount must be positive and may not be more precise
+// than the currency
+func (c Currency) ParseAmount(amount string) (uint64, error) {
+	whole, frac, _ := strings.Cut(amount, ".")
+	frac = strings.TrimRight(frac, "0")
+	exp := c.MinorUnits()
+	if whole == "" || len(frac) > exp || strings.Trim(whole+frac, "0123456789") != "" {
+		return 0, ErrInvalidAmount
+	}
+
+	value, err := strconv.ParseUint(whole+frac+strings.Repeat("0", exp-len(frac)), 10, 64)
+	if err != nil || value == 0 || value > math.MaxInt64 {
+		return 0, ErrInvalidAmount
+	}
+	return value, nil
+}
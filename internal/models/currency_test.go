@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCurrencyFormat(t *testing.T) {
+	tests := []struct {
+		currency Currency
+		amount   int64
+		want     string
+	}{
+		{USD, 12345, "123.45"},
+		{USD, 5, "0.05"},
+		{USD, 0, "0.00"},
+		{USD, -5, "-0.05"},
+		{USD, -12345, "-123.45"},
+		{JPY, 500, "500"},
+		{JPY, -500, "-500"},
+		{KWD, 1, "0.001"},
+		{KWD, 1234567, "1234.567"},
+	}
+	for _, tt := range tests {
+		if got := tt.currency.Format(tt.amount); got != tt.want {
+			t.Errorf("%s.Format(%d) = %q, want %q", tt.currency, tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestCurrencyParseAmount(t *testing.T) {
+	tests := []struct {
+		currency Currency
+		amount   string
+		want     uint64
+		err      error
+	}{
+		{USD, "123.45", 12345, nil},
+		{USD, "1", 100, nil},
+		{USD, "1.", 100, nil},
+		{USD, "0.10", 10, nil},
+		{USD, "1.230", 123, nil},
+		{USD, "92233720368547758.07", 9223372036854775807, nil},
+		{JPY, "500", 500, nil},
+		{KWD, "1.001", 1001, nil},
+		{USD, "1.234", 0, ErrInvalidAmount},
+		{USD, "", 0, ErrInvalidAmount},
+		{USD, ".5", 0, ErrInvalidAmount},
+		{USD, "-1", 0, ErrInvalidAmount},
+		{USD, "+1", 0, ErrInvalidAmount},
+		{USD, "0", 0, ErrInvalidAmount},
+		{USD, "0.00", 0, ErrInvalidAmount},
+		{USD, "1e3", 0, ErrInvalidAmount},
+		{USD, "1,000", 0, ErrInvalidAmount},
+		{USD, "92233720368547758.08", 0, ErrInvalidAmount},
+		{JPY, "500.5", 0, ErrInvalidAmount},
+	}
+	for _, tt := range tests {
+		got, err := tt.currency.ParseAmount(tt.amount)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("%s.ParseAmount(%q) = %d, %v, want %d, %v", tt.currency, tt.amount, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestCurrencyRoundTrip(t *testing.T) {
+	for _, currency := range []Currency{USD, JPY, KWD} {
+		for _, amount := range []int64{1, 99, 100, 123456789} {
+			got, err := currency.ParseAmount(currency.Format(amount))
+			if err != nil || got != uint64(amount) {
+				t.Errorf("%s: ParseAmount(Format(%d)) = %d, %v", currency, amount, got, err)
+			}
+		}
+	}
+}
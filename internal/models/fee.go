@@ -0,0 +1,185 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FeeRevenueAccount returns the account number of the system revenue account credited with the fees charged in the given currency
+func FeeRevenueAccount(currency Currency) string {
+	return "FEE-REV-" + string(currency)
+}
+
+// FeeKind is how a fee schedule prices a transfer
+type FeeKind string
+
+const (
+	// FLAT_FEE charges a fixed amount per transfer
+	FLAT_FEE FeeKind = "flat"
+	// PERCENTAGE_FEE charges a share of the transfer amount, kept within the minimum and maximum
+	PERCENTAGE_FEE FeeKind = "percentage"
+	// TIERED_FEE charges the flat amount and share of the tier the sender's volume for the month has reached
+	TIERED_FEE FeeKind = "tiered"
+)
+
+// errors
+var (
+	ErrInvalidFeeRate  = errors.New("fee rate must be a non-negative decimal")
+	ErrFeeAmountRange  = errors.New("fee amount is out of range")
+	ErrNoFeeTier       = errors.New("fee schedule has no tiers")
+	ErrFeeTierOrdering = errors.New("fee tiers must be in increasing order of minimum volume")
+)
+
+// FeeTier prices transfers once the sender's volume for the month reaches MinVolume, in minor units
+type FeeTier struct {
+	MinVolume uint64 `json:"min_volume"`
+	Flat      uint64 `json:"flat,omitempty"`
+	Rate      string `json:"rate,omitempty"`
+}
+
+// FeeSchedule is a rule charging a fee on transfers in its currency, in force from EffectiveFrom until EffectiveTo. A schedule scoped to a user or account type
+// only applies to senders that match it, and replaces the less specific schedules: a user scope outranks an account type scope, which outranks unscoped schedules
+type FeeSchedule struct {
+	ID          uuid.UUID    `json:"id"`
+	Name        string       `json:"name"`
+	Kind        FeeKind      `json:"kind"`
+	Currency    Currency     `json:"currency"`
+	AccountType *AccountType `json:"account_type,omitempty"`
+	UserID      *uuid.UUID   `json:"user_id,omitempty"`
+	FlatAmount  uint64       `json:"flat_amount,omitempty"`
+	Rate        *string      `json:"rate,omitempty"`
+	MinAmount   *uint64      `json:"min_amount,omitempty"`
+	MaxAmount   *uint64      `json:"max_amount,omitempty"`
+	Tiers       []FeeTier    `json:"tiers,omitempty"`
+	// Supersedes is the schedule this one replaced, if any
+	Supersedes    *uuid.UUID `json:"supersedes,omitempty"`
+	EffectiveFrom time.Time  `json:"effective_from"`
+	EffectiveTo   *time.Time `json:"effective_to,omitempty"`
+	CreatedAt     *time.Time `json:"created_at"`
+	UpdatedAt     *time.Time `json:"updated_at"`
+}
+
+// CreateFeeSchedule represents the fields required to create a new fee schedule
+type CreateFeeSchedule struct {
+	Name          string
+	Kind          FeeKind
+	Currency      Currency
+	AccountType   *AccountType
+	UserID        *uuid.UUID
+	FlatAmount    uint64
+	Rate          *string
+	MinAmount     *uint64
+	MaxAmount     *uint64
+	Tiers         []FeeTier
+	EffectiveFrom time.Time
+	EffectiveTo   *time.Time
+}
+
+// Specificity ranks how narrowly the schedule is scoped. Only the most specific schedules matching a sender apply
+func (s *FeeSchedule) Specificity() int {
+	specificity := 0
+	if s.UserID != nil {
+		specificity += 2
+	}
+	if s.AccountType != nil {
+		specificity++
+	}
+	return specificity
+}
+
+// ParseFeeRate parses a decimal fee rate, eg: "0.015" for 1.5%. The rate may be zero but not negative
+func ParseFeeRate(rate string) (*big.Rat, error) {
+	value, ok := new(big.Rat).SetString(rate)
+	if !ok || value.Sign() < 0 {
+		return nil, ErrInvalidFeeRate
+	}
+	return value, nil
+}
+
+// Fee prices a transfer of the given amount, given the sender's volume for the month before it. Shares are rounded half up to the nearest minor unit
+func (s *FeeSchedule) Fee(amount, monthlyVolume uint64) (uint64, error) {
+	flat, rate := s.FlatAmount, s.Rate
+	if s.Kind == TIERED_FEE {
+		tier, err := s.tier(monthlyVolume)
+		if err != nil {
+			return 0, err
+		}
+		if tier == nil {
+			return 0, nil
+		}
+		flat, rate = tier.Flat, &tier.Rate
+	}
+
+	fee := new(big.Int).SetUint64(flat)
+	if s.Kind != FLAT_FEE && rate != nil && *rate != "" {
+		value, err := ParseFeeRate(*rate)
+		if err != nil {
+			return 0, err
+		}
+		share := new(big.Rat).Mul(new(big.Rat).SetInt(new(big.Int).SetUint64(amount)), value)
+		share.Add(share, big.NewRat(1, 2))
+		fee.Add(fee, new(big.Int).Quo(share.Num(), share.Denom()))
+	}
+
+	if s.Kind != FLAT_FEE {
+		if s.MinAmount != nil && fee.Cmp(new(big.Int).SetUint64(*s.MinAmount)) < 0 {
+			fee.SetUint64(*s.MinAmount)
+		}
+		if s.MaxAmount != nil && fee.Cmp(new(big.Int).SetUint64(*s.MaxAmount)) > 0 {
+			fee.SetUint64(*s.MaxAmount)
+		}
+	}
+	if !fee.IsUint64() || fee.Uint64() > math.MaxInt64 {
+		return 0, ErrFeeAmountRange
+	}
+	return fee.Uint64(), nil
+}
+
+// tier returns the highest tier the monthly volume has reached, if any
+func (s *FeeSchedule) tier(monthlyVolume uint64) (*FeeTier, error) {
+	if len(s.Tiers) == 0 {
+		return nil, ErrNoFeeTier
+	}
+
+	var reached *FeeTier
+	for i := range s.Tiers {
+		if s.Tiers[i].MinVolume <= monthlyVolume {
+			reached = &s.Tiers[i]
+		}
+	}
+	return reached, nil
+}
+
+// ValidateFeeTiers ensures that every tier has a valid rate and that tiers are in strictly increasing order of minimum volume
+func ValidateFeeTiers(tiers []FeeTier) error {
+	if len(tiers) == 0 {
+		return ErrNoFeeTier
+	}
+	for i, tier := range tiers {
+		if i > 0 && tier.MinVolume <= tiers[i-1].MinVolume {
+			return ErrFeeTierOrdering
+		}
+		if tier.Rate != "" {
+			if _, err := ParseFeeRate(tier.Rate); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TransactionFee is a fee charged to the sender of a transfer under a fee schedule. It is posted in the same transaction as the transfer
+type TransactionFee struct {
+	ID            uuid.UUID  `json:"id"`
+	TransactionID uuid.UUID  `json:"transaction_id"`
+	AccountID     uuid.UUID  `json:"account_id"`
+	ScheduleID    uuid.UUID  `json:"schedule_id"`
+	Name          string     `json:"name"`
+	Amount        uint64     `json:"amount"`
+	Currency      Currency   `json:"currency"`
+	CreatedAt     *time.Time `json:"created_at"`
+}
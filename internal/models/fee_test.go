@@ -0,0 +1,79 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestFeeScheduleFee(t *testing.T) {
+	rate := func(r string) *string { return &r }
+	amount := func(a uint64) *uint64 { return &a }
+	tiered := func(min, max *uint64) FeeSchedule {
+		return FeeSchedule{Kind: TIERED_FEE, MinAmount: min, MaxAmount: max, Tiers: []FeeTier{
+			{MinVolume: 0, Flat: 50},
+			{MinVolume: 100000, Rate: "0.01"},
+			{MinVolume: 500000, Flat: 10, Rate: "0.005"},
+		}}
+	}
+
+	tests := []struct {
+		name          string
+		schedule      FeeSchedule
+		amount        uint64
+		monthlyVolume uint64
+		want          uint64
+		err           error
+	}{
+		{"flat", FeeSchedule{Kind: FLAT_FEE, FlatAmount: 50}, 10000, 0, 50, nil},
+		{"flat ignores minimum and maximum", FeeSchedule{Kind: FLAT_FEE, FlatAmount: 50, MinAmount: amount(100), MaxAmount: amount(100)}, 10000, 0, 50, nil},
+		{"percentage", FeeSchedule{Kind: PERCENTAGE_FEE, Rate: rate("0.015")}, 10000, 0, 150, nil},
+		{"percentage rounds half up", FeeSchedule{Kind: PERCENTAGE_FEE, Rate: rate("0.015")}, 100, 0, 2, nil},
+		{"percentage rounds down below half", FeeSchedule{Kind: PERCENTAGE_FEE, Rate: rate("0.015")}, 99, 0, 1, nil},
+		{"percentage rounds to nothing", FeeSchedule{Kind: PERCENTAGE_FEE, Rate: rate("0.015")}, 33, 0, 0, nil},
+		{"percentage with flat", FeeSchedule{Kind: PERCENTAGE_FEE, FlatAmount: 25, Rate: rate("0.01")}, 10000, 0, 125, nil},
+		{"percentage minimum", FeeSchedule{Kind: PERCENTAGE_FEE, Rate: rate("0.01"), MinAmount: amount(50), MaxAmount: amount(200)}, 1000, 0, 50, nil},
+		{"percentage maximum", FeeSchedule{Kind: PERCENTAGE_FEE, Rate: rate("0.01"), MinAmount: amount(50), MaxAmount: amount(200)}, 100000, 0, 200, nil},
+		{"percentage within bounds", FeeSchedule{Kind: PERCENTAGE_FEE, Rate: rate("0.01"), MinAmount: amount(50), MaxAmount: amount(200)}, 10000, 0, 100, nil},
+		{"first tier", tiered(nil, nil), 10000, 0, 50, nil},
+		{"below second tier", tiered(nil, nil), 10000, 99999, 50, nil},
+		{"second tier", tiered(nil, nil), 10000, 100000, 100, nil},
+		{"third tier", tiered(nil, nil), 10000, 600000, 60, nil},
+		{"tier minimum", tiered(amount(75), nil), 1000, 100000, 75, nil},
+		{"tier maximum", tiered(nil, amount(1000)), 1000000, 600000, 1000, nil},
+		{"no tier reached", FeeSchedule{Kind: TIERED_FEE, MinAmount: amount(75), Tiers: []FeeTier{{MinVolume: 1000, Flat: 50}}}, 10000, 0, 0, nil},
+		{"no tiers", FeeSchedule{Kind: TIERED_FEE}, 10000, 0, 0, ErrNoFeeTier},
+		{"invalid rate", FeeSchedule{Kind: PERCENTAGE_FEE, Rate: rate("-0.01")}, 10000, 0, 0, ErrInvalidFeeRate},
+		{"flat out of range", FeeSchedule{Kind: FLAT_FEE, FlatAmount: math.MaxUint64}, 10000, 0, 0, ErrFeeAmountRange},
+		{"percentage out of range", FeeSchedule{Kind: PERCENTAGE_FEE, Rate: rate("2")}, math.MaxInt64, 0, 0, ErrFeeAmountRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.schedule.Fee(tt.amount, tt.monthlyVolume)
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("Fee(%d, %d) = %d, %v, want %d, %v", tt.amount, tt.monthlyVolume, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateFeeTiers(t *testing.T) {
+	tests := []struct {
+		name  string
+		tiers []FeeTier
+		err   error
+	}{
+		{"increasing", []FeeTier{{MinVolume: 0, Flat: 50}, {MinVolume: 1000, Rate: "0.01"}}, nil},
+		{"none", nil, ErrNoFeeTier},
+		{"repeated minimum", []FeeTier{{MinVolume: 0, Flat: 50}, {MinVolume: 0, Rate: "0.01"}}, ErrFeeTierOrdering},
+		{"decreasing", []FeeTier{{MinVolume: 1000, Flat: 50}, {MinVolume: 0, Rate: "0.01"}}, ErrFeeTierOrdering},
+		{"invalid rate", []FeeTier{{MinVolume: 0, Rate: "one percent"}}, ErrInvalidFeeRate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateFeeTiers(tt.tiers); !errors.Is(err, tt.err) {
+				t.Errorf("ValidateFeeTiers() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
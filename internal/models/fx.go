@@ -0,0 +1,90 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FXPositionAccount returns the account number of the system account holding the bank's foreign-exchange position in the given currency
+func FXPositionAccount(currency Currency) string {
+	return "FX-" + string(currency)
+}
+
+// errors
+var (
+	ErrInvalidFXRate  = errors.New("fx rate must be a positive decimal")
+	ErrFXRateMismatch = errors.New("fx rate does not quote the given currency")
+	ErrFXAmountRange  = errors.New("converted amount is out of range")
+)
+
+// FXRate is the price of one unit of the base currency in the quote currency, effective from a point in time
+type FXRate struct {
+	ID            uuid.UUID  `json:"id"`
+	BaseCurrency  Currency   `json:"base_currency"`
+	QuoteCurrency Currency   `json:"quote_currency"`
+	Rate          string     `json:"rate"`
+	EffectiveAt   *time.Time `json:"effective_at"`
+	CreatedAt     *time.Time `json:"created_at"`
+}
+
+// CreateFXRate represents the fields required to load a new fx rate
+type CreateFXRate struct {
+	BaseCurrency  Currency
+	QuoteCurrency Currency
+	Rate          string
+	EffectiveAt   time.Time
+}
+
+// ParseFXRate parses a decimal rate string. The rate must be greater than zero
+func ParseFXRate(rate string) (*big.Rat, error) {
+	value, ok := new(big.Rat).SetString(rate)
+	if !ok || value.Sign() <= 0 {
+		return nil, ErrInvalidFXRate
+	}
+	return value, nil
+}
+
+// Convert converts an amount in the minor units of the given currency to the minor units of the other side of the rate. The rate may be applied in either direction. Fractions of a minor unit are truncated
+func (r *FXRate) Convert(amount uint64, from Currency) (uint64, error) {
+	rate, err := ParseFXRate(r.Rate)
+	if err != nil {
+		return 0, err
+	}
+
+	var to Currency
+	switch from {
+	case r.BaseCurrency:
+		to = r.QuoteCurrency
+	case r.QuoteCurrency:
+		to = r.BaseCurrency
+		rate.Inv(rate)
+	default:
+		return 0, ErrFXRateMismatch
+	}
+
+	// scale the rate by the difference in minor units of both currencies
+	value := new(big.Rat).Mul(new(big.Rat).SetInt(new(big.Int).SetUint64(amount)), rate)
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(to.MinorUnits()-from.MinorUnits()))), nil)
+	if to.MinorUnits() >= from.MinorUnits() {
+		value.Mul(value, new(big.Rat).SetInt(scale))
+	} else {
+		value.Quo(value, new(big.Rat).SetInt(scale))
+	}
+
+	converted := new(big.Int).Quo(value.Num(), value.Denom())
+	if !converted.IsUint64() || converted.Uint64() == 0 || converted.Uint64() > math.MaxInt64 {
+		return 0, ErrFXAmountRange
+	}
+	return converted.Uint64(), nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
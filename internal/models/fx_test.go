@@ -0,0 +1,40 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestFXRateConvert(t *testing.T) {
+	tests := []struct {
+		name   string
+		rate   FXRate
+		amount uint64
+		from   Currency
+		want   uint64
+		err    error
+	}{
+		{"base to quote", FXRate{BaseCurrency: EUR, QuoteCurrency: USD, Rate: "1.10"}, 100, EUR, 110, nil},
+		{"quote to base", FXRate{BaseCurrency: EUR, QuoteCurrency: USD, Rate: "1.10"}, 110, USD, 100, nil},
+		{"truncates base to quote", FXRate{BaseCurrency: EUR, QuoteCurrency: USD, Rate: "1.0999"}, 100, EUR, 109, nil},
+		{"truncates quote to base", FXRate{BaseCurrency: EUR, QuoteCurrency: USD, Rate: "1.10"}, 100, USD, 90, nil},
+		{"to fewer minor units", FXRate{BaseCurrency: USD, QuoteCurrency: JPY, Rate: "150.25"}, 100, USD, 150, nil},
+		{"from fewer minor units", FXRate{BaseCurrency: USD, QuoteCurrency: JPY, Rate: "150.25"}, 150, JPY, 99, nil},
+		{"to more minor units", FXRate{BaseCurrency: USD, QuoteCurrency: KWD, Rate: "0.307"}, 1000, USD, 3070, nil},
+		{"from more minor units", FXRate{BaseCurrency: USD, QuoteCurrency: KWD, Rate: "0.307"}, 3070, KWD, 1000, nil},
+		{"currency not quoted", FXRate{BaseCurrency: EUR, QuoteCurrency: USD, Rate: "1.10"}, 100, GBP, 0, ErrFXRateMismatch},
+		{"rounds to nothing", FXRate{BaseCurrency: USD, QuoteCurrency: JPY, Rate: "150.25"}, 1, JPY, 0, ErrFXAmountRange},
+		{"overflows", FXRate{BaseCurrency: EUR, QuoteCurrency: USD, Rate: "2"}, math.MaxInt64, EUR, 0, ErrFXAmountRange},
+		{"zero rate", FXRate{BaseCurrency: EUR, QuoteCurrency: USD, Rate: "0"}, 100, EUR, 0, ErrInvalidFXRate},
+		{"malformed rate", FXRate{BaseCurrency: EUR, QuoteCurrency: USD, Rate: "abc"}, 100, EUR, 0, ErrInvalidFXRate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rate.Convert(tt.amount, tt.from)
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("Convert(%d, %s) = %d, %v, want %d, %v", tt.amount, tt.from, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
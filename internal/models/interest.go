@@ -0,0 +1,147 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DayCount is the convention used to turn a number of days into a fraction of a year when accruing interest
+type DayCount string
+
+const (
+	// ACT365 counts the actual days elapsed over a fixed 365-day year
+	ACT365 DayCount = "ACT/365"
+	// THIRTY360 counts every month as 30 days over a 360-day year. Month-end days accrue nothing or catch up so that each month accrues 30 days
+	THIRTY360 DayCount = "30/360"
+)
+
+// Valid reports whether the day count convention is supported
+func (d DayCount) Valid() bool {
+	return d == ACT365 || d == THIRTY360
+}
+
+// Days returns the number of days between the two dates under the convention
+func (d DayCount) Days(from, to time.Time) int {
+	from, to = date(from), date(to)
+	if d == ACT365 {
+		return int(to.Sub(from).Hours() / 24)
+	}
+
+	// 30/360 bond basis
+	d1, d2 := from.Day(), to.Day()
+	if d1 == 31 {
+		d1 = 30
+	}
+	if d2 == 31 && d1 == 30 {
+		d2 = 30
+	}
+	return 360*(to.Year()-from.Year()) + 30*(int(to.Month())-int(from.Month())) + d2 - d1
+}
+
+// Basis returns the number of days in a year under the convention
+func (d DayCount) Basis() int {
+	if d == ACT365 {
+		return 365
+	}
+	return 360
+}
+
+// InterestPayableAccount returns the account number of the system liability account holding the interest accrued but not yet paid out in the given currency
+func InterestPayableAccount(currency Currency) string {
+	return "INT-PAY-" + string(currency)
+}
+
+// InterestExpenseAccount returns the account number of the system expense account charged with the interest accrued in the given currency
+func InterestExpenseAccount(currency Currency) string {
+	return "INT-EXP-" + string(currency)
+}
+
+// errors
+var (
+	ErrInvalidInterestRate = errors.New("interest rate must be a non-negative decimal")
+)
+
+// InterestProduct is an interest-bearing product accounts are enrolled in. AnnualRate is a decimal fraction, eg: "0.035" for 3.5% a year
+type InterestProduct struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	AnnualRate string     `json:"annual_rate"`
+	DayCount   DayCount   `json:"day_count"`
+	CreatedAt  *time.Time `json:"created_at"`
+}
+
+// CreateInterestProduct represents the fields required to create a new interest product
+type CreateInterestProduct struct {
+	Name       string
+	AnnualRate string
+	DayCount   DayCount
+}
+
+// ParseInterestRate parses a decimal annual rate. The rate may be zero but not negative
+func ParseInterestRate(rate string) (*big.Rat, error) {
+	value, ok := new(big.Rat).SetString(rate)
+	if !ok || value.Sign() < 0 {
+		return nil, ErrInvalidInterestRate
+	}
+	return value, nil
+}
+
+// DailyInterest returns the exact interest earned by a balance in minor units over the given day, before any rounding
+func (p *InterestProduct) DailyInterest(balance int64, day time.Time) (*big.Rat, int, error) {
+	rate, err := ParseInterestRate(p.AnnualRate)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	days := p.DayCount.Days(day, date(day).AddDate(0, 0, 1))
+	interest := new(big.Rat).Mul(new(big.Rat).SetInt64(balance), rate)
+	interest.Mul(interest, big.NewRat(int64(days), int64(p.DayCount.Basis())))
+	return interest, days, nil
+}
+
+// AccountInterest is the enrolment of an account in an interest product. Interest accrues from the day the account was enrolled
+type AccountInterest struct {
+	AccountID  uuid.UUID        `json:"account_id"`
+	Product    *InterestProduct `json:"product"`
+	EnrolledOn time.Time        `json:"enrolled_on"`
+	UpdatedAt  *time.Time       `json:"updated_at"`
+}
+
+// InterestAccrual is the interest an account earned on its closing balance of a day. Amount is exact to 12 decimal places of a minor unit, while Posted is the
+// whole number of minor units recognised on the ledger for the day. Within a month, the posted amounts always add up to the exact total rounded down
+type InterestAccrual struct {
+	AccountID     uuid.UUID  `json:"account_id"`
+	Currency      Currency   `json:"currency"`
+	AccrualDate   time.Time  `json:"accrual_date"`
+	ProductID     uuid.UUID  `json:"product_id"`
+	Balance       int64      `json:"balance"`
+	AnnualRate    string     `json:"annual_rate"`
+	DayCount      DayCount   `json:"day_count"`
+	Days          int        `json:"days"`
+	Amount        string     `json:"amount"`
+	Posted        int64      `json:"posted"`
+	TransactionID *uuid.UUID `json:"transaction_id,omitempty"`
+	CreatedAt     *time.Time `json:"created_at"`
+}
+
+// InterestRunKind is the kind of an interest run
+type InterestRunKind string
+
+const (
+	// INTEREST_ACCRUAL runs accrue a single day. Their period is the day accrued
+	INTEREST_ACCRUAL InterestRunKind = "accrual"
+	// INTEREST_PAYOUT runs pay out a month of accrued interest. Their period is the first day of the month
+	INTEREST_PAYOUT InterestRunKind = "payout"
+)
+
+// InterestRun records that a period has been accrued or paid out. Each period is run at most once, so re-running it is a no-op
+type InterestRun struct {
+	Kind          InterestRunKind `json:"kind"`
+	Period        time.Time       `json:"period"`
+	Accounts      int             `json:"accounts"`
+	TransactionID *uuid.UUID      `json:"transaction_id,omitempty"`
+	CreatedAt     *time.Time      `json:"created_at"`
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestDayCountDays(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		dayCount DayCount
+		from, to time.Time
+		want     int
+	}{
+		{"act/365 leap year", ACT365, day(2024, 1, 1), day(2024, 12, 31), 365},
+		{"act/365 over leap day", ACT365, day(2024, 2, 28), day(2024, 3, 1), 2},
+		{"act/365 without leap day", ACT365, day(2023, 2, 28), day(2023, 3, 1), 1},
+		{"act/365 ignores time of day", ACT365, time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 1, 0, 0, 0, time.UTC), 1},
+		{"30/360 a year", THIRTY360, day(2024, 1, 15), day(2025, 1, 15), 360},
+		{"30/360 a day", THIRTY360, day(2024, 1, 15), day(2024, 1, 16), 1},
+		{"30/360 the 31st accrues nothing", THIRTY360, day(2024, 1, 30), day(2024, 1, 31), 0},
+		{"30/360 from the 31st", THIRTY360, day(2024, 1, 31), day(2024, 2, 1), 1},
+		{"30/360 end of february catches up", THIRTY360, day(2024, 2, 28), day(2024, 3, 1), 3},
+		{"30/360 end of leap february catches up", THIRTY360, day(2024, 2, 29), day(2024, 3, 1), 2},
+		{"30/360 month end to month end", THIRTY360, day(2024, 3, 31), day(2024, 4, 30), 30},
+		{"30/360 to the 31st", THIRTY360, day(2024, 3, 15), day(2024, 3, 31), 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dayCount.Days(tt.from, tt.to); got != tt.want {
+				t.Errorf("Days(%s, %s) = %d, want %d", tt.from.Format(time.DateOnly), tt.to.Format(time.DateOnly), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterestProductDailyInterest(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		product  InterestProduct
+		balance  int64
+		day      time.Time
+		want     *big.Rat
+		wantDays int
+		err      error
+	}{
+		{"act/365", InterestProduct{AnnualRate: "0.0365", DayCount: ACT365}, 100000, day(2024, 1, 15), big.NewRat(10, 1), 1, nil},
+		{"act/365 fraction of a minor unit", InterestProduct{AnnualRate: "0.05", DayCount: ACT365}, 1, day(2024, 1, 15), big.NewRat(1, 7300), 1, nil},
+		{"act/365 negative balance", InterestProduct{AnnualRate: "0.0365", DayCount: ACT365}, -100000, day(2024, 1, 15), big.NewRat(-10, 1), 1, nil},
+		{"zero rate", InterestProduct{AnnualRate: "0", DayCount: ACT365}, 100000, day(2024, 1, 15), new(big.Rat), 1, nil},
+		{"30/360", InterestProduct{AnnualRate: "0.036", DayCount: THIRTY360}, 100000, day(2024, 1, 15), big.NewRat(10, 1), 1, nil},
+		{"30/360 on the 31st", InterestProduct{AnnualRate: "0.036", DayCount: THIRTY360}, 100000, day(2024, 1, 31), big.NewRat(10, 1), 1, nil},
+		{"30/360 on the 30th of a long month", InterestProduct{AnnualRate: "0.036", DayCount: THIRTY360}, 100000, day(2024, 1, 30), new(big.Rat), 0, nil},
+		{"30/360 end of february", InterestProduct{AnnualRate: "0.036", DayCount: THIRTY360}, 100000, day(2023, 2, 28), big.NewRat(30, 1), 3, nil},
+		{"negative rate", InterestProduct{AnnualRate: "-0.01", DayCount: ACT365}, 100000, day(2024, 1, 15), nil, 0, ErrInvalidInterestRate},
+		{"malformed rate", InterestProduct{AnnualRate: "3.5%", DayCount: ACT365}, 100000, day(2024, 1, 15), nil, 0, ErrInvalidInterestRate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, days, err := tt.product.DailyInterest(tt.balance, tt.day)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("DailyInterest() error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if got.Cmp(tt.want) != 0 || days != tt.wantDays {
+				t.Errorf("DailyInterest(%d, %s) = %s, %d, want %s, %d", tt.balance, tt.day.Format(time.DateOnly), got.RatString(), days, tt.want.RatString(), tt.wantDays)
+			}
+		})
+	}
+}
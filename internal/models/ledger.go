@@ -0,0 +1,116 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GenesisHash is the previous hash of the first entry in the ledger chain
+const GenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// LedgerFaultKind describes how the ledger chain was tampered with
+type LedgerFaultKind string
+
+const (
+	// LedgerEntryAltered is a transaction or line whose content no longer matches its recorded hash
+	LedgerEntryAltered LedgerFaultKind = "altered"
+	// LedgerEntryInserted is a transaction that was written outside of the chain
+	LedgerEntryInserted LedgerFaultKind = "inserted"
+	// LedgerEntryRemoved is a chained transaction or chain entry that no longer exists
+	LedgerEntryRemoved LedgerFaultKind = "removed"
+)
+
+// LedgerEntry links a transaction into the ledger chain. Its hash covers the transaction, all its lines and the hash of the entry before it
+type LedgerEntry struct {
+	Sequence      int64      `json:"sequence"`
+	TransactionID uuid.UUID  `json:"transaction_id"`
+	PrevHash      string     `json:"prev_hash"`
+	Hash          string     `json:"hash"`
+	CreatedAt     *time.Time `json:"created_at"`
+}
+
+// LedgerFault is the first point at which the ledger chain stops matching the ledger
+type LedgerFault struct {
+	Kind          LedgerFaultKind `json:"kind"`
+	Sequence      int64           `json:"sequence,omitempty"`
+	TransactionID *uuid.UUID      `json:"transaction_id,omitempty"`
+	Reason        string          `json:"reason"`
+}
+
+// LedgerVerification is the result of walking the ledger chain. HeadHash can be recorded outside the database to also detect removal of the most recent entries
+type LedgerVerification struct {
+	Valid        bool         `json:"valid"`
+	Entries      int64        `json:"entries"`
+	HeadSequence int64        `json:"head_sequence"`
+	HeadHash     string       `json:"head_hash"`
+	Fault        *LedgerFault `json:"fault,omitempty"`
+	VerifiedAt   time.Time    `json:"verified_at"`
+}
+
+// chainedLine and chainedTransaction are the canonical encoding of a transaction that is hashed into the ledger chain
+type chainedLine struct {
+	ID        string             `json:"id"`
+	AccountID string             `json:"account_id"`
+	Purpose   TransactionPurpose `json:"purpose"`
+	Amount    uint64             `json:"amount"`
+	Currency  Currency           `json:"currency"`
+	CreatedAt string             `json:"created_at"`
+}
+
+type chainedTransaction struct {
+	Sequence   int64         `json:"sequence"`
+	PrevHash   string        `json:"prev_hash"`
+	ID         string        `json:"id"`
+	Reference  string        `json:"reference"`
+	FXRateID   *uuid.UUID    `json:"fx_rate_id"`
+	ReversalOf *uuid.UUID    `json:"reversal_of"`
+	CreatedAt  string        `json:"created_at"`
+	Lines      []chainedLine `json:"lines"`
+}
+
+// ChainHash computes the hex encoded SHA-256 hash of the transaction and its lines at the given position of the ledger chain. Lines are hashed in id order
+// and timestamps in UTC, so the hash does not depend on how the transaction was read
+func (t *Transaction) ChainHash(sequence int64, prevHash string) (string, error) {
+	entry := chainedTransaction{
+		Sequence:   sequence,
+		PrevHash:   prevHash,
+		ID:         t.ID.String(),
+		Reference:  t.Reference,
+		FXRateID:   t.FXRateID,
+		ReversalOf: t.ReversalOf,
+		CreatedAt:  chainTime(t.CreatedAt),
+		Lines:      make([]chainedLine, 0, len(t.Lines)),
+	}
+	for _, line := range t.Lines {
+		entry.Lines = append(entry.Lines, chainedLine{
+			ID:        line.ID,
+			AccountID: line.AccountID,
+			Purpose:   line.Purpose,
+			Amount:    line.Amount,
+			Currency:  line.Currency,
+			CreatedAt: chainTime(line.CreatedAt),
+		})
+	}
+	sort.Slice(entry.Lines, func(i, j int) bool {
+		return entry.Lines[i].ID < entry.Lines[j].ID
+	})
+
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func chainTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestTransactionChainHash(t *testing.T) {
+	// the canonical encoding of this transaction hashes to golden. Changing the encoding breaks every chain already recorded
+	const golden = "54e028731b85bfc89e77ef8cb978ebaa72414c6ec6544146d535ffc6e1a00dd5"
+
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	transaction := func() *Transaction {
+		created := time.Date(2024, 3, 1, 14, 0, 0, 0, zone)
+		posted := time.Date(2024, 3, 1, 12, 0, 0, 500_000_000, time.UTC)
+		// lines are read out of id order
+		return &Transaction{
+			ID:        uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+			Reference: "ref-1",
+			CreatedAt: &created,
+			Lines: []TransactionLine{
+				{ID: "aaaaaaaa-0000-0000-0000-000000000002", AccountID: "33333333-3333-3333-3333-333333333333", Purpose: CREDIT, Amount: 1500, Currency: USD, CreatedAt: &posted},
+				{ID: "aaaaaaaa-0000-0000-0000-000000000001", AccountID: "22222222-2222-2222-2222-222222222222", Purpose: DEBIT, Amount: 1500, Currency: USD, CreatedAt: &posted},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		change   func(tx *Transaction, sequence *int64, prevHash *string)
+		matching bool
+	}{
+		{"canonical", func(*Transaction, *int64, *string) {}, true},
+		{"lines in id order", func(tx *Transaction, _ *int64, _ *string) {
+			tx.Lines[0], tx.Lines[1] = tx.Lines[1], tx.Lines[0]
+		}, true},
+		{"timestamps in utc", func(tx *Transaction, _ *int64, _ *string) {
+			created := tx.CreatedAt.UTC()
+			tx.CreatedAt = &created
+		}, true},
+		{"sequence", func(_ *Transaction, sequence *int64, _ *string) { *sequence = 8 }, false},
+		{"previous hash", func(_ *Transaction, _ *int64, prevHash *string) { *prevHash = golden }, false},
+		{"reference", func(tx *Transaction, _ *int64, _ *string) { tx.Reference = "ref-2" }, false},
+		{"line amount", func(tx *Transaction, _ *int64, _ *string) { tx.Lines[0].Amount = 1501 }, false},
+		{"line account", func(tx *Transaction, _ *int64, _ *string) { tx.Lines[1].AccountID = tx.Lines[0].AccountID }, false},
+		{"line purpose", func(tx *Transaction, _ *int64, _ *string) { tx.Lines[0].Purpose = DEBIT }, false},
+		{"line currency", func(tx *Transaction, _ *int64, _ *string) { tx.Lines[0].Currency = EUR }, false},
+		{"line removed", func(tx *Transaction, _ *int64, _ *string) { tx.Lines = tx.Lines[:1] }, false},
+		{"reversal", func(tx *Transaction, _ *int64, _ *string) {
+			id := uuid.MustParse("44444444-4444-4444-4444-444444444444")
+			tx.ReversalOf = &id
+		}, false},
+		{"creation time", func(tx *Transaction, _ *int64, _ *string) {
+			created := tx.CreatedAt.Add(time.Nanosecond)
+			tx.CreatedAt = &created
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, sequence, prevHash := transaction(), int64(7), GenesisHash
+			tt.change(tx, &sequence, &prevHash)
+
+			got, err := tx.ChainHash(sequence, prevHash)
+			if err != nil {
+				t.Fatalf("ChainHash() error = %v", err)
+			}
+			if (got == golden) != tt.matching {
+				t.Errorf("ChainHash() = %s, golden %s, want matching %t", got, golden, tt.matching)
+			}
+		})
+	}
+}
@@ -14,35 +14,419 @@ const (
 
 // user models
 
+// Role decides what a user may see and do. New users are customers
+type Role string
+
+const (
+	CUSTOMER Role = "customer"
+	AUDITOR  Role = "auditor"
+	OPERATOR Role = "operator"
+)
+
 // User represents a user entity in the application
 type User struct {
-	ID        uuid.UUID  `json:"id"`
-	Email     string     `json:"email"`
-	CreatedAt *time.Time `json:"created_at"`
-	UpdatedAt *time.Time `json:"updated_at"`
+	ID    uuid.UUID `json:"id"`
+	Email string    `json:"email"`
+	Role  Role      `json:"role"`
+	// EmailVerifiedAt is set once the user proves they own their email. Unverified users may not open accounts
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       *time.Time `json:"created_at"`
+	UpdatedAt       *time.Time `json:"updated_at"`
 }
 
 // CreateUser represents the fields required to create a new user
 type CreateUser struct {
 	Email string
+	// PasswordHash is stored as the password credential of the user when it is set
+	PasswordHash string
+}
+
+// access models
+
+// AccessDenial records a request refused for the role of its caller
+type AccessDenial struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	Role   Role      `json:"role"`
+	Method string    `json:"method"`
+	// Route is the registered route pattern and Path the path that was requested
+	Route      string     `json:"route"`
+	Path       string     `json:"path"`
+	Permission string     `json:"permission"`
+	Reason     string     `json:"reason"`
+	IPAddress  string     `json:"ip_address"`
+	CreatedAt  *time.Time `json:"created_at"`
+}
+
+// CreateAccessDenial represents the fields required to record a denied request
+type CreateAccessDenial struct {
+	UserID     uuid.UUID
+	Role       Role
+	Method     string
+	Route      string
+	Path       string
+	Permission string
+	Reason     string
+	IPAddress  string
+}
+
+// audit models
+
+// AuditEntry records a state-changing api call. Request and response bodies are kept only as hex HMAC-SHA256 digests keyed with a server secret, so the entry
+// can prove what was sent without holding secrets such as passwords and tokens, and without letting readers of the log guess them back
+type AuditEntry struct {
+	ID int64 `json:"id"`
+	// ActorID is the user who made the call, or the user a public call named, such as the user logging in. It is empty for anonymous calls that named no known user
+	ActorID *uuid.UUID `json:"actor_id"`
+	// ActorRole is the role of an authenticated caller, and empty for calls made without valid credentials
+	ActorRole    *Role  `json:"actor_role"`
+	AuthMethod   string `json:"auth_method"`
+	CredentialID string `json:"credential_id"`
+	Method       string `json:"method"`
+	Route        string `json:"route"`
+	Path         string `json:"path"`
+	// EntityType is the kind of record acted on, named after the first segment of the route, and EntityID its id when known
+	EntityType     string     `json:"entity_type"`
+	EntityID       string     `json:"entity_id"`
+	Status         int        `json:"status"`
+	IPAddress      string     `json:"ip_address"`
+	UserAgent      string     `json:"user_agent"`
+	RequestDigest  string     `json:"request_digest"`
+	ResponseDigest string     `json:"response_digest"`
+	CreatedAt      *time.Time `json:"created_at"`
+}
+
+// CreateAuditEntry represents the fields required to record an api call
+type CreateAuditEntry struct {
+	ActorID        *uuid.UUID
+	ActorRole      *Role
+	AuthMethod     string
+	CredentialID   string
+	Method         string
+	Route          string
+	Path           string
+	EntityType     string
+	EntityID       string
+	Status         int
+	IPAddress      string
+	UserAgent      string
+	RequestDigest  string
+	ResponseDigest string
+}
+
+// AuditFilter narrows down the audit entries retrieved. Zero fields do not filter. Entries are returned newest first, before the Before id when it is set
+type AuditFilter struct {
+	ActorID    *uuid.UUID
+	EntityType string
+	EntityID   string
+	From       *time.Time
+	To         *time.Time
+	Before     int64
+	Limit      int
+}
+
+// api key models
+
+// APIKey is a credential services authenticate with. Only the hash of the key is stored, so the key itself is shown once, when it is issued
+type APIKey struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+	// Prefix is the start of the key, kept to tell keys apart
+	Prefix string `json:"prefix"`
+	// RotatedFrom is the key this one replaced
+	RotatedFrom *uuid.UUID `json:"rotated_from,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedAt   *time.Time `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+}
+
+// Active reports whether the key can still authenticate at the given time
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// CreateAPIKey represents the fields required to store a new API key
+type CreateAPIKey struct {
+	UserID    uuid.UUID
+	Name      string
+	Prefix    string
+	KeyHash   string
+	ExpiresAt *time.Time
+}
+
+// credential models
+
+// Credentials are the login credentials of a user. They are never returned to clients
+type Credentials struct {
+	UserID       uuid.UUID
+	PasswordHash string
+	// TOTPSecret is set when an enrolment starts, and TOTPEnabledAt once a code from the authenticator confirms it
+	TOTPSecret    string
+	TOTPEnabledAt *time.Time
+	// TOTPLastStep is the time step of the last accepted code, so that a code cannot be replayed
+	TOTPLastStep int64
+	// FailedLogins counts the failed logins since the last successful one. The user is locked out until LockedUntil once they reach the limit
+	FailedLogins int
+	LockedUntil  *time.Time
+}
+
+// TOTPEnabled reports whether logins need a TOTP code or recovery code as a second factor
+func (c *Credentials) TOTPEnabled() bool {
+	return c.TOTPEnabledAt != nil
+}
+
+// Locked reports whether logins are refused at the given time after too many failures
+func (c *Credentials) Locked(now time.Time) bool {
+	return c.LockedUntil != nil && now.Before(*c.LockedUntil)
+}
+
+// Session is a login of a user. The bearer token issued at login is only accepted while its session is neither revoked nor expired
+type Session struct {
+	ID        uuid.UUID  `json:"id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	IPAddress string     `json:"ip_address"`
+	UserAgent string     `json:"user_agent"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+// CreateSession represents the fields required to start a new session
+type CreateSession struct {
+	UserID    uuid.UUID
+	IPAddress string
+	UserAgent string
+	ExpiresAt time.Time
+}
+
+// user token models
+
+// TokenPurpose is what a one-time token sent to a user by email is for
+type TokenPurpose string
+
+const (
+	EMAIL_VERIFICATION TokenPurpose = "email_verification"
+	PASSWORD_RESET     TokenPurpose = "password_reset"
+)
+
+// UserToken is a single use token emailed to a user. Only the hash of the token is stored
+type UserToken struct {
+	ID        uuid.UUID    `json:"id"`
+	UserID    uuid.UUID    `json:"user_id"`
+	Purpose   TokenPurpose `json:"purpose"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	UsedAt    *time.Time   `json:"used_at"`
+	CreatedAt *time.Time   `json:"created_at"`
+}
+
+// CreateUserToken represents the fields required to store a new user token
+type CreateUserToken struct {
+	UserID    uuid.UUID
+	Purpose   TokenPurpose
+	TokenHash string
+	ExpiresAt time.Time
 }
 
 // account models
 
+// AccountClass is the classification of an account in the chart of accounts
+type AccountClass string
+
+const (
+	ASSET     AccountClass = "asset"
+	LIABILITY AccountClass = "liability"
+	EQUITY    AccountClass = "equity"
+	REVENUE   AccountClass = "revenue"
+	EXPENSE   AccountClass = "expense"
+)
+
+// Valid reports whether the class is part of the chart of accounts
+func (c AccountClass) Valid() bool {
+	switch c {
+	case ASSET, LIABILITY, EQUITY, REVENUE, EXPENSE:
+		return true
+	}
+	return false
+}
+
+// NormalSide returns the side on which balances of the class increase. Assets and expenses are debit-normal, all other classes are credit-normal
+func (c AccountClass) NormalSide() TransactionPurpose {
+	switch c {
+	case ASSET, EXPENSE:
+		return DEBIT
+	}
+	return CREDIT
+}
+
+// Balance computes the signed balance on the normal side of the class from the total credits and debits of an account
+func (c AccountClass) Balance(credits, debits int64) int64 {
+	if c.NormalSide() == DEBIT {
+		return debits - credits
+	}
+	return credits - debits
+}
+
+// Effect returns the signed change that a line of the given purpose and amount has on the normal balance of the class
+func (c AccountClass) Effect(purpose TransactionPurpose, amount uint64) int64 {
+	if purpose == c.NormalSide() {
+		return int64(amount)
+	}
+	return -int64(amount)
+}
+
+// AccountType distinguishes ordinary deposit accounts from credit lines
+type AccountType string
+
+const (
+	// DEPOSIT accounts may only go negative within an approved overdraft limit
+	DEPOSIT AccountType = "deposit"
+	// CREDIT_LINE accounts are drawn below zero up to their credit limit
+	CREDIT_LINE AccountType = "credit_line"
+)
+
 // Account represents an account entity in the application
 type Account struct {
-	ID            uuid.UUID  `json:"id"`
-	AccountNumber string     `json:"account_number"`
-	UserID        string     `json:"user_id"`
-	CreatedAt     *time.Time `json:"created_at"`
-	UpdatedAt     *time.Time `json:"updated_at"`
-	DeletedAt     *time.Time `json:"deleted_at"`
+	ID            uuid.UUID    `json:"id"`
+	AccountNumber string       `json:"account_number"`
+	UserID        string       `json:"user_id"`
+	Class         AccountClass `json:"class"`
+	Currency      Currency     `json:"currency"`
+	Type          AccountType  `json:"type"`
+	// OverdraftLimit applies to deposit accounts and CreditLimit to credit lines, both in minor units
+	OverdraftLimit uint64     `json:"overdraft_limit"`
+	CreditLimit    uint64     `json:"credit_limit"`
+	CreatedAt      *time.Time `json:"created_at"`
+	UpdatedAt      *time.Time `json:"updated_at"`
+	DeletedAt      *time.Time `json:"deleted_at"`
+}
+
+// Limit returns how far below zero the balance of the account may go: the credit limit of a credit line or the overdraft limit of any other account
+func (a *Account) Limit() uint64 {
+	if a.Type == CREDIT_LINE {
+		return a.CreditLimit
+	}
+	return a.OverdraftLimit
+}
+
+// IsSystem reports whether the account belongs to the bank itself rather than a customer
+func (a *Account) IsSystem() bool {
+	return a.UserID == SystemUserID
+}
+
+// Accepts reports whether lines in the given currency may be posted to the account. Customer accounts only hold their own currency while system accounts may settle any supported currency
+func (a *Account) Accepts(currency Currency) bool {
+	if a.IsSystem() {
+		return currency.Valid()
+	}
+	return currency == a.Currency
 }
 
 // CreateAccount represents the fields required to create a new account
 type CreateAccount struct {
 	AccountNumber string
 	UserID        string
+	Class         AccountClass
+	Currency      Currency
+	Type          AccountType
+}
+
+// LimitKind is the kind of limit changed on an account
+type LimitKind string
+
+const (
+	OVERDRAFT_LIMIT LimitKind = "overdraft"
+	CREDIT_LIMIT    LimitKind = "credit"
+)
+
+// LimitChange is an approved change to the overdraft or credit limit of an account. Changes are only ever appended and form the account's approval audit trail
+type LimitChange struct {
+	ID            uuid.UUID  `json:"id"`
+	AccountID     uuid.UUID  `json:"account_id"`
+	Kind          LimitKind  `json:"kind"`
+	PreviousLimit uint64     `json:"previous_limit"`
+	NewLimit      uint64     `json:"new_limit"`
+	ApprovedBy    uuid.UUID  `json:"approved_by"`
+	Reason        string     `json:"reason"`
+	CreatedAt     *time.Time `json:"created_at"`
+}
+
+// SetAccountLimit represents the fields required to change the limit of an account
+type SetAccountLimit struct {
+	AccountID  uuid.UUID
+	Limit      uint64
+	ApprovedBy uuid.UUID
+	Reason     string
+}
+
+// ArrearsReason explains why an account was flagged as in arrears
+type ArrearsReason string
+
+const (
+	// ARREARS_OVER_LIMIT is an account whose balance is below its overdraft or credit limit
+	ARREARS_OVER_LIMIT ArrearsReason = "over_limit"
+	// ARREARS_OVERDRAWN is a deposit account that stayed overdrawn for longer than the grace period
+	ARREARS_OVERDRAWN ArrearsReason = "overdrawn"
+)
+
+// AccountArrears tracks a customer account with a negative balance. It is flagged once the account is over its limit or, for deposit accounts, overdrawn for
+// longer than the grace period, and cleared when the balance is back to zero or above
+type AccountArrears struct {
+	AccountID     uuid.UUID      `json:"account_id"`
+	AccountNumber string         `json:"account_number"`
+	Type          AccountType    `json:"type"`
+	Currency      Currency       `json:"currency"`
+	Balance       int64          `json:"balance"`
+	Limit         uint64         `json:"limit"`
+	NegativeSince time.Time      `json:"negative_since"`
+	Reason        *ArrearsReason `json:"reason,omitempty"`
+	FlaggedAt     *time.Time     `json:"flagged_at,omitempty"`
+}
+
+// AccountBalance holds the ledger totals of an account in a single currency and its balance on the normal side of its class
+type AccountBalance struct {
+	AccountID        uuid.UUID          `json:"account_id"`
+	AccountNumber    string             `json:"account_number"`
+	Class            AccountClass       `json:"class"`
+	NormalSide       TransactionPurpose `json:"normal_side"`
+	Currency         Currency           `json:"currency"`
+	Debits           int64              `json:"debits"`
+	Credits          int64              `json:"credits"`
+	Balance          int64              `json:"balance"`
+	FormattedBalance string             `json:"formatted_balance"`
+	// Version is bumped on every posting to the balance
+	Version int64 `json:"version"`
+	// AsOf is set when the balance is historical rather than current
+	AsOf *time.Time `json:"as_of,omitempty"`
+	// Held and Available are set for current balances. Available is the balance less the amount reserved by active holds, plus any overdraft or credit limit
+	Held      *int64 `json:"held,omitempty"`
+	Available *int64 `json:"available,omitempty"`
+}
+
+// BalanceDrift describes a materialized balance that no longer matches the totals of its transaction lines
+type BalanceDrift struct {
+	AccountID     uuid.UUID `json:"account_id"`
+	Currency      Currency  `json:"currency"`
+	StoredDebits  int64     `json:"stored_debits"`
+	StoredCredits int64     `json:"stored_credits"`
+	ActualDebits  int64     `json:"actual_debits"`
+	ActualCredits int64     `json:"actual_credits"`
+}
+
+// CurrencyTotals holds the trial balance totals of a single currency
+type CurrencyTotals struct {
+	ClassTotals  map[AccountClass]int64 `json:"class_totals"`
+	TotalDebits  int64                  `json:"total_debits"`
+	TotalCredits int64                  `json:"total_credits"`
+	Balanced     bool                   `json:"balanced"`
+}
+
+// TrialBalance lists the balances of all accounts in the chart of accounts per currency. The ledger is balanced when the total debits equal the total credits in every currency
+type TrialBalance struct {
+	Accounts   []*AccountBalance            `json:"accounts"`
+	Currencies map[Currency]*CurrencyTotals `json:"currencies"`
+	Balanced   bool                         `json:"balanced"`
 }
 
 // transaction models
@@ -63,15 +447,26 @@ type TransactionLine struct {
 	TransactionID string             `json:"transaction_id"`
 	Purpose       TransactionPurpose `json:"purpose"`
 	Amount        uint64             `json:"amount"`
+	Currency      Currency           `json:"currency"`
 	CreatedAt     *time.Time         `json:"created_at"`
 }
 
+// PostingGrace is how long a posting is given to commit. Postings take their timestamp when they start, so every line stamped longer ago than the grace period
+// has committed, and reads cut off there return the same lines whenever they run
+const PostingGrace = 5 * time.Minute
+
 // Transaction contains all transaction lines and relevant information about a transaction
 type Transaction struct {
-	ID        uuid.UUID         `json:"id"`
-	Reference string            `json:"reference"`
-	Lines     []TransactionLine `json:"lines"`
-	CreatedAt *time.Time        `json:"created_at"`
+	ID        uuid.UUID  `json:"id"`
+	Reference string     `json:"reference"`
+	FXRateID  *uuid.UUID `json:"fx_rate_id,omitempty"`
+	// ReversalOf links a compensating entry to the transaction it reverses
+	ReversalOf *uuid.UUID        `json:"reversal_of,omitempty"`
+	Lines      []TransactionLine `json:"lines"`
+	Reversals  []*Transaction    `json:"reversals,omitempty"`
+	// Fees are the fees charged to the sender of a transfer, itemized
+	Fees      []TransactionFee `json:"fees,omitempty"`
+	CreatedAt *time.Time       `json:"created_at"`
 }
 
 // CreateTransactionLine represents the required fields needed to create a line of transaction
@@ -80,12 +475,248 @@ type CreateTransactionLine struct {
 	TransactionID string
 	Purpose       TransactionPurpose
 	Amount        uint64
+	Currency      Currency
 }
 
 // CreateTransaction holds the information needed to create a new transaction in the system. The transactions lines should be 2 or more
 type CreateTransaction struct {
 	Reference string
-	Lines     []CreateTransactionLine
+	// FXRateID is the rate used to convert between the currencies of the transaction, if any
+	FXRateID *uuid.UUID
+	// ReversalOf is the transaction being reversed, if any
+	ReversalOf *uuid.UUID
+	Lines      []CreateTransactionLine
+}
+
+// idempotency models
+
+// IdempotencyRecord holds the fingerprint of a request made with an idempotency key and the response that was returned for it
+type IdempotencyRecord struct {
+	// UserID is the user who made the request. Keys are only unique per user
+	UserID       uuid.UUID  `json:"user_id"`
+	Key          string     `json:"key"`
+	RequestHash  string     `json:"request_hash"`
+	StatusCode   int        `json:"status_code"`
+	ResponseBody []byte     `json:"response_body"`
+	CreatedAt    *time.Time `json:"created_at"`
+}
+
+// hold models
+
+// HoldStatus is the state of an authorization hold
+type HoldStatus string
+
+const (
+	HoldActive   HoldStatus = "active"
+	HoldCaptured HoldStatus = "captured"
+	HoldVoided   HoldStatus = "voided"
+	HoldExpired  HoldStatus = "expired"
+)
+
+// Hold reserves an amount on an account for a later transfer to the recipient. Only active holds that have not reached their expiry reduce the available balance
+type Hold struct {
+	ID             uuid.UUID  `json:"id"`
+	Reference      string     `json:"reference"`
+	AccountID      uuid.UUID  `json:"account_id"`
+	RecipientID    uuid.UUID  `json:"recipient_id"`
+	Amount         uint64     `json:"amount"`
+	CapturedAmount uint64     `json:"captured_amount"`
+	Currency       Currency   `json:"currency"`
+	Status         HoldStatus `json:"status"`
+	// TransactionID is the posting made when the hold was captured
+	TransactionID *uuid.UUID `json:"transaction_id,omitempty"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	CreatedAt     *time.Time `json:"created_at"`
+	UpdatedAt     *time.Time `json:"updated_at"`
+}
+
+// Expired reports whether an active hold has reached its expiry at the given time
+func (h *Hold) Expired(now time.Time) bool {
+	return h.Status == HoldActive && !now.Before(h.ExpiresAt)
+}
+
+// CreateHold represents the fields required to place a new hold
+type CreateHold struct {
+	Reference   string
+	AccountID   uuid.UUID
+	RecipientID uuid.UUID
+	Amount      uint64
+	Currency    Currency
+	ExpiresAt   time.Time
+}
+
+// standing order models
+
+// StandingOrderStatus is the state of a standing order
+type StandingOrderStatus string
+
+const (
+	StandingOrderActive    StandingOrderStatus = "active"
+	StandingOrderCancelled StandingOrderStatus = "cancelled"
+	// StandingOrderCompleted orders have no further occurrences
+	StandingOrderCompleted StandingOrderStatus = "completed"
+)
+
+// StandingOrder is a scheduled or recurring transfer from the sender to the recipient
+type StandingOrder struct {
+	ID        uuid.UUID           `json:"id"`
+	Reference string              `json:"reference"`
+	Sender    string              `json:"sender"`
+	Recipient string              `json:"recipient"`
+	Amount    uint64              `json:"amount"`
+	Currency  *Currency           `json:"currency,omitempty"`
+	Convert   bool                `json:"convert"`
+	Schedule  Schedule            `json:"schedule"`
+	Status    StandingOrderStatus `json:"status"`
+	// NextRunOn is the next occurrence that has not been scheduled yet
+	NextRunOn *time.Time `json:"next_run_on,omitempty"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// CreateStandingOrder represents the fields required to create a new standing order
+type CreateStandingOrder struct {
+	Reference string
+	Sender    string
+	Recipient string
+	Amount    uint64
+	Currency  *Currency
+	Convert   bool
+	Schedule  Schedule
+	NextRunOn time.Time
+}
+
+// StandingOrderRunStatus is the state of a single occurrence of a standing order
+type StandingOrderRunStatus string
+
+const (
+	// RunPending occurrences are waiting for their first attempt or a retry
+	RunPending   StandingOrderRunStatus = "pending"
+	RunSucceeded StandingOrderRunStatus = "succeeded"
+	// RunFailed occurrences used up every attempt
+	RunFailed StandingOrderRunStatus = "failed"
+)
+
+// StandingOrderRun is a single occurrence of a standing order. There is at most one run per order and occurrence date, so each occurrence is posted at most once
+type StandingOrderRun struct {
+	ID              uuid.UUID              `json:"id"`
+	StandingOrderID uuid.UUID              `json:"standing_order_id"`
+	Occurrence      time.Time              `json:"occurrence"`
+	Status          StandingOrderRunStatus `json:"status"`
+	Attempts        int                    `json:"attempts"`
+	// LastError is the reason the most recent attempt failed
+	LastError     *string    `json:"last_error,omitempty"`
+	TransactionID *uuid.UUID `json:"transaction_id,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	CreatedAt     *time.Time `json:"created_at"`
+	UpdatedAt     *time.Time `json:"updated_at"`
+}
+
+// TransactionReference returns the reference of the transaction posted for the run. It is derived from the order and occurrence so that an occurrence can never be posted twice
+func (r *StandingOrderRun) TransactionReference() string {
+	return "SO-" + r.StandingOrderID.String() + "-" + r.Occurrence.Format("20060102")
+}
+
+// payment batch models
+
+// BatchMode is how the instructions of a payment batch are posted
+type BatchMode string
+
+const (
+	// ATOMIC_BATCH posts every instruction or none of them
+	ATOMIC_BATCH BatchMode = "atomic"
+	// PER_INSTRUCTION_BATCH posts each instruction on its own, so valid instructions are posted even when others are rejected
+	PER_INSTRUCTION_BATCH BatchMode = "per_instruction"
+)
+
+// PaymentStatus is the ISO 20022 status of a payment batch or of one of its instructions, as reported in pain.002
+type PaymentStatus string
+
+const (
+	PAYMENT_ACCEPTED PaymentStatus = "ACSC"
+	PAYMENT_PARTIAL  PaymentStatus = "PART"
+	PAYMENT_REJECTED PaymentStatus = "RJCT"
+	PAYMENT_PENDING  PaymentStatus = "PDNG"
+)
+
+// ISO 20022 status reason codes of rejected instructions
+const (
+	REASON_INCORRECT_ACCOUNT = "AC01"
+	REASON_FORBIDDEN         = "AG01"
+	REASON_INSUFFICIENT      = "AM04"
+	REASON_DUPLICATE         = "AM05"
+	REASON_INVALID_CURRENCY  = "AM11"
+	REASON_INVALID_AMOUNT    = "AM12"
+	REASON_NARRATIVE         = "NARR"
+)
+
+// NotProvided is the end to end id of instructions that carry none
+const NotProvided = "NOTPROVIDED"
+
+// PaymentInstruction is a credit transfer from a pain.001 file. Amount is the decimal amount as written in the file
+type PaymentInstruction struct {
+	PaymentInfoID string   `json:"payment_info_id"`
+	InstructionID string   `json:"instruction_id,omitempty"`
+	EndToEndID    string   `json:"end_to_end_id"`
+	Debtor        string   `json:"debtor"`
+	Creditor      string   `json:"creditor"`
+	Amount        string   `json:"amount"`
+	Currency      Currency `json:"currency"`
+	Remittance    string   `json:"remittance,omitempty"`
+}
+
+// PaymentBatch is a pain.001 credit transfer initiation. NumberOfTransactions and ControlSum are the totals declared in the group header
+type PaymentBatch struct {
+	MessageID            string               `json:"message_id"`
+	NumberOfTransactions int                  `json:"number_of_transactions"`
+	ControlSum           string               `json:"control_sum,omitempty"`
+	Instructions         []PaymentInstruction `json:"instructions"`
+}
+
+// InstructionReport is the outcome of an instruction of a payment batch. Rejected instructions carry an ISO 20022 reason code and a description
+type InstructionReport struct {
+	PaymentInstruction
+	Status        PaymentStatus `json:"status"`
+	ReasonCode    string        `json:"reason_code,omitempty"`
+	Reason        string        `json:"reason,omitempty"`
+	TransactionID *uuid.UUID    `json:"transaction_id,omitempty"`
+}
+
+// Reject marks the instruction as rejected for the given reason
+func (r *InstructionReport) Reject(code, reason string) {
+	r.Status, r.ReasonCode, r.Reason, r.TransactionID = PAYMENT_REJECTED, code, reason, nil
+}
+
+// PaymentReport is the pain.002 style status report of a payment batch. Each batch is identified by the message id of its pain.001 file and processed once
+type PaymentReport struct {
+	ID           uuid.UUID           `json:"id"`
+	MessageID    string              `json:"message_id"`
+	Mode         BatchMode           `json:"mode"`
+	Status       PaymentStatus       `json:"status"`
+	Instructions []InstructionReport `json:"instructions"`
+	CreatedAt    *time.Time          `json:"created_at,omitempty"`
+}
+
+// Settle sets the status of the batch from the status of its instructions
+func (r *PaymentReport) Settle() {
+	r.Status = SettledStatus(r.Instructions)
+}
+
+// SettledStatus returns the status of a group of processed instructions: accepted when all were posted, rejected when none were, partial otherwise
+func SettledStatus(instructions []InstructionReport) PaymentStatus {
+	accepted := 0
+	for _, instruction := range instructions {
+		if instruction.Status == PAYMENT_ACCEPTED {
+			accepted++
+		}
+	}
+	switch accepted {
+	case len(instructions):
+		return PAYMENT_ACCEPTED
+	case 0:
+		return PAYMENT_REJECTED
+	}
+	return PAYMENT_PARTIAL
 }
 
 // APIResponse is the standard application response for both success and error messages
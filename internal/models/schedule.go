@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// ScheduleKind is how often a standing order recurs
+type ScheduleKind string
+
+const (
+	// ONCE runs a single time on the start date
+	ONCE ScheduleKind = "once"
+	// DAILY runs every day from the start date
+	DAILY ScheduleKind = "daily"
+	// WEEKLY runs every week on the weekday of the start date
+	WEEKLY ScheduleKind = "weekly"
+	// MONTHLY runs every month on the day of month, or the last day of shorter months
+	MONTHLY ScheduleKind = "monthly"
+	// LAST_BUSINESS_DAY runs on the last weekday of every month
+	LAST_BUSINESS_DAY ScheduleKind = "last_business_day"
+)
+
+// Schedule describes when a standing order runs. Occurrences are calendar dates in UTC
+type Schedule struct {
+	Kind      ScheduleKind `json:"kind"`
+	StartDate time.Time    `json:"start_date"`
+	// EndDate is the last date an occurrence may fall on, if any
+	EndDate *time.Time `json:"end_date,omitempty"`
+	// DayOfMonth is the day monthly schedules run on
+	DayOfMonth int `json:"day_of_month,omitempty"`
+}
+
+// Next returns the first occurrence of the schedule on or after the given date. It reports false when the schedule has no further occurrences
+func (s *Schedule) Next(from time.Time) (time.Time, bool) {
+	start := date(s.StartDate)
+	from = date(from)
+	if from.Before(start) {
+		from = start
+	}
+
+	var next time.Time
+	switch s.Kind {
+	case ONCE:
+		if from.After(start) {
+			return time.Time{}, false
+		}
+		next = start
+	case DAILY:
+		next = from
+	case WEEKLY:
+		next = from.AddDate(0, 0, (int(start.Weekday())-int(from.Weekday())+7)%7)
+	case MONTHLY, LAST_BUSINESS_DAY:
+		next = s.inMonth(from.Year(), from.Month())
+		if next.Before(from) {
+			next = s.inMonth(from.Year(), from.Month()+1)
+		}
+	default:
+		return time.Time{}, false
+	}
+
+	if s.EndDate != nil && next.After(date(*s.EndDate)) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// inMonth returns the occurrence of a monthly schedule in the given month
+func (s *Schedule) inMonth(year int, month time.Month) time.Time {
+	// day 0 of the next month is the last day of this one
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+	if s.Kind == MONTHLY {
+		return time.Date(year, month, min(s.DayOfMonth, last.Day()), 0, 0, 0, 0, time.UTC)
+	}
+	for last.Weekday() == time.Saturday || last.Weekday() == time.Sunday {
+		last = last.AddDate(0, 0, -1)
+	}
+	return last
+}
+
+// date truncates a time to its calendar date in UTC
+func date(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
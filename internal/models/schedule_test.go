@@ -0,0 +1,54 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	end := func(t time.Time) *time.Time { return &t }
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		from     time.Time
+		want     time.Time
+		ok       bool
+	}{
+		{"once before start", Schedule{Kind: ONCE, StartDate: day(2024, 3, 10)}, day(2024, 3, 1), day(2024, 3, 10), true},
+		{"once on start", Schedule{Kind: ONCE, StartDate: day(2024, 3, 10)}, day(2024, 3, 10), day(2024, 3, 10), true},
+		{"once after start", Schedule{Kind: ONCE, StartDate: day(2024, 3, 10)}, day(2024, 3, 11), time.Time{}, false},
+		{"daily before start", Schedule{Kind: DAILY, StartDate: day(2024, 3, 10)}, day(2024, 3, 1), day(2024, 3, 10), true},
+		{"daily", Schedule{Kind: DAILY, StartDate: day(2024, 3, 10)}, day(2024, 3, 15), day(2024, 3, 15), true},
+		{"daily on end date", Schedule{Kind: DAILY, StartDate: day(2024, 3, 10), EndDate: end(day(2024, 3, 12))}, day(2024, 3, 12), day(2024, 3, 12), true},
+		{"daily after end date", Schedule{Kind: DAILY, StartDate: day(2024, 3, 10), EndDate: end(day(2024, 3, 12))}, day(2024, 3, 13), time.Time{}, false},
+		{"weekly on start weekday", Schedule{Kind: WEEKLY, StartDate: day(2024, 3, 10)}, day(2024, 3, 17), day(2024, 3, 17), true},
+		{"weekly", Schedule{Kind: WEEKLY, StartDate: day(2024, 3, 10)}, day(2024, 3, 12), day(2024, 3, 17), true},
+		{"monthly", Schedule{Kind: MONTHLY, StartDate: day(2024, 1, 15), DayOfMonth: 15}, day(2024, 3, 10), day(2024, 3, 15), true},
+		{"monthly after the day", Schedule{Kind: MONTHLY, StartDate: day(2024, 1, 15), DayOfMonth: 15}, day(2024, 3, 16), day(2024, 4, 15), true},
+		{"monthly across the year", Schedule{Kind: MONTHLY, StartDate: day(2024, 1, 15), DayOfMonth: 15}, day(2024, 12, 16), day(2025, 1, 15), true},
+		{"monthly clamped to leap february", Schedule{Kind: MONTHLY, StartDate: day(2024, 1, 31), DayOfMonth: 31}, day(2024, 2, 1), day(2024, 2, 29), true},
+		{"monthly clamped to february", Schedule{Kind: MONTHLY, StartDate: day(2024, 1, 31), DayOfMonth: 31}, day(2025, 2, 1), day(2025, 2, 28), true},
+		{"monthly clamped to a 30 day month", Schedule{Kind: MONTHLY, StartDate: day(2024, 1, 31), DayOfMonth: 31}, day(2024, 4, 1), day(2024, 4, 30), true},
+		{"monthly back to the day", Schedule{Kind: MONTHLY, StartDate: day(2024, 1, 31), DayOfMonth: 31}, day(2024, 3, 1), day(2024, 3, 31), true},
+		{"monthly on the clamped day", Schedule{Kind: MONTHLY, StartDate: day(2024, 1, 31), DayOfMonth: 31}, day(2024, 2, 29), day(2024, 2, 29), true},
+		{"last business day on a sunday", Schedule{Kind: LAST_BUSINESS_DAY, StartDate: day(2024, 1, 1)}, day(2024, 3, 1), day(2024, 3, 29), true},
+		{"last business day on a saturday", Schedule{Kind: LAST_BUSINESS_DAY, StartDate: day(2024, 1, 1)}, day(2024, 8, 1), day(2024, 8, 30), true},
+		{"last business day on a weekday", Schedule{Kind: LAST_BUSINESS_DAY, StartDate: day(2024, 1, 1)}, day(2024, 4, 1), day(2024, 4, 30), true},
+		{"last business day passed", Schedule{Kind: LAST_BUSINESS_DAY, StartDate: day(2024, 1, 1)}, day(2024, 3, 30), day(2024, 4, 30), true},
+		{"monthly past end date", Schedule{Kind: MONTHLY, StartDate: day(2024, 1, 15), DayOfMonth: 15, EndDate: end(day(2024, 4, 14))}, day(2024, 3, 16), time.Time{}, false},
+		{"from is truncated to its utc date", Schedule{Kind: DAILY, StartDate: day(2024, 3, 10)}, time.Date(2024, 3, 10, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*60*60)), day(2024, 3, 11), true},
+		{"unknown kind", Schedule{Kind: "yearly", StartDate: day(2024, 3, 10)}, day(2024, 3, 10), time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.schedule.Next(tt.from)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, %t, want %s, %t", tt.from.Format(time.DateOnly), got.Format(time.DateOnly), ok, tt.want.Format(time.DateOnly), tt.ok)
+			}
+		})
+	}
+}
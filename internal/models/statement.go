@@ -0,0 +1,147 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StatementFormat is the encoding a statement is rendered in
+type StatementFormat string
+
+const (
+	CSV_STATEMENT  StatementFormat = "csv"
+	JSON_STATEMENT StatementFormat = "json"
+	TXT_STATEMENT  StatementFormat = "txt"
+	// CAMT053_STATEMENT is an ISO 20022 camt.053 bank to customer statement and MT940_STATEMENT a SWIFT MT940 customer statement
+	CAMT053_STATEMENT StatementFormat = "camt053"
+	MT940_STATEMENT   StatementFormat = "mt940"
+)
+
+// Statement lists every line posted to an account between two dates inclusive, in UTC. Balances are on the normal side of the account class, and the statement
+// holds nothing that depends on when it was generated, so the same period always renders the same bytes
+type Statement struct {
+	AccountID     uuid.UUID          `json:"account_id"`
+	AccountNumber string             `json:"account_number"`
+	Class         AccountClass       `json:"class"`
+	From          string             `json:"from"`
+	To            string             `json:"to"`
+	Balances      []StatementBalance `json:"balances"`
+	Lines         []StatementLine    `json:"lines"`
+}
+
+// StatementBalance summarizes the statement period in one currency. Closing is Opening plus the effect of the period's debits and credits
+type StatementBalance struct {
+	Currency Currency `json:"currency"`
+	Opening  int64    `json:"opening"`
+	Debits   uint64   `json:"debits"`
+	Credits  uint64   `json:"credits"`
+	Closing  int64    `json:"closing"`
+}
+
+// StatementLine is a line posted to the account along with the running balance of its currency once it was posted
+type StatementLine struct {
+	BookedAt      time.Time          `json:"booked_at"`
+	TransactionID uuid.UUID          `json:"transaction_id"`
+	LineID        string             `json:"line_id"`
+	Reference     string             `json:"reference"`
+	Purpose       TransactionPurpose `json:"purpose"`
+	Amount        uint64             `json:"amount"`
+	Currency      Currency           `json:"currency"`
+	Balance       int64              `json:"balance"`
+}
+
+// StatementPeriod returns the start of the first day and the end of the last day of a statement covering the days from and to inclusive, in UTC
+func StatementPeriod(from, to time.Time) (start, end time.Time) {
+	start = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	end = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	return start, end
+}
+
+// NewStatement builds the statement of an account for the days from and to inclusive out of its balances at the start of the period and the transactions
+// posted during it. Lines are ordered by booking time, then transaction and line id, so the order does not depend on how the transactions were read
+func NewStatement(account *Account, opening []*AccountBalance, transactions []*Transaction, from, to time.Time) *Statement {
+	start, end := StatementPeriod(from, to)
+
+	balances := map[Currency]*StatementBalance{
+		account.Currency: {Currency: account.Currency},
+	}
+	for _, balance := range opening {
+		balances[balance.Currency] = &StatementBalance{Currency: balance.Currency, Opening: balance.Balance}
+	}
+	var lines []StatementLine
+	for _, t := range transactions {
+		bookedAt := utcTime(t.CreatedAt)
+		for _, line := range t.Lines {
+			if line.AccountID != account.ID.String() {
+				continue
+			}
+			if _, ok := balances[line.Currency]; !ok {
+				balances[line.Currency] = &StatementBalance{Currency: line.Currency}
+			}
+			lines = append(lines, StatementLine{
+				BookedAt:      bookedAt,
+				TransactionID: t.ID,
+				LineID:        line.ID,
+				Reference:     t.Reference,
+				Purpose:       line.Purpose,
+				Amount:        line.Amount,
+				Currency:      line.Currency,
+			})
+		}
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		if !a.BookedAt.Equal(b.BookedAt) {
+			return a.BookedAt.Before(b.BookedAt)
+		}
+		if a.TransactionID != b.TransactionID {
+			return a.TransactionID.String() < b.TransactionID.String()
+		}
+		return a.LineID < b.LineID
+	})
+
+	// carry the running balance of each currency through the period
+	for _, balance := range balances {
+		balance.Closing = balance.Opening
+	}
+	for i := range lines {
+		balance := balances[lines[i].Currency]
+		if lines[i].Purpose == DEBIT {
+			balance.Debits += lines[i].Amount
+		} else {
+			balance.Credits += lines[i].Amount
+		}
+		balance.Closing += account.Class.Effect(lines[i].Purpose, lines[i].Amount)
+		lines[i].Balance = balance.Closing
+	}
+
+	statement := &Statement{
+		AccountID:     account.ID,
+		AccountNumber: account.AccountNumber,
+		Class:         account.Class,
+		From:          start.Format(time.DateOnly),
+		To:            end.AddDate(0, 0, -1).Format(time.DateOnly),
+		Balances:      make([]StatementBalance, 0, len(balances)),
+		Lines:         lines,
+	}
+	if statement.Lines == nil {
+		statement.Lines = []StatementLine{}
+	}
+	for _, balance := range balances {
+		statement.Balances = append(statement.Balances, *balance)
+	}
+	sort.Slice(statement.Balances, func(i, j int) bool {
+		return statement.Balances[i].Currency < statement.Balances[j].Currency
+	})
+	return statement
+}
+
+func utcTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
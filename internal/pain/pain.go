@@ -0,0 +1,229 @@
+package pain
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// errors
+var (
+	ErrInvalidDocument      = errors.New("document is not a pain.001 customer credit transfer initiation")
+	ErrMessageID            = errors.New("message id must be between 1 and 35 characters")
+	ErrEmptyBatch           = errors.New("document holds no credit transfer instructions")
+	ErrNumberOfTransactions = errors.New("number of transactions does not match the instructions in the document")
+	ErrControlSum           = errors.New("control sum does not match the instructed amounts")
+)
+
+// painNamespace prefixes the namespace of every version of pain.001
+const painNamespace = "urn:iso:std:iso:20022:tech:xsd:pain.001."
+
+// pain.001 customer credit transfer initiation, limited to the elements the ledger uses. Elements are matched in any pain.001 version
+type initiationDocument struct {
+	XMLName    xml.Name   `xml:"Document"`
+	Initiation initiation `xml:"CstmrCdtTrfInitn"`
+}
+
+type initiation struct {
+	XMLName     xml.Name `xml:"CstmrCdtTrfInitn"`
+	GroupHeader struct {
+		MessageID            string `xml:"MsgId"`
+		NumberOfTransactions string `xml:"NbOfTxs"`
+		ControlSum           string `xml:"CtrlSum"`
+	} `xml:"GrpHdr"`
+	PaymentInfos []struct {
+		ID            string           `xml:"PmtInfId"`
+		DebtorAccount account          `xml:"DbtrAcct"`
+		Transfers     []creditTransfer `xml:"CdtTrfTxInf"`
+	} `xml:"PmtInf"`
+}
+
+type creditTransfer struct {
+	InstructionID string `xml:"PmtId>InstrId"`
+	EndToEndID    string `xml:"PmtId>EndToEndId"`
+	Amount        struct {
+		Currency string `xml:"Ccy,attr"`
+		Value    string `xml:",chardata"`
+	} `xml:"Amt>InstdAmt"`
+	CreditorAccount account  `xml:"CdtrAcct"`
+	Remittance      []string `xml:"RmtInf>Ustrd"`
+}
+
+// account identifies an account by IBAN or, as sgbank account numbers are, by a proprietary id
+type account struct {
+	IBAN  string `xml:"Id>IBAN"`
+	Other string `xml:"Id>Othr>Id"`
+}
+
+func (a account) number() string {
+	if a.Other != "" {
+		return strings.TrimSpace(a.Other)
+	}
+	return strings.TrimSpace(a.IBAN)
+}
+
+// Parse reads a pain.001 file into a payment batch. The file is rejected as a whole when it is malformed or its declared number of transactions or control
+// sum do not match its instructions. Instructions themselves are validated when the batch is posted
+func Parse(r io.Reader) (*models.PaymentBatch, error) {
+	var doc initiationDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, errors.Join(ErrInvalidDocument, err)
+	}
+	if !strings.HasPrefix(doc.XMLName.Space, painNamespace) || doc.Initiation.XMLName.Local == "" {
+		return nil, ErrInvalidDocument
+	}
+
+	header := doc.Initiation.GroupHeader
+	batch := &models.PaymentBatch{
+		MessageID:  strings.TrimSpace(header.MessageID),
+		ControlSum: strings.TrimSpace(header.ControlSum),
+	}
+	if batch.MessageID == "" || len(batch.MessageID) > 35 {
+		return nil, ErrMessageID
+	}
+
+	sum := new(big.Rat)
+	sumValid := true
+	for _, info := range doc.Initiation.PaymentInfos {
+		for _, transfer := range info.Transfers {
+			instruction := models.PaymentInstruction{
+				PaymentInfoID: strings.TrimSpace(info.ID),
+				InstructionID: strings.TrimSpace(transfer.InstructionID),
+				EndToEndID:    strings.TrimSpace(transfer.EndToEndID),
+				Debtor:        info.DebtorAccount.number(),
+				Creditor:      transfer.CreditorAccount.number(),
+				Amount:        strings.TrimSpace(transfer.Amount.Value),
+				Currency:      models.Currency(strings.TrimSpace(transfer.Amount.Currency)),
+				Remittance:    strings.TrimSpace(strings.Join(transfer.Remittance, " ")),
+			}
+			if instruction.EndToEndID == "" {
+				instruction.EndToEndID = models.NotProvided
+			}
+			batch.Instructions = append(batch.Instructions, instruction)
+
+			amount, ok := new(big.Rat).SetString(instruction.Amount)
+			sumValid = sumValid && ok
+			if ok {
+				sum.Add(sum, amount)
+			}
+		}
+	}
+	if len(batch.Instructions) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(header.NumberOfTransactions))
+	if err != nil || count != len(batch.Instructions) {
+		return nil, ErrNumberOfTransactions
+	}
+	batch.NumberOfTransactions = count
+
+	if batch.ControlSum != "" {
+		declared, ok := new(big.Rat).SetString(batch.ControlSum)
+		if !ok || !sumValid || declared.Cmp(sum) != 0 {
+			return nil, ErrControlSum
+		}
+	}
+	return batch, nil
+}
+
+// pain.002 customer payment status report. Fields are declared in schema order
+type statusDocument struct {
+	XMLName xml.Name     `xml:"urn:iso:std:iso:20022:tech:xsd:pain.002.001.03 Document"`
+	Report  statusReport `xml:"CstmrPmtStsRpt"`
+}
+
+type statusReport struct {
+	GroupHeader struct {
+		MessageID string `xml:"MsgId"`
+		CreatedAt string `xml:"CreDtTm"`
+	} `xml:"GrpHdr"`
+	Group struct {
+		MessageID            string               `xml:"OrgnlMsgId"`
+		MessageName          string               `xml:"OrgnlMsgNmId"`
+		NumberOfTransactions int                  `xml:"OrgnlNbOfTxs"`
+		Status               models.PaymentStatus `xml:"GrpSts"`
+	} `xml:"OrgnlGrpInfAndSts"`
+	PaymentInfos []paymentInfoStatus `xml:"OrgnlPmtInfAndSts"`
+}
+
+type paymentInfoStatus struct {
+	ID           string               `xml:"OrgnlPmtInfId"`
+	Status       models.PaymentStatus `xml:"PmtInfSts"`
+	Transactions []transactionStatus  `xml:"TxInfAndSts"`
+}
+
+type transactionStatus struct {
+	InstructionID string               `xml:"OrgnlInstrId,omitempty"`
+	EndToEndID    string               `xml:"OrgnlEndToEndId"`
+	Status        models.PaymentStatus `xml:"TxSts"`
+	Reason        *statusReason        `xml:"StsRsnInf,omitempty"`
+}
+
+type statusReason struct {
+	Code string `xml:"Rsn>Cd"`
+	Info string `xml:"AddtlInf,omitempty"`
+}
+
+// StatusReport renders the report of a payment batch as a pain.002.001.03 customer payment status report, with the instructions grouped by the payment
+// information block they came from
+func StatusReport(report *models.PaymentReport) ([]byte, error) {
+	var doc statusDocument
+	doc.Report.GroupHeader.MessageID = strings.ReplaceAll(report.ID.String(), "-", "")
+	createdAt := time.Now()
+	if report.CreatedAt != nil {
+		createdAt = *report.CreatedAt
+	}
+	doc.Report.GroupHeader.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	doc.Report.Group.MessageID = report.MessageID
+	doc.Report.Group.MessageName = "pain.001.001.03"
+	doc.Report.Group.NumberOfTransactions = len(report.Instructions)
+	doc.Report.Group.Status = report.Status
+
+	var groups [][]models.InstructionReport
+	for _, instruction := range report.Instructions {
+		n := len(doc.Report.PaymentInfos)
+		if n == 0 || doc.Report.PaymentInfos[n-1].ID != instruction.PaymentInfoID {
+			doc.Report.PaymentInfos = append(doc.Report.PaymentInfos, paymentInfoStatus{ID: instruction.PaymentInfoID})
+			groups = append(groups, nil)
+			n++
+		}
+		status := transactionStatus{
+			InstructionID: instruction.InstructionID,
+			EndToEndID:    instruction.EndToEndID,
+			Status:        instruction.Status,
+		}
+		if instruction.ReasonCode != "" {
+			status.Reason = &statusReason{Code: instruction.ReasonCode, Info: truncate(instruction.Reason, 105)}
+		}
+		doc.Report.PaymentInfos[n-1].Transactions = append(doc.Report.PaymentInfos[n-1].Transactions, status)
+		groups[n-1] = append(groups[n-1], instruction)
+	}
+	for i := range doc.Report.PaymentInfos {
+		doc.Report.PaymentInfos[i].Status = report.Status
+		if report.Status != models.PAYMENT_PENDING {
+			doc.Report.PaymentInfos[i].Status = models.SettledStatus(groups[i])
+		}
+	}
+
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
+
+// truncate shortens text to at most the given number of characters
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length])
+}
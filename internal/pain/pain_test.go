@@ -0,0 +1,187 @@
+package pain
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// document returns a pain.001.001.03 file of two payment information blocks with three instructions in all. The group header is given as is
+func document(namespace, groupHeader, secondEndToEndID string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="` + namespace + `">
+  <CstmrCdtTrfInitn>
+    <GrpHdr>` + groupHeader + `</GrpHdr>
+    <PmtInf>
+      <PmtInfId>PMT-1</PmtInfId>
+      <DbtrAcct><Id><Othr><Id>1000000001</Id></Othr></Id></DbtrAcct>
+      <CdtTrfTxInf>
+        <PmtId><InstrId>I-1</InstrId><EndToEndId>E2E-1</EndToEndId></PmtId>
+        <Amt><InstdAmt Ccy="USD">10.50</InstdAmt></Amt>
+        <CdtrAcct><Id><Othr><Id>2000000001</Id></Othr></Id></CdtrAcct>
+        <RmtInf><Ustrd>invoice</Ustrd><Ustrd>42</Ustrd></RmtInf>
+      </CdtTrfTxInf>
+      <CdtTrfTxInf>
+        <PmtId>` + secondEndToEndID + `</PmtId>
+        <Amt><InstdAmt Ccy="USD">4.5</InstdAmt></Amt>
+        <CdtrAcct><Id><IBAN>GB33BUKB20201555555555</IBAN></Id></CdtrAcct>
+      </CdtTrfTxInf>
+    </PmtInf>
+    <PmtInf>
+      <PmtInfId>PMT-2</PmtInfId>
+      <DbtrAcct><Id><Othr><Id> 1000000002 </Id></Othr></Id></DbtrAcct>
+      <CdtTrfTxInf>
+        <PmtId><EndToEndId>E2E-3</EndToEndId></PmtId>
+        <Amt><InstdAmt Ccy="JPY">1000</InstdAmt></Amt>
+        <CdtrAcct><Id><Othr><Id>2000000003</Id></Othr></Id></CdtrAcct>
+      </CdtTrfTxInf>
+    </PmtInf>
+  </CstmrCdtTrfInitn>
+</Document>`
+}
+
+const (
+	testNamespace = "urn:iso:std:iso:20022:tech:xsd:pain.001.001.03"
+	testEndToEnd  = "<EndToEndId>E2E-2</EndToEndId>"
+)
+
+func TestParse(t *testing.T) {
+	header := func(messageID, count, sum string) string {
+		h := "<MsgId>" + messageID + "</MsgId><NbOfTxs>" + count + "</NbOfTxs>"
+		if sum != "" {
+			h += "<CtrlSum>" + sum + "</CtrlSum>"
+		}
+		return h
+	}
+
+	tests := []struct {
+		name string
+		file string
+		err  error
+	}{
+		{"valid", document(testNamespace, header("MSG-1", "3", "1015.00"), testEndToEnd), nil},
+		{"without control sum", document(testNamespace, header("MSG-1", "3", ""), testEndToEnd), nil},
+		{"other pain.001 version", document("urn:iso:std:iso:20022:tech:xsd:pain.001.001.09", header("MSG-1", "3", ""), testEndToEnd), nil},
+		{"pain.008 namespace", document("urn:iso:std:iso:20022:tech:xsd:pain.008.001.02", header("MSG-1", "3", ""), testEndToEnd), ErrInvalidDocument},
+		{"no namespace", document("", header("MSG-1", "3", ""), testEndToEnd), ErrInvalidDocument},
+		{"not xml", "MSG-1;3;1015.00", ErrInvalidDocument},
+		{"fewer transactions declared", document(testNamespace, header("MSG-1", "2", ""), testEndToEnd), ErrNumberOfTransactions},
+		{"more transactions declared", document(testNamespace, header("MSG-1", "4", ""), testEndToEnd), ErrNumberOfTransactions},
+		{"transactions not a number", document(testNamespace, header("MSG-1", "three", ""), testEndToEnd), ErrNumberOfTransactions},
+		{"control sum too low", document(testNamespace, header("MSG-1", "3", "1014.99"), testEndToEnd), ErrControlSum},
+		{"control sum not a number", document(testNamespace, header("MSG-1", "3", "ten"), testEndToEnd), ErrControlSum},
+		{"no message id", document(testNamespace, header(" ", "3", ""), testEndToEnd), ErrMessageID},
+		{"message id too long", document(testNamespace, header(strings.Repeat("M", 36), "3", ""), testEndToEnd), ErrMessageID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.file))
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Parse() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseInstructions(t *testing.T) {
+	// the second instruction carries no end to end id
+	batch, err := Parse(strings.NewReader(document(testNamespace, "<MsgId> MSG-1 </MsgId><NbOfTxs>3</NbOfTxs><CtrlSum>1015</CtrlSum>", "<InstrId>I-2</InstrId>")))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if batch.MessageID != "MSG-1" || batch.NumberOfTransactions != 3 || batch.ControlSum != "1015" {
+		t.Errorf("batch = %+v", batch)
+	}
+	want := []models.PaymentInstruction{
+		{PaymentInfoID: "PMT-1", InstructionID: "I-1", EndToEndID: "E2E-1", Debtor: "1000000001", Creditor: "2000000001", Amount: "10.50", Currency: models.USD, Remittance: "invoice 42"},
+		{PaymentInfoID: "PMT-1", InstructionID: "I-2", EndToEndID: models.NotProvided, Debtor: "1000000001", Creditor: "GB33BUKB20201555555555", Amount: "4.5", Currency: models.USD},
+		{PaymentInfoID: "PMT-2", EndToEndID: "E2E-3", Debtor: "1000000002", Creditor: "2000000003", Amount: "1000", Currency: models.JPY},
+	}
+	if len(batch.Instructions) != len(want) {
+		t.Fatalf("got %d instructions, want %d", len(batch.Instructions), len(want))
+	}
+	for i := range want {
+		if batch.Instructions[i] != want[i] {
+			t.Errorf("instruction %d = %+v, want %+v", i, batch.Instructions[i], want[i])
+		}
+	}
+}
+
+func TestStatusReport(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+	transactionID := uuid.MustParse("33333333-3333-3333-3333-333333333333")
+	instruction := func(paymentInfoID, endToEndID string, status models.PaymentStatus) models.InstructionReport {
+		report := models.InstructionReport{
+			PaymentInstruction: models.PaymentInstruction{PaymentInfoID: paymentInfoID, EndToEndID: endToEndID},
+			Status:             status,
+		}
+		if status == models.PAYMENT_ACCEPTED {
+			report.TransactionID = &transactionID
+		} else {
+			report.Reject(models.REASON_INSUFFICIENT, strings.Repeat("insufficient balance ", 10))
+		}
+		return report
+	}
+
+	report := &models.PaymentReport{
+		ID:        uuid.MustParse("11111111-2222-3333-4444-555555555555"),
+		MessageID: "MSG-1",
+		Mode:      models.PER_INSTRUCTION_BATCH,
+		CreatedAt: &createdAt,
+		Instructions: []models.InstructionReport{
+			instruction("PMT-1", "E2E-1", models.PAYMENT_ACCEPTED),
+			instruction("PMT-1", "E2E-2", models.PAYMENT_REJECTED),
+			instruction("PMT-2", "E2E-3", models.PAYMENT_ACCEPTED),
+		},
+	}
+	report.Settle()
+
+	body, err := StatusReport(report)
+	if err != nil {
+		t.Fatalf("StatusReport() error = %v", err)
+	}
+	if !strings.HasPrefix(string(body), xml.Header) {
+		t.Error("report does not start with the xml header")
+	}
+
+	var doc statusDocument
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("unmarshal pain.002: %v", err)
+	}
+	if doc.XMLName.Space != "urn:iso:std:iso:20022:tech:xsd:pain.002.001.03" {
+		t.Errorf("namespace = %q", doc.XMLName.Space)
+	}
+
+	header, group := doc.Report.GroupHeader, doc.Report.Group
+	if header.MessageID != "11111111222233334444555555555555" || header.CreatedAt != "2024-03-01T10:00:00Z" {
+		t.Errorf("group header = %+v", header)
+	}
+	if group.MessageID != "MSG-1" || group.MessageName != "pain.001.001.03" || group.NumberOfTransactions != 3 || group.Status != models.PAYMENT_PARTIAL {
+		t.Errorf("original group = %+v", group)
+	}
+
+	// instructions are grouped by their payment information block, each with the status of its own instructions
+	infos := doc.Report.PaymentInfos
+	if len(infos) != 2 || infos[0].ID != "PMT-1" || infos[1].ID != "PMT-2" {
+		t.Fatalf("payment infos = %+v", infos)
+	}
+	if infos[0].Status != models.PAYMENT_PARTIAL || infos[1].Status != models.PAYMENT_ACCEPTED {
+		t.Errorf("payment info statuses = %s, %s", infos[0].Status, infos[1].Status)
+	}
+	if len(infos[0].Transactions) != 2 || len(infos[1].Transactions) != 1 {
+		t.Fatalf("transactions = %+v", infos)
+	}
+	if accepted := infos[0].Transactions[0]; accepted.EndToEndID != "E2E-1" || accepted.Status != models.PAYMENT_ACCEPTED || accepted.Reason != nil {
+		t.Errorf("accepted transaction = %+v", accepted)
+	}
+	rejected := infos[0].Transactions[1]
+	if rejected.Status != models.PAYMENT_REJECTED || rejected.Reason == nil || rejected.Reason.Code != models.REASON_INSUFFICIENT || len(rejected.Reason.Info) > 105 {
+		t.Errorf("rejected transaction = %+v", rejected)
+	}
+}
@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// AccessDenialRepository handles database operations for the append-only record of denied requests
+type AccessDenialRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewAccessDenialRepository creates a new access denial repository
+func NewAccessDenialRepository(db *sql.DB, logger *slog.Logger) *AccessDenialRepository {
+	return &AccessDenialRepository{db: db, logger: logger}
+}
+
+// RecordDenial appends a denied request to the record. It is written outside the database transaction of the request, so it is kept when the request rolls back
+func (r *AccessDenialRepository) RecordDenial(ctx context.Context, data *models.CreateAccessDenial) error {
+	query := `
+		INSERT INTO access_denials (user_id, role, method, route, path, permission, reason, ip_address)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := r.db.ExecContext(ctx, query, data.UserID, data.Role, data.Method, data.Route, data.Path, data.Permission, data.Reason, data.IPAddress)
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/mrshabel/sgbank/internal/models"
 )
 
@@ -29,14 +30,48 @@ func (r *AccountRepository) GetTx(ctx context.Context) (tx *sql.Tx, err error) {
 // CreateAccount adds a new account to the database
 func (r *AccountRepository) CreateAccount(ctx context.Context, data *models.CreateAccount) (*models.Account, error) {
 	query := `
-		INSERT INTO accounts (account_number, user_id)
-		VALUES ($1, $2)
-		RETURNING id, account_number, user_id, created_at, updated_at, deleted_at
+		INSERT INTO accounts (account_number, user_id, class, currency, type)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, account_number, user_id, class, currency, type, overdraft_limit, credit_limit, created_at, updated_at, deleted_at
 	`
 
 	// retrieve account details
 	var account models.Account
-	if err := r.db.QueryRowContext(ctx, query, data.AccountNumber, data.UserID).Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.CreatedAt, &account.UpdatedAt, &account.DeletedAt); err != nil {
+	if err := r.db.QueryRowContext(ctx, query, data.AccountNumber, data.UserID, data.Class, data.Currency, data.Type).Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.Class, &account.Currency, &account.Type, &account.OverdraftLimit, &account.CreditLimit, &account.CreatedAt, &account.UpdatedAt, &account.DeletedAt); err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// GetOrCreateSystemAccount retrieves a bank-owned account by its account number, creating it when it does not exist yet. It runs in the posting's database
+// transaction, so an account created for a posting that is rolled back goes with it, and concurrent first postings wait on each other's insert
+func (r *AccountRepository) GetOrCreateSystemAccount(ctx context.Context, tx *sql.Tx, data *models.CreateAccount) (*models.Account, error) {
+	return getOrCreateSystemAccount(ctx, tx, data)
+}
+
+// execQuerier is satisfied by both *sql.DB and *sql.Tx
+type execQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func getOrCreateSystemAccount(ctx context.Context, q execQuerier, data *models.CreateAccount) (*models.Account, error) {
+	query := `
+		INSERT INTO accounts (account_number, user_id, class, currency)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (account_number) DO NOTHING
+	`
+	if _, err := q.ExecContext(ctx, query, data.AccountNumber, models.SystemUserID, data.Class, data.Currency); err != nil {
+		return nil, err
+	}
+
+	query = `
+	 SELECT id, account_number, user_id, class, currency, type, overdraft_limit, credit_limit, created_at, updated_at FROM accounts
+	 WHERE deleted_at IS NULL AND account_number = $1 AND user_id = $2
+	 `
+	var account models.Account
+	if err := q.QueryRowContext(ctx, query, data.AccountNumber, models.SystemUserID).Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.Class, &account.Currency, &account.Type, &account.OverdraftLimit, &account.CreditLimit, &account.CreatedAt, &account.UpdatedAt); err != nil {
 		return nil, err
 	}
 
@@ -46,11 +81,11 @@ func (r *AccountRepository) CreateAccount(ctx context.Context, data *models.Crea
 // GetAccountByID retrieves a non-deleted account by their ID
 func (r *AccountRepository) GetAccountByID(ctx context.Context, id uuid.UUID) (*models.Account, error) {
 	query := `
-	 SELECT id, account_number, user_id, created_at, updated_at FROM accounts
+	 SELECT id, account_number, user_id, class, currency, type, overdraft_limit, credit_limit, created_at, updated_at FROM accounts
 	 WHERE deleted_at IS NULL AND id = $1
 	 `
 	var account models.Account
-	if err := r.db.QueryRowContext(ctx, query, id).Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.CreatedAt, &account.UpdatedAt); err != nil {
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.Class, &account.Currency, &account.Type, &account.OverdraftLimit, &account.CreditLimit, &account.CreatedAt, &account.UpdatedAt); err != nil {
 		return nil, err
 	}
 
@@ -60,19 +95,50 @@ func (r *AccountRepository) GetAccountByID(ctx context.Context, id uuid.UUID) (*
 // GetAccountByAcctNumbers retrieves all non-deleted accounts belonging associated with the given account numbers
 func (r *AccountRepository) GetAccountsByAcctNumbers(ctx context.Context, acctNums []string) ([]*models.Account, error) {
 	query := `
-	 SELECT id, account_number, user_id, created_at, updated_at FROM accounts
-	 WHERE deleted_at IS NULL AND account_number IN ($1)
+	 SELECT id, account_number, user_id, class, currency, type, overdraft_limit, credit_limit, created_at, updated_at FROM accounts
+	 WHERE deleted_at IS NULL AND account_number = ANY($1)
+	 `
+
+	var accounts []*models.Account
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(acctNums))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var account models.Account
+		if err := rows.Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.Class, &account.Currency, &account.Type, &account.OverdraftLimit, &account.CreditLimit, &account.CreatedAt, &account.UpdatedAt); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, &account)
+	}
+
+	return accounts, nil
+}
+
+// GetAccountsByIDs retrieves all non-deleted accounts with the given IDs
+func (r *AccountRepository) GetAccountsByIDs(ctx context.Context, ids []uuid.UUID) ([]*models.Account, error) {
+	query := `
+	 SELECT id, account_number, user_id, class, currency, type, overdraft_limit, credit_limit, created_at, updated_at FROM accounts
+	 WHERE deleted_at IS NULL AND id = ANY($1)
 	 `
 
+	acctIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		acctIDs = append(acctIDs, id.String())
+	}
+
 	var accounts []*models.Account
-	rows, err := r.db.QueryContext(ctx, query, acctNums)
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(acctIDs))
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var account models.Account
-		if err := rows.Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.CreatedAt, &account.UpdatedAt); err != nil {
+		if err := rows.Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.Class, &account.Currency, &account.Type, &account.OverdraftLimit, &account.CreditLimit, &account.CreatedAt, &account.UpdatedAt); err != nil {
 			return nil, err
 		}
 		accounts = append(accounts, &account)
@@ -81,10 +147,42 @@ func (r *AccountRepository) GetAccountsByAcctNumbers(ctx context.Context, acctNu
 	return accounts, nil
 }
 
+// GetAccountOwners retrieves the distinct users owning the accounts with the given IDs or account numbers, disabled accounts included
+func (r *AccountRepository) GetAccountOwners(ctx context.Context, ids []uuid.UUID, acctNums []string) ([]string, error) {
+	query := `
+	 SELECT DISTINCT user_id FROM accounts
+	 WHERE id = ANY($1) OR account_number = ANY($2)
+	 `
+
+	acctIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		acctIDs = append(acctIDs, id.String())
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(acctIDs), pq.Array(acctNums))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var owners []string
+	for rows.Next() {
+		var owner string
+		if err := rows.Scan(&owner); err != nil {
+			return nil, err
+		}
+		owners = append(owners, owner)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return owners, nil
+}
+
 // GetAccountByUserId retrieves all non-deleted accounts belonging to a user
 func (r *AccountRepository) GetAccountsByUserID(ctx context.Context, userId uuid.UUID) ([]*models.Account, error) {
 	query := `
-	 SELECT id, account_number, user_id, created_at, updated_at FROM accounts
+	 SELECT id, account_number, user_id, class, currency, type, overdraft_limit, credit_limit, created_at, updated_at FROM accounts
 	 WHERE deleted_at IS NULL AND user_id  = $1
 	 `
 
@@ -96,7 +194,7 @@ func (r *AccountRepository) GetAccountsByUserID(ctx context.Context, userId uuid
 
 	for rows.Next() {
 		var account models.Account
-		if err := rows.Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.CreatedAt, &account.UpdatedAt); err != nil {
+		if err := rows.Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.Class, &account.Currency, &account.Type, &account.OverdraftLimit, &account.CreditLimit, &account.CreatedAt, &account.UpdatedAt); err != nil {
 			return nil, err
 		}
 		accounts = append(accounts, &account)
@@ -111,11 +209,11 @@ func (r *AccountRepository) DisableAccountByID(ctx context.Context, id uuid.UUID
 	 UPDATE accounts
 	 SET deleted_at = NOW()
 	 WHERE deleted_at IS NULL AND id  = $1
-	 RETURNING id, account_number, user_id, created_at, updated_at, deleted_at
+	 RETURNING id, account_number, user_id, class, currency, type, overdraft_limit, credit_limit, created_at, updated_at, deleted_at
 	 `
 
 	var account models.Account
-	if err := r.db.QueryRowContext(ctx, query, id).Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.CreatedAt, &account.UpdatedAt, &account.DeletedAt); err != nil {
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.Class, &account.Currency, &account.Type, &account.OverdraftLimit, &account.CreditLimit, &account.CreatedAt, &account.UpdatedAt, &account.DeletedAt); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,440 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// balanceKey identifies the materialized balance of an account in a single currency
+type balanceKey struct {
+	accountID uuid.UUID
+	currency  models.Currency
+}
+
+// applyBalances adds the given lines to the materialized balances of their accounts within the posting's database transaction. Each balance touched has its version
+// bumped once. Rows are updated in a stable order so that concurrent postings do not deadlock. Totals beyond bigint fail the posting, in the database when they are
+// added to the stored balance
+func applyBalances(ctx context.Context, tx *sql.Tx, lines []models.CreateTransactionLine) error {
+	type totals struct {
+		debits, credits int64
+	}
+
+	grouped := make(map[balanceKey]*totals)
+	var keys []balanceKey
+	for _, line := range lines {
+		key := balanceKey{accountID: line.AccountID, currency: line.Currency}
+		t, ok := grouped[key]
+		if !ok {
+			t = &totals{}
+			grouped[key] = t
+			keys = append(keys, key)
+		}
+		total := &t.credits
+		if line.Purpose == models.DEBIT {
+			total = &t.debits
+		}
+		// a wrapped total would pass the >= 0 check of account_balances and leave every later sum of the account's lines beyond bigint
+		if line.Amount > math.MaxInt64 || *total > math.MaxInt64-int64(line.Amount) {
+			return ErrLineAmountOverflow
+		}
+		*total += int64(line.Amount)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].accountID != keys[j].accountID {
+			return keys[i].accountID.String() < keys[j].accountID.String()
+		}
+		return keys[i].currency < keys[j].currency
+	})
+
+	query := `
+		INSERT INTO account_balances (account_id, currency, debits, credits, version)
+		VALUES ($1, $2, $3, $4, 1)
+		ON CONFLICT (account_id, currency) DO UPDATE
+		SET debits = account_balances.debits + EXCLUDED.debits,
+		credits = account_balances.credits + EXCLUDED.credits,
+		version = account_balances.version + 1,
+		updated_at = NOW()
+	`
+	for _, key := range keys {
+		t := grouped[key]
+		if _, err := tx.ExecContext(ctx, query, key.accountID, key.currency, t.debits, t.credits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBalanceByAccountID retrieves the current balance of an account in its own currency, on the normal side of its class. The balance is read within the provided
+// database transaction so that it is consistent with any locks held
+func (r *TransactionRepository) GetBalanceByAccountID(ctx context.Context, tx *sql.Tx, acctID uuid.UUID) (int64, error) {
+	query := `
+	 SELECT
+	 accounts.class,
+	 COALESCE(balances.credits, 0) AS credit_balance,
+	 COALESCE(balances.debits, 0) AS debit_balance
+	 FROM accounts
+	 LEFT JOIN account_balances AS balances
+	 ON balances.account_id = accounts.id AND balances.currency = accounts.currency
+	 WHERE accounts.id = $1
+	 `
+
+	// retrieve balances
+	var class models.AccountClass
+	var creditBalance, debitBalance int64
+	if err := tx.QueryRowContext(ctx, query, acctID).Scan(&class, &creditBalance, &debitBalance); err != nil {
+		return 0, err
+	}
+
+	return class.Balance(creditBalance, debitBalance), nil
+}
+
+// GetAvailableBalanceByAccountID retrieves the balance of an account in its own currency less the amount reserved by its active holds, plus its overdraft or credit limit.
+// It reads within the provided database transaction so that it is consistent with any locks held
+func (r *TransactionRepository) GetAvailableBalanceByAccountID(ctx context.Context, tx *sql.Tx, acctID uuid.UUID) (int64, error) {
+	query := `
+	 SELECT
+	 accounts.class,
+	 COALESCE(balances.credits, 0) AS credit_balance,
+	 COALESCE(balances.debits, 0) AS debit_balance,
+	 COALESCE((
+		SELECT SUM(holds.amount) FROM holds
+		WHERE holds.account_id = accounts.id AND holds.currency = accounts.currency
+		AND holds.status = $2 AND holds.expires_at > NOW()
+	 ), 0) AS held,
+	 CASE WHEN accounts.type = $3 THEN accounts.credit_limit ELSE accounts.overdraft_limit END AS limit_amount
+	 FROM accounts
+	 LEFT JOIN account_balances AS balances
+	 ON balances.account_id = accounts.id AND balances.currency = accounts.currency
+	 WHERE accounts.id = $1
+	 `
+
+	var class models.AccountClass
+	var creditBalance, debitBalance, held, limit int64
+	if err := tx.QueryRowContext(ctx, query, acctID, models.HoldActive, models.CREDIT_LINE).Scan(&class, &creditBalance, &debitBalance, &held, &limit); err != nil {
+		return 0, err
+	}
+
+	return class.Balance(creditBalance, debitBalance) - held + limit, nil
+}
+
+// GetAccountBalances retrieves the debit and credit totals of an account along with its normal balance in every currency it holds
+func (r *TransactionRepository) GetAccountBalances(ctx context.Context, acctID uuid.UUID) ([]*models.AccountBalance, error) {
+	query := `
+	 SELECT
+	 accounts.id,
+	 accounts.account_number,
+	 accounts.class,
+	 COALESCE(balances.currency, accounts.currency) AS currency,
+	 COALESCE(balances.credits, 0) AS credit_balance,
+	 COALESCE(balances.debits, 0) AS debit_balance,
+	 COALESCE(balances.version, 0) AS version
+	 FROM accounts
+	 LEFT JOIN account_balances AS balances
+	 ON balances.account_id = accounts.id
+	 WHERE accounts.id = $1
+	 ORDER BY currency
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, acctID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances, err := scanAccountBalances(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(balances) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	// reduce each balance by its active holds. The overdraft or credit limit only extends the account's own currency
+	held, err := heldAmounts(ctx, r.db, acctID)
+	if err != nil {
+		return nil, err
+	}
+	var account models.Account
+	query = `SELECT currency, type, overdraft_limit, credit_limit FROM accounts WHERE id = $1`
+	if err := r.db.QueryRowContext(ctx, query, acctID).Scan(&account.Currency, &account.Type, &account.OverdraftLimit, &account.CreditLimit); err != nil {
+		return nil, err
+	}
+	for _, balance := range balances {
+		amount := held[balance.Currency]
+		available := balance.Balance - amount
+		if balance.Currency == account.Currency {
+			available += int64(account.Limit())
+		}
+		balance.Held, balance.Available = &amount, &available
+	}
+	return balances, nil
+}
+
+// GetTrialBalance retrieves the balances of all accounts in the chart of accounts, per currency
+func (r *TransactionRepository) GetTrialBalance(ctx context.Context) (*models.TrialBalance, error) {
+	query := `
+	 SELECT
+	 accounts.id,
+	 accounts.account_number,
+	 accounts.class,
+	 COALESCE(balances.currency, accounts.currency) AS currency,
+	 COALESCE(balances.credits, 0) AS credit_balance,
+	 COALESCE(balances.debits, 0) AS debit_balance,
+	 COALESCE(balances.version, 0) AS version
+	 FROM accounts
+	 LEFT JOIN account_balances AS balances
+	 ON balances.account_id = accounts.id
+	 ORDER BY currency, accounts.class, accounts.account_number
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances, err := scanAccountBalances(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	report := models.TrialBalance{
+		Accounts:   balances,
+		Currencies: make(map[models.Currency]*models.CurrencyTotals),
+		Balanced:   true,
+	}
+	for _, balance := range balances {
+		totals, ok := report.Currencies[balance.Currency]
+		if !ok {
+			totals = &models.CurrencyTotals{ClassTotals: make(map[models.AccountClass]int64)}
+			report.Currencies[balance.Currency] = totals
+		}
+		totals.ClassTotals[balance.Class] += balance.Balance
+		totals.TotalDebits += balance.Debits
+		totals.TotalCredits += balance.Credits
+	}
+
+	for _, totals := range report.Currencies {
+		totals.Balanced = totals.TotalDebits == totals.TotalCredits
+		report.Balanced = report.Balanced && totals.Balanced
+	}
+	return &report, nil
+}
+
+// RebuildBalances recomputes every materialized balance from the transaction lines and reports the balances that drifted. Drifted balances are only corrected when
+// apply is set, in which case postings are blocked until the rebuild completes
+func (r *TransactionRepository) RebuildBalances(ctx context.Context, apply bool) ([]*models.BalanceDrift, error) {
+	// a single snapshot keeps lines and balances consistent with each other while reading
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: !apply})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if apply {
+		if _, err := tx.ExecContext(ctx, `LOCK TABLE transaction_lines IN SHARE MODE`); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+	 WITH actual AS (
+		SELECT
+		account_id,
+		currency,
+		COALESCE(SUM(CASE WHEN purpose = $1 THEN amount END), 0) AS credits,
+		COALESCE(SUM(CASE WHEN purpose = $2 THEN amount END), 0) AS debits
+		FROM transaction_lines
+		GROUP BY account_id, currency
+	 )
+	 SELECT
+	 COALESCE(actual.account_id, balances.account_id) AS account_id,
+	 COALESCE(actual.currency, balances.currency) AS currency,
+	 COALESCE(balances.debits, 0) AS stored_debits,
+	 COALESCE(balances.credits, 0) AS stored_credits,
+	 COALESCE(actual.debits, 0) AS actual_debits,
+	 COALESCE(actual.credits, 0) AS actual_credits
+	 FROM actual
+	 FULL OUTER JOIN account_balances AS balances
+	 ON balances.account_id = actual.account_id AND balances.currency = actual.currency
+	 WHERE COALESCE(balances.debits, 0) <> COALESCE(actual.debits, 0)
+	 OR COALESCE(balances.credits, 0) <> COALESCE(actual.credits, 0)
+	 ORDER BY account_id, currency
+	 `
+
+	rows, err := tx.QueryContext(ctx, query, models.CREDIT, models.DEBIT)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drifts := []*models.BalanceDrift{}
+	for rows.Next() {
+		var drift models.BalanceDrift
+		if err := rows.Scan(&drift.AccountID, &drift.Currency, &drift.StoredDebits, &drift.StoredCredits, &drift.ActualDebits, &drift.ActualCredits); err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, &drift)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !apply || len(drifts) == 0 {
+		return drifts, nil
+	}
+
+	// overwrite drifted balances with the recomputed totals
+	query = `
+		INSERT INTO account_balances (account_id, currency, debits, credits, version)
+		VALUES ($1, $2, $3, $4, 1)
+		ON CONFLICT (account_id, currency) DO UPDATE
+		SET debits = EXCLUDED.debits,
+		credits = EXCLUDED.credits,
+		version = account_balances.version + 1,
+		updated_at = NOW()
+	`
+	for _, drift := range drifts {
+		if _, err := tx.ExecContext(ctx, query, drift.AccountID, drift.Currency, drift.ActualDebits, drift.ActualCredits); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return drifts, nil
+}
+
+// scanAccountBalances reads rows of (account id, account number, class, currency, credits, debits, version) into account balances
+func scanAccountBalances(rows *sql.Rows) ([]*models.AccountBalance, error) {
+	balances := []*models.AccountBalance{}
+	for rows.Next() {
+		var balance models.AccountBalance
+		if err := rows.Scan(&balance.AccountID, &balance.AccountNumber, &balance.Class, &balance.Currency, &balance.Credits, &balance.Debits, &balance.Version); err != nil {
+			return nil, err
+		}
+		balance.NormalSide = balance.Class.NormalSide()
+		balance.Balance = balance.Class.Balance(balance.Credits, balance.Debits)
+		balance.FormattedBalance = balance.Currency.Format(balance.Balance)
+		balances = append(balances, &balance)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// GetAccountBalancesAsOf retrieves the balances of an account in every currency as they stood at the given time. The latest checkpoint at or before that time is used
+// as the starting point so that only the lines posted after it are scanned
+func (r *TransactionRepository) GetAccountBalancesAsOf(ctx context.Context, acctID uuid.UUID, asOf time.Time) ([]*models.AccountBalance, error) {
+	query := `
+	 WITH checkpoint AS (
+		SELECT DISTINCT ON (currency) currency, as_of, debits, credits
+		FROM balance_checkpoints
+		WHERE account_id = $3 AND as_of <= $4
+		ORDER BY currency, as_of DESC
+	 ),
+	 delta AS (
+		SELECT
+		lines.currency,
+		COALESCE(SUM(CASE WHEN lines.purpose = $1 THEN lines.amount END), 0) AS credits,
+		COALESCE(SUM(CASE WHEN lines.purpose = $2 THEN lines.amount END), 0) AS debits
+		FROM transaction_lines AS lines
+		LEFT JOIN checkpoint
+		ON checkpoint.currency = lines.currency
+		WHERE lines.account_id = $3 AND lines.created_at <= $4
+		AND (checkpoint.as_of IS NULL OR lines.created_at > checkpoint.as_of)
+		GROUP BY lines.currency
+	 ),
+	 totals AS (
+		SELECT
+		COALESCE(delta.currency, checkpoint.currency) AS currency,
+		COALESCE(checkpoint.credits, 0) + COALESCE(delta.credits, 0) AS credits,
+		COALESCE(checkpoint.debits, 0) + COALESCE(delta.debits, 0) AS debits
+		FROM delta
+		FULL OUTER JOIN checkpoint
+		ON checkpoint.currency = delta.currency
+	 )
+	 SELECT
+	 accounts.id,
+	 accounts.account_number,
+	 accounts.class,
+	 COALESCE(totals.currency, accounts.currency) AS currency,
+	 COALESCE(totals.credits, 0) AS credit_balance,
+	 COALESCE(totals.debits, 0) AS debit_balance,
+	 0 AS version
+	 FROM accounts
+	 LEFT JOIN totals ON TRUE
+	 WHERE accounts.id = $3
+	 ORDER BY currency
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, models.CREDIT, models.DEBIT, acctID, asOf)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances, err := scanAccountBalances(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(balances) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	for _, balance := range balances {
+		balance.AsOf = &asOf
+	}
+	return balances, nil
+}
+
+// CreateBalanceCheckpoints records the cumulative totals of every account and currency with activity since its previous checkpoint, as at the given time.
+// Checkpoints are immutable, so re-running for the same time is a no-op. The time should be far enough in the past that no posting dated before it is still in flight
+func (r *TransactionRepository) CreateBalanceCheckpoints(ctx context.Context, asOf time.Time) (int64, error) {
+	query := `
+	 WITH prev AS (
+		SELECT DISTINCT ON (account_id, currency) account_id, currency, as_of, debits, credits
+		FROM balance_checkpoints
+		WHERE as_of < $3
+		ORDER BY account_id, currency, as_of DESC
+	 ),
+	 delta AS (
+		SELECT
+		lines.account_id,
+		lines.currency,
+		COALESCE(SUM(CASE WHEN lines.purpose = $1 THEN lines.amount END), 0) AS credits,
+		COALESCE(SUM(CASE WHEN lines.purpose = $2 THEN lines.amount END), 0) AS debits
+		FROM transaction_lines AS lines
+		LEFT JOIN prev
+		ON prev.account_id = lines.account_id AND prev.currency = lines.currency
+		WHERE lines.created_at <= $3
+		AND (prev.as_of IS NULL OR lines.created_at > prev.as_of)
+		GROUP BY lines.account_id, lines.currency
+	 )
+	 INSERT INTO balance_checkpoints (account_id, currency, as_of, debits, credits)
+	 SELECT
+	 delta.account_id,
+	 delta.currency,
+	 $3,
+	 COALESCE(prev.debits, 0) + delta.debits,
+	 COALESCE(prev.credits, 0) + delta.credits
+	 FROM delta
+	 LEFT JOIN prev
+	 ON prev.account_id = delta.account_id AND prev.currency = delta.currency
+	 ON CONFLICT (account_id, currency, as_of) DO NOTHING
+	 `
+
+	result, err := r.db.ExecContext(ctx, query, models.CREDIT, models.DEBIT, asOf)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
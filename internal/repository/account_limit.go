@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// SetAccountLimit changes the overdraft limit of a deposit account or the credit limit of a credit line and records the approved change in the audit trail. The account
+// is locked while its limit changes, so postings against it either see the old limit or the new one
+func (r *AccountRepository) SetAccountLimit(ctx context.Context, data *models.SetAccountLimit) (*models.Account, *models.LimitChange, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	 SELECT type, overdraft_limit, credit_limit FROM accounts
+	 WHERE deleted_at IS NULL AND id = $1
+	 FOR UPDATE
+	 `
+	var current models.Account
+	if err := tx.QueryRowContext(ctx, query, data.AccountID).Scan(&current.Type, &current.OverdraftLimit, &current.CreditLimit); err != nil {
+		return nil, nil, err
+	}
+
+	kind, column := models.OVERDRAFT_LIMIT, "overdraft_limit"
+	if current.Type == models.CREDIT_LINE {
+		kind, column = models.CREDIT_LIMIT, "credit_limit"
+	}
+
+	query = `
+	 UPDATE accounts
+	 SET ` + column + ` = $2, updated_at = NOW()
+	 WHERE id = $1
+	 RETURNING id, account_number, user_id, class, currency, type, overdraft_limit, credit_limit, created_at, updated_at, deleted_at
+	 `
+	var account models.Account
+	if err := tx.QueryRowContext(ctx, query, data.AccountID, data.Limit).Scan(&account.ID, &account.AccountNumber, &account.UserID, &account.Class, &account.Currency, &account.Type, &account.OverdraftLimit, &account.CreditLimit, &account.CreatedAt, &account.UpdatedAt, &account.DeletedAt); err != nil {
+		return nil, nil, err
+	}
+
+	query = `
+		INSERT INTO account_limit_changes (account_id, kind, previous_limit, new_limit, approved_by, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, account_id, kind, previous_limit, new_limit, approved_by, reason, created_at
+	`
+	var change models.LimitChange
+	if err := tx.QueryRowContext(ctx, query, data.AccountID, kind, current.Limit(), data.Limit, data.ApprovedBy, data.Reason).Scan(&change.ID, &change.AccountID, &change.Kind, &change.PreviousLimit, &change.NewLimit, &change.ApprovedBy, &change.Reason, &change.CreatedAt); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return &account, &change, nil
+}
+
+// GetLimitChanges retrieves the approval audit trail of an account's limits, oldest first
+func (r *AccountRepository) GetLimitChanges(ctx context.Context, acctID uuid.UUID) ([]*models.LimitChange, error) {
+	query := `
+	 SELECT id, account_id, kind, previous_limit, new_limit, approved_by, reason, created_at FROM account_limit_changes
+	 WHERE account_id = $1
+	 ORDER BY created_at, id
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, acctID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*models.LimitChange{}
+	for rows.Next() {
+		var change models.LimitChange
+		if err := rows.Scan(&change.ID, &change.AccountID, &change.Kind, &change.PreviousLimit, &change.NewLimit, &change.ApprovedBy, &change.Reason, &change.CreatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, &change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// APIKeyRepository handles database operations for api keys
+type APIKeyRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewAPIKeyRepository creates a new api key repository
+func NewAPIKeyRepository(db *sql.DB, logger *slog.Logger) *APIKeyRepository {
+	return &APIKeyRepository{db: db, logger: logger}
+}
+
+// apiKeyColumns lists the columns scanned by scanAPIKey. The hash of the key is never read back
+const apiKeyColumns = `id, user_id, name, prefix, rotated_from, expires_at, revoked_at, created_at, updated_at`
+
+// CreateAPIKey stores the hash of a newly issued api key
+func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, data *models.CreateAPIKey) (*models.APIKey, error) {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + apiKeyColumns
+	return scanAPIKey(r.db.QueryRowContext(ctx, query, data.UserID, data.Name, data.Prefix, data.KeyHash, data.ExpiresAt))
+}
+
+// GetActiveAPIKeyByHash retrieves the api key with the given hash when it is neither revoked nor expired
+func (r *APIKeyRepository) GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	query := `
+	 SELECT ` + apiKeyColumns + ` FROM api_keys
+	 WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	 `
+	return scanAPIKey(r.db.QueryRowContext(ctx, query, keyHash))
+}
+
+// GetActiveAPIKey retrieves an api key of the user when it is neither revoked nor expired
+func (r *APIKeyRepository) GetActiveAPIKey(ctx context.Context, id, userID uuid.UUID) (*models.APIKey, error) {
+	query := `
+	 SELECT ` + apiKeyColumns + ` FROM api_keys
+	 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	 `
+	return scanAPIKey(r.db.QueryRowContext(ctx, query, id, userID))
+}
+
+// GetAPIKeysByUserID retrieves every api key issued to a user, most recent first
+func (r *APIKeyRepository) GetAPIKeysByUserID(ctx context.Context, userID uuid.UUID) ([]*models.APIKey, error) {
+	query := `
+	 SELECT ` + apiKeyColumns + ` FROM api_keys
+	 WHERE user_id = $1
+	 ORDER BY created_at DESC, id
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RotateAPIKey replaces an active api key of the user with a new one named after it. The replaced key keeps working until graceUntil, or its own expiry when
+// that comes first. A key is only ever replaced once, so rotating it again fails with a unique violation
+func (r *APIKeyRepository) RotateAPIKey(ctx context.Context, id uuid.UUID, data *models.CreateAPIKey, graceUntil time.Time) (*models.APIKey, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	 UPDATE api_keys
+	 SET expires_at = LEAST(COALESCE(expires_at, $3), $3), updated_at = NOW()
+	 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	 RETURNING name
+	 `
+	var name string
+	if err := tx.QueryRowContext(ctx, query, id, data.UserID, graceUntil).Scan(&name); err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, rotated_from, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + apiKeyColumns
+	key, err := scanAPIKey(tx.QueryRowContext(ctx, query, data.UserID, name, data.Prefix, data.KeyHash, id, data.ExpiresAt))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// RevokeAPIKey stops an api key of the user from authenticating at once
+func (r *APIKeyRepository) RevokeAPIKey(ctx context.Context, id, userID uuid.UUID) (*models.APIKey, error) {
+	query := `
+	 UPDATE api_keys
+	 SET revoked_at = NOW(), updated_at = NOW()
+	 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	 RETURNING ` + apiKeyColumns
+	return scanAPIKey(r.db.QueryRowContext(ctx, query, id, userID))
+}
+
+func scanAPIKey(row scanner) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.RotatedFrom, &key.ExpiresAt, &key.RevokedAt, &key.CreatedAt, &key.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
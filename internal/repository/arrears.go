@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// customerBalances computes the balance of every active customer account in its own currency, along with its overdraft or credit limit
+const customerBalances = `
+	 WITH customer_balances AS (
+		SELECT
+		accounts.id,
+		accounts.currency,
+		CASE WHEN accounts.class IN ('asset', 'expense') THEN balances.debits - balances.credits ELSE balances.credits - balances.debits END AS balance,
+		CASE WHEN accounts.type = 'credit_line' THEN accounts.credit_limit ELSE accounts.overdraft_limit END AS limit_amount
+		FROM accounts
+		JOIN account_balances AS balances
+		ON balances.account_id = accounts.id AND balances.currency = accounts.currency
+		WHERE accounts.user_id <> $1 AND accounts.deleted_at IS NULL
+	 )
+	 `
+
+// UpdateArrears records every customer account with a negative balance and flags those in arrears: accounts below their limit, and deposit accounts that have been
+// overdrawn since before the grace cutoff. Accounts back at zero or above are cleared. It returns the number of accounts newly flagged
+func (r *TransactionRepository) UpdateArrears(ctx context.Context, now time.Time, grace time.Duration) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// clear accounts that are no longer negative
+	query := customerBalances + `
+	 DELETE FROM account_arrears
+	 WHERE account_id NOT IN (SELECT id FROM customer_balances WHERE balance < 0)
+	 `
+	if _, err := tx.ExecContext(ctx, query, models.SystemUserID); err != nil {
+		return 0, err
+	}
+
+	// track negative accounts, keeping the time they were first seen negative
+	query = customerBalances + `
+	 INSERT INTO account_arrears (account_id, currency, balance, limit_amount, negative_since)
+	 SELECT id, currency, balance, limit_amount, $2 FROM customer_balances
+	 WHERE balance < 0
+	 ON CONFLICT (account_id) DO UPDATE
+	 SET balance = EXCLUDED.balance, limit_amount = EXCLUDED.limit_amount, updated_at = NOW()
+	 `
+	if _, err := tx.ExecContext(ctx, query, models.SystemUserID, now); err != nil {
+		return 0, err
+	}
+
+	// flagged accounts that went over their limit are reported as such
+	query = `
+	 UPDATE account_arrears
+	 SET reason = $1
+	 WHERE flagged_at IS NOT NULL AND balance < -limit_amount
+	 `
+	if _, err := tx.ExecContext(ctx, query, models.ARREARS_OVER_LIMIT); err != nil {
+		return 0, err
+	}
+
+	query = `
+	 UPDATE account_arrears AS arrears
+	 SET reason = CASE WHEN arrears.balance < -arrears.limit_amount THEN $1 ELSE $2 END, flagged_at = $3
+	 FROM accounts
+	 WHERE accounts.id = arrears.account_id AND arrears.flagged_at IS NULL
+	 AND (arrears.balance < -arrears.limit_amount OR (accounts.type = $4 AND arrears.negative_since <= $5))
+	 `
+	result, err := tx.ExecContext(ctx, query, models.ARREARS_OVER_LIMIT, models.ARREARS_OVERDRAWN, now, models.DEPOSIT, now.Add(-grace))
+	if err != nil {
+		return 0, err
+	}
+	flagged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return flagged, nil
+}
+
+// GetArrears retrieves the accounts currently flagged as in arrears, longest negative first
+func (r *TransactionRepository) GetArrears(ctx context.Context) ([]*models.AccountArrears, error) {
+	query := `
+	 SELECT
+	 arrears.account_id,
+	 accounts.account_number,
+	 accounts.type,
+	 arrears.currency,
+	 arrears.balance,
+	 arrears.limit_amount,
+	 arrears.negative_since,
+	 arrears.reason,
+	 arrears.flagged_at
+	 FROM account_arrears AS arrears
+	 JOIN accounts
+	 ON accounts.id = arrears.account_id
+	 WHERE arrears.flagged_at IS NOT NULL
+	 ORDER BY arrears.negative_since, accounts.account_number
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	arrears := []*models.AccountArrears{}
+	for rows.Next() {
+		var a models.AccountArrears
+		if err := rows.Scan(&a.AccountID, &a.AccountNumber, &a.Type, &a.Currency, &a.Balance, &a.Limit, &a.NegativeSince, &a.Reason, &a.FlaggedAt); err != nil {
+			return nil, err
+		}
+		arrears = append(arrears, &a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return arrears, nil
+}
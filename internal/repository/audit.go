@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// AuditRepository handles database operations for the append-only audit log of api calls
+type AuditRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewAuditRepository creates a new audit repository
+func NewAuditRepository(db *sql.DB, logger *slog.Logger) *AuditRepository {
+	return &AuditRepository{db: db, logger: logger}
+}
+
+// auditColumns lists the columns scanned by scanAuditEntry
+const auditColumns = `id, actor_id, actor_role, auth_method, credential_id, method, route, path, entity_type, entity_id, status, ip_address, user_agent, request_digest, response_digest, created_at`
+
+// RecordEntry appends an api call to the audit log. It is written outside the database transaction of the request, so calls that failed are kept too
+func (r *AuditRepository) RecordEntry(ctx context.Context, data *models.CreateAuditEntry) error {
+	query := `
+		INSERT INTO audit_log (actor_id, actor_role, auth_method, credential_id, method, route, path, entity_type, entity_id, status, ip_address, user_agent, request_digest, response_digest)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	`
+	_, err := r.db.ExecContext(ctx, query, data.ActorID, data.ActorRole, data.AuthMethod, data.CredentialID, data.Method, data.Route, data.Path, data.EntityType,
+		data.EntityID, data.Status, data.IPAddress, data.UserAgent, data.RequestDigest, data.ResponseDigest)
+	return err
+}
+
+// GetEntries retrieves the audit entries matching the filter, newest first
+func (r *AuditRepository) GetEntries(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEntry, error) {
+	query := `
+	 SELECT ` + auditColumns + ` FROM audit_log
+	 WHERE ($1::UUID IS NULL OR actor_id = $1)
+	  AND ($2 = '' OR entity_type = $2)
+	  AND ($3 = '' OR entity_id = $3)
+	  AND ($4::TIMESTAMPTZ IS NULL OR created_at >= $4)
+	  AND ($5::TIMESTAMPTZ IS NULL OR created_at < $5)
+	  AND ($6 = 0 OR id < $6)
+	 ORDER BY id DESC
+	 LIMIT $7
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, filter.ActorID, filter.EntityType, filter.EntityID, filter.From, filter.To, filter.Before, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*models.AuditEntry{}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func scanAuditEntry(row scanner) (*models.AuditEntry, error) {
+	var entry models.AuditEntry
+	if err := row.Scan(&entry.ID, &entry.ActorID, &entry.ActorRole, &entry.AuthMethod, &entry.CredentialID, &entry.Method, &entry.Route, &entry.Path, &entry.EntityType,
+		&entry.EntityID, &entry.Status, &entry.IPAddress, &entry.UserAgent, &entry.RequestDigest, &entry.ResponseDigest, &entry.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// CredentialRepository handles database operations for the passwords, TOTP secrets and recovery codes of users
+type CredentialRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewCredentialRepository creates a new credential repository
+func NewCredentialRepository(db *sql.DB, logger *slog.Logger) *CredentialRepository {
+	return &CredentialRepository{db: db, logger: logger}
+}
+
+// credentialColumns lists the columns scanned by scanCredentials
+const credentialColumns = `user_id, COALESCE(password_hash, ''), COALESCE(totp_secret, ''), totp_enabled_at, totp_last_step, failed_logins, locked_until`
+
+// GetCredentials retrieves the credentials of a user
+func (r *CredentialRepository) GetCredentials(ctx context.Context, userID uuid.UUID) (*models.Credentials, error) {
+	query := `SELECT ` + credentialColumns + ` FROM user_credentials WHERE user_id = $1`
+	return scanCredentials(r.db.QueryRowContext(ctx, query, userID))
+}
+
+// SetPassword stores a new password hash for the user and revokes every session of theirs except the one it was changed from, if any
+func (r *CredentialRepository) SetPassword(ctx context.Context, userID uuid.UUID, passwordHash string, keepSession *uuid.UUID) error {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setPassword(ctx, tx, userID, passwordHash, keepSession); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordFailedLogin counts a failed login of the user. Reaching maxAttempts locks the user out until lockout has passed and starts the count over
+func (r *CredentialRepository) RecordFailedLogin(ctx context.Context, userID uuid.UUID, maxAttempts int, lockout time.Duration) (*models.Credentials, error) {
+	query := `
+	 UPDATE user_credentials
+	 SET failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+	  locked_until = CASE WHEN failed_logins + 1 >= $2 THEN NOW() + make_interval(secs => $3) ELSE locked_until END,
+	  updated_at = NOW()
+	 WHERE user_id = $1
+	 RETURNING ` + credentialColumns
+	return scanCredentials(r.db.QueryRowContext(ctx, query, userID, maxAttempts, lockout.Seconds()))
+}
+
+// RecordLogin clears the failed logins of the user after a successful one. A TOTP code used to log in is claimed by its time step, and sql.ErrNoRows is
+// returned when a concurrent login already claimed it
+func (r *CredentialRepository) RecordLogin(ctx context.Context, userID uuid.UUID, totpStep int64) error {
+	query := `
+	 UPDATE user_credentials
+	 SET failed_logins = 0, locked_until = NULL, totp_last_step = GREATEST(totp_last_step, $2), updated_at = NOW()
+	 WHERE user_id = $1 AND ($2 = 0 OR totp_last_step < $2)
+	 `
+	return expectRow(r.db.ExecContext(ctx, query, userID, totpStep))
+}
+
+// StartTOTP stores a new pending TOTP secret for the user. It fails with sql.ErrNoRows when the user has no password or TOTP is already enabled
+func (r *CredentialRepository) StartTOTP(ctx context.Context, userID uuid.UUID, secret string) error {
+	query := `
+	 UPDATE user_credentials SET totp_secret = $2, updated_at = NOW()
+	 WHERE user_id = $1 AND password_hash IS NOT NULL AND totp_enabled_at IS NULL
+	 `
+	return expectRow(r.db.ExecContext(ctx, query, userID, secret))
+}
+
+// EnableTOTP enables the pending TOTP secret of the user, claiming the time step of the code that confirmed it, and replaces their recovery codes
+func (r *CredentialRepository) EnableTOTP(ctx context.Context, userID uuid.UUID, totpStep int64, codeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	 UPDATE user_credentials SET totp_enabled_at = NOW(), totp_last_step = $2, updated_at = NOW()
+	 WHERE user_id = $1 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL
+	 `
+	if err := expectRow(tx.ExecContext(ctx, query, userID, totpStep)); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes of the user
+func (r *CredentialRepository) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	 UPDATE user_credentials SET totp_secret = NULL, totp_enabled_at = NULL, updated_at = NOW()
+	 WHERE user_id = $1 AND totp_secret IS NOT NULL
+	 `
+	if err := expectRow(tx.ExecContext(ctx, query, userID)); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes invalidates the recovery codes of the user in favour of new ones
+func (r *CredentialRepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks an unused recovery code of the user as used. It fails with sql.ErrNoRows when no such code is left
+func (r *CredentialRepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error {
+	query := `
+	 UPDATE recovery_codes SET used_at = NOW()
+	 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	 `
+	return expectRow(r.db.ExecContext(ctx, query, userID, codeHash))
+}
+
+// setPassword stores the password hash of the user, lifting any lockout, and revokes every session of theirs but keepSession within the provided database
+// transaction
+func setPassword(ctx context.Context, tx *sql.Tx, userID uuid.UUID, passwordHash string, keepSession *uuid.UUID) error {
+	query := `
+		INSERT INTO user_credentials (user_id, password_hash)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET password_hash = EXCLUDED.password_hash, failed_logins = 0, locked_until = NULL, updated_at = NOW()
+	`
+	if _, err := tx.ExecContext(ctx, query, userID, passwordHash); err != nil {
+		return err
+	}
+
+	query = `
+	 UPDATE sessions SET revoked_at = NOW()
+	 WHERE user_id = $1 AND revoked_at IS NULL AND ($2::UUID IS NULL OR id <> $2)
+	 `
+	_, err := tx.ExecContext(ctx, query, userID, keepSession)
+	return err
+}
+
+// replaceRecoveryCodes deletes the recovery codes of the user and stores the given ones within the provided database transaction
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID uuid.UUID, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if len(codeHashes) == 0 {
+		return nil
+	}
+	query := `
+	 INSERT INTO recovery_codes (user_id, code_hash)
+	 SELECT $1, UNNEST($2::TEXT[])
+	 `
+	_, err := tx.ExecContext(ctx, query, userID, pq.Array(codeHashes))
+	return err
+}
+
+// expectRow turns the result of a statement that changed no rows into sql.ErrNoRows
+func expectRow(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanCredentials(row scanner) (*models.Credentials, error) {
+	var credentials models.Credentials
+	if err := row.Scan(&credentials.UserID, &credentials.PasswordHash, &credentials.TOTPSecret, &credentials.TOTPEnabledAt, &credentials.TOTPLastStep,
+		&credentials.FailedLogins, &credentials.LockedUntil); err != nil {
+		return nil, err
+	}
+	return &credentials, nil
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/auth"
+	dbpkg "github.com/mrshabel/sgbank/internal/db"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// TestUseRecoveryCode checks that each recovery code signs in once. It runs against the postgres database in TEST_DATABASE_URL, which is migrated up first
+func TestUseRecoveryCode(t *testing.T) {
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db, err := dbpkg.New(connStr, logger)
+	if err != nil {
+		t.Fatalf("connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := dbpkg.MigrateUp(ctx, db, logger); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+
+	user, err := NewUserRepository(db, logger).CreateUser(ctx, &models.CreateUser{Email: uuid.NewString() + "@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("generate recovery codes: %v", err)
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+
+	repo := NewCredentialRepository(db, logger)
+	if err := repo.ReplaceRecoveryCodes(ctx, user.ID, hashes); err != nil {
+		t.Fatalf("store recovery codes: %v", err)
+	}
+
+	if err := repo.UseRecoveryCode(ctx, user.ID, hashes[0]); err != nil {
+		t.Fatalf("use recovery code: %v", err)
+	}
+	if err := repo.UseRecoveryCode(ctx, user.ID, hashes[0]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("reusing a recovery code = %v, want %v", err, sql.ErrNoRows)
+	}
+	// the other codes are still good, but only to their own user
+	if err := repo.UseRecoveryCode(ctx, uuid.New(), hashes[1]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("using the code of another user = %v, want %v", err, sql.ErrNoRows)
+	}
+	if err := repo.UseRecoveryCode(ctx, user.ID, hashes[1]); err != nil {
+		t.Errorf("use another recovery code: %v", err)
+	}
+
+	// new codes replace the unused ones
+	if err := repo.ReplaceRecoveryCodes(ctx, user.ID, []string{auth.HashRecoveryCode("aaaa-bbbb-cccc-dddd")}); err != nil {
+		t.Fatalf("replace recovery codes: %v", err)
+	}
+	if err := repo.UseRecoveryCode(ctx, user.ID, hashes[2]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("using a replaced recovery code = %v, want %v", err, sql.ErrNoRows)
+	}
+}
@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// errors
+var (
+	ErrFeeScheduleEnded = errors.New("fee schedule is no longer in force at the given time")
+)
+
+// FeeRepository handles database operations for fee schedules and the fees charged under them
+type FeeRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewFeeRepository creates a new fee repository
+func NewFeeRepository(db *sql.DB, logger *slog.Logger) *FeeRepository {
+	return &FeeRepository{db: db, logger: logger}
+}
+
+// feeScheduleColumns lists the columns scanned by scanFeeSchedule
+const feeScheduleColumns = `id, name, kind, currency, account_type, user_id, flat_amount, rate::text, min_amount, max_amount, tiers, supersedes, effective_from, effective_to, created_at, updated_at`
+
+// CreateSchedule adds a new fee schedule to the database
+func (r *FeeRepository) CreateSchedule(ctx context.Context, data *models.CreateFeeSchedule) (*models.FeeSchedule, error) {
+	return createFeeSchedule(ctx, r.db, data, nil)
+}
+
+// GetSchedules retrieves the fee schedules in force at the given time, or every schedule ever defined when no time is given
+func (r *FeeRepository) GetSchedules(ctx context.Context, at *time.Time) ([]*models.FeeSchedule, error) {
+	query := `
+	 SELECT ` + feeScheduleColumns + ` FROM fee_schedules
+	 WHERE $1::timestamptz IS NULL OR (effective_from <= $1 AND (effective_to IS NULL OR effective_to > $1))
+	 ORDER BY currency, name, effective_from
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []*models.FeeSchedule{}
+	for rows.Next() {
+		schedule, err := scanFeeSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// GetScheduleByID retrieves a fee schedule by its ID
+func (r *FeeRepository) GetScheduleByID(ctx context.Context, id uuid.UUID) (*models.FeeSchedule, error) {
+	query := `SELECT ` + feeScheduleColumns + ` FROM fee_schedules WHERE id = $1`
+	return scanFeeSchedule(r.db.QueryRowContext(ctx, query, id))
+}
+
+// SupersedeSchedule replaces a fee schedule from the effective time of the new version onwards. The old version stays in force until then, so fees charged
+// before the change keep pointing at the rules they were priced with
+func (r *FeeRepository) SupersedeSchedule(ctx context.Context, id uuid.UUID, data *models.CreateFeeSchedule) (*models.FeeSchedule, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := endFeeSchedule(ctx, tx, id, data.EffectiveFrom); err != nil {
+		return nil, err
+	}
+	schedule, err := createFeeSchedule(ctx, tx, data, &id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// EndSchedule takes a fee schedule out of force from the given time
+func (r *FeeRepository) EndSchedule(ctx context.Context, id uuid.UUID, at time.Time) (*models.FeeSchedule, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := endFeeSchedule(ctx, tx, id, at); err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + feeScheduleColumns + ` FROM fee_schedules WHERE id = $1`
+	schedule, err := scanFeeSchedule(tx.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// GetApplicableSchedules retrieves the fee schedules that price a transfer from the given account in the given currency at the given time. Only the most specifically
+// scoped schedules matching the account are returned
+func (r *FeeRepository) GetApplicableSchedules(ctx context.Context, tx *sql.Tx, acct *models.Account, currency models.Currency, at time.Time) ([]*models.FeeSchedule, error) {
+	query := `
+	 SELECT ` + feeScheduleColumns + ` FROM fee_schedules
+	 WHERE currency = $1 AND effective_from <= $4 AND (effective_to IS NULL OR effective_to > $4)
+	 AND (user_id IS NULL OR user_id = $2) AND (account_type IS NULL OR account_type = $3)
+	 ORDER BY name, id
+	 `
+
+	rows, err := tx.QueryContext(ctx, query, currency, acct.UserID, acct.Type, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []*models.FeeSchedule
+	specificity := 0
+	for rows.Next() {
+		schedule, err := scanFeeSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		switch s := schedule.Specificity(); {
+		case s > specificity:
+			specificity, schedules = s, []*models.FeeSchedule{schedule}
+		case s == specificity:
+			schedules = append(schedules, schedule)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// GetMonthlyVolume retrieves the amount an account has sent in the given currency since the start of the month of the given time, excluding the fees it was charged
+func (r *FeeRepository) GetMonthlyVolume(ctx context.Context, tx *sql.Tx, acctID uuid.UUID, currency models.Currency, at time.Time) (uint64, error) {
+	query := `
+	 SELECT
+	 COALESCE((SELECT SUM(amount) FROM transaction_lines WHERE account_id = $1 AND currency = $2 AND purpose = $3 AND created_at >= $4), 0)
+	 - COALESCE((SELECT SUM(amount) FROM transaction_fees WHERE account_id = $1 AND currency = $2 AND created_at >= $4), 0)
+	 `
+
+	at = at.UTC()
+	monthStart := time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var volume int64
+	if err := tx.QueryRowContext(ctx, query, acctID, currency, models.DEBIT, monthStart).Scan(&volume); err != nil {
+		return 0, err
+	}
+	return uint64(max(volume, 0)), nil
+}
+
+// RecordFees records the fees charged on a transaction within the provided database transaction
+func (r *FeeRepository) RecordFees(ctx context.Context, tx *sql.Tx, transactionID uuid.UUID, fees []models.TransactionFee) ([]models.TransactionFee, error) {
+	query := `
+		INSERT INTO transaction_fees (transaction_id, account_id, schedule_id, name, amount, currency)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, transaction_id, account_id, schedule_id, name, amount, currency, created_at
+	`
+
+	recorded := make([]models.TransactionFee, 0, len(fees))
+	for _, f := range fees {
+		var fee models.TransactionFee
+		if err := tx.QueryRowContext(ctx, query, transactionID, f.AccountID, f.ScheduleID, f.Name, f.Amount, f.Currency).Scan(&fee.ID, &fee.TransactionID, &fee.AccountID, &fee.ScheduleID, &fee.Name, &fee.Amount, &fee.Currency, &fee.CreatedAt); err != nil {
+			return nil, err
+		}
+		recorded = append(recorded, fee)
+	}
+	return recorded, nil
+}
+
+// RecordRefunds records that the given fees were paid back by the refund transaction, within the provided database transaction. A fee that was already refunded
+// fails with a unique violation
+func (r *FeeRepository) RecordRefunds(ctx context.Context, tx *sql.Tx, transactionID uuid.UUID, fees []models.TransactionFee) error {
+	query := `INSERT INTO fee_refunds (transaction_fee_id, transaction_id) VALUES ($1, $2)`
+
+	for _, fee := range fees {
+		if _, err := tx.ExecContext(ctx, query, fee.ID, transactionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTransactionFees retrieves the fees charged on a transaction
+func (r *FeeRepository) GetTransactionFees(ctx context.Context, transactionID uuid.UUID) ([]models.TransactionFee, error) {
+	query := `
+	 SELECT id, transaction_id, account_id, schedule_id, name, amount, currency, created_at
+	 FROM transaction_fees
+	 WHERE transaction_id = $1
+	 ORDER BY created_at, id
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fees []models.TransactionFee
+	for rows.Next() {
+		var fee models.TransactionFee
+		if err := rows.Scan(&fee.ID, &fee.TransactionID, &fee.AccountID, &fee.ScheduleID, &fee.Name, &fee.Amount, &fee.Currency, &fee.CreatedAt); err != nil {
+			return nil, err
+		}
+		fees = append(fees, fee)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return fees, nil
+}
+
+func createFeeSchedule(ctx context.Context, q execQuerier, data *models.CreateFeeSchedule, supersedes *uuid.UUID) (*models.FeeSchedule, error) {
+	tiers, err := json.Marshal(data.Tiers)
+	if err != nil {
+		return nil, err
+	}
+	if data.Tiers == nil {
+		tiers = []byte("[]")
+	}
+
+	query := `
+		INSERT INTO fee_schedules (name, kind, currency, account_type, user_id, flat_amount, rate, min_amount, max_amount, tiers, supersedes, effective_from, effective_to)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING ` + feeScheduleColumns
+	return scanFeeSchedule(q.QueryRowContext(ctx, query, data.Name, data.Kind, data.Currency, data.AccountType, data.UserID, data.FlatAmount, data.Rate, data.MinAmount, data.MaxAmount, tiers, supersedes, data.EffectiveFrom, data.EffectiveTo))
+}
+
+// endFeeSchedule closes a schedule at the given time. The schedule must still be in force at that time
+func endFeeSchedule(ctx context.Context, tx *sql.Tx, id uuid.UUID, at time.Time) error {
+	query := `
+	 UPDATE fee_schedules
+	 SET effective_to = $2, updated_at = NOW()
+	 WHERE id = $1 AND effective_from < $2 AND (effective_to IS NULL OR effective_to > $2)
+	 `
+	result, err := tx.ExecContext(ctx, query, id, at)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	// tell a missing schedule apart from one that is not in force
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM fee_schedules WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return ErrFeeScheduleEnded
+}
+
+func scanFeeSchedule(row scanner) (*models.FeeSchedule, error) {
+	var schedule models.FeeSchedule
+	var tiers []byte
+	if err := row.Scan(&schedule.ID, &schedule.Name, &schedule.Kind, &schedule.Currency, &schedule.AccountType, &schedule.UserID, &schedule.FlatAmount, &schedule.Rate, &schedule.MinAmount, &schedule.MaxAmount, &tiers, &schedule.Supersedes, &schedule.EffectiveFrom, &schedule.EffectiveTo, &schedule.CreatedAt, &schedule.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(tiers, &schedule.Tiers); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
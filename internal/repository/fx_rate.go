@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// FXRateRepository handles database operations for foreign-exchange rates
+type FXRateRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewFXRateRepository creates a new fx rate repository
+func NewFXRateRepository(db *sql.DB, logger *slog.Logger) *FXRateRepository {
+	return &FXRateRepository{db: db, logger: logger}
+}
+
+// CreateRate adds a new fx rate to the database. Rates are never updated, a newer effective rate supersedes older ones
+func (r *FXRateRepository) CreateRate(ctx context.Context, data *models.CreateFXRate) (*models.FXRate, error) {
+	query := `
+		INSERT INTO fx_rates (base_currency, quote_currency, rate, effective_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, base_currency, quote_currency, rate, effective_at, created_at
+	`
+
+	var rate models.FXRate
+	if err := r.db.QueryRowContext(ctx, query, data.BaseCurrency, data.QuoteCurrency, data.Rate, data.EffectiveAt).Scan(&rate.ID, &rate.BaseCurrency, &rate.QuoteCurrency, &rate.Rate, &rate.EffectiveAt, &rate.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &rate, nil
+}
+
+// GetRates retrieves all rates loaded for a currency pair in either direction, most recent first
+func (r *FXRateRepository) GetRates(ctx context.Context, base, quote models.Currency) ([]*models.FXRate, error) {
+	query := `
+	 SELECT id, base_currency, quote_currency, rate, effective_at, created_at FROM fx_rates
+	 WHERE (base_currency = $1 AND quote_currency = $2) OR (base_currency = $2 AND quote_currency = $1)
+	 ORDER BY effective_at DESC, created_at DESC
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, base, quote)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []*models.FXRate{}
+	for rows.Next() {
+		var rate models.FXRate
+		if err := rows.Scan(&rate.ID, &rate.BaseCurrency, &rate.QuoteCurrency, &rate.Rate, &rate.EffectiveAt, &rate.CreatedAt); err != nil {
+			return nil, err
+		}
+		rates = append(rates, &rate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// GetEffectiveRate retrieves the rate in force for a currency pair at the given time. A rate quoted in the opposite direction is used when no direct rate exists
+func (r *FXRateRepository) GetEffectiveRate(ctx context.Context, base, quote models.Currency, at time.Time) (*models.FXRate, error) {
+	query := `
+	 SELECT id, base_currency, quote_currency, rate, effective_at, created_at FROM fx_rates
+	 WHERE ((base_currency = $1 AND quote_currency = $2) OR (base_currency = $2 AND quote_currency = $1))
+	 AND effective_at <= $3
+	 ORDER BY effective_at DESC, (base_currency = $1) DESC, created_at DESC
+	 LIMIT 1
+	 `
+
+	var rate models.FXRate
+	if err := r.db.QueryRowContext(ctx, query, base, quote, at).Scan(&rate.ID, &rate.BaseCurrency, &rate.QuoteCurrency, &rate.Rate, &rate.EffectiveAt, &rate.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &rate, nil
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// HoldRepository handles database operations for authorization holds
+type HoldRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewHoldRepository creates a new hold repository
+func NewHoldRepository(db *sql.DB, logger *slog.Logger) *HoldRepository {
+	return &HoldRepository{db: db, logger: logger}
+}
+
+// holdColumns lists the columns scanned by scanHold
+const holdColumns = `id, reference, account_id, recipient_id, amount, captured_amount, currency, status, transaction_id, expires_at, created_at, updated_at`
+
+// CreateHold places a new active hold within the provided database transaction
+func (r *HoldRepository) CreateHold(ctx context.Context, tx *sql.Tx, data *models.CreateHold) (*models.Hold, error) {
+	query := `
+		INSERT INTO holds (reference, account_id, recipient_id, amount, currency, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + holdColumns
+
+	return scanHold(tx.QueryRowContext(ctx, query, data.Reference, data.AccountID, data.RecipientID, data.Amount, data.Currency, data.ExpiresAt))
+}
+
+// GetHoldByID retrieves a hold by its ID
+func (r *HoldRepository) GetHoldByID(ctx context.Context, id uuid.UUID) (*models.Hold, error) {
+	query := `SELECT ` + holdColumns + ` FROM holds WHERE id = $1`
+	return scanHold(r.db.QueryRowContext(ctx, query, id))
+}
+
+// LockHold retrieves a hold and locks it until the database transaction completes, so that it is captured or voided at most once
+func (r *HoldRepository) LockHold(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*models.Hold, error) {
+	query := `SELECT ` + holdColumns + ` FROM holds WHERE id = $1 FOR UPDATE`
+	return scanHold(tx.QueryRowContext(ctx, query, id))
+}
+
+// CaptureHold marks an active hold as captured by the given posting. Any amount above the captured amount is released
+func (r *HoldRepository) CaptureHold(ctx context.Context, tx *sql.Tx, id uuid.UUID, amount uint64, transactionID uuid.UUID) (*models.Hold, error) {
+	query := `
+		UPDATE holds
+		SET status = $2, captured_amount = $3, transaction_id = $4, updated_at = NOW()
+		WHERE id = $1 AND status = $5
+		RETURNING ` + holdColumns
+
+	return scanHold(tx.QueryRowContext(ctx, query, id, models.HoldCaptured, amount, transactionID, models.HoldActive))
+}
+
+// VoidHold releases an active hold without moving any funds
+func (r *HoldRepository) VoidHold(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*models.Hold, error) {
+	query := `
+		UPDATE holds
+		SET status = $2, updated_at = NOW()
+		WHERE id = $1 AND status = $3
+		RETURNING ` + holdColumns
+
+	return scanHold(tx.QueryRowContext(ctx, query, id, models.HoldVoided, models.HoldActive))
+}
+
+// ExpireHolds marks every active hold that reached its expiry by the given time as expired and returns how many were expired. Expired holds stop reserving funds
+// as soon as their expiry passes, so this only brings their status in line
+func (r *HoldRepository) ExpireHolds(ctx context.Context, now time.Time) (int64, error) {
+	query := `
+		UPDATE holds
+		SET status = $1, updated_at = NOW()
+		WHERE status = $2 AND expires_at <= $3
+	`
+
+	result, err := r.db.ExecContext(ctx, query, models.HoldExpired, models.HoldActive, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func scanHold(row *sql.Row) (*models.Hold, error) {
+	var hold models.Hold
+	if err := row.Scan(&hold.ID, &hold.Reference, &hold.AccountID, &hold.RecipientID, &hold.Amount, &hold.CapturedAmount, &hold.Currency, &hold.Status, &hold.TransactionID, &hold.ExpiresAt, &hold.CreatedAt, &hold.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// heldAmounts retrieves the total reserved by the active, unexpired holds of an account per currency
+func heldAmounts(ctx context.Context, q querier, acctID uuid.UUID) (map[models.Currency]int64, error) {
+	query := `
+	 SELECT currency, SUM(amount)
+	 FROM holds
+	 WHERE account_id = $1 AND status = $2 AND expires_at > NOW()
+	 GROUP BY currency
+	 `
+
+	rows, err := q.QueryContext(ctx, query, acctID, models.HoldActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	held := make(map[models.Currency]int64)
+	for rows.Next() {
+		var currency models.Currency
+		var amount int64
+		if err := rows.Scan(&currency, &amount); err != nil {
+			return nil, err
+		}
+		held[currency] = amount
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return held, nil
+}
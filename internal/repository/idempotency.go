@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// IdempotencyRepository handles database operations for idempotency keys
+type IdempotencyRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewIdempotencyRepository creates a new idempotency repository
+func NewIdempotencyRepository(db *sql.DB, logger *slog.Logger) *IdempotencyRepository {
+	return &IdempotencyRepository{db: db, logger: logger}
+}
+
+// ClaimKey reserves an idempotency key of the user within the provided database transaction. When the user has already used the key, the stored record is
+// returned instead. A concurrent claim of the same key blocks until the holding transaction completes, so only one request can proceed with a key at a time
+func (r *IdempotencyRepository) ClaimKey(ctx context.Context, tx *sql.Tx, userID uuid.UUID, key, requestHash string) (*models.IdempotencyRecord, error) {
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, request_hash)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, key) DO NOTHING
+		RETURNING key
+	`
+
+	var claimed string
+	err := tx.QueryRowContext(ctx, query, userID, key, requestHash).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	// key exists, retrieve the original request and response
+	query = `
+	 SELECT user_id, key, request_hash, status_code, response_body, created_at FROM idempotency_keys
+	 WHERE user_id = $1 AND key = $2
+	 `
+	var record models.IdempotencyRecord
+	if err := tx.QueryRowContext(ctx, query, userID, key).Scan(&record.UserID, &record.Key, &record.RequestHash, &record.StatusCode, &record.ResponseBody, &record.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// SaveResponse stores the response of the request that claimed the key. It must run in the same database transaction as the claim
+func (r *IdempotencyRepository) SaveResponse(ctx context.Context, tx *sql.Tx, userID uuid.UUID, key string, statusCode int, body []byte) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $3, response_body = $4
+		WHERE user_id = $1 AND key = $2
+	`
+	_, err := tx.ExecContext(ctx, query, userID, key, statusCode, body)
+	return err
+}
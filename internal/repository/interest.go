@@ -0,0 +1,521 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// interestLockID is the postgres advisory lock serializing interest runs
+const interestLockID int64 = 7_263_541_003
+
+// errors
+var (
+	ErrInterestDayOpen        = errors.New("interest can only be accrued for days that have ended")
+	ErrInterestMonthOpen      = errors.New("interest can only be paid out for months that have ended")
+	ErrInterestMonthPaid      = errors.New("interest for the month has already been paid out")
+	ErrInterestAccrualPending = errors.New("the last day of the month has not been accrued yet")
+)
+
+// InterestRepository handles database operations for interest products, enrolments and accruals. Runs that post to the ledger live on the TransactionRepository
+type InterestRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewInterestRepository creates a new interest repository
+func NewInterestRepository(db *sql.DB, logger *slog.Logger) *InterestRepository {
+	return &InterestRepository{db: db, logger: logger}
+}
+
+// CreateProduct adds a new interest product to the database. Products are never updated, accounts are moved to a new product instead
+func (r *InterestRepository) CreateProduct(ctx context.Context, data *models.CreateInterestProduct) (*models.InterestProduct, error) {
+	query := `
+		INSERT INTO interest_products (name, annual_rate, day_count)
+		VALUES ($1, $2, $3)
+		RETURNING id, name, annual_rate, day_count, created_at
+	`
+
+	var product models.InterestProduct
+	if err := r.db.QueryRowContext(ctx, query, data.Name, data.AnnualRate, data.DayCount).Scan(&product.ID, &product.Name, &product.AnnualRate, &product.DayCount, &product.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// GetProducts retrieves every interest product by name
+func (r *InterestRepository) GetProducts(ctx context.Context) ([]*models.InterestProduct, error) {
+	query := `SELECT id, name, annual_rate, day_count, created_at FROM interest_products ORDER BY name`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []*models.InterestProduct{}
+	for rows.Next() {
+		var product models.InterestProduct
+		if err := rows.Scan(&product.ID, &product.Name, &product.AnnualRate, &product.DayCount, &product.CreatedAt); err != nil {
+			return nil, err
+		}
+		products = append(products, &product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// GetProductByID retrieves an interest product by its ID
+func (r *InterestRepository) GetProductByID(ctx context.Context, id uuid.UUID) (*models.InterestProduct, error) {
+	query := `SELECT id, name, annual_rate, day_count, created_at FROM interest_products WHERE id = $1`
+
+	var product models.InterestProduct
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&product.ID, &product.Name, &product.AnnualRate, &product.DayCount, &product.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// EnrolAccount enrols an account in an interest product from the given day. Accounts already enrolled move to the new product and keep their enrolment day
+func (r *InterestRepository) EnrolAccount(ctx context.Context, acctID, productID uuid.UUID, enrolledOn time.Time) (*models.AccountInterest, error) {
+	query := `
+		INSERT INTO account_interest (account_id, product_id, enrolled_on)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (account_id) DO UPDATE
+		SET product_id = EXCLUDED.product_id, updated_at = NOW()
+	`
+	if _, err := r.db.ExecContext(ctx, query, acctID, productID, enrolledOn); err != nil {
+		return nil, err
+	}
+	return r.GetAccountInterest(ctx, acctID)
+}
+
+// GetAccountInterest retrieves the interest product an account is enrolled in
+func (r *InterestRepository) GetAccountInterest(ctx context.Context, acctID uuid.UUID) (*models.AccountInterest, error) {
+	query := `
+	 SELECT enrolment.account_id, enrolment.enrolled_on, enrolment.updated_at, products.id, products.name, products.annual_rate, products.day_count, products.created_at
+	 FROM account_interest AS enrolment
+	 JOIN interest_products AS products ON products.id = enrolment.product_id
+	 WHERE enrolment.account_id = $1
+	 `
+
+	enrolment := models.AccountInterest{Product: &models.InterestProduct{}}
+	product := enrolment.Product
+	if err := r.db.QueryRowContext(ctx, query, acctID).Scan(&enrolment.AccountID, &enrolment.EnrolledOn, &enrolment.UpdatedAt, &product.ID, &product.Name, &product.AnnualRate, &product.DayCount, &product.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &enrolment, nil
+}
+
+// GetAccruals retrieves the daily interest accrued by an account between the two days inclusive, oldest first
+func (r *InterestRepository) GetAccruals(ctx context.Context, acctID uuid.UUID, from, to time.Time) ([]*models.InterestAccrual, error) {
+	query := `
+	 SELECT account_id, currency, accrual_date, product_id, balance, annual_rate, day_count, days, amount, posted, transaction_id, created_at
+	 FROM interest_accruals
+	 WHERE account_id = $1 AND accrual_date >= $2 AND accrual_date <= $3
+	 ORDER BY accrual_date, currency
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, acctID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accruals := []*models.InterestAccrual{}
+	for rows.Next() {
+		var accrual models.InterestAccrual
+		if err := rows.Scan(&accrual.AccountID, &accrual.Currency, &accrual.AccrualDate, &accrual.ProductID, &accrual.Balance, &accrual.AnnualRate, &accrual.DayCount, &accrual.Days, &accrual.Amount, &accrual.Posted, &accrual.TransactionID, &accrual.CreatedAt); err != nil {
+			return nil, err
+		}
+		accruals = append(accruals, &accrual)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return accruals, nil
+}
+
+// LastInterestAccrual returns the latest day interest has been accrued for. sql.ErrNoRows is returned when interest has never been accrued
+func (r *TransactionRepository) LastInterestAccrual(ctx context.Context) (time.Time, error) {
+	query := `SELECT MAX(period) FROM interest_runs WHERE kind = $1`
+
+	var last sql.NullTime
+	if err := r.db.QueryRowContext(ctx, query, models.INTEREST_ACCRUAL).Scan(&last); err != nil {
+		return time.Time{}, err
+	}
+	if !last.Valid {
+		return time.Time{}, sql.ErrNoRows
+	}
+	return last.Time, nil
+}
+
+// UnpaidInterestMonths returns the months before the given day that have accrued interest but have not been paid out, oldest first
+func (r *TransactionRepository) UnpaidInterestMonths(ctx context.Context, before time.Time) ([]time.Time, error) {
+	query := `
+	 SELECT DISTINCT date_trunc('month', period)::date AS month FROM interest_runs
+	 WHERE kind = $1 AND period < date_trunc('month', $3::date)
+	 EXCEPT
+	 SELECT period FROM interest_runs WHERE kind = $2
+	 ORDER BY month
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, models.INTEREST_ACCRUAL, models.INTEREST_PAYOUT, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var months []time.Time
+	for rows.Next() {
+		var month time.Time
+		if err := rows.Scan(&month); err != nil {
+			return nil, err
+		}
+		months = append(months, month)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return months, nil
+}
+
+// interestAccountKey identifies the balance of an account in one currency
+type interestAccountKey struct {
+	accountID uuid.UUID
+	currency  models.Currency
+}
+
+// AccrueInterest accrues a day of interest on the closing balance of every enrolled account with a positive balance. The interest is charged to the interest expense
+// account and owed through the interest payable account of each currency, in a single ledger transaction. A day is accrued at most once, so re-running it returns the
+// original run. created reports whether this call accrued the day. Days of months that have been paid out can no longer be accrued
+func (r *TransactionRepository) AccrueInterest(ctx context.Context, day time.Time) (run *models.InterestRun, created bool, err error) {
+	day = dateOf(day)
+	if !day.Before(dateOf(time.Now())) {
+		return nil, false, ErrInterestDayOpen
+	}
+	month := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, interestLockID); err != nil {
+		return nil, false, err
+	}
+	if run, err := getInterestRun(ctx, tx, models.INTEREST_ACCRUAL, day); err != sql.ErrNoRows {
+		return run, false, err
+	}
+	if _, err := getInterestRun(ctx, tx, models.INTEREST_PAYOUT, month); err != sql.ErrNoRows {
+		if err == nil {
+			err = ErrInterestMonthPaid
+		}
+		return nil, false, err
+	}
+
+	// closing balance of every enrolled account on the day, starting from its latest checkpoint before the day ended
+	query := `
+	 WITH enrolled AS (
+		SELECT accounts.id, accounts.currency, products.id AS product_id, products.annual_rate::text AS annual_rate, products.day_count
+		FROM account_interest AS enrolment
+		JOIN accounts ON accounts.id = enrolment.account_id
+		JOIN interest_products AS products ON products.id = enrolment.product_id
+		WHERE enrolment.enrolled_on <= $2 AND accounts.deleted_at IS NULL
+	 ),
+	 checkpoint AS (
+		SELECT DISTINCT ON (checkpoints.account_id) checkpoints.account_id, checkpoints.as_of, checkpoints.credits - checkpoints.debits AS balance
+		FROM balance_checkpoints AS checkpoints
+		JOIN enrolled ON enrolled.id = checkpoints.account_id AND enrolled.currency = checkpoints.currency
+		WHERE checkpoints.as_of < $3
+		ORDER BY checkpoints.account_id, checkpoints.as_of DESC
+	 )
+	 SELECT
+	 enrolled.id,
+	 enrolled.currency,
+	 enrolled.product_id,
+	 enrolled.annual_rate,
+	 enrolled.day_count,
+	 COALESCE(checkpoint.balance, 0) + COALESCE(SUM(CASE WHEN lines.purpose = $1 THEN lines.amount ELSE -lines.amount END), 0) AS balance
+	 FROM enrolled
+	 LEFT JOIN checkpoint ON checkpoint.account_id = enrolled.id
+	 LEFT JOIN transaction_lines AS lines
+	 ON lines.account_id = enrolled.id AND lines.currency = enrolled.currency AND lines.created_at < $3
+	 AND (checkpoint.as_of IS NULL OR lines.created_at > checkpoint.as_of)
+	 GROUP BY enrolled.id, enrolled.currency, enrolled.product_id, enrolled.annual_rate, enrolled.day_count, checkpoint.balance
+	 ORDER BY enrolled.id
+	 `
+	rows, err := tx.QueryContext(ctx, query, models.CREDIT, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var accruals []*models.InterestAccrual
+	for rows.Next() {
+		accrual := models.InterestAccrual{AccrualDate: day}
+		if err := rows.Scan(&accrual.AccountID, &accrual.Currency, &accrual.ProductID, &accrual.AnnualRate, &accrual.DayCount, &accrual.Balance); err != nil {
+			return nil, false, err
+		}
+		if accrual.Balance > 0 {
+			accruals = append(accruals, &accrual)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	rows.Close()
+
+	// post the whole minor units that the month-to-date interest of each account has grown by, so that rounding never loses or invents interest over a month
+	accrued, err := monthlyInterest(ctx, tx, month)
+	if err != nil {
+		return nil, false, err
+	}
+	totals := make(map[models.Currency]uint64)
+	for _, accrual := range accruals {
+		product := &models.InterestProduct{AnnualRate: accrual.AnnualRate, DayCount: accrual.DayCount}
+		interest, days, err := product.DailyInterest(accrual.Balance, day)
+		if err != nil {
+			return nil, false, fmt.Errorf("accrue interest for account %s: %w", accrual.AccountID, err)
+		}
+		accrual.Days = days
+		accrual.Amount = interest.FloatString(12)
+		interest.SetString(accrual.Amount)
+
+		total := new(big.Rat).Set(interest)
+		prev, ok := accrued[interestAccountKey{accrual.AccountID, accrual.Currency}]
+		if ok {
+			total.Add(total, prev.amount)
+		}
+		posted := new(big.Int).Quo(total.Num(), total.Denom()).Int64() - prev.posted
+		accrual.Posted = max(posted, 0)
+		totals[accrual.Currency] += uint64(accrual.Posted)
+	}
+
+	var lines []models.CreateTransactionLine
+	for _, currency := range sortedCurrencies(totals) {
+		expense, err := getOrCreateSystemAccount(ctx, tx, &models.CreateAccount{AccountNumber: models.InterestExpenseAccount(currency), Class: models.EXPENSE, Currency: currency})
+		if err != nil {
+			return nil, false, fmt.Errorf("retrieve interest expense account: %w", err)
+		}
+		payable, err := getOrCreateSystemAccount(ctx, tx, &models.CreateAccount{AccountNumber: models.InterestPayableAccount(currency), Class: models.LIABILITY, Currency: currency})
+		if err != nil {
+			return nil, false, fmt.Errorf("retrieve interest payable account: %w", err)
+		}
+		lines = append(lines,
+			models.CreateTransactionLine{AccountID: expense.ID, Purpose: models.DEBIT, Amount: totals[currency], Currency: currency},
+			models.CreateTransactionLine{AccountID: payable.ID, Purpose: models.CREDIT, Amount: totals[currency], Currency: currency},
+		)
+	}
+
+	var transactionID *uuid.UUID
+	if len(lines) > 0 {
+		transaction, err := r.CreateTransaction(ctx, tx, &models.CreateTransaction{
+			Reference: "INT-ACCRUAL-" + day.Format("20060102"),
+			Lines:     lines,
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("post interest accrual: %w", err)
+		}
+		transactionID = &transaction.ID
+	}
+
+	query = `
+		INSERT INTO interest_accruals (account_id, currency, accrual_date, product_id, balance, annual_rate, day_count, days, amount, posted, transaction_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	for _, accrual := range accruals {
+		var accrualTransactionID *uuid.UUID
+		if accrual.Posted > 0 {
+			accrualTransactionID = transactionID
+		}
+		if _, err := tx.ExecContext(ctx, query, accrual.AccountID, accrual.Currency, day, accrual.ProductID, accrual.Balance, accrual.AnnualRate, accrual.DayCount, accrual.Days, accrual.Amount, accrual.Posted, accrualTransactionID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	run, err = createInterestRun(ctx, tx, models.INTEREST_ACCRUAL, day, len(accruals), transactionID)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return run, true, nil
+}
+
+// PayInterest credits every account with the interest it accrued over the month of the given day, drawn from the interest payable account of each currency in a single
+// ledger transaction. A month is paid out at most once, so re-running it returns the original run. created reports whether this call paid out the month
+func (r *TransactionRepository) PayInterest(ctx context.Context, day time.Time) (run *models.InterestRun, created bool, err error) {
+	month := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	monthEnd := month.AddDate(0, 1, -1)
+	if !monthEnd.Before(dateOf(time.Now())) {
+		return nil, false, ErrInterestMonthOpen
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, interestLockID); err != nil {
+		return nil, false, err
+	}
+	if run, err := getInterestRun(ctx, tx, models.INTEREST_PAYOUT, month); err != sql.ErrNoRows {
+		return run, false, err
+	}
+	if _, err := getInterestRun(ctx, tx, models.INTEREST_ACCRUAL, monthEnd); err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrInterestAccrualPending
+		}
+		return nil, false, err
+	}
+
+	accrued, err := monthlyInterest(ctx, tx, month)
+	if err != nil {
+		return nil, false, err
+	}
+	keys := make([]interestAccountKey, 0, len(accrued))
+	for key, interest := range accrued {
+		if interest.posted > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].accountID != keys[j].accountID {
+			return keys[i].accountID.String() < keys[j].accountID.String()
+		}
+		return keys[i].currency < keys[j].currency
+	})
+
+	var lines []models.CreateTransactionLine
+	totals := make(map[models.Currency]uint64)
+	for _, key := range keys {
+		amount := uint64(accrued[key].posted)
+		lines = append(lines, models.CreateTransactionLine{AccountID: key.accountID, Purpose: models.CREDIT, Amount: amount, Currency: key.currency})
+		totals[key.currency] += amount
+	}
+	for _, currency := range sortedCurrencies(totals) {
+		payable, err := getOrCreateSystemAccount(ctx, tx, &models.CreateAccount{AccountNumber: models.InterestPayableAccount(currency), Class: models.LIABILITY, Currency: currency})
+		if err != nil {
+			return nil, false, fmt.Errorf("retrieve interest payable account: %w", err)
+		}
+		lines = append(lines, models.CreateTransactionLine{AccountID: payable.ID, Purpose: models.DEBIT, Amount: totals[currency], Currency: currency})
+	}
+
+	var transactionID *uuid.UUID
+	if len(lines) > 0 {
+		transaction, err := r.CreateTransaction(ctx, tx, &models.CreateTransaction{
+			Reference: "INT-PAYOUT-" + month.Format("200601"),
+			Lines:     lines,
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("post interest payout: %w", err)
+		}
+		transactionID = &transaction.ID
+	}
+
+	run, err = createInterestRun(ctx, tx, models.INTEREST_PAYOUT, month, len(keys), transactionID)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return run, true, nil
+}
+
+// accruedInterest is the exact interest an account accrued so far in a month, and the whole minor units of it already posted
+type accruedInterest struct {
+	amount *big.Rat
+	posted int64
+}
+
+// monthlyInterest retrieves the interest accrued by every account and currency over the month starting on the given day
+func monthlyInterest(ctx context.Context, tx *sql.Tx, month time.Time) (map[interestAccountKey]accruedInterest, error) {
+	query := `
+	 SELECT account_id, currency, SUM(amount)::text, SUM(posted)
+	 FROM interest_accruals
+	 WHERE accrual_date >= $1 AND accrual_date < $2
+	 GROUP BY account_id, currency
+	 `
+
+	rows, err := tx.QueryContext(ctx, query, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accrued := make(map[interestAccountKey]accruedInterest)
+	for rows.Next() {
+		var key interestAccountKey
+		var amount string
+		var posted int64
+		if err := rows.Scan(&key.accountID, &key.currency, &amount, &posted); err != nil {
+			return nil, err
+		}
+		total, ok := new(big.Rat).SetString(amount)
+		if !ok {
+			return nil, fmt.Errorf("invalid accrued interest %q for account %s", amount, key.accountID)
+		}
+		accrued[key] = accruedInterest{amount: total, posted: posted}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return accrued, nil
+}
+
+func getInterestRun(ctx context.Context, tx *sql.Tx, kind models.InterestRunKind, period time.Time) (*models.InterestRun, error) {
+	query := `SELECT kind, period, accounts, transaction_id, created_at FROM interest_runs WHERE kind = $1 AND period = $2`
+
+	var run models.InterestRun
+	if err := tx.QueryRowContext(ctx, query, kind, period).Scan(&run.Kind, &run.Period, &run.Accounts, &run.TransactionID, &run.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func createInterestRun(ctx context.Context, tx *sql.Tx, kind models.InterestRunKind, period time.Time, accounts int, transactionID *uuid.UUID) (*models.InterestRun, error) {
+	query := `
+		INSERT INTO interest_runs (kind, period, accounts, transaction_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING kind, period, accounts, transaction_id, created_at
+	`
+
+	var run models.InterestRun
+	if err := tx.QueryRowContext(ctx, query, kind, period, accounts, transactionID).Scan(&run.Kind, &run.Period, &run.Accounts, &run.TransactionID, &run.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// sortedCurrencies returns the currencies of the totals in a stable order
+func sortedCurrencies(totals map[models.Currency]uint64) []models.Currency {
+	currencies := make([]models.Currency, 0, len(totals))
+	for currency, total := range totals {
+		if total > 0 {
+			currencies = append(currencies, currency)
+		}
+	}
+	sort.Slice(currencies, func(i, j int) bool { return currencies[i] < currencies[j] })
+	return currencies
+}
+
+// dateOf truncates a time to its UTC day
+func dateOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,293 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// ledgerChainLockID is the postgres advisory lock that serializes appends to the ledger chain
+const ledgerChainLockID int64 = 7_263_541_002
+
+// appendToChain links a newly created transaction to the head of the ledger chain within the provided database transaction. The chain lock is held until commit,
+// so it is taken last: the posting has already acquired every other lock it needs and cannot deadlock with another posting waiting on the chain
+func appendToChain(ctx context.Context, tx *sql.Tx, transaction *models.Transaction) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ledgerChainLockID); err != nil {
+		return err
+	}
+
+	sequence, prevHash, err := chainHead(ctx, tx)
+	if err != nil {
+		return err
+	}
+	_, err = insertChainEntry(ctx, tx, transaction, sequence+1, prevHash)
+	return err
+}
+
+// chainHead retrieves the sequence and hash of the most recent ledger chain entry. An empty chain starts from the genesis hash
+func chainHead(ctx context.Context, tx *sql.Tx) (int64, string, error) {
+	query := `SELECT sequence, hash FROM ledger_chain ORDER BY sequence DESC LIMIT 1`
+
+	var sequence int64
+	var hash string
+	err := tx.QueryRowContext(ctx, query).Scan(&sequence, &hash)
+	if err == sql.ErrNoRows {
+		return 0, models.GenesisHash, nil
+	}
+	return sequence, hash, err
+}
+
+// insertChainEntry records the transaction at the given position of the ledger chain and returns its hash
+func insertChainEntry(ctx context.Context, tx *sql.Tx, transaction *models.Transaction, sequence int64, prevHash string) (string, error) {
+	hash, err := transaction.ChainHash(sequence, prevHash)
+	if err != nil {
+		return "", err
+	}
+
+	query := `
+		INSERT INTO ledger_chain (sequence, transaction_id, prev_hash, hash)
+		VALUES ($1, $2, $3, $4)
+	`
+	if _, err := tx.ExecContext(ctx, query, sequence, transaction.ID, prevHash, hash); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// SealLedger chains the transactions recorded before the ledger chain existed, oldest first, and returns how many were sealed. It only runs while the chain is
+// empty: once sealed, a transaction without a chain entry was written around the API and is reported by VerifyLedger instead
+func (r *TransactionRepository) SealLedger(ctx context.Context) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ledgerChainLockID); err != nil {
+		return 0, err
+	}
+	sequence, prevHash, err := chainHead(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	if sequence > 0 {
+		return 0, nil
+	}
+
+	query := `
+	 SELECT
+	 transactions.id,
+	 transactions.reference,
+	 transactions.fx_rate_id,
+	 transactions.reversal_of,
+	 transactions.created_at,
+	 lines.id AS line_id,
+	 lines.account_id AS line_account_id,
+	 lines.purpose AS line_purpose,
+	 lines.amount AS line_amount,
+	 lines.currency AS line_currency,
+	 lines.created_at AS line_created_at
+	 FROM transactions
+	 JOIN transaction_lines AS lines
+	 ON transactions.id = lines.transaction_id
+	 ORDER BY transactions.created_at, transactions.id, lines.id
+	 `
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	// collect every transaction first, the connection cannot insert while rows are still being read
+	var transactions []*models.Transaction
+	for rows.Next() {
+		var transaction models.Transaction
+		var line models.TransactionLine
+		if err := rows.Scan(&transaction.ID, &transaction.Reference, &transaction.FXRateID, &transaction.ReversalOf, &transaction.CreatedAt, &line.ID, &line.AccountID, &line.Purpose, &line.Amount, &line.Currency, &line.CreatedAt); err != nil {
+			return 0, err
+		}
+		line.TransactionID = transaction.ID.String()
+
+		if n := len(transactions); n > 0 && transactions[n-1].ID == transaction.ID {
+			transactions[n-1].Lines = append(transactions[n-1].Lines, line)
+			continue
+		}
+		transaction.Lines = append(transaction.Lines, line)
+		transactions = append(transactions, &transaction)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	for _, transaction := range transactions {
+		sequence++
+		if prevHash, err = insertChainEntry(ctx, tx, transaction, sequence, prevHash); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return sequence, nil
+}
+
+// VerifyLedger walks the ledger chain in order, recomputing the hash of every transaction from its current content, and reports the first entry that was altered,
+// inserted or removed. Transactions missing from an intact chain are reported last as insertions. The walk reads a single snapshot of the ledger
+func (r *TransactionRepository) VerifyLedger(ctx context.Context) (*models.LedgerVerification, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	 SELECT
+	 chain.sequence,
+	 chain.transaction_id,
+	 chain.prev_hash,
+	 chain.hash,
+	 transactions.id,
+	 transactions.reference,
+	 transactions.fx_rate_id,
+	 transactions.reversal_of,
+	 transactions.created_at,
+	 lines.id AS line_id,
+	 lines.account_id AS line_account_id,
+	 lines.purpose AS line_purpose,
+	 lines.amount AS line_amount,
+	 lines.currency AS line_currency,
+	 lines.created_at AS line_created_at
+	 FROM ledger_chain AS chain
+	 LEFT JOIN transactions
+	 ON transactions.id = chain.transaction_id
+	 LEFT JOIN transaction_lines AS lines
+	 ON lines.transaction_id = chain.transaction_id
+	 ORDER BY chain.sequence, lines.id
+	 `
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &models.LedgerVerification{HeadHash: models.GenesisHash, VerifiedAt: time.Now().UTC()}
+
+	// entry and transaction being assembled from the current group of rows
+	var entry *models.LedgerEntry
+	var transaction *models.Transaction
+
+	for rows.Next() {
+		var current models.LedgerEntry
+		var txnID *uuid.UUID
+		var txnReference *string
+		var txn models.Transaction
+		var lineID, lineAccountID *string
+		var linePurpose *models.TransactionPurpose
+		var lineAmount *uint64
+		var lineCurrency *models.Currency
+		var lineCreatedAt *time.Time
+		if err := rows.Scan(&current.Sequence, &current.TransactionID, &current.PrevHash, &current.Hash, &txnID, &txnReference, &txn.FXRateID, &txn.ReversalOf, &txn.CreatedAt, &lineID, &lineAccountID, &linePurpose, &lineAmount, &lineCurrency, &lineCreatedAt); err != nil {
+			return nil, err
+		}
+
+		if entry == nil || entry.Sequence != current.Sequence {
+			// a new entry starts, so the previous one is complete
+			if entry != nil {
+				if result.Fault = checkChainEntry(result, entry, transaction); result.Fault != nil {
+					return result, nil
+				}
+			}
+			entry, transaction = &current, nil
+			if txnID != nil {
+				txn.ID, txn.Reference = *txnID, *txnReference
+				transaction = &txn
+			}
+		}
+
+		if transaction != nil && lineID != nil {
+			transaction.Lines = append(transaction.Lines, models.TransactionLine{
+				ID:            *lineID,
+				AccountID:     *lineAccountID,
+				TransactionID: transaction.ID.String(),
+				Purpose:       *linePurpose,
+				Amount:        *lineAmount,
+				Currency:      *lineCurrency,
+				CreatedAt:     lineCreatedAt,
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		if result.Fault = checkChainEntry(result, entry, transaction); result.Fault != nil {
+			return result, nil
+		}
+	}
+	rows.Close()
+
+	// the chain is intact, so any transaction outside of it was inserted directly
+	query = `
+	 SELECT id FROM transactions
+	 WHERE NOT EXISTS (SELECT 1 FROM ledger_chain AS chain WHERE chain.transaction_id = transactions.id)
+	 ORDER BY created_at, id
+	 LIMIT 1
+	 `
+	var inserted uuid.UUID
+	err = tx.QueryRowContext(ctx, query).Scan(&inserted)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil {
+		result.Fault = &models.LedgerFault{
+			Kind:          models.LedgerEntryInserted,
+			TransactionID: &inserted,
+			Reason:        "transaction is not part of the ledger chain",
+		}
+		return result, nil
+	}
+
+	result.Valid = true
+	return result, nil
+}
+
+// checkChainEntry verifies a chain entry against the verified head and the current content of its transaction. The head advances when the entry is valid
+func checkChainEntry(result *models.LedgerVerification, entry *models.LedgerEntry, transaction *models.Transaction) *models.LedgerFault {
+	fault := &models.LedgerFault{Sequence: entry.Sequence, TransactionID: &entry.TransactionID}
+	expected := result.HeadSequence + 1
+
+	switch {
+	case entry.Sequence != expected:
+		fault.Kind = models.LedgerEntryRemoved
+		fault.Sequence, fault.TransactionID = expected, nil
+		fault.Reason = fmt.Sprintf("chain entry %d is missing", expected)
+		return fault
+	case entry.PrevHash != result.HeadHash:
+		fault.Kind = models.LedgerEntryAltered
+		fault.Reason = "chain entry does not link to the hash of the entry before it"
+		return fault
+	case transaction == nil:
+		fault.Kind = models.LedgerEntryRemoved
+		fault.Reason = "chained transaction no longer exists"
+		return fault
+	}
+
+	hash, err := transaction.ChainHash(entry.Sequence, entry.PrevHash)
+	if err != nil || hash != entry.Hash {
+		fault.Kind = models.LedgerEntryAltered
+		fault.Reason = "transaction or its lines no longer match the recorded hash"
+		return fault
+	}
+
+	result.Entries++
+	result.HeadSequence, result.HeadHash = entry.Sequence, entry.Hash
+	return nil
+}
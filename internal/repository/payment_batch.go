@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// PaymentBatchRepository handles database operations for payment batches and their status reports
+type PaymentBatchRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewPaymentBatchRepository creates a new payment batch repository
+func NewPaymentBatchRepository(db *sql.DB, logger *slog.Logger) *PaymentBatchRepository {
+	return &PaymentBatchRepository{db: db, logger: logger}
+}
+
+// paymentBatchColumns lists the columns scanned by scanPaymentBatch
+const paymentBatchColumns = `id, message_id, mode, status, instructions, created_at`
+
+// CreateBatch records a payment batch within the provided database transaction. The message id of a batch is unique, so a concurrent upload of the same file
+// waits for this one and then fails
+func (r *PaymentBatchRepository) CreateBatch(ctx context.Context, tx *sql.Tx, report *models.PaymentReport) (*models.PaymentReport, error) {
+	instructions, err := json.Marshal(report.Instructions)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO payment_batches (message_id, mode, status, instructions)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + paymentBatchColumns
+	return scanPaymentBatch(tx.QueryRowContext(ctx, query, report.MessageID, report.Mode, report.Status, instructions))
+}
+
+// CompleteBatch stores the final status of a payment batch and of each of its instructions within the provided database transaction
+func (r *PaymentBatchRepository) CompleteBatch(ctx context.Context, tx *sql.Tx, report *models.PaymentReport) error {
+	instructions, err := json.Marshal(report.Instructions)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE payment_batches SET status = $2, instructions = $3, updated_at = NOW() WHERE id = $1`
+	result, err := tx.ExecContext(ctx, query, report.ID, report.Status, instructions)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetBatchByMessageID retrieves a payment batch by the message id of its pain.001 file
+func (r *PaymentBatchRepository) GetBatchByMessageID(ctx context.Context, messageID string) (*models.PaymentReport, error) {
+	query := `SELECT ` + paymentBatchColumns + ` FROM payment_batches WHERE message_id = $1`
+	return scanPaymentBatch(r.db.QueryRowContext(ctx, query, messageID))
+}
+
+func scanPaymentBatch(row scanner) (*models.PaymentReport, error) {
+	var report models.PaymentReport
+	var instructions []byte
+	if err := row.Scan(&report.ID, &report.MessageID, &report.Mode, &report.Status, &instructions, &report.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(instructions, &report.Instructions); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// SessionRepository handles database operations for login sessions
+type SessionRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewSessionRepository creates a new session repository
+func NewSessionRepository(db *sql.DB, logger *slog.Logger) *SessionRepository {
+	return &SessionRepository{db: db, logger: logger}
+}
+
+// sessionColumns lists the columns scanned by scanSession
+const sessionColumns = `id, user_id, ip_address, user_agent, expires_at, revoked_at, created_at`
+
+// CreateSession starts a new session for a user who logged in
+func (r *SessionRepository) CreateSession(ctx context.Context, data *models.CreateSession) (*models.Session, error) {
+	query := `
+		INSERT INTO sessions (user_id, ip_address, user_agent, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + sessionColumns
+	return scanSession(r.db.QueryRowContext(ctx, query, data.UserID, data.IPAddress, data.UserAgent, data.ExpiresAt))
+}
+
+// GetActiveSession retrieves a session of the user when it is neither revoked nor expired
+func (r *SessionRepository) GetActiveSession(ctx context.Context, id, userID uuid.UUID) (*models.Session, error) {
+	query := `
+	 SELECT ` + sessionColumns + ` FROM sessions
+	 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+	 `
+	return scanSession(r.db.QueryRowContext(ctx, query, id, userID))
+}
+
+// GetActiveSessionsByUserID retrieves the sessions of a user that are neither revoked nor expired, most recent first
+func (r *SessionRepository) GetActiveSessionsByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Session, error) {
+	query := `
+	 SELECT ` + sessionColumns + ` FROM sessions
+	 WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+	 ORDER BY created_at DESC, id
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*models.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes an active session of the user. Its bearer token stops working at once
+func (r *SessionRepository) RevokeSession(ctx context.Context, id, userID uuid.UUID) (*models.Session, error) {
+	query := `
+	 UPDATE sessions SET revoked_at = NOW()
+	 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+	 RETURNING ` + sessionColumns
+	return scanSession(r.db.QueryRowContext(ctx, query, id, userID))
+}
+
+func scanSession(row scanner) (*models.Session, error) {
+	var session models.Session
+	if err := row.Scan(&session.ID, &session.UserID, &session.IPAddress, &session.UserAgent, &session.ExpiresAt, &session.RevokedAt, &session.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// StandingOrderRepository handles database operations for standing orders and their runs
+type StandingOrderRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewStandingOrderRepository creates a new standing order repository
+func NewStandingOrderRepository(db *sql.DB, logger *slog.Logger) *StandingOrderRepository {
+	return &StandingOrderRepository{db: db, logger: logger}
+}
+
+// standingOrderColumns and standingOrderRunColumns list the columns scanned by scanStandingOrder and scanStandingOrderRun
+const (
+	standingOrderColumns    = `id, reference, sender, recipient, amount, currency, convert_currency, schedule_kind, start_date, end_date, COALESCE(day_of_month, 0), status, next_run_on, created_at, updated_at`
+	standingOrderRunColumns = `id, standing_order_id, occurrence, status, attempts, last_error, transaction_id, next_attempt_at, created_at, updated_at`
+)
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// CreateStandingOrder adds a new active standing order to the database
+func (r *StandingOrderRepository) CreateStandingOrder(ctx context.Context, data *models.CreateStandingOrder) (*models.StandingOrder, error) {
+	query := `
+		INSERT INTO standing_orders (reference, sender, recipient, amount, currency, convert_currency, schedule_kind, start_date, end_date, day_of_month, next_run_on)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, 0), $11)
+		RETURNING ` + standingOrderColumns
+
+	schedule := data.Schedule
+	return scanStandingOrder(r.db.QueryRowContext(ctx, query, data.Reference, data.Sender, data.Recipient, data.Amount, data.Currency, data.Convert, schedule.Kind, schedule.StartDate, schedule.EndDate, schedule.DayOfMonth, data.NextRunOn))
+}
+
+// GetStandingOrderByID retrieves a standing order by its ID
+func (r *StandingOrderRepository) GetStandingOrderByID(ctx context.Context, id uuid.UUID) (*models.StandingOrder, error) {
+	query := `SELECT ` + standingOrderColumns + ` FROM standing_orders WHERE id = $1`
+	return scanStandingOrder(r.db.QueryRowContext(ctx, query, id))
+}
+
+// GetStandingOrdersByAccount retrieves the standing orders paying from or into an account, most recent first
+func (r *StandingOrderRepository) GetStandingOrdersByAccount(ctx context.Context, acctNum string) ([]*models.StandingOrder, error) {
+	query := `
+	 SELECT ` + standingOrderColumns + ` FROM standing_orders
+	 WHERE sender = $1 OR recipient = $1
+	 ORDER BY created_at DESC, id
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, acctNum)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*models.StandingOrder{}
+	for rows.Next() {
+		order, err := scanStandingOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// CancelStandingOrder stops an active standing order. Runs still waiting for an attempt are failed with the cancellation as their reason
+func (r *StandingOrderRepository) CancelStandingOrder(ctx context.Context, id uuid.UUID) (*models.StandingOrder, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	 UPDATE standing_orders
+	 SET status = $2, next_run_on = NULL, updated_at = NOW()
+	 WHERE id = $1 AND status = $3
+	 RETURNING ` + standingOrderColumns
+	order, err := scanStandingOrder(tx.QueryRowContext(ctx, query, id, models.StandingOrderCancelled, models.StandingOrderActive))
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+	 UPDATE standing_order_runs
+	 SET status = $2, last_error = 'standing order cancelled', next_attempt_at = NULL, updated_at = NOW()
+	 WHERE standing_order_id = $1 AND status = $3
+	 `
+	if _, err := tx.ExecContext(ctx, query, id, models.RunFailed, models.RunPending); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// GetRuns retrieves the runs of a standing order, most recent occurrence first
+func (r *StandingOrderRepository) GetRuns(ctx context.Context, orderID uuid.UUID) ([]*models.StandingOrderRun, error) {
+	query := `
+	 SELECT ` + standingOrderRunColumns + ` FROM standing_order_runs
+	 WHERE standing_order_id = $1
+	 ORDER BY occurrence DESC
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*models.StandingOrderRun{}
+	for rows.Next() {
+		run, err := scanStandingOrderRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// ScheduleDueRuns creates a pending run for every occurrence of an active standing order up to the given time, including occurrences missed while the server was down.
+// Orders are advanced to their next occurrence, or completed when they have none. Orders being scheduled by another worker are skipped. It returns the number of runs created
+func (r *StandingOrderRepository) ScheduleDueRuns(ctx context.Context, now time.Time) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	 SELECT ` + standingOrderColumns + ` FROM standing_orders
+	 WHERE status = $1 AND next_run_on <= $2
+	 FOR UPDATE SKIP LOCKED
+	 `
+	rows, err := tx.QueryContext(ctx, query, models.StandingOrderActive, now)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var orders []*models.StandingOrder
+	for rows.Next() {
+		order, err := scanStandingOrder(rows)
+		if err != nil {
+			return 0, err
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	var created int64
+	for _, order := range orders {
+		occurrence, ok := *order.NextRunOn, true
+		for ok && !occurrence.After(now) {
+			// occurrences are due from the start of their day
+			query = `
+			 INSERT INTO standing_order_runs (standing_order_id, occurrence, next_attempt_at)
+			 VALUES ($1, $2, $2::date::timestamptz)
+			 ON CONFLICT (standing_order_id, occurrence) DO NOTHING
+			 `
+			result, err := tx.ExecContext(ctx, query, order.ID, occurrence)
+			if err != nil {
+				return 0, err
+			}
+			count, err := result.RowsAffected()
+			if err != nil {
+				return 0, err
+			}
+			created += count
+
+			occurrence, ok = order.Schedule.Next(occurrence.AddDate(0, 0, 1))
+		}
+
+		status, nextRunOn := models.StandingOrderActive, &occurrence
+		if !ok {
+			status, nextRunOn = models.StandingOrderCompleted, nil
+		}
+		query = `
+		 UPDATE standing_orders
+		 SET status = $2, next_run_on = $3, updated_at = NOW()
+		 WHERE id = $1
+		 `
+		if _, err := tx.ExecContext(ctx, query, order.ID, status, nextRunOn); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return created, nil
+}
+
+// ClaimDueRun locks the pending run that has waited longest for an attempt, along with its standing order, until the provided database transaction completes. Runs locked by
+// another worker are skipped. sql.ErrNoRows is returned when no run is due
+func (r *StandingOrderRepository) ClaimDueRun(ctx context.Context, tx *sql.Tx, now time.Time) (*models.StandingOrderRun, error) {
+	query := `
+	 SELECT ` + standingOrderRunColumns + ` FROM standing_order_runs
+	 WHERE status = $1 AND next_attempt_at <= $2
+	 ORDER BY next_attempt_at, id
+	 LIMIT 1
+	 FOR UPDATE SKIP LOCKED
+	 `
+	return scanStandingOrderRun(tx.QueryRowContext(ctx, query, models.RunPending, now))
+}
+
+// CompleteRun marks a claimed run as succeeded by the given posting, within the database transaction that posted it
+func (r *StandingOrderRepository) CompleteRun(ctx context.Context, tx *sql.Tx, id, transactionID uuid.UUID) error {
+	query := `
+	 UPDATE standing_order_runs
+	 SET status = $2, attempts = attempts + 1, last_error = NULL, transaction_id = $3, next_attempt_at = NULL, updated_at = NOW()
+	 WHERE id = $1
+	 `
+	_, err := tx.ExecContext(ctx, query, id, models.RunSucceeded, transactionID)
+	return err
+}
+
+// FailRun records a failed attempt of a pending run along with its reason. The run is retried at the given time until it has been attempted maxAttempts times, after which it fails
+func (r *StandingOrderRepository) FailRun(ctx context.Context, id uuid.UUID, reason string, maxAttempts int, retryAt time.Time) (*models.StandingOrderRun, error) {
+	query := `
+	 UPDATE standing_order_runs
+	 SET attempts = attempts + 1,
+	 last_error = $2,
+	 status = CASE WHEN attempts + 1 >= $3 THEN $4 ELSE status END,
+	 next_attempt_at = CASE WHEN attempts + 1 >= $3 THEN NULL ELSE $5 END,
+	 updated_at = NOW()
+	 WHERE id = $1 AND status = $6
+	 RETURNING ` + standingOrderRunColumns
+	return scanStandingOrderRun(r.db.QueryRowContext(ctx, query, id, reason, maxAttempts, models.RunFailed, retryAt, models.RunPending))
+}
+
+func scanStandingOrder(row scanner) (*models.StandingOrder, error) {
+	var order models.StandingOrder
+	if err := row.Scan(&order.ID, &order.Reference, &order.Sender, &order.Recipient, &order.Amount, &order.Currency, &order.Convert, &order.Schedule.Kind, &order.Schedule.StartDate, &order.Schedule.EndDate, &order.Schedule.DayOfMonth, &order.Status, &order.NextRunOn, &order.CreatedAt, &order.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func scanStandingOrderRun(row scanner) (*models.StandingOrderRun, error) {
+	var run models.StandingOrderRun
+	if err := row.Scan(&run.ID, &run.StandingOrderID, &run.Occurrence, &run.Status, &run.Attempts, &run.LastError, &run.TransactionID, &run.NextAttemptAt, &run.CreatedAt, &run.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
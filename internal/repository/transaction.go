@@ -3,12 +3,26 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log/slog"
+	"math"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/mrshabel/sgbank/internal/models"
 )
 
+// errors
+var (
+	ErrTooFewLines           = errors.New("a transaction requires at least one debit and one credit line")
+	ErrInvalidLineAmount     = errors.New("transaction line amount must be greater than zero and fit a signed 64-bit integer")
+	ErrInvalidLinePurpose    = errors.New("transaction line purpose must be credit or debit")
+	ErrLineAmountOverflow    = errors.New("transaction line amounts overflow")
+	ErrUnsupportedCurrency   = errors.New("transaction line currency is not supported")
+	ErrUnbalancedTransaction = errors.New("total debits must equal total credits in every currency")
+)
+
 // TransactionRepository handles database operations for transactions
 type TransactionRepository struct {
 	db     *sql.DB
@@ -26,80 +40,150 @@ func (r *TransactionRepository) GetTx(ctx context.Context) (tx *sql.Tx, err erro
 	return tx, err
 }
 
-// CreateTransaction adds a new transaction to the database
+// CreateTransaction adds a new transaction and all its lines to the database within the provided database transaction
 func (r *TransactionRepository) CreateTransaction(ctx context.Context, tx *sql.Tx, data *models.CreateTransaction) (*models.Transaction, error) {
+	// guard the double-entry invariant before anything is written
+	if err := ValidateLines(data.Lines); err != nil {
+		return nil, err
+	}
+
 	query := `
-		INSERT INTO transactions (reference)
+		INSERT INTO transactions (reference, fx_rate_id, reversal_of)
 		VALUES ($1, $2, $3)
-		RETURNING id, reference, created_at
+		RETURNING id, reference, fx_rate_id, reversal_of, created_at
 	`
 
 	// retrieve transaction details
 	var transaction models.Transaction
 
 	// create transaction
-	if err := tx.QueryRowContext(ctx, query, data.Reference).Scan(&transaction.ID, &transaction.Reference, &transaction.CreatedAt); err != nil {
+	if err := tx.QueryRowContext(ctx, query, data.Reference, data.FXRateID, data.ReversalOf).Scan(&transaction.ID, &transaction.Reference, &transaction.FXRateID, &transaction.ReversalOf, &transaction.CreatedAt); err != nil {
 		return nil, err
 	}
 
 	// create transaction lines
 	query = `
-		INSERT INTO transaction_lines (account_id, transaction_id, purpose, amount)
-		VALUES ($1, $2, $3)
-		RETURNING id, account_id, transaction_id, purpose, amount, created_at
+		INSERT INTO transaction_lines (account_id, transaction_id, purpose, amount, currency)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, account_id, transaction_id, purpose, amount, currency, created_at
 	`
 
-	// create transaction lines
-	rows, err := r.db.QueryContext(ctx, query, transaction.ID)
-	if err != nil {
-		return nil, err
-	}
-	for rows.Next() {
+	transaction.Lines = make([]models.TransactionLine, 0, len(data.Lines))
+	for _, l := range data.Lines {
 		var line models.TransactionLine
-		if err := rows.Scan(&line.ID, &line.AccountID, &line.TransactionID, &line.Purpose, &line.Amount, &line.CreatedAt); err != nil {
+		if err := tx.QueryRowContext(ctx, query, l.AccountID, transaction.ID, l.Purpose, l.Amount, l.Currency).Scan(&line.ID, &line.AccountID, &line.TransactionID, &line.Purpose, &line.Amount, &line.Currency, &line.CreatedAt); err != nil {
 			return nil, err
 		}
 		transaction.Lines = append(transaction.Lines, line)
 	}
+
+	// keep the materialized balances in step with the new lines
+	if err := applyBalances(ctx, tx, data.Lines); err != nil {
+		return nil, err
+	}
+
+	// seal the transaction into the tamper-evident ledger chain
+	if err := appendToChain(ctx, tx, &transaction); err != nil {
+		return nil, err
+	}
 	return &transaction, nil
 }
 
-// GetBalanceByAccountID retrieves an non-deleted transaction by their ID
-func (r *TransactionRepository) GetBalanceByAccountID(ctx context.Context, acctID uuid.UUID) (uint64, error) {
+// ValidateLines ensures that the given lines form a balanced double-entry. There should be at least one debit and one credit, and the total debits must equal the total credits in every currency
+func ValidateLines(lines []models.CreateTransactionLine) error {
+	if len(lines) < 2 {
+		return ErrTooFewLines
+	}
+
+	// debit and credit totals per currency
+	debits := make(map[models.Currency]uint64)
+	credits := make(map[models.Currency]uint64)
+	for _, line := range lines {
+		if line.Amount == 0 || line.Amount > math.MaxInt64 {
+			return ErrInvalidLineAmount
+		}
+		if !line.Currency.Valid() {
+			return ErrUnsupportedCurrency
+		}
+
+		var totals map[models.Currency]uint64
+		switch line.Purpose {
+		case models.DEBIT:
+			totals = debits
+		case models.CREDIT:
+			totals = credits
+		default:
+			return ErrInvalidLinePurpose
+		}
+
+		// totals are stored as bigint, so each side of a currency must fit in an int64
+		if totals[line.Currency] > math.MaxInt64-line.Amount {
+			return ErrLineAmountOverflow
+		}
+		totals[line.Currency] += line.Amount
+	}
+
+	if len(debits) == 0 || len(credits) == 0 {
+		return ErrTooFewLines
+	}
+	if len(debits) != len(credits) {
+		return ErrUnbalancedTransaction
+	}
+	for currency, debit := range debits {
+		if credits[currency] != debit {
+			return ErrUnbalancedTransaction
+		}
+	}
+	return nil
+}
+
+// LockAccounts acquires row locks on the given accounts until the database transaction completes. Concurrent postings against the same accounts are serialized,
+// so a balance read after the lock cannot be invalidated before commit. Locks are taken in id order to avoid deadlocks
+func (r *TransactionRepository) LockAccounts(ctx context.Context, tx *sql.Tx, acctIDs ...uuid.UUID) error {
 	query := `
-	 SELECT 
-	 SUM(CASE WHEN purpose = $1 THEN amount END) AS credit_balance,
-	 SUM(CASE WHEN purpose = $2 THEN amount END) AS debit_balance
-	 FROM transaction_lines
-	 WHERE account_id = $1
+	 SELECT id FROM accounts
+	 WHERE id = ANY($1)
+	 ORDER BY id
+	 FOR UPDATE
 	 `
 
-	// retrieve balances
-	var creditBalance, debitBalance uint64
-	if err := r.db.QueryRowContext(ctx, query, models.CREDIT, models.DEBIT, acctID).Scan(creditBalance, debitBalance); err != nil {
-		return 0, err
+	ids := make([]string, 0, len(acctIDs))
+	for _, id := range acctIDs {
+		ids = append(ids, id.String())
 	}
 
-	normalBalance := creditBalance - debitBalance
-	return normalBalance, nil
+	rows, err := tx.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// drain rows so that every lock is acquired
+	for rows.Next() {
+	}
+	return rows.Err()
 }
 
-// GetTransactionByID retrieves an non-deleted transaction by their ID
+// GetTransactionByID retrieves a transaction and all its lines by their ID
 func (r *TransactionRepository) GetTransactionByID(ctx context.Context, id uuid.UUID) (*models.Transaction, error) {
 	query := `
 	 SELECT 
-	 id,
-	 reference,
-	 created_at,
+	 transactions.id,
+	 transactions.reference,
+	 transactions.fx_rate_id,
+	 transactions.reversal_of,
+	 transactions.created_at,
 	 lines.id AS line_id,
 	 lines.account_id AS line_account_id,
 	 lines.purpose AS line_purpose,
 	 lines.amount AS line_amount,
+	 lines.currency AS line_currency,
 	 lines.created_at AS line_created_at
 	 FROM transactions
-	 JOIN transaction_lines
-	 ON transactions.id = transaction_lines.transaction_id
-	 WHERE id = $1
+	 JOIN transaction_lines AS lines
+	 ON transactions.id = lines.transaction_id
+	 WHERE transactions.id = $1
+	 ORDER BY lines.created_at, lines.id
 	 `
 
 	//  retrieve transaction with lines
@@ -107,41 +191,80 @@ func (r *TransactionRepository) GetTransactionByID(ctx context.Context, id uuid.
 	if err != nil {
 		return nil, err
 	}
-	var transaction models.Transaction
+	defer rows.Close()
 
+	var transaction models.Transaction
 	for rows.Next() {
 		var line models.TransactionLine
-		if err := rows.Scan(&transaction.ID, &transaction.Reference, &transaction.CreatedAt, &line.ID, &line.AccountID, &line.Purpose, &line.Amount, &line.CreatedAt); err != nil {
+		if err := rows.Scan(&transaction.ID, &transaction.Reference, &transaction.FXRateID, &transaction.ReversalOf, &transaction.CreatedAt, &line.ID, &line.AccountID, &line.Purpose, &line.Amount, &line.Currency, &line.CreatedAt); err != nil {
 			return nil, err
 		}
+		line.TransactionID = transaction.ID.String()
 		transaction.Lines = append(transaction.Lines, line)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// a transaction always has lines, so none means it does not exist
+	if len(transaction.Lines) == 0 {
+		return nil, sql.ErrNoRows
+	}
 	return &transaction, nil
 }
 
-// GetTransactionByUserId retrieves all non-deleted transactions belonging to a user
-func (r *TransactionRepository) GetTransactionsByAccountID(ctx context.Context, accountId uuid.UUID) ([]*models.Transaction, error) {
-	query := `
+// transactionsQuery selects transactions joined with their lines, in the columns read by scanTransactions. Callers add the WHERE and ORDER BY clauses
+const transactionsQuery = `
 	 SELECT 
-	 id,
-	 reference,
-	 created_at,
+	 transactions.id,
+	 transactions.reference,
+	 transactions.fx_rate_id,
+	 transactions.reversal_of,
+	 transactions.created_at,
 	 lines.id AS line_id,
 	 lines.account_id AS line_account_id,
 	 lines.purpose AS line_purpose,
 	 lines.amount AS line_amount,
+	 lines.currency AS line_currency,
 	 lines.created_at AS line_created_at
 	 FROM transactions
 	 JOIN transaction_lines AS lines
-	 ON transactions.id = transaction_lines.transaction_id
+	 ON transactions.id = lines.transaction_id
+`
+
+// GetTransactionsByAccountID retrieves all transactions of an account along with the account's lines, most recent first
+func (r *TransactionRepository) GetTransactionsByAccountID(ctx context.Context, accountId uuid.UUID) ([]*models.Transaction, error) {
+	query := transactionsQuery + `
 	 WHERE lines.account_id = $1
-	 ORDER BY created_at DESC
+	 ORDER BY transactions.created_at DESC, transactions.id, lines.id
 	 `
 
 	rows, err := r.db.QueryContext(ctx, query, accountId)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+	return scanTransactions(rows)
+}
+
+// GetTransactionsByAccountIDBetween retrieves the transactions of an account along with the account's lines posted from the start of the period up to its end
+// exclusive, most recent first
+func (r *TransactionRepository) GetTransactionsByAccountIDBetween(ctx context.Context, accountId uuid.UUID, from, to time.Time) ([]*models.Transaction, error) {
+	query := transactionsQuery + `
+	 WHERE lines.account_id = $1 AND lines.created_at >= $2 AND lines.created_at < $3
+	 ORDER BY transactions.created_at DESC, transactions.id, lines.id
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, accountId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTransactions(rows)
+}
+
+// scanTransactions groups rows of transactions joined with their lines into transactions, in the order they were returned
+func scanTransactions(rows *sql.Rows) ([]*models.Transaction, error) {
 	// hold group transaction lines in order of how they were returned from the db as grouped by their id
 	groupedTx := make(map[uuid.UUID]*models.Transaction)
 	var orderedTx []uuid.UUID
@@ -150,19 +273,23 @@ func (r *TransactionRepository) GetTransactionsByAccountID(ctx context.Context,
 		var transaction models.Transaction
 		var line models.TransactionLine
 
-		if err := rows.Scan(&transaction.ID, &transaction.Reference, &transaction.CreatedAt, &line.ID, &line.AccountID, &line.Purpose, &line.Amount, &line.CreatedAt); err != nil {
+		if err := rows.Scan(&transaction.ID, &transaction.Reference, &transaction.FXRateID, &transaction.ReversalOf, &transaction.CreatedAt, &line.ID, &line.AccountID, &line.Purpose, &line.Amount, &line.Currency, &line.CreatedAt); err != nil {
 			return nil, err
 		}
+		line.TransactionID = transaction.ID.String()
 
 		// add new line or append line to existing transaction
 		existingTx, exists := groupedTx[transaction.ID]
 		if !exists {
 			transaction.Lines = append(transaction.Lines, line)
 			groupedTx[transaction.ID] = &transaction
+			orderedTx = append(orderedTx, transaction.ID)
 		} else {
 			existingTx.Lines = append(existingTx.Lines, line)
 		}
-		orderedTx = append(orderedTx, transaction.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
 	// order transactions
@@ -173,3 +300,60 @@ func (r *TransactionRepository) GetTransactionsByAccountID(ctx context.Context,
 
 	return transactions, nil
 }
+
+// LockTransaction acquires a row lock on a transaction until the database transaction completes. It serializes the reversals of a transaction
+func (r *TransactionRepository) LockTransaction(ctx context.Context, tx *sql.Tx, id uuid.UUID) error {
+	query := `SELECT id FROM transactions WHERE id = $1 FOR UPDATE`
+
+	var locked uuid.UUID
+	return tx.QueryRowContext(ctx, query, id).Scan(&locked)
+}
+
+// GetReversedTotals retrieves the amounts of a transaction that have already been reversed, per currency. Fee refunds posted against the transaction are not
+// reversals of it and are left out. It reads within the provided database transaction
+func (r *TransactionRepository) GetReversedTotals(ctx context.Context, tx *sql.Tx, id uuid.UUID) (map[models.Currency]uint64, error) {
+	query := `
+	 SELECT lines.currency, SUM(lines.amount)
+	 FROM transactions
+	 JOIN transaction_lines AS lines
+	 ON transactions.id = lines.transaction_id
+	 WHERE transactions.reversal_of = $1 AND lines.purpose = $2
+	 AND NOT EXISTS (SELECT 1 FROM fee_refunds WHERE fee_refunds.transaction_id = transactions.id)
+	 GROUP BY lines.currency
+	 `
+
+	rows, err := tx.QueryContext(ctx, query, id, models.DEBIT)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[models.Currency]uint64)
+	for rows.Next() {
+		var currency models.Currency
+		var total uint64
+		if err := rows.Scan(&currency, &total); err != nil {
+			return nil, err
+		}
+		totals[currency] = total
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// GetReversals retrieves all transactions that reverse the given transaction, oldest first
+func (r *TransactionRepository) GetReversals(ctx context.Context, id uuid.UUID) ([]*models.Transaction, error) {
+	query := transactionsQuery + `
+	 WHERE transactions.reversal_of = $1
+	 ORDER BY transactions.created_at, transactions.id, lines.id
+	 `
+
+	rows, err := r.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTransactions(rows)
+}
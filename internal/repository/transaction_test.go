@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+func TestValidateLines(t *testing.T) {
+	line := func(purpose models.TransactionPurpose, amount uint64, currency models.Currency) models.CreateTransactionLine {
+		return models.CreateTransactionLine{Purpose: purpose, Amount: amount, Currency: currency}
+	}
+	debit := func(amount uint64, currency models.Currency) models.CreateTransactionLine {
+		return line(models.DEBIT, amount, currency)
+	}
+	credit := func(amount uint64, currency models.Currency) models.CreateTransactionLine {
+		return line(models.CREDIT, amount, currency)
+	}
+
+	tests := []struct {
+		name  string
+		lines []models.CreateTransactionLine
+		err   error
+	}{
+		{"balanced", []models.CreateTransactionLine{debit(100, models.USD), credit(100, models.USD)}, nil},
+		{"multi-leg", []models.CreateTransactionLine{debit(100, models.USD), credit(60, models.USD), credit(40, models.USD)}, nil},
+		{"balanced per currency", []models.CreateTransactionLine{debit(100, models.USD), credit(100, models.USD), debit(9000, models.JPY), credit(9000, models.JPY)}, nil},
+		{"largest amount", []models.CreateTransactionLine{debit(math.MaxInt64, models.USD), credit(math.MaxInt64, models.USD)}, nil},
+		{"no lines", nil, ErrTooFewLines},
+		{"single line", []models.CreateTransactionLine{debit(100, models.USD)}, ErrTooFewLines},
+		{"debits only", []models.CreateTransactionLine{debit(100, models.USD), debit(100, models.USD)}, ErrTooFewLines},
+		{"zero amount", []models.CreateTransactionLine{debit(0, models.USD), credit(0, models.USD)}, ErrInvalidLineAmount},
+		{"amount out of range", []models.CreateTransactionLine{debit(math.MaxInt64+1, models.USD), credit(math.MaxInt64+1, models.USD)}, ErrInvalidLineAmount},
+		{"unsupported currency", []models.CreateTransactionLine{debit(100, "XYZ"), credit(100, "XYZ")}, ErrUnsupportedCurrency},
+		{"invalid purpose", []models.CreateTransactionLine{debit(100, models.USD), line("transfer", 100, models.USD)}, ErrInvalidLinePurpose},
+		{"unbalanced", []models.CreateTransactionLine{debit(100, models.USD), credit(99, models.USD)}, ErrUnbalancedTransaction},
+		{"balanced across currencies only", []models.CreateTransactionLine{debit(100, models.USD), credit(100, models.EUR)}, ErrUnbalancedTransaction},
+		{"currency missing a side", []models.CreateTransactionLine{debit(100, models.USD), credit(100, models.USD), debit(5, models.EUR)}, ErrUnbalancedTransaction},
+		{"side above int64", []models.CreateTransactionLine{debit(math.MaxInt64, models.USD), debit(math.MaxInt64, models.USD), credit(math.MaxInt64, models.USD), credit(math.MaxInt64, models.USD)}, ErrLineAmountOverflow},
+		{"side at int64", []models.CreateTransactionLine{debit(math.MaxInt64-1, models.USD), debit(1, models.USD), credit(math.MaxInt64, models.USD)}, nil},
+		{"overflow", []models.CreateTransactionLine{debit(math.MaxInt64, models.USD), debit(math.MaxInt64, models.USD), debit(math.MaxInt64, models.USD), credit(1, models.USD)}, ErrLineAmountOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateLines(tt.lines); !errors.Is(err, tt.err) {
+				t.Errorf("ValidateLines() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestApplyBalancesOverflow(t *testing.T) {
+	acctID := uuid.New()
+	lines := []models.CreateTransactionLine{
+		{AccountID: acctID, Purpose: models.DEBIT, Amount: math.MaxInt64, Currency: models.USD},
+		{AccountID: acctID, Purpose: models.DEBIT, Amount: 1, Currency: models.USD},
+	}
+	// the totals are checked before any balance is written, so no database transaction is needed
+	if err := applyBalances(context.Background(), nil, lines); !errors.Is(err, ErrLineAmountOverflow) {
+		t.Errorf("applyBalances() = %v, want %v", err, ErrLineAmountOverflow)
+	}
+}
@@ -20,23 +20,32 @@ func NewUserRepository(db *sql.DB, logger *slog.Logger) *UserRepository {
 	return &UserRepository{db: db, logger: logger}
 }
 
+// userColumns lists the columns every user query returns
+const userColumns = `id, email, role, email_verified_at, created_at, updated_at`
+
 // GetTx returns a database transaction that can be used for all operations
 func (r *UserRepository) GetTx(ctx context.Context) (tx *sql.Tx, err error) {
 	tx, err = r.db.BeginTx(ctx, &sql.TxOptions{})
 	return tx, err
 }
 
-// CreateUser adds a new user to the database. This is a password-less user
+// CreateUser adds a new user to the database, along with their password credential when a password hash is given
 func (r *UserRepository) CreateUser(ctx context.Context, data *models.CreateUser) (*models.User, error) {
 	query := `
-		INSERT INTO users (email)
-		VALUES ($1)
-		RETURNING *
+		WITH created AS (
+			INSERT INTO users (email)
+			VALUES ($1)
+			RETURNING ` + userColumns + `
+		), credentials AS (
+			INSERT INTO user_credentials (user_id, password_hash)
+			SELECT id, $2 FROM created WHERE $2 <> ''
+		)
+		SELECT ` + userColumns + ` FROM created
 	`
 
 	// retrieve user details
 	var user models.User
-	if err := r.db.QueryRowContext(ctx, query, data.Email).Scan(&user.ID, &user.Email, &user.CreatedAt, &user.UpdatedAt); err != nil {
+	if err := r.db.QueryRowContext(ctx, query, data.Email, data.PasswordHash).Scan(&user.ID, &user.Email, &user.Role, &user.EmailVerifiedAt, &user.CreatedAt, &user.UpdatedAt); err != nil {
 		return nil, err
 	}
 
@@ -46,12 +55,12 @@ func (r *UserRepository) CreateUser(ctx context.Context, data *models.CreateUser
 // GetUserByID retrieves a user by their ID
 func (r *UserRepository) GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	query := `
-	 SELECT id, email, created_at, updated_at FROM users
+	 SELECT ` + userColumns + ` FROM users
 	 WHERE id = ($1)
 	 `
 	r.logger.Debug("user id", "id", id.String())
 	var user models.User
-	if err := r.db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Email, &user.CreatedAt, &user.UpdatedAt); err != nil {
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Email, &user.Role, &user.EmailVerifiedAt, &user.CreatedAt, &user.UpdatedAt); err != nil {
 		return nil, err
 	}
 
@@ -60,10 +69,26 @@ func (r *UserRepository) GetUserByID(ctx context.Context, id uuid.UUID) (*models
 
 // GetUserByID retrieves a user by their email
 func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	query := `SELECT * FROM users WHERE email = ($1)`
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = ($1)`
+
+	var user models.User
+	if err := r.db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Email, &user.Role, &user.EmailVerifiedAt, &user.CreatedAt, &user.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// SetUserRole changes the role of a user
+func (r *UserRepository) SetUserRole(ctx context.Context, id uuid.UUID, role models.Role) (*models.User, error) {
+	query := `
+	 UPDATE users SET role = $2, updated_at = NOW()
+	 WHERE id = $1
+	 RETURNING ` + userColumns + `
+	 `
 
 	var user models.User
-	if err := r.db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Email, &user.CreatedAt, &user.UpdatedAt); err != nil {
+	if err := r.db.QueryRowContext(ctx, query, id, role).Scan(&user.ID, &user.Email, &user.Role, &user.EmailVerifiedAt, &user.CreatedAt, &user.UpdatedAt); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// UserTokenRepository handles database operations for the single use tokens emailed to users
+type UserTokenRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewUserTokenRepository creates a new user token repository
+func NewUserTokenRepository(db *sql.DB, logger *slog.Logger) *UserTokenRepository {
+	return &UserTokenRepository{db: db, logger: logger}
+}
+
+// userTokenColumns lists the columns scanned by scanUserToken. The hash of the token is never read back
+const userTokenColumns = `id, user_id, purpose, expires_at, used_at, created_at`
+
+// CreateToken stores the hash of a new token for the user. Unused tokens issued to them earlier for the same purpose expire, so only the latest email works
+func (r *UserTokenRepository) CreateToken(ctx context.Context, data *models.CreateUserToken) (*models.UserToken, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	 UPDATE user_tokens SET expires_at = NOW()
+	 WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+	 `
+	if _, err := tx.ExecContext(ctx, query, data.UserID, data.Purpose); err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + userTokenColumns
+	token, err := scanUserToken(tx.QueryRowContext(ctx, query, data.UserID, data.Purpose, data.TokenHash, data.ExpiresAt))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// VerifyEmail uses an email verification token and marks the email of its user as verified. It fails with sql.ErrNoRows when the token is unknown, used or
+// expired
+func (r *UserTokenRepository) VerifyEmail(ctx context.Context, tokenHash string) (*models.User, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	token, err := useToken(ctx, tx, models.EMAIL_VERIFICATION, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	 UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+	 WHERE id = $1
+	 RETURNING ` + userColumns
+	var user models.User
+	if err := tx.QueryRowContext(ctx, query, token.UserID).Scan(&user.ID, &user.Email, &user.Role, &user.EmailVerifiedAt, &user.CreatedAt, &user.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ResetPassword uses a password reset token and stores the new password hash of its user, revoking every session of theirs. The token was delivered to the
+// email of the user, which is marked as verified. It fails with sql.ErrNoRows when the token is unknown, used or expired
+func (r *UserTokenRepository) ResetPassword(ctx context.Context, tokenHash, passwordHash string) (*models.UserToken, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	token, err := useToken(ctx, tx, models.PASSWORD_RESET, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if err := setPassword(ctx, tx, token.UserID, passwordHash, nil); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET email_verified_at = NOW(), updated_at = NOW() WHERE id = $1 AND email_verified_at IS NULL`, token.UserID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// useToken marks an unused and unexpired token as used within the provided database transaction, so that it works only once
+func useToken(ctx context.Context, tx *sql.Tx, purpose models.TokenPurpose, tokenHash string) (*models.UserToken, error) {
+	query := `
+	 UPDATE user_tokens SET used_at = NOW()
+	 WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+	 RETURNING ` + userTokenColumns
+	return scanUserToken(tx.QueryRowContext(ctx, query, tokenHash, purpose))
+}
+
+func scanUserToken(row scanner) (*models.UserToken, error) {
+	var token models.UserToken
+	if err := row.Scan(&token.ID, &token.UserID, &token.Purpose, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
@@ -0,0 +1,189 @@
+package statement
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// camt.053 credit and debit indicators, balance types and entry status
+const (
+	camtCredit        = "CRDT"
+	camtDebit         = "DBIT"
+	camtOpeningBooked = "OPBD"
+	camtClosingBooked = "CLBD"
+	camtBooked        = "BOOK"
+	// camtTransfer is the proprietary bank transaction code of every entry
+	camtTransfer = "NTRF"
+)
+
+// camt.053.001.02 document, limited to the elements the ledger can fill. Fields are declared in schema order
+type camtDocument struct {
+	XMLName   xml.Name           `xml:"urn:iso:std:iso:20022:tech:xsd:camt.053.001.02 Document"`
+	Statement camtBankToCustomer `xml:"BkToCstmrStmt"`
+}
+
+type camtBankToCustomer struct {
+	GroupHeader camtGroupHeader `xml:"GrpHdr"`
+	Statements  []camtStatement `xml:"Stmt"`
+}
+
+type camtGroupHeader struct {
+	MessageID string `xml:"MsgId"`
+	CreatedAt string `xml:"CreDtTm"`
+}
+
+type camtStatement struct {
+	ID        string        `xml:"Id"`
+	CreatedAt string        `xml:"CreDtTm"`
+	Period    camtPeriod    `xml:"FrToDt"`
+	Account   camtAccount   `xml:"Acct"`
+	Balances  []camtBalance `xml:"Bal"`
+	Summary   camtSummary   `xml:"TxsSummry"`
+	Entries   []camtEntry   `xml:"Ntry"`
+}
+
+type camtPeriod struct {
+	From string `xml:"FrDtTm"`
+	To   string `xml:"ToDtTm"`
+}
+
+type camtAccount struct {
+	ID       string          `xml:"Id>Othr>Id"`
+	Currency models.Currency `xml:"Ccy"`
+}
+
+type camtAmount struct {
+	Currency models.Currency `xml:"Ccy,attr"`
+	Value    string          `xml:",chardata"`
+}
+
+type camtBalance struct {
+	Type      string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount    camtAmount `xml:"Amt"`
+	Indicator string     `xml:"CdtDbtInd"`
+	Date      string     `xml:"Dt>Dt"`
+}
+
+type camtSummary struct {
+	Entries camtSummaryCount `xml:"TtlNtries"`
+	Credits camtSummaryCount `xml:"TtlCdtNtries"`
+	Debits  camtSummaryCount `xml:"TtlDbtNtries"`
+}
+
+type camtSummaryCount struct {
+	Count int    `xml:"NbOfNtries"`
+	Sum   string `xml:"Sum"`
+}
+
+type camtEntry struct {
+	Amount      camtAmount  `xml:"Amt"`
+	Indicator   string      `xml:"CdtDbtInd"`
+	Status      string      `xml:"Sts"`
+	BookedAt    string      `xml:"BookgDt>DtTm"`
+	ValueDate   string      `xml:"ValDt>Dt"`
+	ServicerRef string      `xml:"AcctSvcrRef"`
+	Code        string      `xml:"BkTxCd>Prtry>Cd"`
+	Details     camtDetails `xml:"NtryDtls>TxDtls"`
+}
+
+type camtDetails struct {
+	ServicerRef string `xml:"Refs>AcctSvcrRef"`
+	EndToEndID  string `xml:"Refs>EndToEndId"`
+	Remittance  string `xml:"RmtInf>Ustrd"`
+}
+
+// renderCAMT053 writes the statement as an ISO 20022 camt.053.001.02 bank to customer statement with one Stmt per currency. The creation time is the close of the
+// period rather than the time of rendering, so the document stays reproducible
+func renderCAMT053(s *models.Statement) ([]byte, error) {
+	from, to, err := period(s)
+	if err != nil {
+		return nil, err
+	}
+	closedAt := to.AddDate(0, 0, 1).Format(time.RFC3339)
+	id := messageID(s)
+
+	doc := camtDocument{
+		Statement: camtBankToCustomer{
+			GroupHeader: camtGroupHeader{MessageID: id, CreatedAt: closedAt},
+		},
+	}
+	for _, balance := range s.Balances {
+		stmt := camtStatement{
+			ID:        fmt.Sprintf("%s-%s", id, balance.Currency),
+			CreatedAt: closedAt,
+			Period: camtPeriod{
+				From: from.Format(time.RFC3339),
+				To:   to.Add(24*time.Hour - time.Second).Format(time.RFC3339),
+			},
+			Account: camtAccount{ID: s.AccountNumber, Currency: balance.Currency},
+			Balances: []camtBalance{
+				camtBalanceOf(s.Class, camtOpeningBooked, balance.Currency, balance.Opening, s.From),
+				camtBalanceOf(s.Class, camtClosingBooked, balance.Currency, balance.Closing, s.To),
+			},
+		}
+
+		var credits, debits int
+		for _, line := range s.Lines {
+			if line.Currency != balance.Currency {
+				continue
+			}
+			indicator := camtCredit
+			if line.Purpose == models.DEBIT {
+				indicator = camtDebit
+				debits++
+			} else {
+				credits++
+			}
+			ref := servicerRef(line)
+			stmt.Entries = append(stmt.Entries, camtEntry{
+				Amount:      camtAmount{Currency: line.Currency, Value: line.Currency.Format(int64(line.Amount))},
+				Indicator:   indicator,
+				Status:      camtBooked,
+				BookedAt:    line.BookedAt.Format(time.RFC3339),
+				ValueDate:   line.BookedAt.Format(time.DateOnly),
+				ServicerRef: ref,
+				Code:        camtTransfer,
+				Details: camtDetails{
+					ServicerRef: ref,
+					EndToEndID:  truncate(line.Reference, 35),
+					Remittance:  truncate(line.Reference, 140),
+				},
+			})
+		}
+		stmt.Summary = camtSummary{
+			Entries: camtSummaryCount{Count: credits + debits, Sum: balance.Currency.Format(int64(balance.Credits + balance.Debits))},
+			Credits: camtSummaryCount{Count: credits, Sum: balance.Currency.Format(int64(balance.Credits))},
+			Debits:  camtSummaryCount{Count: debits, Sum: balance.Currency.Format(int64(balance.Debits))},
+		}
+		doc.Statement.Statements = append(doc.Statement.Statements, stmt)
+	}
+
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
+
+// camtBalanceOf reports a balance on the normal side of the account class as an unsigned amount with its credit or debit indicator
+func camtBalanceOf(class models.AccountClass, kind string, currency models.Currency, amount int64, date string) camtBalance {
+	indicator := camtCredit
+	if !creditBalance(class, amount) {
+		indicator = camtDebit
+	}
+	return camtBalance{
+		Type:      kind,
+		Amount:    camtAmount{Currency: currency, Value: currency.Format(absolute(amount))},
+		Indicator: indicator,
+		Date:      date,
+	}
+}
+
+// servicerRef identifies the line at the bank within the 35 characters allowed, as the transaction id without dashes
+func servicerRef(line models.StatementLine) string {
+	return strings.ReplaceAll(line.TransactionID.String(), "-", "")
+}
@@ -0,0 +1,143 @@
+package statement
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// assertGolden compares a rendered export with its golden file in testdata, or rewrites the file when the tests run with -update
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("write golden file: %v", err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from its golden file:\n%s\n--- want ---\n%s", name, got, want)
+	}
+}
+
+func TestRenderCAMT053(t *testing.T) {
+	tests := []struct {
+		name  string
+		class models.AccountClass
+		// indicator is how the opening balance, on the normal side of the class, is reported
+		indicator string
+	}{
+		{"camt053_credit_normal.xml", models.LIABILITY, camtCredit},
+		{"camt053_debit_normal.xml", models.ASSET, camtDebit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := Render(testStatement(tt.class), models.CAMT053_STATEMENT)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			assertGolden(t, tt.name, body)
+
+			var doc camtDocument
+			if err := xml.Unmarshal(body, &doc); err != nil {
+				t.Fatalf("unmarshal camt.053: %v", err)
+			}
+			if doc.XMLName.Space != "urn:iso:std:iso:20022:tech:xsd:camt.053.001.02" {
+				t.Errorf("namespace = %q", doc.XMLName.Space)
+			}
+			if len(doc.Statement.Statements) != 1 {
+				t.Fatalf("got %d statements, want 1", len(doc.Statement.Statements))
+			}
+			stmt := doc.Statement.Statements[0]
+			if len(stmt.Balances) != 2 || stmt.Balances[0].Type != camtOpeningBooked || stmt.Balances[1].Type != camtClosingBooked {
+				t.Fatalf("balances = %+v", stmt.Balances)
+			}
+			if stmt.Balances[0].Indicator != tt.indicator || stmt.Balances[0].Amount.Value != "100.00" {
+				t.Errorf("opening balance = %+v, want 100.00 %s", stmt.Balances[0], tt.indicator)
+			}
+			// entries keep the side they were posted on whatever the class
+			if len(stmt.Entries) != 3 || stmt.Entries[0].Indicator != camtCredit || stmt.Entries[1].Indicator != camtDebit {
+				t.Errorf("entries = %+v", stmt.Entries)
+			}
+			if stmt.Summary.Entries.Count != 3 || stmt.Summary.Credits.Count != 1 || stmt.Summary.Debits.Count != 2 {
+				t.Errorf("summary = %+v", stmt.Summary)
+			}
+		})
+	}
+}
+
+func TestRenderMT940(t *testing.T) {
+	account := testAccount(models.LIABILITY)
+	transactions := testTransactions(account)
+	// a reference far beyond every field length, with characters outside the SWIFT set
+	long := &models.Transaction{
+		ID:        uuid.MustParse("aaaaaaaa-0000-0000-0000-000000000004"),
+		Reference: "refund: " + strings.Repeat("order #4711 – café ", 25),
+		CreatedAt: transactions[0].CreatedAt,
+		Lines: []models.TransactionLine{
+			{ID: "aaaaaaaa-0000-0000-0000-000000000041", AccountID: account.ID.String(), Purpose: models.CREDIT, Amount: 5, Currency: models.USD},
+		},
+	}
+	opening := []*models.AccountBalance{{Currency: models.USD, Balance: 10000}}
+	stmt := models.NewStatement(account, opening, append(transactions, long), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC))
+
+	body, err := Render(stmt, models.MT940_STATEMENT)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	assertGolden(t, "mt940.sta", body)
+
+	if !bytes.HasSuffix(body, []byte("-\r\n")) {
+		t.Error("message does not end with a \"-\" line")
+	}
+	if bytes.Count(body, []byte("\n")) != bytes.Count(body, []byte("\r\n")) {
+		t.Error("lines are not all ended with CRLF")
+	}
+
+	// :86: runs on over untagged lines until the next field
+	var field string
+	narrative := 0
+	for _, line := range strings.Split(strings.TrimSuffix(string(body), "\r\n"), "\r\n") {
+		if strings.HasPrefix(line, ":") || line == "-" {
+			field, _, _ = strings.Cut(strings.TrimPrefix(line, ":"), ":")
+			narrative = 0
+		}
+
+		switch field {
+		case "60F", "62F":
+			if amount := line[len(":60F:C240301USD"):]; !strings.Contains(amount, ",") || strings.Contains(amount, ".") {
+				t.Errorf("balance %q has no decimal comma", line)
+			}
+		case "61":
+			value := strings.TrimPrefix(line, ":61:")
+			_, rest, _ := strings.Cut(value, "NTRF")
+			ref, bankRef, _ := strings.Cut(rest, "//")
+			if ref == "" || len(ref) > 16 || len(bankRef) > 16 {
+				t.Errorf(":61: references %q and %q exceed 16 characters", ref, bankRef)
+			}
+			if amount := value[11:strings.Index(value, "NTRF")]; !strings.Contains(amount, ",") || strings.Contains(amount, ".") {
+				t.Errorf(":61: amount %q has no decimal comma", amount)
+			}
+		case "86":
+			narrative++
+			text := strings.TrimPrefix(line, ":86:")
+			if len(text) > 65 || narrative > 6 {
+				t.Errorf(":86: line %d %q exceeds 6 lines of 65 characters", narrative, text)
+			}
+		}
+	}
+}
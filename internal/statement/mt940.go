@@ -0,0 +1,104 @@
+package statement
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// mt940Layout is the YYMMDD date format of MT940 fields
+const mt940Layout = "060102"
+
+// renderMT940 writes the statement as SWIFT MT940 text blocks, one message per currency ended by a "-" line, with CRLF line endings. Text is reduced to the SWIFT
+// x character set
+func renderMT940(s *models.Statement) ([]byte, error) {
+	from, to, err := period(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for i, balance := range s.Balances {
+		field := func(tag, value string) {
+			buf.WriteString(":" + tag + ":" + value + "\r\n")
+		}
+
+		field("20", "ST"+from.Format(mt940Layout)+to.Format(mt940Layout))
+		field("25", swiftText(s.AccountNumber+"/"+string(balance.Currency), 35))
+		field("28C", fmt.Sprintf("00001/%03d", i+1))
+		field("60F", mt940Balance(s.Class, balance.Currency, balance.Opening, from.Format(mt940Layout)))
+		for _, line := range s.Lines {
+			if line.Currency != balance.Currency {
+				continue
+			}
+			mark := "C"
+			if line.Purpose == models.DEBIT {
+				mark = "D"
+			}
+			ref := swiftRef(line.Reference)
+			if ref == "" {
+				ref = "NONREF"
+			}
+			field("61", line.BookedAt.Format(mt940Layout)+line.BookedAt.Format("0102")+mark+mt940Amount(line.Currency, int64(line.Amount))+"NTRF"+ref+"//"+servicerRef(line)[:16])
+			field("86", strings.Join(wrap(swiftText(line.Reference+" TXN "+line.TransactionID.String(), 6*65), 65), "\r\n"))
+		}
+		field("62F", mt940Balance(s.Class, balance.Currency, balance.Closing, to.Format(mt940Layout)))
+		buf.WriteString("-\r\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// mt940Balance formats a balance field: the credit or debit mark, date, currency and amount
+func mt940Balance(class models.AccountClass, currency models.Currency, amount int64, date string) string {
+	mark := "C"
+	if !creditBalance(class, amount) {
+		mark = "D"
+	}
+	return mark + date + string(currency) + mt940Amount(currency, absolute(amount))
+}
+
+// mt940Amount formats an amount with a decimal comma, which is kept even for currencies without minor units
+func mt940Amount(currency models.Currency, amount int64) string {
+	value := strings.Replace(currency.Format(amount), ".", ",", 1)
+	if !strings.Contains(value, ",") {
+		value += ","
+	}
+	return value
+}
+
+// swiftText replaces the characters outside the SWIFT x character set with spaces, collapses runs of spaces and truncates the text to the given length. Colons are replaced too, since a
+// wrapped line starting with one would read as a new field
+func swiftText(s string, length int) string {
+	mapped := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune("/-?().,'+ ", r):
+			return r
+		}
+		return ' '
+	}, s)
+	return truncate(strings.Join(strings.Fields(mapped), " "), length)
+}
+
+// swiftRef reduces a reference to the 16 characters of an MT940 reference field, which may not start or end with a slash or contain two in a row
+func swiftRef(s string) string {
+	ref := swiftText(s, len(s))
+	for strings.Contains(ref, "//") {
+		ref = strings.ReplaceAll(ref, "//", "/")
+	}
+	ref = strings.Trim(truncate(strings.Trim(ref, "/"), 16), "/")
+	return strings.TrimSpace(ref)
+}
+
+// wrap splits text into lines of at most the given length
+func wrap(s string, length int) []string {
+	var lines []string
+	for len(s) > length {
+		lines = append(lines, s[:length])
+		s = s[length:]
+	}
+	return append(lines, s)
+}
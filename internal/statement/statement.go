@@ -0,0 +1,179 @@
+package statement
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// errors
+var (
+	ErrUnsupportedFormat = errors.New("unsupported statement format")
+)
+
+// bookedAtLayout is how booking times are written in csv and txt statements
+const bookedAtLayout = "2006-01-02 15:04:05"
+
+// ContentType returns the media type of a statement rendered in the given format
+func ContentType(format models.StatementFormat) string {
+	switch format {
+	case models.CSV_STATEMENT:
+		return "text/csv; charset=utf-8"
+	case models.JSON_STATEMENT:
+		return "application/json; charset=utf-8"
+	case models.CAMT053_STATEMENT:
+		return "application/xml; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Render encodes the statement in the given format. The output only depends on the statement, so rendering the same statement twice gives the same bytes
+func Render(s *models.Statement, format models.StatementFormat) ([]byte, error) {
+	switch format {
+	case models.CSV_STATEMENT:
+		return renderCSV(s)
+	case models.JSON_STATEMENT:
+		return json.Marshal(s)
+	case models.TXT_STATEMENT:
+		return renderTXT(s)
+	case models.CAMT053_STATEMENT:
+		return renderCAMT053(s)
+	case models.MT940_STATEMENT:
+		return renderMT940(s)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// Checksum returns the hex encoded SHA-256 hash of a rendered statement
+func Checksum(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// renderCSV writes one row per line, preceded by the opening and followed by the closing balance of each currency
+func renderCSV(s *models.Statement) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write([]string{"entry", "booked_at", "transaction_id", "line_id", "reference", "currency", "debit", "credit", "balance"}); err != nil {
+		return nil, err
+	}
+	for _, balance := range s.Balances {
+		if err := w.Write([]string{"opening", "", "", "", "", string(balance.Currency), "", "", balance.Currency.Format(balance.Opening)}); err != nil {
+			return nil, err
+		}
+	}
+	for _, line := range s.Lines {
+		debit, credit := amounts(line)
+		if err := w.Write([]string{"line", line.BookedAt.Format(bookedAtLayout), line.TransactionID.String(), line.LineID, line.Reference, string(line.Currency), debit, credit, line.Currency.Format(line.Balance)}); err != nil {
+			return nil, err
+		}
+	}
+	for _, balance := range s.Balances {
+		if err := w.Write([]string{"closing", "", "", "", "", string(balance.Currency), balance.Currency.Format(int64(balance.Debits)), balance.Currency.Format(int64(balance.Credits)), balance.Currency.Format(balance.Closing)}); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderTXT writes a printable statement with a section per currency
+func renderTXT(s *models.Statement) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "STATEMENT OF ACCOUNT %s\n", s.AccountNumber)
+	fmt.Fprintf(&buf, "Account ID: %s\n", s.AccountID)
+	fmt.Fprintf(&buf, "Class: %s\n", s.Class)
+	fmt.Fprintf(&buf, "Period: %s to %s (UTC)\n", s.From, s.To)
+
+	for _, balance := range s.Balances {
+		fmt.Fprintf(&buf, "\n%s\n", balance.Currency)
+		w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "BOOKED AT\tREFERENCE\tDEBIT\tCREDIT\tBALANCE\t")
+		fmt.Fprintf(w, "%s\tOpening balance\t\t\t%s\t\n", s.From, balance.Currency.Format(balance.Opening))
+		for _, line := range s.Lines {
+			if line.Currency != balance.Currency {
+				continue
+			}
+			debit, credit := amounts(line)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", line.BookedAt.Format(bookedAtLayout), line.Reference, debit, credit, line.Currency.Format(line.Balance))
+		}
+		fmt.Fprintf(w, "%s\tClosing balance\t%s\t%s\t%s\t\n", s.To, balance.Currency.Format(int64(balance.Debits)), balance.Currency.Format(int64(balance.Credits)), balance.Currency.Format(balance.Closing))
+		if err := w.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// amounts returns the formatted amount of a line in its debit or credit column
+func amounts(line models.StatementLine) (debit, credit string) {
+	amount := line.Currency.Format(int64(line.Amount))
+	if line.Purpose == models.DEBIT {
+		return amount, ""
+	}
+	return "", amount
+}
+
+// Filename returns the name a statement rendered in the given format is downloaded as
+func Filename(s *models.Statement, format models.StatementFormat) string {
+	ext := string(format)
+	switch format {
+	case models.CAMT053_STATEMENT:
+		ext = "xml"
+	case models.MT940_STATEMENT:
+		ext = "sta"
+	}
+	return fmt.Sprintf("statement-%s-%s-%s.%s", s.AccountNumber, s.From, s.To, ext)
+}
+
+// period returns the first and last day of the statement
+func period(s *models.Statement) (from, to time.Time, err error) {
+	if from, err = time.Parse(time.DateOnly, s.From); err != nil {
+		return from, to, err
+	}
+	to, err = time.Parse(time.DateOnly, s.To)
+	return from, to, err
+}
+
+// messageID identifies the statement of an account for a period, so that exporting the same period twice gives the same id
+func messageID(s *models.Statement) string {
+	return truncate(fmt.Sprintf("%s-%s-%s", s.AccountNumber, strings.ReplaceAll(s.From, "-", ""), strings.ReplaceAll(s.To, "-", "")), 30)
+}
+
+// creditBalance reports whether a balance on the normal side of the class is in credit, ie: owed by the bank to the account holder
+func creditBalance(class models.AccountClass, amount int64) bool {
+	if class.NormalSide() == models.CREDIT {
+		return amount >= 0
+	}
+	return amount < 0
+}
+
+func absolute(amount int64) int64 {
+	if amount < 0 {
+		return -amount
+	}
+	return amount
+}
+
+// truncate shortens text to at most the given number of characters
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length])
+}
@@ -0,0 +1,110 @@
+package statement
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrshabel/sgbank/internal/models"
+)
+
+// testAccount returns the account the test statements are drawn for
+func testAccount(class models.AccountClass) *models.Account {
+	return &models.Account{
+		ID:            uuid.MustParse("44444444-4444-4444-4444-444444444444"),
+		AccountNumber: "1234567890",
+		Class:         class,
+		Currency:      models.USD,
+	}
+}
+
+// testTransactions returns the transactions posted to the test account during March 2024, with lines of another account mixed in
+func testTransactions(account *models.Account) []*models.Transaction {
+	other := "55555555-5555-5555-5555-555555555555"
+	at := func(day, hour int) *time.Time {
+		t := time.Date(2024, 3, day, hour, 30, 0, 0, time.UTC)
+		return &t
+	}
+	transaction := func(id, reference string, createdAt *time.Time, purpose models.TransactionPurpose, amount uint64) *models.Transaction {
+		opposite := models.CREDIT
+		if purpose == models.CREDIT {
+			opposite = models.DEBIT
+		}
+		return &models.Transaction{
+			ID:        uuid.MustParse(id),
+			Reference: reference,
+			CreatedAt: createdAt,
+			Lines: []models.TransactionLine{
+				{ID: id[:8] + "-0000-0000-0000-000000000001", AccountID: account.ID.String(), Purpose: purpose, Amount: amount, Currency: models.USD, CreatedAt: createdAt},
+				{ID: id[:8] + "-0000-0000-0000-000000000002", AccountID: other, Purpose: opposite, Amount: amount, Currency: models.USD, CreatedAt: createdAt},
+			},
+		}
+	}
+	return []*models.Transaction{
+		transaction("aaaaaaaa-0000-0000-0000-000000000003", "SO-rent/2024-03", at(28, 9), models.DEBIT, 120000),
+		transaction("aaaaaaaa-0000-0000-0000-000000000002", "coffee, \"large\"", at(5, 8), models.DEBIT, 450),
+		transaction("aaaaaaaa-0000-0000-0000-000000000001", "salary march", at(1, 7), models.CREDIT, 350000),
+	}
+}
+
+// testStatement builds the March 2024 statement of the test account, which opened the month with 100.00
+func testStatement(class models.AccountClass) *models.Statement {
+	account := testAccount(class)
+	opening := []*models.AccountBalance{{Currency: models.USD, Balance: 10000}}
+	return models.NewStatement(account, opening, testTransactions(account), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC))
+}
+
+func TestRenderIsReproducible(t *testing.T) {
+	for _, format := range []models.StatementFormat{models.CSV_STATEMENT, models.JSON_STATEMENT, models.TXT_STATEMENT} {
+		t.Run(string(format), func(t *testing.T) {
+			first, err := Render(testStatement(models.LIABILITY), format)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+
+			// the same period read again, with the transactions in another order
+			account := testAccount(models.LIABILITY)
+			transactions := testTransactions(account)
+			transactions[0], transactions[2] = transactions[2], transactions[0]
+			opening := []*models.AccountBalance{{Currency: models.USD, Balance: 10000}}
+			stmt := models.NewStatement(account, opening, transactions, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 23, 0, 0, 0, time.UTC))
+			second, err := Render(stmt, format)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+
+			if !bytes.Equal(first, second) {
+				t.Errorf("Render() differs between runs:\n%s\n---\n%s", first, second)
+			}
+			if Checksum(first) != Checksum(second) {
+				t.Errorf("Checksum() = %s, then %s", Checksum(first), Checksum(second))
+			}
+		})
+	}
+}
+
+func TestNewStatementBalances(t *testing.T) {
+	stmt := testStatement(models.LIABILITY)
+	if len(stmt.Balances) != 1 {
+		t.Fatalf("got %d balances, want 1", len(stmt.Balances))
+	}
+	balance := stmt.Balances[0]
+	if balance.Opening != 10000 || balance.Credits != 350000 || balance.Debits != 120450 || balance.Closing != 239550 {
+		t.Errorf("balance = %+v", balance)
+	}
+
+	// lines are booked in time order and carry the running balance, ignoring the lines of other accounts
+	want := []int64{360000, 359550, 239550}
+	if len(stmt.Lines) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(stmt.Lines), len(want))
+	}
+	for i, line := range stmt.Lines {
+		if line.Balance != want[i] {
+			t.Errorf("line %d balance = %d, want %d", i, line.Balance, want[i])
+		}
+	}
+	if stmt.From != "2024-03-01" || stmt.To != "2024-03-31" {
+		t.Errorf("period = %s to %s", stmt.From, stmt.To)
+	}
+}